@@ -1,7 +1,21 @@
 package main
 
-import "github.com/rwool/saas-interview-challenge1/cmd/service"
+import (
+	"os"
+
+	"github.com/rwool/saas-interview-challenge1/cmd/service"
+)
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "namespace":
+			service.RunNamespace(os.Args[2:])
+			return
+		case "reencrypt":
+			service.RunReencrypt(os.Args[2:])
+			return
+		}
+	}
 	service.Run()
 }
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/codec"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+)
+
+const (
+	// memorySweepInterval is how often expired keys are removed from the
+	// in-memory and embedded key value stores.
+	memorySweepInterval = time.Minute
+
+	// memoryCounterExpiration is how long the in-memory key value store keeps
+	// counters, which track the shards of documents and are never deleted
+	// once the document is counted. It is far longer than any document takes
+	// to count.
+	memoryCounterExpiration = 24 * time.Hour
+
+	// invalidationChannel is the Redis pub/sub channel that changed keys are
+	// published on, so that they are evicted from the in-memory caches of
+	// other processes.
+	invalidationChannel = "keyvalue:invalidate"
+
+	// defaultBoltPath is the file of the embedded database if BOLT_PATH is not
+	// set.
+	defaultBoltPath = "service.db"
+
+	// boltSyncInterval is how often the embedded database is synced to disk
+	// when BOLT_SYNC is "interval".
+	boltSyncInterval = time.Second
+
+	// shardDepthInterval is how often the depth of each shard of a sharded
+	// queue is logged.
+	shardDepthInterval = time.Minute
+)
+
+// backends opens the stores that the key value store and the queue are kept
+// in, selected by the KEYVALUE_BACKEND and QUEUE_BACKEND environment
+// variables. Each store is only opened if a backend uses it.
+type backends struct {
+	l log.Logger
+	// ns is the namespace of the keys and queues kept in Redis.
+	ns keyspace.Namespace
+	// codec encodes the values and messages kept in Redis.
+	codec *codec.Codec
+	redis redis.UniversalClient
+	bolt  *bolt.DB
+	// queueShards are the Redis nodes that the sharded queue is spread over.
+	queueShards []*redis.Client
+	sharded     *queue.Sharded
+	// loops maintain the backends in the background until the context is
+	// done.
+	loops []func(context.Context) error
+}
+
+func (b *backends) redisClient() (redis.UniversalClient, error) {
+	if b.redis == nil {
+		rc, err := getRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		b.redis = rc
+	}
+	return b.redis, nil
+}
+
+// boltDB opens the embedded database at BOLT_PATH.
+//
+// BOLT_SYNC sets when changes are synced to disk: "commit" (the default)
+// syncs every change before it returns, "interval" syncs every second, and
+// "none" leaves it to the operating system. Without syncing every commit,
+// changes made just before a crash can be lost, and the database can be
+// corrupted by a power failure.
+func (b *backends) boltDB() (*bolt.DB, error) {
+	if b.bolt != nil {
+		return b.bolt, nil
+	}
+	path := os.Getenv("BOLT_PATH")
+	if path == "" {
+		path = defaultBoltPath
+	}
+	sync := os.Getenv("BOLT_SYNC")
+	switch sync {
+	case "", "commit", "interval", "none":
+	default:
+		return nil, errors.Errorf("unknown BOLT_SYNC %q", sync)
+	}
+	// The file is locked by the process that has it open.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open database %s", path)
+	}
+	db.NoSync = sync == "interval" || sync == "none"
+	if sync == "interval" {
+		b.loops = append(b.loops, func(ctx context.Context) error {
+			return syncBolt(ctx, db)
+		})
+	}
+	b.bolt = db
+	return db, nil
+}
+
+// syncBolt syncs the database every boltSyncInterval and once more when the
+// context is done.
+func syncBolt(ctx context.Context, db *bolt.DB) error {
+	ticker := time.NewTicker(boltSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrap(db.Sync(), "unable to sync database")
+		}
+		if err := db.Sync(); err != nil {
+			return errors.Wrap(err, "unable to sync database")
+		}
+	}
+}
+
+// queue returns the queue selected by QUEUE_BACKEND, which is "redis" by
+// default or "bolt".
+//
+// With Redis, the queue is sharded over the Redis nodes in QUEUE_SHARDS if it
+// is set.
+func (b *backends) queue() (queue.Queue, error) {
+	switch backend := os.Getenv("QUEUE_BACKEND"); backend {
+	case "", "redis":
+		if os.Getenv("QUEUE_SHARDS") != "" {
+			return b.shardedQueue()
+		}
+		rc, err := b.redisClient()
+		if err != nil {
+			return nil, err
+		}
+		return queue.NewRedisAdapterWithConfig(rc, queue.RedisConfig{Namespace: b.ns, Codec: b.codec}), nil
+	case "bolt":
+		db, err := b.boltDB()
+		if err != nil {
+			return nil, err
+		}
+		return queue.NewBoltAdapter(db)
+	default:
+		return nil, errors.Errorf("unknown QUEUE_BACKEND %q", backend)
+	}
+}
+
+// shardedQueue returns a queue sharded over the Redis nodes in QUEUE_SHARDS.
+//
+// QUEUE_SHARDING sets the shard that each message is pushed to: "round-robin"
+// (the default) pushes to each node in turn, and "hash" pushes to the node
+// that the message hashes to, so that adding a node only moves a share of
+// the messages to it. Nodes can be added to QUEUE_SHARDS while the services
+// are restarted one at a time, since every node is pulled from.
+func (b *backends) shardedQueue() (queue.Queue, error) {
+	var strategy queue.ShardStrategy
+	switch sharding := os.Getenv("QUEUE_SHARDING"); sharding {
+	case "", "round-robin":
+		strategy = queue.RoundRobin
+	case "hash":
+		strategy = queue.ConsistentHash
+	default:
+		return nil, errors.Errorf("unknown QUEUE_SHARDING %q", sharding)
+	}
+	clients, err := getQueueShardClients()
+	if err != nil {
+		return nil, err
+	}
+	b.queueShards = clients
+	shards := make([]queue.Shard, len(clients))
+	for i, c := range clients {
+		shards[i] = queue.Shard{
+			Name:  c.Options().Addr,
+			Queue: queue.NewRedisAdapterWithConfig(c, queue.RedisConfig{Namespace: b.ns, Codec: b.codec}),
+		}
+	}
+	sharded, err := queue.NewSharded(queue.ShardedConfig{Shards: shards, Strategy: strategy})
+	if err != nil {
+		return nil, err
+	}
+	b.sharded = sharded
+	b.loops = append(b.loops, func(ctx context.Context) error {
+		logShardDepths(ctx, b.l, sharded, workerQueueName)
+		return nil
+	})
+	return sharded, nil
+}
+
+// logShardDepths logs the number of messages waiting on a channel of each
+// shard every shardDepthInterval until the context is done.
+func logShardDepths(ctx context.Context, l log.Logger, sharded *queue.Sharded, channel string) {
+	ticker := time.NewTicker(shardDepthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		depths, err := sharded.Depths(ctx, channel)
+		if err != nil {
+			_ = l.Log("LEVEL", "WARN", "MESSAGE", fmt.Sprintf("Unable to get queue depths: %v", err))
+			continue
+		}
+		for _, d := range depths {
+			_ = l.Log("LEVEL", "INFO", "MESSAGE", fmt.Sprintf("Queue shard %s has %d messages waiting", d.Name, d.Depth))
+		}
+	}
+}
+
+// keyValue returns the key value store selected by KEYVALUE_BACKEND, which is
+// "redis" by default, "memory" or "bolt".
+//
+// The in-memory store is only shared by the API and the workers of a single
+// process, so it is only suitable for running a single node. Redis is cached
+// in memory if KEYVALUE_L1_LIMIT is set.
+func (b *backends) keyValue() (keyvalue.KeyValue, error) {
+	switch backend := os.Getenv("KEYVALUE_BACKEND"); backend {
+	case "", "redis":
+		rc, err := b.redisClient()
+		if err != nil {
+			return nil, err
+		}
+		kv := keyvalue.NewRedisAdapterWithConfig(rc, keyvalue.RedisConfig{
+			Namespace: b.ns,
+			Codec:     b.codec,
+			HashTags:  isCluster(rc),
+		})
+		l1Bytes, err := getSize("KEYVALUE_L1_LIMIT")
+		if err != nil || l1Bytes == 0 {
+			return kv, err
+		}
+		// Pub/sub channels are shared by every database of a Redis server,
+		// so the channel is namespaced like the keys.
+		invalidator, err := keyvalue.NewRedisInvalidator(rc, b.ns.Key(invalidationChannel))
+		if err != nil {
+			return nil, err
+		}
+		l1 := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{MaxBytes: l1Bytes})
+		tiered := keyvalue.NewTieredKeyValue(keyvalue.TieredConfig{
+			L1:          l1,
+			L2:          kv,
+			Invalidator: invalidator,
+			OnPublishError: func(err error) {
+				_ = b.l.Log("LEVEL", "WARN", "MESSAGE", err.Error())
+			},
+		})
+		b.loops = append(b.loops, sweepMemory(l1), tiered.Run)
+		return tiered, nil
+	case "memory":
+		maxBytes, err := getSize("KEYVALUE_MEMORY_LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		m := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{
+			MaxBytes:          maxBytes,
+			Durable:           true,
+			CounterExpiration: memoryCounterExpiration,
+		})
+		b.loops = append(b.loops, sweepMemory(m))
+		return m, nil
+	case "bolt":
+		db, err := b.boltDB()
+		if err != nil {
+			return nil, err
+		}
+		kv, err := keyvalue.NewBoltAdapter(db)
+		if err != nil {
+			return nil, err
+		}
+		b.loops = append(b.loops, func(ctx context.Context) error {
+			kv.Run(ctx, memorySweepInterval, func(err error) {
+				_ = b.l.Log("LEVEL", "WARN", "MESSAGE", fmt.Sprintf("Unable to sweep expired keys: %v", err))
+			})
+			return nil
+		})
+		return kv, nil
+	default:
+		return nil, errors.Errorf("unknown KEYVALUE_BACKEND %q", backend)
+	}
+}
+
+// sweepMemory returns a loop that removes expired keys from m.
+func sweepMemory(m *keyvalue.MemoryAdapter) func(context.Context) error {
+	return func(ctx context.Context) error {
+		m.Run(ctx, memorySweepInterval)
+		return nil
+	}
+}
+
+// close closes the opened stores.
+//
+// The sharded queue is closed first, so that the messages it pulled but did
+// not hand out are pushed back to their nodes.
+func (b *backends) close() {
+	if b.sharded != nil {
+		_ = b.sharded.Close()
+	}
+	for _, c := range b.queueShards {
+		_ = c.Close()
+	}
+	if b.bolt != nil {
+		_ = b.bolt.Close()
+	}
+	if b.redis != nil {
+		_ = b.redis.Close()
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+)
+
+// RunNamespace runs the namespace tool with the command line arguments that
+// follow "namespace", and exits with its status:
+//
+//	namespace [-namespace name] [-keys] list
+//	namespace [-namespace name] clean
+//
+// "list" prints the number of keys of each kind in the namespace, and every
+// key with -keys. "clean" deletes every key in the namespace. The namespace is
+// REDIS_NAMESPACE unless -namespace is set, and Redis is found at
+// REDIS_ADDRESS like for the services. The queue shards in QUEUE_SHARDS are
+// listed and cleaned too.
+func RunNamespace(args []string) {
+	if err := runNamespace(context.Background(), os.Stdout, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runNamespace(ctx context.Context, w io.Writer, args []string) error {
+	flags := flag.NewFlagSet("namespace", flag.ContinueOnError)
+	name := flags.String("namespace", os.Getenv("REDIS_NAMESPACE"), "namespace of the keys")
+	showKeys := flags.Bool("keys", false, "list every key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: namespace [-namespace name] [-keys] list|clean")
+	}
+	ns := keyspace.Namespace(*name)
+	if err := ns.Validate(); err != nil {
+		return err
+	}
+
+	clients, err := getNamespaceClients()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, c := range clients {
+			_ = c.Close()
+		}
+	}()
+
+	switch command := flags.Arg(0); command {
+	case "list":
+		counts := make(map[keyspace.Kind]int)
+		for _, client := range clients {
+			err := keyspace.List(ctx, client, ns, func(e keyspace.Entry) error {
+				counts[e.Kind]++
+				if *showKeys {
+					_, err := fmt.Fprintf(w, "%s\t%s\n", e.Kind, e.Key)
+					return errors.WithStack(err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		kinds := make([]string, 0, len(counts))
+		for kind := range counts {
+			kinds = append(kinds, string(kind))
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "%d %s keys\n", counts[keyspace.Kind(kind)], kind)
+		}
+		return nil
+	case "clean":
+		var deleted int64
+		for _, client := range clients {
+			n, err := keyspace.Clean(ctx, client, ns)
+			deleted += n
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(w, "Deleted %d keys in namespace %q\n", deleted, string(ns))
+		return errors.WithStack(err)
+	default:
+		return errors.Errorf("unknown command %q", command)
+	}
+}
+
+// getNamespaceClients connects to the Redis of REDIS_ADDRESS and to the queue
+// shards in QUEUE_SHARDS, leaving out a shard that is the node of
+// REDIS_ADDRESS so that its keys are not listed twice.
+func getNamespaceClients() ([]redis.UniversalClient, error) {
+	client, err := getRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	shards, err := getQueueShardClients()
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	clients := []redis.UniversalClient{client}
+	for _, shard := range shards {
+		if node, ok := client.(*redis.Client); ok && node.Options().Addr == shard.Options().Addr {
+			_ = shard.Close()
+			continue
+		}
+		clients = append(clients, shard)
+	}
+	return clients, nil
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// getRedisClient connects to Redis as configured by environment variables:
+//
+//   - REDIS_MODE is "single" (the default) for a single node, "sentinel" for
+//     the master named REDIS_MASTER found by Sentinel, or "cluster" for Redis
+//     Cluster.
+//   - REDIS_ADDRESS is the address of the node, or a comma separated list of
+//     the addresses of the sentinels or of some nodes of the cluster.
+//   - REDIS_PASSWORD authenticates with a password, as REDIS_USERNAME if it
+//     is set for an ACL user. Sentinels are connected to without a password.
+//   - REDIS_TLS=true connects with TLS, verifying the server with the CA
+//     certificates in REDIS_TLS_CA or the system's, as REDIS_TLS_SERVER_NAME
+//     if it is set. REDIS_TLS_CERT and REDIS_TLS_KEY are the files of a client
+//     certificate.
+func getRedisClient() (redis.UniversalClient, error) {
+	address, ok := os.LookupEnv("REDIS_ADDRESS")
+	if !ok {
+		return nil, errors.New("missing Redis address")
+	}
+	var addrs []string
+	for _, addr := range strings.Split(address, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("missing Redis address")
+	}
+	auth, err := getRedisAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	var client redis.UniversalClient
+	switch mode := os.Getenv("REDIS_MODE"); mode {
+	case "", "single":
+		if len(addrs) != 1 {
+			return nil, errors.New("REDIS_ADDRESS must be a single address for a single node")
+		}
+		client = newRedisNode(addrs[0], auth)
+	case "sentinel":
+		master := os.Getenv("REDIS_MASTER")
+		if master == "" {
+			return nil, errors.New("missing REDIS_MASTER for Sentinel")
+		}
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    master,
+			SentinelAddrs: addrs,
+			Password:      auth.password,
+			OnConnect:     auth.onConnect,
+			TLSConfig:     auth.tls,
+			DB:            0,
+			MaxRetries:    10,
+			DialTimeout:   10 * time.Second,
+			ReadTimeout:   2 * time.Second,
+			WriteTimeout:  2 * time.Second,
+		})
+	case "cluster":
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        addrs,
+			Password:     auth.password,
+			OnConnect:    auth.onConnect,
+			TLSConfig:    auth.tls,
+			MaxRetries:   10,
+			DialTimeout:  10 * time.Second,
+			ReadTimeout:  2 * time.Second,
+			WriteTimeout: 2 * time.Second,
+		})
+	default:
+		return nil, errors.Errorf("unknown REDIS_MODE %q", mode)
+	}
+	if err := client.Ping().Err(); err != nil {
+		_ = client.Close()
+		return nil, errors.WithStack(err)
+	}
+	return client, nil
+}
+
+// redisAuth is how every Redis connection authenticates and is encrypted.
+type redisAuth struct {
+	password  string
+	onConnect func(*redis.Conn) error
+	tls       *tls.Config
+}
+
+// getRedisAuth gets the credentials and the TLS configuration of Redis from
+// the REDIS_USERNAME, REDIS_PASSWORD and REDIS_TLS environment variables.
+func getRedisAuth() (redisAuth, error) {
+	tlsConfig, err := getRedisTLS()
+	if err != nil {
+		return redisAuth{}, err
+	}
+	auth := redisAuth{password: os.Getenv("REDIS_PASSWORD"), tls: tlsConfig}
+	if username := os.Getenv("REDIS_USERNAME"); username != "" {
+		// The client only authenticates with a password, so ACL users
+		// authenticate themselves on every new connection.
+		password := auth.password
+		auth.onConnect = func(conn *redis.Conn) error {
+			return conn.Process(redis.NewStatusCmd("auth", username, password))
+		}
+		auth.password = ""
+	}
+	return auth, nil
+}
+
+// newRedisNode creates a client of a single Redis node.
+func newRedisNode(addr string, auth redisAuth) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     auth.password,
+		OnConnect:    auth.onConnect,
+		TLSConfig:    auth.tls,
+		DB:           0,
+		MaxRetries:   10,
+		DialTimeout:  10 * time.Second,
+		ReadTimeout:  2 * time.Second,
+		WriteTimeout: 2 * time.Second,
+	})
+}
+
+// getQueueShardClients connects to the Redis nodes in the comma separated
+// QUEUE_SHARDS that the queue is sharded over, or returns none if it is not
+// set. The nodes are connected to like a single node of REDIS_ADDRESS.
+func getQueueShardClients() ([]*redis.Client, error) {
+	auth, err := getRedisAuth()
+	if err != nil {
+		return nil, err
+	}
+	var clients []*redis.Client
+	for _, addr := range strings.Split(os.Getenv("QUEUE_SHARDS"), ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		client := newRedisNode(addr, auth)
+		clients = append(clients, client)
+		if err := client.Ping().Err(); err != nil {
+			for _, c := range clients {
+				_ = c.Close()
+			}
+			return nil, errors.Wrapf(err, "unable to connect to queue shard %s", addr)
+		}
+	}
+	return clients, nil
+}
+
+// getRedisTLS gets the TLS configuration for connecting to Redis from the
+// REDIS_TLS environment variables, or nil if TLS is not enabled.
+func getRedisTLS() (*tls.Config, error) {
+	enabled, err := strconv.ParseBool(os.Getenv("REDIS_TLS"))
+	if err != nil && os.Getenv("REDIS_TLS") != "" {
+		return nil, errors.Wrap(err, "invalid REDIS_TLS")
+	}
+	if !enabled {
+		return nil, nil
+	}
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: os.Getenv("REDIS_TLS_SERVER_NAME"),
+	}
+	if path := os.Getenv("REDIS_TLS_CA"); path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read REDIS_TLS_CA")
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates in REDIS_TLS_CA %s", path)
+		}
+	}
+	certFile, keyFile := os.Getenv("REDIS_TLS_CERT"), os.Getenv("REDIS_TLS_KEY")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load REDIS_TLS_CERT and REDIS_TLS_KEY")
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// isCluster reports whether c is a Redis Cluster client, whose keys are stored
+// with hash tags.
+func isCluster(c redis.UniversalClient) bool {
+	_, ok := c.(*redis.ClusterClient)
+	return ok
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/envelope"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// RunReencrypt runs the re-encryption tool with the command line arguments
+// that follow "reencrypt", and exits with its status:
+//
+//	reencrypt [-namespace name] [-keyring path]
+//
+// Every value in the namespace that is not encrypted under the primary key of
+// the keyring is re-encrypted under it, keeping its expiration, so that older
+// keys can be removed from the keyring afterwards. Values that were stored
+// before encryption was enabled are encrypted too. Counters are not
+// encrypted, and queued messages are left to be drained. The keyring is
+// ENCRYPTION_KEYRING unless -keyring is set, and the key value store of
+// KEYVALUE_BACKEND and the namespace are found like for the services.
+func RunReencrypt(args []string) {
+	if err := runReencrypt(context.Background(), os.Stdout, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runReencrypt(ctx context.Context, w io.Writer, args []string) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	name := flags.String("namespace", os.Getenv("REDIS_NAMESPACE"), "namespace of the keys")
+	path := flags.String("keyring", os.Getenv("ENCRYPTION_KEYRING"), "keyring file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || *path == "" {
+		return errors.New("usage: reencrypt [-namespace name] [-keyring path]")
+	}
+	ns := keyspace.Namespace(*name)
+	if err := ns.Validate(); err != nil {
+		return err
+	}
+	keyring, err := envelope.LoadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	conf := envelope.Config{Keyring: keyring}
+
+	var rewritten, kept int
+	reencrypt := func(kv *envelope.KeyValue, key string, expiration time.Duration) error {
+		ok, err := kv.Reencrypt(ctx, key, expiration)
+		if err != nil {
+			return err
+		}
+		if ok {
+			rewritten++
+		} else {
+			kept++
+		}
+		return nil
+	}
+	switch backend := os.Getenv("KEYVALUE_BACKEND"); backend {
+	case "", "redis":
+		err = reencryptRedis(ctx, ns, conf, reencrypt)
+	case "bolt":
+		err = reencryptBolt(ctx, conf, reencrypt)
+	case "memory":
+		return errors.New("values of KEYVALUE_BACKEND=memory are lost when the services stop, there is nothing to re-encrypt")
+	default:
+		return errors.Errorf("unknown KEYVALUE_BACKEND %q", backend)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Re-encrypted %d values under key %q, %d were up to date or changed\n",
+		rewritten, keyring.Primary(), kept)
+	return errors.WithStack(err)
+}
+
+// reencryptRedis calls reencrypt with every value in the namespace ns of Redis
+// and its expiration.
+func reencryptRedis(ctx context.Context, ns keyspace.Namespace, conf envelope.Config, reencrypt func(*envelope.KeyValue, string, time.Duration) error) error {
+	valueCodec, err := getCodec()
+	if err != nil {
+		return err
+	}
+	client, err := getRedisClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	kv := envelope.NewKeyValue(
+		keyvalue.NewRedisAdapterWithConfig(client, keyvalue.RedisConfig{
+			Namespace: ns,
+			Codec:     valueCodec,
+			HashTags:  isCluster(client),
+		}),
+		conf,
+	)
+
+	return keyspace.List(ctx, client, ns, func(e keyspace.Entry) error {
+		if e.Kind == keyspace.KindCounter || e.Kind == keyspace.KindQueue {
+			return nil
+		}
+		ttl, err := client.PTTL(e.RedisKey).Result()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// PTTL is -2ms for keys that expired in the meantime, and -1ms for
+		// keys that never expire.
+		switch {
+		case ttl == -2*time.Millisecond:
+			return nil
+		case ttl < 0:
+			ttl = 0
+		}
+		return reencrypt(kv, e.Key, ttl)
+	})
+}
+
+// reencryptBolt calls reencrypt with every value in the embedded database at
+// BOLT_PATH and its expiration. The services must be stopped, since only one
+// process can open the database.
+func reencryptBolt(ctx context.Context, conf envelope.Config, reencrypt func(*envelope.KeyValue, string, time.Duration) error) error {
+	storage := &backends{l: log.NewNopLogger()}
+	defer storage.close()
+	db, err := storage.boltDB()
+	if err != nil {
+		return err
+	}
+	store, err := keyvalue.NewBoltAdapter(db)
+	if err != nil {
+		return err
+	}
+	kv := envelope.NewKeyValue(store, conf)
+
+	keys, err := store.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	for key, expiration := range keys {
+		if err := reencrypt(kv, key, expiration); err != nil {
+			return err
+		}
+	}
+	// The database may not sync its commits, see BOLT_SYNC.
+	return errors.Wrap(db.Sync(), "unable to sync database")
+}
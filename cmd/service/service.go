@@ -5,42 +5,146 @@ import (
 	"net"
 	gohttp "net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
 
-	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
 	"github.com/rwool/saas-interview-challenge1/pkg/endpoint"
 	"github.com/rwool/saas-interview-challenge1/pkg/http"
 	"github.com/rwool/saas-interview-challenge1/pkg/queuesubscribe"
 	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/codec"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/docstore"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/envelope"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
 	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
 	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/search"
 )
 
-const workerQueueName = "worker_document_parser"
+const (
+	workerQueueName = keyspace.WorkerQueue
 
-func getRedisClient() (*redis.Client, error) {
-	address, ok := os.LookupEnv("REDIS_ADDRESS")
+	// blobExpiration is how long streamed documents are kept for workers to
+	// process them.
+	blobExpiration = 10 * time.Minute
+)
+
+// getSize gets a size in bytes from the environment variable name.
+//
+// 0 is returned if the variable is not set so that the default is used.
+func getSize(name string) (int64, error) {
+	v, ok := os.LookupEnv(name)
 	if !ok {
-		return nil, errors.New("missing Redis address")
+		return 0, nil
 	}
+	size, err := strconv.ParseInt(v, 10, 64)
+	return size, errors.Wrapf(err, "invalid size for %s", name)
+}
 
-	client := redis.NewClient(&redis.Options{
-		Addr:         address,
-		Password:     "",
-		DB:           0,
-		MaxRetries:   10,
-		DialTimeout:  10 * time.Second,
-		ReadTimeout:  2 * time.Second,
-		WriteTimeout: 2 * time.Second,
-	})
-	if err := client.Ping().Err(); err != nil {
-		return nil, errors.WithStack(err)
+// getBool gets a boolean from the environment variable name. It is false if
+// the variable is not set.
+func getBool(name string) (bool, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	return b, errors.Wrapf(err, "invalid %s", name)
+}
+
+// getRetention gets the global and per tenant retention of reports from the
+// RESULT_RETENTION and TENANT_RETENTION environment variables.
+func getRetention() (service.Retention, map[string]service.Retention, error) {
+	var retention service.Retention
+	if v, ok := os.LookupEnv("RESULT_RETENTION"); ok {
+		r, err := service.ParseRetention(v)
+		if err != nil {
+			return retention, nil, errors.Wrap(err, "invalid RESULT_RETENTION")
+		}
+		retention = r
+	}
+	tenants, err := service.ParseTenantRetention(os.Getenv("TENANT_RETENTION"))
+	return retention, tenants, errors.Wrap(err, "invalid TENANT_RETENTION")
+}
+
+// getMaxRetention gets the longest retention that a request can select from
+// the MAX_RETENTION environment variable.
+func getMaxRetention() (service.Retention, error) {
+	v, ok := os.LookupEnv("MAX_RETENTION")
+	if !ok {
+		return service.Retention{}, nil
+	}
+	r, err := service.ParseRetention(v)
+	return r, errors.Wrap(err, "invalid MAX_RETENTION")
+}
+
+// getTenantKeys gets the API key of each tenant from the TENANT_KEYS
+// environment variable, a comma separated list of tenant=key pairs.
+func getTenantKeys() (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("TENANT_KEYS"), ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid TENANT_KEYS entry for tenant %q", parts[0])
+		}
+		keys[parts[0]] = parts[1]
 	}
-	return client, nil
+	return keys, nil
+}
+
+// getNamespace gets the namespace of the keys and queues kept in Redis from
+// the REDIS_NAMESPACE environment variable.
+func getNamespace() (keyspace.Namespace, error) {
+	ns := keyspace.Namespace(os.Getenv("REDIS_NAMESPACE"))
+	return ns, errors.Wrap(ns.Validate(), "invalid REDIS_NAMESPACE")
+}
+
+// getCodec gets the codec of the values and messages kept in Redis from the
+// VALUE_ENCODING and VALUE_COMPRESSION_THRESHOLD environment variables.
+func getCodec() (*codec.Codec, error) {
+	conf := codec.Config{Encoding: codec.Raw}
+	if v, ok := os.LookupEnv("VALUE_ENCODING"); ok {
+		e, err := codec.ParseEncoding(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid VALUE_ENCODING")
+		}
+		conf.Encoding = e
+	}
+	threshold, err := getSize("VALUE_COMPRESSION_THRESHOLD")
+	if err != nil {
+		return nil, err
+	}
+	conf.Threshold = int(threshold)
+	c, err := codec.New(conf)
+	return c, errors.Wrap(err, "invalid VALUE_ENCODING")
+}
+
+// getEncryption gets the configuration for encrypting stored values and
+// queued messages from the ENCRYPTION_KEYRING and ENCRYPTION_ALLOW_PLAINTEXT
+// environment variables. Nothing is encrypted if ENCRYPTION_KEYRING is not
+// set.
+func getEncryption() (*envelope.Config, error) {
+	path := os.Getenv("ENCRYPTION_KEYRING")
+	if path == "" {
+		return nil, nil
+	}
+	keyring, err := envelope.LoadKeyring(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := &envelope.Config{Keyring: keyring}
+	allow, err := getBool("ENCRYPTION_ALLOW_PLAINTEXT")
+	conf.AllowPlaintext = allow
+	return conf, err
 }
 
 // Run runs the API and Worker services.
@@ -62,29 +166,129 @@ type Config struct {
 func setup() {
 	l := log.NewJSONLogger(os.Stderr)
 
-	rc, err := getRedisClient()
+	maxDocumentSize, err := getSize("MAX_DOCUMENT_SIZE")
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	shardSize, err := getSize("SHARD_SIZE")
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	retention, tenantRetention, err := getRetention()
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	maxRetention, err := getMaxRetention()
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	tenantKeys, err := getTenantKeys()
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	legacyIDs, err := getBool("LEGACY_DOCUMENT_IDS")
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	keepDocuments, err := getBool("DOCUMENT_STORE")
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	ns, err := getNamespace()
 	if err != nil {
 		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
 		os.Exit(1)
 	}
-	q := queue.NewRedisAdapter(rc)
-	kv := keyvalue.NewRedisAdapter(rc)
+	valueCodec, err := getCodec()
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	encryption, err := getEncryption()
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	storage := &backends{l: l, ns: ns, codec: valueCodec}
+	defer storage.close()
+	q, err := storage.queue()
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	kv, err := storage.keyValue()
+	if err != nil {
+		_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+		os.Exit(1)
+	}
+	if encryption != nil {
+		// Encryption wraps the whole store, including its in-memory cache,
+		// so it works the same with every backend.
+		q = envelope.NewQueue(q, *encryption)
+		kv = envelope.NewKeyValue(kv, *encryption)
+	}
+	blobs := blob.NewStore(kv, blob.Config{Expiration: blobExpiration})
+	// The search index is shared by the API and every worker through the key
+	// value store.
+	index := search.NewIndex(kv, search.Config{})
+	// Submitted documents are only kept, and searchable, if the document
+	// store is enabled, as they are kept until they are deleted.
+	var documents *docstore.Store
+	if keepDocuments {
+		documents = docstore.NewStore(kv, docstore.Config{})
+	}
 
 	// Business logic.
-	apiService := service.NewAPIService(q, kv, workerQueueName, l)
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:           q,
+		KeyVal:          kv,
+		Log:             l,
+		Channel:         workerQueueName,
+		Blobs:           blobs,
+		MaxDocumentSize: maxDocumentSize,
+		Index:           index,
+		Documents:       documents,
+		Retention:       retention,
+		TenantRetention: tenantRetention,
+		MaxRetention:    maxRetention,
+		LegacyIDs:       legacyIDs,
+	})
 	workerService := service.NewWorkerService(service.WorkerServiceConfig{
-		Queue:   q,
-		KeyVal:  kv,
-		Log:     l,
-		Channel: workerQueueName,
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       l,
+		Channel:   workerQueueName,
+		Blobs:     blobs,
+		ShardSize: int(shardSize),
+		Index:     index,
 	})
 
 	// Endpoints.
-	apiEndpoint := endpoint.MakeAPIProcessDocumentEndpoint(apiService)
+	apiEndpoints := http.APIEndpoints{
+		ProcessDocument:       endpoint.MakeAPIProcessDocumentEndpoint(apiService),
+		AddToCollection:       endpoint.MakeAPIAddToCollectionEndpoint(apiService),
+		RemoveFromCollection:  endpoint.MakeAPIRemoveFromCollectionEndpoint(apiService),
+		CollectionFrequencies: endpoint.MakeAPICollectionFrequenciesEndpoint(apiService),
+		Similarity:            endpoint.MakeAPISimilarityEndpoint(apiService),
+		NearDuplicates:        endpoint.MakeAPINearDuplicatesEndpoint(apiService),
+		Search:                endpoint.MakeAPISearchEndpoint(apiService),
+		Document:              endpoint.MakeAPIDocumentEndpoint(apiService),
+		DocumentContent:       endpoint.MakeAPIDocumentContentEndpoint(apiService),
+		ListDocuments:         endpoint.MakeAPIListDocumentsEndpoint(apiService),
+		DeleteDocument:        endpoint.MakeAPIDeleteDocumentEndpoint(apiService),
+		JobStatus:             endpoint.MakeAPIJobStatusEndpoint(apiService),
+	}
 	workerEndpoint := endpoint.MakeWorkerParseDocumentEndpoint(workerService)
 
 	// Transports.
-	httpHandler := http.NewAPIHTTPHandler(apiEndpoint, nil)
+	httpHandler := http.NewTenantHandler(http.NewAPIHTTPHandler(apiEndpoints, nil), tenantKeys)
 	subscriber := queuesubscribe.MakeWorkerHandler(queuesubscribe.Config{
 		Endpoint: workerEndpoint,
 		Queue:    q,
@@ -112,6 +316,15 @@ func setup() {
 		defer wg.Done()
 		subscriber(ctx)
 	}()
+	for _, loop := range storage.loops {
+		wg.Add(1)
+		go func(loop func(context.Context) error) {
+			defer wg.Done()
+			if err := loop(ctx); err != nil {
+				_ = l.Log("LEVEL", "ERROR", "MESSAGE", err)
+			}
+		}(loop)
+	}
 	wg.Wait()
 }
 
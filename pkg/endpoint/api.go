@@ -2,7 +2,6 @@ package endpoint
 
 import (
 	"context"
-	"time"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/rwool/saas-interview-challenge1/pkg/service"
@@ -20,14 +19,29 @@ type ProcessDocumentResponse struct {
 	e error
 }
 
+// Failed indicates if there was a business logic failure.
+func (p ProcessDocumentResponse) Failed() error {
+	return p.e
+}
+
 // MakeAPIProcessDocumentEndpoint creates an endpoint for processing documents.
 func MakeAPIProcessDocumentEndpoint(a service.APIService) endpoint.Endpoint {
-	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-		defer cancel()
-
-		req := request.(service.DocumentRequest)
-		dfr, err := a.ProcessDocument(ctx, req)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		var dfr service.DocumentFrequenciesResponse
+		var err error
+		// Streamed documents and archives are given service.ProcessTimeout
+		// once their body has been read, since the upload itself can take
+		// longer than processing it.
+		switch req := request.(type) {
+		case service.DocumentStreamRequest:
+			dfr, err = a.ProcessDocumentStream(ctx, req)
+		case service.ArchiveRequest:
+			dfr, err = a.ProcessArchive(ctx, req)
+		default:
+			ctx, cancel := context.WithTimeout(ctx, service.ProcessTimeout)
+			defer cancel()
+			dfr, err = a.ProcessDocument(ctx, req.(service.DocumentRequest))
+		}
 		return ProcessDocumentResponse{
 			MessageMetadata:             MessageMetadata{},
 			DocumentFrequenciesResponse: dfr,
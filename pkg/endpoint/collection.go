@@ -0,0 +1,83 @@
+package endpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+// CollectionResponse contains the response for a call to the AddToCollection
+// or RemoveFromCollection endpoints.
+type CollectionResponse struct {
+	MessageMetadata
+	service.CollectionResponse
+	e error
+}
+
+// Failed indicates if there was a business logic failure.
+func (c CollectionResponse) Failed() error {
+	return c.e
+}
+
+// CollectionFrequenciesResponse contains the response for a call to the
+// CollectionFrequencies endpoint.
+type CollectionFrequenciesResponse struct {
+	MessageMetadata
+	service.CollectionFrequenciesResponse
+	e error
+}
+
+// Failed indicates if there was a business logic failure.
+func (c CollectionFrequenciesResponse) Failed() error {
+	return c.e
+}
+
+// MakeAPIAddToCollectionEndpoint creates an endpoint for adding documents to
+// collections.
+func MakeAPIAddToCollectionEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		cr, err := a.AddToCollection(ctx, request.(service.CollectionDocumentRequest))
+		return CollectionResponse{
+			MessageMetadata:    MessageMetadata{},
+			CollectionResponse: cr,
+			e:                  err,
+		}, nil
+	}
+}
+
+// MakeAPIRemoveFromCollectionEndpoint creates an endpoint for removing
+// documents from collections.
+func MakeAPIRemoveFromCollectionEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		cr, err := a.RemoveFromCollection(ctx, request.(service.CollectionRemoveRequest))
+		return CollectionResponse{
+			MessageMetadata:    MessageMetadata{},
+			CollectionResponse: cr,
+			e:                  err,
+		}, nil
+	}
+}
+
+// MakeAPICollectionFrequenciesEndpoint creates an endpoint for getting the most
+// frequent words of collections.
+func MakeAPICollectionFrequenciesEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		cfr, err := a.CollectionFrequencies(ctx, request.(service.CollectionFrequenciesRequest))
+		return CollectionFrequenciesResponse{
+			MessageMetadata:               MessageMetadata{},
+			CollectionFrequenciesResponse: cfr,
+			e:                             err,
+		}, nil
+	}
+}
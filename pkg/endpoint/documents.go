@@ -0,0 +1,112 @@
+package endpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+// StoredDocumentResponse contains the response for a call to the Document or
+// DeleteDocument endpoints.
+type StoredDocumentResponse struct {
+	MessageMetadata
+	service.StoredDocumentResponse
+	e error
+}
+
+// Failed indicates if there was a business logic failure.
+func (s StoredDocumentResponse) Failed() error {
+	return s.e
+}
+
+// DocumentContentResponse contains the response for a call to the
+// DocumentContent endpoint.
+type DocumentContentResponse struct {
+	MessageMetadata
+	service.DocumentContentResponse
+	e error
+}
+
+// Failed indicates if there was a business logic failure.
+func (d DocumentContentResponse) Failed() error {
+	return d.e
+}
+
+// ListDocumentsResponse contains the response for a call to the ListDocuments
+// endpoint.
+type ListDocumentsResponse struct {
+	MessageMetadata
+	service.ListDocumentsResponse
+	e error
+}
+
+// Failed indicates if there was a business logic failure.
+func (l ListDocumentsResponse) Failed() error {
+	return l.e
+}
+
+// MakeAPIDocumentEndpoint creates an endpoint for getting the metadata of
+// stored documents.
+func MakeAPIDocumentEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		sdr, err := a.Document(ctx, request.(service.DocumentLookupRequest))
+		return StoredDocumentResponse{
+			MessageMetadata:        MessageMetadata{},
+			StoredDocumentResponse: sdr,
+			e:                      err,
+		}, nil
+	}
+}
+
+// MakeAPIDocumentContentEndpoint creates an endpoint for reading the contents
+// of stored documents.
+//
+// The contents are read after the endpoint returns, so no timeout is applied
+// to them.
+func MakeAPIDocumentContentEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		dcr, err := a.DocumentContent(ctx, request.(service.DocumentLookupRequest))
+		return DocumentContentResponse{
+			MessageMetadata:         MessageMetadata{},
+			DocumentContentResponse: dcr,
+			e:                       err,
+		}, nil
+	}
+}
+
+// MakeAPIListDocumentsEndpoint creates an endpoint for listing stored
+// documents.
+func MakeAPIListDocumentsEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		ldr, err := a.ListDocuments(ctx, request.(service.ListDocumentsRequest))
+		return ListDocumentsResponse{
+			MessageMetadata:       MessageMetadata{},
+			ListDocumentsResponse: ldr,
+			e:                     err,
+		}, nil
+	}
+}
+
+// MakeAPIDeleteDocumentEndpoint creates an endpoint for deleting stored
+// documents.
+func MakeAPIDeleteDocumentEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		sdr, err := a.DeleteDocument(ctx, request.(service.DocumentLookupRequest))
+		return StoredDocumentResponse{
+			MessageMetadata:        MessageMetadata{},
+			StoredDocumentResponse: sdr,
+			e:                      err,
+		}, nil
+	}
+}
@@ -0,0 +1,38 @@
+package endpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+// JobStatusResponse contains the response for a call to the JobStatus
+// endpoint.
+type JobStatusResponse struct {
+	MessageMetadata
+	service.JobStatusResponse
+	e error
+}
+
+// Failed indicates if there was a business logic failure.
+func (j JobStatusResponse) Failed() error {
+	return j.e
+}
+
+// MakeAPIJobStatusEndpoint creates an endpoint for looking up the state of the
+// jobs analyzing documents.
+func MakeAPIJobStatusEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		jsr, err := a.JobStatus(ctx, request.(service.JobStatusRequest))
+		return JobStatusResponse{
+			MessageMetadata:   MessageMetadata{},
+			JobStatusResponse: jsr,
+			e:                 err,
+		}, nil
+	}
+}
@@ -0,0 +1,36 @@
+package endpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+// SearchResponse contains the response for a call to the Search endpoint.
+type SearchResponse struct {
+	MessageMetadata
+	service.SearchResponse
+	e error
+}
+
+// Failed indicates if there was a business logic failure.
+func (s SearchResponse) Failed() error {
+	return s.e
+}
+
+// MakeAPISearchEndpoint creates an endpoint for searching documents.
+func MakeAPISearchEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		sr, err := a.Search(ctx, request.(service.SearchRequest))
+		return SearchResponse{
+			MessageMetadata: MessageMetadata{},
+			SearchResponse:  sr,
+			e:               err,
+		}, nil
+	}
+}
@@ -0,0 +1,53 @@
+package endpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+// SimilarityResponse contains the response for a call to the Similarity or
+// NearDuplicates endpoints.
+type SimilarityResponse struct {
+	MessageMetadata
+	service.SimilarityResponse
+	e error
+}
+
+// Failed indicates if there was a business logic failure.
+func (s SimilarityResponse) Failed() error {
+	return s.e
+}
+
+// MakeAPISimilarityEndpoint creates an endpoint for comparing documents.
+func MakeAPISimilarityEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		sr, err := a.Similarity(ctx, request.(service.SimilarityRequest))
+		return SimilarityResponse{
+			MessageMetadata:    MessageMetadata{},
+			SimilarityResponse: sr,
+			e:                  err,
+		}, nil
+	}
+}
+
+// MakeAPINearDuplicatesEndpoint creates an endpoint for finding
+// near-duplicates of documents.
+func MakeAPINearDuplicatesEndpoint(a service.APIService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		sr, err := a.NearDuplicates(ctx, request.(service.NearDuplicatesRequest))
+		return SimilarityResponse{
+			MessageMetadata:    MessageMetadata{},
+			SimilarityResponse: sr,
+			e:                  err,
+		}, nil
+	}
+}
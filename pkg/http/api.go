@@ -5,23 +5,61 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	gohttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/pkg/errors"
 	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/archive"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
 
 	"github.com/go-kit/kit/transport/http"
 )
 
-// NewHTTPHandler returns a handler that makes the API service endpoints
+// APIEndpoints contains the endpoints of the API service. Endpoints that are
+// nil are not made available.
+type APIEndpoints struct {
+	ProcessDocument       endpoint.Endpoint
+	AddToCollection       endpoint.Endpoint
+	RemoveFromCollection  endpoint.Endpoint
+	CollectionFrequencies endpoint.Endpoint
+	Similarity            endpoint.Endpoint
+	NearDuplicates        endpoint.Endpoint
+	Search                endpoint.Endpoint
+	Document              endpoint.Endpoint
+	DocumentContent       endpoint.Endpoint
+	ListDocuments         endpoint.Endpoint
+	DeleteDocument        endpoint.Endpoint
+	JobStatus             endpoint.Endpoint
+}
+
+// NewAPIHTTPHandler returns a handler that makes the API service endpoints
 // available via HTTP.
-func NewAPIHTTPHandler(endpoint endpoint.Endpoint, options map[string][]http.ServerOption) gohttp.Handler {
+//
+// Options are keyed by the name of the endpoint in APIEndpoints.
+func NewAPIHTTPHandler(endpoints APIEndpoints, options map[string][]http.ServerOption) gohttp.Handler {
 	if options == nil {
 		options = make(map[string][]http.ServerOption)
 	}
 	m := gohttp.NewServeMux()
-	makeAPIProcessDocumentHandler(m, endpoint, options["ProcessDocument"]...)
+	if endpoints.ProcessDocument != nil {
+		makeAPIProcessDocumentHandler(m, endpoints.ProcessDocument, options["ProcessDocument"]...)
+	}
+	makeAPICollectionHandler(m, endpoints, options)
+	if endpoints.Similarity != nil {
+		makeAPISimilarityHandler(m, endpoints.Similarity, options["Similarity"]...)
+	}
+	makeAPIDocumentsHandler(m, endpoints, options)
+	if endpoints.Search != nil {
+		makeAPISearchHandler(m, endpoints.Search, options["Search"]...)
+	}
+	if endpoints.JobStatus != nil {
+		makeAPIJobStatusHandler(m, endpoints.JobStatus, options["JobStatus"]...)
+	}
 	return m
 }
 
@@ -29,10 +67,10 @@ type errorResponse struct {
 	Error string
 }
 
-func encodeAPIProcessDocumentResponse(_ context.Context, w gohttp.ResponseWriter, r interface{}) error {
+func encodeAPIResponse(_ context.Context, w gohttp.ResponseWriter, r interface{}) error {
 	w.Header().Set("Content-Type", "application/json")
 	if v, ok := r.(endpoint.Failer); ok && v.Failed() != nil {
-		w.WriteHeader(gohttp.StatusInternalServerError)
+		w.WriteHeader(errorStatus(v.Failed()))
 		_ = json.NewEncoder(w).Encode(errorResponse{Error: v.Failed().Error()})
 		return nil
 	}
@@ -40,7 +78,179 @@ func encodeAPIProcessDocumentResponse(_ context.Context, w gohttp.ResponseWriter
 	return errors.WithStack(err)
 }
 
-func decodeAPIProcessDocumentRequest(_ context.Context, req *gohttp.Request) (i interface{}, e error) {
+// errorStatus returns the HTTP status code for a business logic error.
+func errorStatus(err error) int {
+	switch errors.Cause(err) {
+	case service.ErrDocumentTooLarge:
+		return gohttp.StatusRequestEntityTooLarge
+	case service.ErrInvalidDocument, service.ErrInvalidRequest:
+		return gohttp.StatusBadRequest
+	case service.ErrNotFound:
+		return gohttp.StatusNotFound
+	default:
+		return gohttp.StatusInternalServerError
+	}
+}
+
+// streamedTypes are the media types of documents that are sent as the request
+// body instead of being wrapped in JSON.
+var streamedTypes = map[string]bool{
+	extract.TypePlain:       true,
+	extract.TypeHTML:        true,
+	extract.TypeMarkdown:    true,
+	extract.TypeCSV:         true,
+	"text/x-markdown":       true,
+	"application/xhtml+xml": true,
+}
+
+// decodeAPIProcessDocumentRequest decodes a request to process a document.
+//
+// Bodies with a document or archive media type are streamed to the service as
+// the document itself, with options read from the query string. All other
+// bodies are decoded as JSON.
+func decodeAPIProcessDocumentRequest(ctx context.Context, req *gohttp.Request) (interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if streamedTypes[mediaType] {
+		return decodeAPIProcessDocumentStreamRequest(ctx, req)
+	}
+	if archive.MediaType(mediaType) != "" {
+		return decodeAPIProcessArchiveRequest(ctx, req)
+	}
+	return decodeAPIProcessDocumentJSONRequest(ctx, req)
+}
+
+func decodeAPIProcessArchiveRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	query := req.URL.Query()
+	ar := service.ArchiveRequest{
+		Body:             req.Body,
+		ContentType:      mediaType,
+		JSONPath:         query.Get("json_path"),
+		CSVColumns:       csvColumns(query),
+		Stemmer:          query.Get("stemmer"),
+		Submission:       submission(query),
+		RetentionOptions: retentionOptions(query),
+	}
+	surfaceForms, err := boolOption(query, "surface_forms")
+	if err != nil {
+		return nil, err
+	}
+	ar.SurfaceForms = surfaceForms
+	duration, err := durationSeconds(query)
+	ar.DurationSeconds = duration
+	return ar, err
+}
+
+// csvColumns reads the CSV columns from a query string. Columns may be given
+// as a comma separated list, as repeated parameters or both.
+func csvColumns(query url.Values) []string {
+	var columns []string
+	for _, c := range query["csv_columns"] {
+		columns = append(columns, strings.Split(c, ",")...)
+	}
+	return columns
+}
+
+// submission reads who submitted a document from a query string. Tags may be
+// given as a comma separated list, as repeated parameters or both.
+func submission(query url.Values) service.Submission {
+	s := service.Submission{Submitter: query.Get("submitter")}
+	for _, t := range query["tags"] {
+		s.Tags = append(s.Tags, strings.Split(t, ",")...)
+	}
+	return s
+}
+
+// retentionOptions reads how long a report is kept for from a query string.
+// The tenant is not read from it, as it is authenticated by
+// NewTenantHandler instead.
+func retentionOptions(query url.Values) service.RetentionOptions {
+	return service.RetentionOptions{
+		RetainFor: query.Get("retain_for"),
+	}
+}
+
+// scoringOptions reads the options for ranking words against a collection
+// from a query string.
+func scoringOptions(query url.Values) (service.ScoringOptions, error) {
+	opts := service.ScoringOptions{
+		Mode:       query.Get("mode"),
+		Collection: query.Get("collection"),
+		Smoothing:  query.Get("smoothing"),
+	}
+	for name, v := range map[string]**float64{"k1": &opts.K1, "b": &opts.B} {
+		if s := query.Get(name); s != "" {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return opts, errors.Wrapf(err, "invalid %s", name)
+			}
+			*v = &f
+		}
+	}
+	if n := query.Get("n"); n != "" {
+		var err error
+		opts.N, err = strconv.Atoi(n)
+		if err != nil {
+			return opts, errors.Wrap(err, "invalid n")
+		}
+	}
+	return opts, nil
+}
+
+// boolOption reads an optional boolean from a query string.
+func boolOption(query url.Values, name string) (bool, error) {
+	v := query.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	return b, errors.Wrapf(err, "invalid %s", name)
+}
+
+func durationSeconds(query url.Values) (int, error) {
+	d := query.Get("duration_seconds")
+	if d == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(d)
+	return seconds, errors.Wrap(err, "invalid duration_seconds")
+}
+
+func decodeAPIProcessDocumentStreamRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	query := req.URL.Query()
+	dsr := service.DocumentStreamRequest{
+		Body:             req.Body,
+		Submission:       submission(query),
+		RetentionOptions: retentionOptions(query),
+		AnalysisOptions: service.AnalysisOptions{
+			ContentType: req.Header.Get("Content-Type"),
+			JSONPath:    query.Get("json_path"),
+			Language:    query.Get("language"),
+			Stemmer:     query.Get("stemmer"),
+		},
+	}
+	// The declared type can be overridden for formats that cannot be sent
+	// as the body directly, such as JSON documents.
+	if ct := query.Get("content_type"); ct != "" {
+		dsr.ContentType = ct
+	}
+	dsr.CSVColumns = csvColumns(query)
+	surfaceForms, err := boolOption(query, "surface_forms")
+	if err != nil {
+		return nil, err
+	}
+	dsr.SurfaceForms = surfaceForms
+	scoring, err := scoringOptions(query)
+	if err != nil {
+		return nil, err
+	}
+	dsr.ScoringOptions = scoring
+	duration, err := durationSeconds(query)
+	dsr.DurationSeconds = duration
+	return dsr, err
+}
+
+func decodeAPIProcessDocumentJSONRequest(_ context.Context, req *gohttp.Request) (i interface{}, e error) {
 	decoder := json.NewDecoder(req.Body)
 	decoder.DisallowUnknownFields()
 	defer func() {
@@ -68,7 +278,7 @@ func decodeAPIProcessDocumentRequest(_ context.Context, req *gohttp.Request) (i
 func makeAPIProcessDocumentHandler(m *gohttp.ServeMux, endpoint endpoint.Endpoint, options ...http.ServerOption) {
 	handler := http.NewServer(endpoint,
 		decodeAPIProcessDocumentRequest,
-		encodeAPIProcessDocumentResponse,
+		encodeAPIResponse,
 		options...)
 	hf := func(w gohttp.ResponseWriter, r *gohttp.Request) {
 		if r.Method != gohttp.MethodPost {
@@ -3,13 +3,18 @@ package http_test
 import (
 	"context"
 	"errors"
+	"io/ioutil"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/rwool/saas-interview-challenge1/pkg/endpoint"
 	"github.com/rwool/saas-interview-challenge1/pkg/http"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/docstore"
 )
 
 func TestHTTP(t *testing.T) {
@@ -20,7 +25,7 @@ func TestHTTP(t *testing.T) {
 		f := func(_ context.Context, request interface{}) (response interface{}, err error) {
 			return nil, nil
 		}
-		handler := http.NewAPIHTTPHandler(f, nil)
+		handler := http.NewAPIHTTPHandler(http.APIEndpoints{ProcessDocument: f}, nil)
 		req := httptest.NewRequest("POST", "http://something.com/document", strings.NewReader(`{"document": "abcd"}`))
 		rec := httptest.NewRecorder()
 		handler.ServeHTTP(rec, req)
@@ -32,11 +37,289 @@ func TestHTTP(t *testing.T) {
 		f := func(_ context.Context, request interface{}) (response interface{}, err error) {
 			return nil, errors.New("error")
 		}
-		handler := http.NewAPIHTTPHandler(f, nil)
+		handler := http.NewAPIHTTPHandler(http.APIEndpoints{ProcessDocument: f}, nil)
 		req := httptest.NewRequest("POST", "http://something.com/document", strings.NewReader(`{"document": "abcd"}`))
 		rec := httptest.NewRecorder()
 		handler.ServeHTTP(rec, req)
 		assert.Equal(t, "error", rec.Body.String(), "Error value should be in response.")
 		assert.Equal(t, 500, rec.Code, "Should have 500 status code.")
 	})
+	t.Run("Stream", func(t *testing.T) {
+		t.Parallel()
+		var body string
+		var duration int
+		f := func(_ context.Context, request interface{}) (response interface{}, err error) {
+			dsr, ok := request.(service.DocumentStreamRequest)
+			if !ok {
+				return nil, errors.New("unexpected request type")
+			}
+			b, err := ioutil.ReadAll(dsr.Body)
+			body = string(b)
+			duration = dsr.DurationSeconds
+			return nil, err
+		}
+		handler := http.NewAPIHTTPHandler(http.APIEndpoints{ProcessDocument: f}, nil)
+		req := httptest.NewRequest("POST", "http://something.com/document?duration_seconds=2", strings.NewReader("abcd efg"))
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, 200, rec.Code, "Should have 200 status code.")
+		assert.Equal(t, "abcd efg", body, "Document should be streamed to the endpoint.")
+		assert.Equal(t, 2, duration, "Duration should be read from the query string.")
+	})
+	t.Run("Stream Options", func(t *testing.T) {
+		t.Parallel()
+		var dsr service.DocumentStreamRequest
+		f := func(_ context.Context, request interface{}) (response interface{}, err error) {
+			dsr = request.(service.DocumentStreamRequest)
+			return nil, nil
+		}
+		handler := http.NewAPIHTTPHandler(http.APIEndpoints{ProcessDocument: f}, nil)
+		req := httptest.NewRequest("POST", "http://something.com/document?csv_columns=a,b&csv_columns=c&mode=bm25&collection=books&k1=1.5&n=3&tenant=acme&retain_for=sliding:1h", strings.NewReader("a,b,c"))
+		req.Header.Set("Content-Type", "text/csv")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, 200, rec.Code, "Should have 200 status code.")
+		assert.Equal(t, "text/csv", dsr.ContentType, "Content type should be read from the header.")
+		assert.Equal(t, []string{"a", "b", "c"}, dsr.CSVColumns, "Columns should be read from the query string.")
+		k1 := 1.5
+		assert.Equal(t, service.ScoringOptions{Mode: "bm25", Collection: "books", K1: &k1, N: 3}, dsr.ScoringOptions, "Scoring options should be read from the query string.")
+		assert.Equal(t, service.RetentionOptions{RetainFor: "sliding:1h"}, dsr.RetentionOptions, "Retention, but not the tenant, should be read from the query string.")
+	})
+	t.Run("Archive", func(t *testing.T) {
+		t.Parallel()
+		var ar service.ArchiveRequest
+		f := func(_ context.Context, request interface{}) (response interface{}, err error) {
+			ar = request.(service.ArchiveRequest)
+			return nil, nil
+		}
+		handler := http.NewAPIHTTPHandler(http.APIEndpoints{ProcessDocument: f}, nil)
+		req := httptest.NewRequest("POST", "http://something.com/document?json_path=$.body", strings.NewReader("PK"))
+		req.Header.Set("Content-Type", "application/x-gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, 200, rec.Code, "Should have 200 status code.")
+		assert.Equal(t, "application/x-gzip", ar.ContentType, "Archive type should be read from the header.")
+		assert.Equal(t, "$.body", ar.JSONPath, "JSONPath should be read from the query string.")
+	})
+}
+
+func TestTenantHandler(t *testing.T) {
+	t.Parallel()
+
+	var tenant string
+	f := func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		tenant = service.TenantFrom(ctx)
+		return nil, nil
+	}
+	handler := http.NewTenantHandler(http.NewAPIHTTPHandler(http.APIEndpoints{ProcessDocument: f}, nil),
+		map[string]string{"acme": "acme-key", "beta": "beta-key"})
+	for auth, want := range map[string]string{
+		"Bearer acme-key":  "acme",
+		"Bearer beta-key":  "beta",
+		"Bearer other-key": "",
+		"acme-key":         "",
+		"":                 "",
+	} {
+		req := httptest.NewRequest("POST", "http://something.com/document?tenant=acme", strings.NewReader(`{"document": "abcd"}`))
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, 200, rec.Code, "Should have 200 status code.")
+		assert.Equal(t, want, tenant, "Tenant should be authenticated by the API key %q.", auth)
+	}
+}
+
+func TestHTTPCollections(t *testing.T) {
+	t.Parallel()
+
+	var requests []interface{}
+	f := func(_ context.Context, request interface{}) (response interface{}, err error) {
+		requests = append(requests, request)
+		return nil, nil
+	}
+	handler := http.NewAPIHTTPHandler(http.APIEndpoints{
+		AddToCollection:       f,
+		RemoveFromCollection:  f,
+		CollectionFrequencies: f,
+	}, nil)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{"POST", "/collections/books/documents", `{"document": "abcd"}`, 200},
+		{"DELETE", "/collections/books/documents/a/b+c=", "", 200},
+		{"GET", "/collections/books/frequencies?n=5", "", 200},
+		{"GET", "/collections/books/documents", "", 405},
+		{"GET", "/collections/books/unknown", "", 404},
+		{"POST", "/document", `{"document": "abcd"}`, 404},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://something.com"+test.target, strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, test.code, rec.Code, "%s %s should have the expected status code.", test.method, test.target)
+	}
+
+	assert.Equal(t, []interface{}{
+		service.CollectionDocumentRequest{
+			Collection:      "books",
+			DocumentRequest: service.DocumentRequest{Document: "abcd"},
+		},
+		service.CollectionRemoveRequest{Collection: "books", DocumentID: "a/b+c="},
+		service.CollectionFrequenciesRequest{Collection: "books", N: 5},
+	}, requests, "Requests should be decoded from the path.")
+}
+
+func TestHTTPSimilarity(t *testing.T) {
+	t.Parallel()
+
+	var requests []interface{}
+	f := func(_ context.Context, request interface{}) (response interface{}, err error) {
+		requests = append(requests, request)
+		return nil, nil
+	}
+	handler := http.NewAPIHTTPHandler(http.APIEndpoints{
+		Similarity:     f,
+		NearDuplicates: f,
+	}, nil)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{"POST", "/similarity", `{"documents": ["a", "b"]}`, 200},
+		{"GET", "/documents/a/b+c=/near-duplicates?threshold=0.5", "", 200},
+		{"GET", "/similarity", "", 405},
+		{"GET", "/documents/a", "", 404},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://something.com"+test.target, strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, test.code, rec.Code, "%s %s should have the expected status code.", test.method, test.target)
+	}
+
+	assert.Equal(t, []interface{}{
+		service.SimilarityRequest{Documents: []string{"a", "b"}},
+		service.NearDuplicatesRequest{DocumentID: "a/b+c=", Threshold: 0.5},
+	}, requests, "Requests should be decoded.")
+}
+
+func TestHTTPSearch(t *testing.T) {
+	t.Parallel()
+
+	var sr service.SearchRequest
+	f := func(_ context.Context, request interface{}) (response interface{}, err error) {
+		sr = request.(service.SearchRequest)
+		return nil, nil
+	}
+	handler := http.NewAPIHTTPHandler(http.APIEndpoints{Search: f}, nil)
+	req := httptest.NewRequest("GET", `http://something.com/search?q="lazy+dog"+OR+cat&ranking=tfidf&offset=10&limit=5`, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, 200, rec.Code, "Should have 200 status code.")
+	assert.Equal(t, service.SearchRequest{Query: `"lazy dog" OR cat`, Ranking: "tfidf", Offset: 10, Limit: 5}, sr)
+
+	req = httptest.NewRequest("GET", "http://something.com/search?offset=x", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.NotEqual(t, 200, rec.Code, "Invalid offsets should be rejected.")
+}
+
+func TestHTTPJobStatus(t *testing.T) {
+	t.Parallel()
+
+	var jsr service.JobStatusRequest
+	f := func(_ context.Context, request interface{}) (response interface{}, err error) {
+		jsr = request.(service.JobStatusRequest)
+		return nil, nil
+	}
+	handler := http.NewAPIHTTPHandler(http.APIEndpoints{JobStatus: f}, nil)
+	req := httptest.NewRequest("POST", "http://something.com/jobs/status", strings.NewReader(`{"documents": ["a", "b"], "stemmer": "en"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, 200, rec.Code, "Should have 200 status code.")
+	assert.Equal(t, service.JobStatusRequest{
+		Documents:       []string{"a", "b"},
+		AnalysisOptions: service.AnalysisOptions{Stemmer: "en"},
+	}, jsr)
+
+	req = httptest.NewRequest("GET", "http://something.com/jobs/status", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, 405, rec.Code, "Only POST should be allowed.")
+}
+
+func TestHTTPDocuments(t *testing.T) {
+	t.Parallel()
+
+	var requests []interface{}
+	f := func(_ context.Context, request interface{}) (response interface{}, err error) {
+		requests = append(requests, request)
+		return nil, nil
+	}
+	content := func(_ context.Context, request interface{}) (response interface{}, err error) {
+		return endpoint.DocumentContentResponse{
+			DocumentContentResponse: service.DocumentContentResponse{
+				Metadata: docstore.Metadata{ID: "a", ContentType: "text/html", Size: 4},
+				Content:  strings.NewReader("text"),
+			},
+		}, nil
+	}
+	handler := http.NewAPIHTTPHandler(http.APIEndpoints{
+		Document:        f,
+		DocumentContent: content,
+		ListDocuments:   f,
+		DeleteDocument:  f,
+	}, nil)
+
+	tests := []struct {
+		method string
+		target string
+		code   int
+	}{
+		{"GET", "/documents?submitter=alice&tag=news&content_type=text/plain&created_after=2019-01-02T03:04:05Z&offset=2&limit=3", 200},
+		{"GET", "/documents/a/b+c=", 200},
+		{"DELETE", "/documents/a/b+c=", 200},
+		{"POST", "/documents/a", 405},
+		{"POST", "/documents", 405},
+		{"GET", "/documents/a/near-duplicates", 404},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://something.com"+test.target, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, test.code, rec.Code, "%s %s should have the expected status code.", test.method, test.target)
+	}
+
+	assert.Equal(t, []interface{}{
+		service.ListDocumentsRequest{Filter: docstore.Filter{
+			Submitter:    "alice",
+			ContentType:  "text/plain",
+			Tag:          "news",
+			CreatedAfter: time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+			Offset:       2,
+			Limit:        3,
+		}},
+		service.DocumentLookupRequest{DocumentID: "a/b+c="},
+		service.DocumentLookupRequest{DocumentID: "a/b+c="},
+	}, requests, "Requests should be decoded.")
+
+	req := httptest.NewRequest("GET", "http://something.com/documents/a/content", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, 200, rec.Code, "Should have 200 status code.")
+	assert.Equal(t, "text/html", rec.Header().Get("Content-Type"), "Original content type should be sent.")
+	assert.Equal(t, "text", rec.Body.String(), "Original contents should be sent.")
+
+	req = httptest.NewRequest("GET", "http://something.com/documents?created_after=yesterday", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.NotEqual(t, 200, rec.Code, "Invalid times should be rejected.")
 }
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	gohttp "net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+const collectionsPrefix = "/collections/"
+
+// splitCollectionPath splits a path below /collections/ into the collection
+// name, the resource and the rest of the path.
+//
+// Document IDs may contain slashes, so the rest of the path is not split.
+func splitCollectionPath(path string) (name, resource, rest string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, collectionsPrefix), "/", 3)
+	name = parts[0]
+	if len(parts) > 1 {
+		resource = parts[1]
+	}
+	if len(parts) > 2 {
+		rest = parts[2]
+	}
+	return name, resource, rest
+}
+
+func decodeAPIAddToCollectionRequest(ctx context.Context, req *gohttp.Request) (interface{}, error) {
+	name, _, _ := splitCollectionPath(req.URL.Path)
+	dr, err := decodeAPIProcessDocumentJSONRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return service.CollectionDocumentRequest{
+		Collection:      name,
+		DocumentRequest: dr.(service.DocumentRequest),
+	}, nil
+}
+
+func decodeAPIRemoveFromCollectionRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	name, _, id := splitCollectionPath(req.URL.Path)
+	return service.CollectionRemoveRequest{
+		Collection: name,
+		DocumentID: id,
+	}, nil
+}
+
+func decodeAPICollectionFrequenciesRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	name, _, _ := splitCollectionPath(req.URL.Path)
+	cfr := service.CollectionFrequenciesRequest{Collection: name}
+	if n := req.URL.Query().Get("n"); n != "" {
+		var err error
+		cfr.N, err = strconv.Atoi(n)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid n")
+		}
+	}
+	return cfr, nil
+}
+
+func encodeJSONError(w gohttp.ResponseWriter, status int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: fmt.Sprintf(format, args...)})
+}
+
+// makeAPICollectionHandler registers the handlers for the collection
+// endpoints:
+//
+//	POST   /collections/{name}/documents
+//	DELETE /collections/{name}/documents/{id}
+//	GET    /collections/{name}/frequencies
+func makeAPICollectionHandler(m *gohttp.ServeMux, endpoints APIEndpoints, options map[string][]http.ServerOption) {
+	handlers := make(map[string]gohttp.Handler)
+	if endpoints.AddToCollection != nil {
+		handlers[gohttp.MethodPost+" documents"] = http.NewServer(endpoints.AddToCollection,
+			decodeAPIAddToCollectionRequest,
+			encodeAPIResponse,
+			options["AddToCollection"]...)
+	}
+	if endpoints.RemoveFromCollection != nil {
+		handlers[gohttp.MethodDelete+" documents/"] = http.NewServer(endpoints.RemoveFromCollection,
+			decodeAPIRemoveFromCollectionRequest,
+			encodeAPIResponse,
+			options["RemoveFromCollection"]...)
+	}
+	if endpoints.CollectionFrequencies != nil {
+		handlers[gohttp.MethodGet+" frequencies"] = http.NewServer(endpoints.CollectionFrequencies,
+			decodeAPICollectionFrequenciesRequest,
+			encodeAPIResponse,
+			options["CollectionFrequencies"]...)
+	}
+	if len(handlers) == 0 {
+		return
+	}
+
+	hf := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		name, resource, rest := splitCollectionPath(r.URL.Path)
+		if name == "" || resource == "" {
+			encodeJSONError(w, gohttp.StatusNotFound, "Unknown path %s", r.URL.Path)
+			return
+		}
+		route := resource
+		if rest != "" {
+			route += "/"
+		}
+		handler, ok := handlers[r.Method+" "+route]
+		if !ok {
+			for key := range handlers {
+				if strings.HasSuffix(key, " "+route) {
+					encodeJSONError(w, gohttp.StatusMethodNotAllowed, "Invalid request method %s", r.Method)
+					return
+				}
+			}
+			encodeJSONError(w, gohttp.StatusNotFound, "Unknown path %s", r.URL.Path)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+	m.Handle(collectionsPrefix, gohttp.HandlerFunc(hf))
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"context"
+	"io"
+	gohttp "net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+
+	apiendpoint "github.com/rwool/saas-interview-challenge1/pkg/endpoint"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/docstore"
+)
+
+const (
+	documentsPath   = "/documents"
+	documentsPrefix = documentsPath + "/"
+)
+
+// documentResources are the resources below a document, by the suffix of
+// their path.
+var documentResources = []string{"content", "near-duplicates"}
+
+// splitDocumentPath splits a path below /documents/ into the document ID and
+// the resource, which is empty for the document itself.
+//
+// Document IDs may contain slashes, so only known resources are split off.
+func splitDocumentPath(path string) (id, resource string) {
+	id = strings.TrimPrefix(path, documentsPrefix)
+	for _, r := range documentResources {
+		if strings.HasSuffix(id, "/"+r) {
+			return strings.TrimSuffix(id, "/"+r), r
+		}
+	}
+	return id, ""
+}
+
+func decodeAPIDocumentLookupRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	id, _ := splitDocumentPath(req.URL.Path)
+	return service.DocumentLookupRequest{DocumentID: id}, nil
+}
+
+func decodeAPIListDocumentsRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	query := req.URL.Query()
+	f := docstore.Filter{
+		Submitter:   query.Get("submitter"),
+		ContentType: query.Get("content_type"),
+		Tag:         query.Get("tag"),
+	}
+	for name, v := range map[string]*time.Time{"created_after": &f.CreatedAfter, "created_before": &f.CreatedBefore} {
+		if s := query.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", name)
+			}
+			*v = t
+		}
+	}
+	for name, v := range map[string]*int{"offset": &f.Offset, "limit": &f.Limit} {
+		if s := query.Get(name); s != "" {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", name)
+			}
+			*v = i
+		}
+	}
+	return service.ListDocumentsRequest{Filter: f}, nil
+}
+
+// encodeDocumentContentResponse writes the original contents of a document
+// with its content type, or an error as JSON.
+func encodeDocumentContentResponse(ctx context.Context, w gohttp.ResponseWriter, r interface{}) error {
+	dcr, ok := r.(apiendpoint.DocumentContentResponse)
+	if !ok || dcr.Failed() != nil {
+		return encodeAPIResponse(ctx, w, r)
+	}
+	contentType := dcr.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(dcr.Size, 10))
+	_, err := io.Copy(w, dcr.Content)
+	return errors.Wrapf(err, "unable to write contents of document %s", dcr.ID)
+}
+
+// makeAPIDocumentsHandler registers the handlers for the document endpoints:
+//
+//	GET    /documents
+//	GET    /documents/{id}
+//	DELETE /documents/{id}
+//	GET    /documents/{id}/content
+//	GET    /documents/{id}/near-duplicates
+func makeAPIDocumentsHandler(m *gohttp.ServeMux, endpoints APIEndpoints, options map[string][]http.ServerOption) {
+	if endpoints.ListDocuments != nil {
+		handler := http.NewServer(endpoints.ListDocuments,
+			decodeAPIListDocumentsRequest,
+			encodeAPIResponse,
+			options["ListDocuments"]...)
+		hf := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+			if r.Method != gohttp.MethodGet {
+				encodeJSONError(w, gohttp.StatusMethodNotAllowed, "Invalid request method %s", r.Method)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}
+		m.Handle(documentsPath, gohttp.HandlerFunc(hf))
+	}
+
+	handlers := make(map[string]gohttp.Handler)
+	add := func(route string, e endpoint.Endpoint, dec http.DecodeRequestFunc, enc http.EncodeResponseFunc, name string) {
+		if e != nil {
+			handlers[route] = http.NewServer(e, dec, enc, options[name]...)
+		}
+	}
+	add(gohttp.MethodGet+" ", endpoints.Document, decodeAPIDocumentLookupRequest, encodeAPIResponse, "Document")
+	add(gohttp.MethodDelete+" ", endpoints.DeleteDocument, decodeAPIDocumentLookupRequest, encodeAPIResponse, "DeleteDocument")
+	add(gohttp.MethodGet+" content", endpoints.DocumentContent, decodeAPIDocumentLookupRequest, encodeDocumentContentResponse, "DocumentContent")
+	add(gohttp.MethodGet+" near-duplicates", endpoints.NearDuplicates, decodeAPINearDuplicatesRequest, encodeAPIResponse, "NearDuplicates")
+	if len(handlers) == 0 {
+		return
+	}
+
+	hf := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		id, resource := splitDocumentPath(r.URL.Path)
+		if id == "" {
+			encodeJSONError(w, gohttp.StatusNotFound, "Unknown path %s", r.URL.Path)
+			return
+		}
+		handler, ok := handlers[r.Method+" "+resource]
+		if !ok {
+			for key := range handlers {
+				if strings.HasSuffix(key, " "+resource) {
+					encodeJSONError(w, gohttp.StatusMethodNotAllowed, "Invalid request method %s", r.Method)
+					return
+				}
+			}
+			encodeJSONError(w, gohttp.StatusNotFound, "Unknown path %s", r.URL.Path)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+	m.Handle(documentsPrefix, gohttp.HandlerFunc(hf))
+}
@@ -0,0 +1,40 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	gohttp "net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+func decodeAPIJobStatusRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	defer req.Body.Close()
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	var jsr service.JobStatusRequest
+	err := decoder.Decode(&jsr)
+	return jsr, errors.Wrap(err, "invalid job status request")
+}
+
+// makeAPIJobStatusHandler registers the handler for looking up the state of
+// the jobs of several documents:
+//
+//	POST /jobs/status
+func makeAPIJobStatusHandler(m *gohttp.ServeMux, endpoint endpoint.Endpoint, options ...http.ServerOption) {
+	handler := http.NewServer(endpoint,
+		decodeAPIJobStatusRequest,
+		encodeAPIResponse,
+		options...)
+	hf := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.Method != gohttp.MethodPost {
+			encodeJSONError(w, gohttp.StatusMethodNotAllowed, "Invalid request method %s", r.Method)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+	m.Handle("/jobs/status", gohttp.HandlerFunc(hf))
+}
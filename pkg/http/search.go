@@ -0,0 +1,45 @@
+package http
+
+import (
+	"context"
+	gohttp "net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+func decodeAPISearchRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	query := req.URL.Query()
+	sr := service.SearchRequest{
+		Query:   query.Get("q"),
+		Ranking: query.Get("ranking"),
+	}
+	for name, v := range map[string]*int{"offset": &sr.Offset, "limit": &sr.Limit} {
+		if s := query.Get(name); s != "" {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", name)
+			}
+			*v = i
+		}
+	}
+	return sr, nil
+}
+
+func makeAPISearchHandler(m *gohttp.ServeMux, endpoint endpoint.Endpoint, options ...http.ServerOption) {
+	handler := http.NewServer(endpoint,
+		decodeAPISearchRequest,
+		encodeAPIResponse,
+		options...)
+	hf := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.Method != gohttp.MethodGet {
+			encodeJSONError(w, gohttp.StatusMethodNotAllowed, "Invalid request method %s", r.Method)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+	m.Handle("/search", gohttp.HandlerFunc(hf))
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	gohttp "net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+func decodeAPISimilarityRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	defer req.Body.Close()
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	var sr service.SimilarityRequest
+	err := decoder.Decode(&sr)
+	return sr, errors.Wrap(err, "invalid similarity request")
+}
+
+func decodeAPINearDuplicatesRequest(_ context.Context, req *gohttp.Request) (interface{}, error) {
+	id, _ := splitDocumentPath(req.URL.Path)
+	ndr := service.NearDuplicatesRequest{DocumentID: id}
+	if t := req.URL.Query().Get("threshold"); t != "" {
+		var err error
+		ndr.Threshold, err = strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid threshold")
+		}
+	}
+	return ndr, nil
+}
+
+func makeAPISimilarityHandler(m *gohttp.ServeMux, endpoint endpoint.Endpoint, options ...http.ServerOption) {
+	handler := http.NewServer(endpoint,
+		decodeAPISimilarityRequest,
+		encodeAPIResponse,
+		options...)
+	hf := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.Method != gohttp.MethodPost {
+			encodeJSONError(w, gohttp.StatusMethodNotAllowed, "Invalid request method %s", r.Method)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+	m.Handle("/similarity", gohttp.HandlerFunc(hf))
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"crypto/subtle"
+	gohttp "net/http"
+	"strings"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+// bearerPrefix starts the Authorization header of requests that send an API
+// key.
+const bearerPrefix = "Bearer "
+
+// NewTenantHandler returns a handler that authenticates the tenant of each
+// request by the API key sent as a bearer token in its Authorization header,
+// and passes the request to h with the tenant set by service.WithTenant.
+//
+// keys maps each tenant to its API key. Requests without a known key have no
+// tenant, and are not rejected.
+func NewTenantHandler(h gohttp.Handler, keys map[string]string) gohttp.Handler {
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if tenant := tenantOf(r, keys); tenant != "" {
+			r = r.WithContext(service.WithTenant(r.Context(), tenant))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// tenantOf returns the tenant whose API key a request sends, or "" if it
+// sends none of them. Every key is compared in constant time, so that the
+// keys can't be guessed from how long requests take.
+func tenantOf(r *gohttp.Request, keys map[string]string) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return ""
+	}
+	key := []byte(strings.TrimPrefix(auth, bearerPrefix))
+	var found string
+	for tenant, k := range keys {
+		if k != "" && subtle.ConstantTimeCompare(key, []byte(k)) == 1 {
+			found = tenant
+		}
+	}
+	return found
+}
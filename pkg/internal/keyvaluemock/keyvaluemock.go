@@ -2,94 +2,235 @@ package keyvaluemock
 
 import (
 	"context"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
 )
 
 // KeyValueMock is a mock implementation of the keyvalue.KeyValue type.
+//
+// It behaves like the Redis adapter: values are kept after they are
+// retrieved, stored values replace previous ones, missing keys have no value
+// and keys expire. Counters are stored as decimal strings, so they can't be
+// read as values and values can't be read as counters.
+//
+// Intended for testing only.
 type KeyValueMock struct {
-	channels *sync.Map
+	mu      sync.Mutex
+	entries map[string]entry
 }
 
 // New returns a new KeyValueMock.
 func New() *KeyValueMock {
-	return &KeyValueMock{channels: new(sync.Map)}
+	return &KeyValueMock{entries: make(map[string]entry)}
 }
 
-type counter struct {
-	i  int64
-	mu sync.Mutex
+type entry struct {
+	data     []byte
+	counter  bool
+	deadline time.Time
 }
 
-func (k *KeyValueMock) getVal(key string, fn func() interface{}) interface{} {
-	var v interface{}
-	var ok bool
-	v, ok = k.channels.Load(key)
-	if !ok {
-		val := fn()
-		v, _ = k.channels.LoadOrStore(key, val)
+// get returns the live entry for key. k.mu must be held.
+func (k *KeyValueMock) get(key string) (entry, bool) {
+	e, ok := k.entries[key]
+	if ok && !e.deadline.IsZero() && !time.Now().Before(e.deadline) {
+		delete(k.entries, key)
+		return entry{}, false
 	}
-	return v
+	return e, ok
 }
 
-func (k *KeyValueMock) getChan(key string) chan []byte {
-	val := k.getVal(key, func() interface{} {
-		return make(chan []byte, 100)
-	})
-	return val.(chan []byte)
+// Store stores bytes into key.
+func (k *KeyValueMock) Store(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	if key == "" {
+		return errors.New("invalid key")
+	}
+	e := entry{data: append([]byte{}, data...)}
+	if expiration > 0 {
+		e.deadline = time.Now().Add(expiration)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.entries[key] = e
+	return nil
 }
 
-func (k *KeyValueMock) getCounter(key string) *counter {
-	val := k.getVal(key, func() interface{} {
-		return &counter{}
-	})
-	return val.(*counter)
+// Retrieve retrieves the bytes for key, or nil if there are none.
+func (k *KeyValueMock) Retrieve(ctx context.Context, key string) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("invalid key")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	e, ok := k.get(key)
+	switch {
+	case !ok:
+		return nil, nil
+	case e.counter:
+		return nil, errors.Errorf("key %q holds a counter", key)
+	}
+	return append([]byte{}, e.data...), nil
 }
 
-// Store stores bytes into key.
-func (k *KeyValueMock) Store(ctx context.Context, key string, data []byte, expiration time.Duration) error {
-	c := k.getChan(key)
-	select {
-	case c <- data:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
+// Delete deletes the bytes or counter for key.
+func (k *KeyValueMock) Delete(ctx context.Context, key string) error {
+	if key == "" {
+		return errors.New("invalid key")
 	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.entries, key)
+	return nil
 }
 
-// Retrieve retrieves the bytes for key.
-func (k *KeyValueMock) Retrieve(ctx context.Context, key string) ([]byte, error) {
-	c := k.getChan(key)
-	select {
-	case v := <-c:
-		return v, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+// RetrieveMany retrieves the bytes for several keys.
+func (k *KeyValueMock) RetrieveMany(ctx context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	failed := false
+	for i, key := range keys {
+		values[i], errs[i] = k.Retrieve(ctx, key)
+		failed = failed || errs[i] != nil
+	}
+	if failed {
+		return values, keyvalue.BatchError(errs)
+	}
+	return values, nil
+}
+
+// StoreMany stores bytes into several keys.
+func (k *KeyValueMock) StoreMany(ctx context.Context, entries []keyvalue.Entry) error {
+	errs := make([]error, len(entries))
+	failed := false
+	for i, e := range entries {
+		errs[i] = k.Store(ctx, e.Key, e.Data, e.Expiration)
+		failed = failed || errs[i] != nil
+	}
+	if failed {
+		return keyvalue.BatchError(errs)
+	}
+	return nil
+}
+
+// RetrieveVersion retrieves the bytes for key and their version.
+func (k *KeyValueMock) RetrieveVersion(ctx context.Context, key string) ([]byte, keyvalue.Version, error) {
+	data, err := k.Retrieve(ctx, key)
+	if err != nil || data == nil {
+		return nil, keyvalue.NoVersion, err
 	}
+	return data, keyvalue.VersionOf(data), nil
+}
+
+// StoreIfAbsent stores bytes into key if it has no value.
+func (k *KeyValueMock) StoreIfAbsent(ctx context.Context, key string, data []byte, expiration time.Duration) (bool, error) {
+	return k.CompareAndSwap(ctx, key, keyvalue.NoVersion, data, expiration)
+}
+
+// CompareAndSwap stores bytes into key if its bytes have the given version.
+func (k *KeyValueMock) CompareAndSwap(ctx context.Context, key string, version keyvalue.Version, data []byte, expiration time.Duration) (bool, error) {
+	if key == "" {
+		return false, errors.New("invalid key")
+	}
+	e := entry{data: append([]byte{}, data...)}
+	if expiration > 0 {
+		e.deadline = time.Now().Add(expiration)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if ok, err := k.matches(key, version); !ok || err != nil {
+		return false, err
+	}
+	k.entries[key] = e
+	return true, nil
+}
+
+// CompareAndDelete deletes key if its bytes have the given version.
+func (k *KeyValueMock) CompareAndDelete(ctx context.Context, key string, version keyvalue.Version) (bool, error) {
+	if key == "" {
+		return false, errors.New("invalid key")
+	}
+	if version == keyvalue.NoVersion {
+		return false, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if ok, err := k.matches(key, version); !ok || err != nil {
+		return false, err
+	}
+	delete(k.entries, key)
+	return true, nil
+}
+
+// matches reports whether the bytes for key have the given version. k.mu must
+// be held.
+func (k *KeyValueMock) matches(key string, version keyvalue.Version) (bool, error) {
+	e, ok := k.get(key)
+	switch {
+	case !ok:
+		return version == keyvalue.NoVersion, nil
+	case e.counter:
+		return false, errors.Errorf("key %q holds a counter", key)
+	}
+	return keyvalue.VersionOf(e.data) == version, nil
 }
 
 // SetCounter sets the value of the counter for key.
 func (k *KeyValueMock) SetCounter(ctx context.Context, key string, value int64) error {
-	c := k.getCounter(key)
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.i = value
+	if key == "" {
+		return errors.New("invalid key")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.entries[key] = entry{data: []byte(strconv.FormatInt(value, 10)), counter: true}
 	return nil
 }
 
+// counter returns the value of the counter for key. k.mu must be held.
+func (k *KeyValueMock) counter(key string) (entry, int64, error) {
+	e, ok := k.get(key)
+	if !ok {
+		return entry{}, 0, errors.Errorf("no counter for key %q", key)
+	}
+	if !e.counter {
+		return entry{}, 0, errors.Errorf("key %q is not a counter", key)
+	}
+	i, err := strconv.ParseInt(string(e.data), 10, 64)
+	return e, i, errors.WithStack(err)
+}
+
 // GetCounter gets the current value of the counter for key.
 func (k *KeyValueMock) GetCounter(ctx context.Context, key string) (int64, error) {
-	c := k.getCounter(key)
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.i, nil
+	if key == "" {
+		return 0, errors.New("invalid key")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, i, err := k.counter(key)
+	return i, err
 }
 
-// IncrementCounter increments the value of the counter for key.
+// IncrementCounter increments the value of the counter for key. Missing
+// counters are incremented from 0.
 func (k *KeyValueMock) IncrementCounter(ctx context.Context, key string) error {
-	c := k.getCounter(key)
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.i++
+	if key == "" {
+		return errors.New("invalid key")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	e, i := entry{counter: true}, int64(0)
+	if _, ok := k.get(key); ok {
+		var err error
+		if e, i, err = k.counter(key); err != nil {
+			return err
+		}
+	}
+	// The expiration of the counter is kept.
+	e.data = []byte(strconv.FormatInt(i+1, 10))
+	k.entries[key] = e
 	return nil
 }
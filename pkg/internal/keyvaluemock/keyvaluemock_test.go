@@ -0,0 +1,17 @@
+package keyvaluemock_test
+
+import (
+	"testing"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue/keyvaluetest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		return keyvaluemock.New(), func() {}
+	})
+}
@@ -3,46 +3,65 @@ package queuemock
 import (
 	"context"
 	"sync"
+
+	"github.com/pkg/errors"
 )
 
 // QueueMock is a mock implementation of the queue.Queue type.
 //
+// Like the Redis adapter, queues are unbounded and pulls wait until there is
+// a message or their context is done.
+//
 // Intended for testing only.
 type QueueMock struct {
-	Data *sync.Map
+	mu     sync.Mutex
+	queues map[string][][]byte
+	// pushed is closed and replaced whenever messages are pushed.
+	pushed chan struct{}
 }
 
 // New returns a new QueueMock.
 func New() *QueueMock {
-	return &QueueMock{Data: new(sync.Map)}
-}
-
-func (q *QueueMock) getChan(key string) chan []byte {
-	var v interface{}
-	var ok bool
-	v, ok = q.Data.Load(key)
-	if !ok {
-		c := make(chan []byte, 100)
-		v, _ = q.Data.LoadOrStore(key, c)
+	return &QueueMock{
+		queues: make(map[string][][]byte),
+		pushed: make(chan struct{}),
 	}
-	return v.(chan []byte)
 }
 
-// Push pushes Data to the given channel.
+// Push pushes data to the given channel.
 func (q *QueueMock) Push(ctx context.Context, channel string, data [][]byte) error {
-	c := q.getChan(channel)
+	if len(data) == 0 {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	for _, d := range data {
-		c <- d
+		q.queues[channel] = append(q.queues[channel], append([]byte{}, d...))
 	}
+	close(q.pushed)
+	q.pushed = make(chan struct{})
 	return nil
 }
 
-// Pull pull Data from the given channel.
+// Pull pulls data from the given channel.
 //
-// If the channel has no Data available, then this call will block until there
-// is.
+// If the channel has no data available, then this call will block until there
+// is or the context is done.
 func (q *QueueMock) Pull(ctx context.Context, channel string) ([]byte, error) {
-	c := q.getChan(channel)
-	out := <-c
-	return out, nil
+	for {
+		q.mu.Lock()
+		if messages := q.queues[channel]; len(messages) > 0 {
+			q.queues[channel] = messages[1:]
+			q.mu.Unlock()
+			return messages[0], nil
+		}
+		pushed := q.pushed
+		q.mu.Unlock()
+
+		select {
+		case <-pushed:
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		}
+	}
 }
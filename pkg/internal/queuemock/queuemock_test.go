@@ -0,0 +1,17 @@
+package queuemock_test
+
+import (
+	"testing"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue/queuetest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	queuetest.Run(t, func(t *testing.T) (queue.Queue, func()) {
+		return queuemock.New(), func() {}
+	})
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/language"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/stem"
+)
+
+// StemmerAuto selects the stemmer for the detected language of a document.
+const StemmerAuto = "auto"
+
+// AnalysisOptions contains the options that change how a document is
+// analyzed.
+type AnalysisOptions struct {
+	// ContentType is the media type of the document. The type is detected
+	// from the document if it is not set.
+	ContentType string `json:"content_type,omitempty"`
+	// JSONPath selects the parts of a JSON document to analyze.
+	JSONPath string `json:"json_path,omitempty"`
+	// CSVColumns are the names of the columns of a CSV document to analyze.
+	CSVColumns []string `json:"csv_columns,omitempty"`
+	// Language is the ISO 639-1 code of the language of the document, which
+	// picks the analyzer. The language is detected from the document if it is
+	// not set.
+	Language string `json:"language,omitempty"`
+	// Stemmer counts the inflected forms of a word together under their stem.
+	// It is the ISO 639-1 code of the language whose stemmer is used, or
+	// StemmerAuto for the stemmer of the detected language. Words are not
+	// stemmed if it is not set, or if there is no stemmer for the detected
+	// language.
+	Stemmer string `json:"stemmer,omitempty"`
+	// SurfaceForms reports the most common form of each stemmed word.
+	SurfaceForms bool `json:"surface_forms,omitempty"`
+}
+
+func (o AnalysisOptions) validate() error {
+	if o.Language != "" && !language.Supported(o.Language) {
+		return errors.Wrapf(ErrInvalidRequest, "unsupported language %q", o.Language)
+	}
+	if _, ok := stem.For(o.Stemmer); !ok && o.Stemmer != "" && o.Stemmer != StemmerAuto {
+		return errors.Wrapf(ErrInvalidRequest, "no stemmer for %q", o.Stemmer)
+	}
+	if o.SurfaceForms && o.Stemmer == "" {
+		return errors.Wrap(ErrInvalidRequest, "surface forms require a stemmer")
+	}
+	return nil
+}
+
+// analyzer returns the analyzer for a document in the detected language.
+func (o AnalysisOptions) analyzer(detection language.Detection) language.Analyzer {
+	a := language.NewAnalyzer(detection)
+	lang := o.Stemmer
+	if lang == StemmerAuto {
+		lang = a.Language
+	}
+	if s, ok := stem.For(lang); ok {
+		a = a.WithStemmer(s)
+	}
+	return a
+}
+
+// analyzerVersion identifies the analyzer that the options select beyond
+// the defaults, so that reports that were counted differently are not used.
+func (o AnalysisOptions) analyzerVersion() string {
+	if o.Stemmer == "" {
+		return ""
+	}
+	return fmt.Sprintf("stem/%d", stem.Version)
+}
+
+func (o AnalysisOptions) extractOptions() extract.Options {
+	return extract.Options{
+		JSONPath:   o.JSONPath,
+		CSVColumns: o.CSVColumns,
+	}
+}
+
+// resultKey returns the key that the report for the document with the given
+// ID is stored under when it is analyzed with opts.
+//
+// Documents analyzed with the default options are stored under their ID.
+func resultKey(id string, opts AnalysisOptions) string {
+	opts.ContentType = extract.MediaType(opts.ContentType)
+	data, err := json.Marshal(struct {
+		AnalysisOptions
+		Analyzer string `json:"analyzer,omitempty"`
+	}{opts, opts.analyzerVersion()})
+	if err != nil || string(data) == "{}" {
+		return keyspace.Result(id, "")
+	}
+	sum := sha256.Sum256(data)
+	return keyspace.Result(id, base64.RawURLEncoding.EncodeToString(sum[:12]))
+}
@@ -1,41 +1,160 @@
 package service
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
 
 	"github.com/pkg/errors"
 
+	"github.com/rwool/saas-interview-challenge1/pkg/service/archive"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/docstore"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
 	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
 	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/search"
+)
+
+const (
+	// DefaultMaxDocumentSize is the largest streamed document that is
+	// accepted when no limit is configured.
+	DefaultMaxDocumentSize = 64 * 1024 * 1024
+
+	// ProcessTimeout is how long a streamed document or archive may take to
+	// be processed once it has been read. Reading the request body is only
+	// bounded by the size limit, since large uploads take a while to send.
+	ProcessTimeout = 10 * time.Second
+)
+
+var (
+	// ErrDocumentTooLarge is returned when a streamed document exceeds the
+	// configured size limit.
+	ErrDocumentTooLarge = errors.New("document too large")
+	// ErrInvalidDocument is returned when a document cannot be read.
+	ErrInvalidDocument = errors.New("invalid document")
 )
 
 // APIService is the user accessible service.
 type APIService interface {
 	ProcessDocument(ctx context.Context, request DocumentRequest) (DocumentFrequenciesResponse, error)
+	ProcessDocumentStream(ctx context.Context, request DocumentStreamRequest) (DocumentFrequenciesResponse, error)
+	ProcessArchive(ctx context.Context, request ArchiveRequest) (DocumentFrequenciesResponse, error)
+	AddToCollection(ctx context.Context, request CollectionDocumentRequest) (CollectionResponse, error)
+	RemoveFromCollection(ctx context.Context, request CollectionRemoveRequest) (CollectionResponse, error)
+	CollectionFrequencies(ctx context.Context, request CollectionFrequenciesRequest) (CollectionFrequenciesResponse, error)
+	Similarity(ctx context.Context, request SimilarityRequest) (SimilarityResponse, error)
+	NearDuplicates(ctx context.Context, request NearDuplicatesRequest) (SimilarityResponse, error)
+	Search(ctx context.Context, request SearchRequest) (SearchResponse, error)
+	Document(ctx context.Context, request DocumentLookupRequest) (StoredDocumentResponse, error)
+	DocumentContent(ctx context.Context, request DocumentLookupRequest) (DocumentContentResponse, error)
+	ListDocuments(ctx context.Context, request ListDocumentsRequest) (ListDocumentsResponse, error)
+	DeleteDocument(ctx context.Context, request DocumentLookupRequest) (StoredDocumentResponse, error)
+	JobStatus(ctx context.Context, request JobStatusRequest) (JobStatusResponse, error)
 }
 
 // DocumentRequest is a request for a document to be processed.
 type DocumentRequest struct {
 	Document        string `json:"document"`
 	DurationSeconds int    `json:"duration_seconds"`
+	AnalysisOptions
+	ScoringOptions
+	Submission
+	RetentionOptions
+}
+
+// DocumentStreamRequest is a request for a document to be processed where the
+// document is read from Body instead of being held in memory.
+type DocumentStreamRequest struct {
+	Body            io.Reader
+	DurationSeconds int
+	AnalysisOptions
+	ScoringOptions
+	Submission
+	RetentionOptions
 }
 
 // DocumentFrequenciesResponse is the response for processing a document.
 type DocumentFrequenciesResponse struct {
-	DocumentID  string
-	Frequencies []Frequency
+	DocumentID string
+	// Language is the ISO 639-1 code of the detected language of the
+	// document, and LanguageConfidence how certain the detection is. The
+	// language is missing from the report of an archive whose files are in
+	// different languages.
+	Language           string  `json:",omitempty"`
+	LanguageConfidence float64 `json:",omitempty"`
+	Frequencies        []Frequency
+	// Files holds the report for each file when the document is an archive.
+	Files []FileFrequencies `json:",omitempty"`
+}
+
+// FileFrequencies is the report for a single file of an archive.
+type FileFrequencies struct {
+	Name               string
+	DocumentID         string
+	Language           string  `json:",omitempty"`
+	LanguageConfidence float64 `json:",omitempty"`
+	Frequencies        []Frequency
+}
+
+// APIServiceConfig contains the configuration for an APIService.
+type APIServiceConfig struct {
+	Queue   queue.Queue
+	KeyVal  keyvalue.KeyValue
+	Log     log.Logger
+	Channel string
+
+	// Blobs stores streamed documents. Streaming is unsupported if it is nil.
+	Blobs *blob.Store
+	// MaxDocumentSize is the largest number of bytes accepted for a streamed
+	// document or archive. DefaultMaxDocumentSize is used if it is 0.
+	MaxDocumentSize int64
+	// ArchiveLimits limits the contents of archives.
+	ArchiveLimits archive.Limits
+	// Index is the search index filled by the workers. Searching is
+	// unsupported if it is nil.
+	Index *search.Index
+	// Documents keeps the submitted documents. Documents are not kept if it
+	// is nil. It must share the key value store of Blobs, as streamed
+	// documents are linked as blobs instead of being copied.
+	Documents *docstore.Store
+	// Retention is how long reports are kept for when neither the request
+	// nor its tenant selects a retention. DefaultRetention is used if it is
+	// zero.
+	Retention Retention
+	// TenantRetention is the retention for the requests of each tenant,
+	// which are authenticated by the transport with WithTenant.
+	TenantRetention map[string]Retention
+	// MaxRetention is the longest retention that a request can select. If
+	// it is zero, requests can't select a longer retention than the one of
+	// their tenant, or the global retention.
+	MaxRetention Retention
+	// LegacyIDs looks up reports and collection members under the IDs that
+	// documents had before IDs were versioned, and migrates the reports found.
+	// It is only needed while such reports are still stored.
+	LegacyIDs bool
 }
 
 type apiService struct {
-	q              queue.Queue
-	kv             keyvalue.KeyValue
-	requestChannel string
-	l              log.Logger
+	q                queue.Queue
+	kv               keyvalue.KeyValue
+	blobs            *blob.Store
+	requestChannel   string
+	maxDocumentSize  int64
+	archiveLimits    archive.Limits
+	index            *search.Index
+	documents        *docstore.Store
+	defaultRetention Retention
+	tenantRetention  map[string]Retention
+	maxRetention     Retention
+	legacyIDs        bool
+	l                log.Logger
 }
 
 // shortRetrieve approximates a non-blocking get request by blocking less.
@@ -53,72 +172,331 @@ func shortRetrieve(ctx context.Context, kv keyvalue.KeyValue, key string) ([]byt
 
 // ProcessDocument processes a document.
 func (a *apiService) ProcessDocument(ctx context.Context, request DocumentRequest) (DocumentFrequenciesResponse, error) {
-	var dfr DocumentFrequenciesResponse
-
-	id := createStringSHA256(request.Document)
-	workerRequest := DocumentID{
+	if err := request.AnalysisOptions.validate(); err != nil {
+		return DocumentFrequenciesResponse{}, err
+	}
+	if err := request.ScoringOptions.validate(); err != nil {
+		return DocumentFrequenciesResponse{}, err
+	}
+	if _, err := a.retention(ctx, request.RetentionOptions); err != nil {
+		return DocumentFrequenciesResponse{}, err
+	}
+	id := documentIDOf(request.Document)
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API request to process document %s", id))
+	if err := a.keepDocument(ctx, id, request.ContentType, request.Submission, strings.NewReader(request.Document)); err != nil {
+		return DocumentFrequenciesResponse{}, err
+	}
+	prefix := request.Document
+	if len(prefix) > extract.SniffLength {
+		prefix = prefix[:extract.SniffLength]
+	}
+	request.ContentType = extract.Normalize(request.ContentType, []byte(prefix))
+	return a.process(ctx, DocumentID{
 		DocumentRequest: request,
 		ID:              id,
+		Searchable:      a.documents != nil,
+	})
+}
+
+// ProcessDocumentStream processes a document that is streamed into blob
+// storage.
+//
+// The document is hashed while it is being stored so that it never has to be
+// held in memory.
+func (a *apiService) ProcessDocumentStream(ctx context.Context, request DocumentStreamRequest) (DocumentFrequenciesResponse, error) {
+	var dfr DocumentFrequenciesResponse
+	if a.blobs == nil {
+		return dfr, errors.New("document streaming is not supported")
+	}
+	if err := request.AnalysisOptions.validate(); err != nil {
+		return dfr, err
+	}
+	if err := request.ScoringOptions.validate(); err != nil {
+		return dfr, err
+	}
+	if _, err := a.retention(ctx, request.RetentionOptions); err != nil {
+		return dfr, err
 	}
-	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API request to process document %s", id))
 
-	// Check if result is already cached.
-	v, err := shortRetrieve(ctx, a.kv, id)
+	// Read one byte past the limit to detect documents that are too large.
+	body := bufio.NewReaderSize(io.LimitReader(request.Body, a.maxDocumentSize+1), extract.SniffLength)
+	// Read errors are returned again when the document is stored.
+	prefix, _ := body.Peek(extract.SniffLength)
+	contentType := extract.Normalize(request.ContentType, prefix)
+	var id string
+	var err error
+	if a.documents != nil {
+		id, err = a.keepStream(ctx, body, request.ContentType, request.Submission)
+	} else {
+		id, err = a.storeBlob(ctx, body)
+	}
 	if err != nil {
-		return dfr, errors.WithStack(err)
+		return dfr, err
 	}
-	if v != nil {
-		_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API cache hit for document %s", id))
-		err = json.Unmarshal(v, &dfr)
-		return dfr, errors.WithStack(err)
-	} else {
-		_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API cache miss for document %s", id))
+	ctx, cancel := context.WithTimeout(ctx, ProcessTimeout)
+	defer cancel()
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API request to process streamed document %s", id))
+	opts := request.AnalysisOptions
+	opts.ContentType = contentType
+	return a.process(ctx, DocumentID{
+		DocumentRequest: DocumentRequest{
+			DurationSeconds:  request.DurationSeconds,
+			AnalysisOptions:  opts,
+			ScoringOptions:   request.ScoringOptions,
+			RetentionOptions: request.RetentionOptions,
+		},
+		ID:         id,
+		Blob:       id,
+		Searchable: a.documents != nil,
+	})
+}
+
+// storeBlob stores the document read from r as a blob under its ID.
+//
+// ErrDocumentTooLarge is returned if r holds more than maxDocumentSize bytes.
+// The chunks that were stored are deleted if the document is not committed.
+func (a *apiService) storeBlob(ctx context.Context, r io.Reader) (string, error) {
+	w, err := a.blobs.Create(ctx)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	id, m, err := a.writeBlob(w, r)
+	if err == nil {
+		if err = a.blobs.Link(ctx, id, m); err == nil {
+			return id, nil
+		}
+		err = errors.Wrap(err, "unable to store document")
+	}
+	if aerr := w.Abort(); aerr != nil {
+		_ = a.l.Log("LEVEL", "WARN", "MESSAGE", aerr.Error())
+	}
+	return "", err
+}
+
+// writeBlob writes the document read from r to w and closes it, returning the
+// ID of the document and the manifest of the upload.
+func (a *apiService) writeBlob(w *blob.Writer, r io.Reader) (string, blob.Manifest, error) {
+	h := newDocumentHash()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		return "", blob.Manifest{}, errors.Wrap(err, "unable to store document")
+	}
+	if w.Size() > a.maxDocumentSize {
+		return "", blob.Manifest{}, errors.Wrapf(ErrDocumentTooLarge, "limit is %d bytes", a.maxDocumentSize)
+	}
+	m, err := w.Close()
+	if err != nil {
+		return "", blob.Manifest{}, errors.Wrap(err, "unable to store document")
+	}
+	return documentHashID(h), m, nil
+}
+
+// process returns the cached report for a document or sends it to a worker
+// and waits for the report.
+//
+// Documents that are ranked against a collection are scored from their word
+// counts instead.
+func (a *apiService) process(ctx context.Context, workerRequest DocumentID) (DocumentFrequenciesResponse, error) {
+	retention, err := a.retention(ctx, workerRequest.RetentionOptions)
+	if err != nil {
+		return DocumentFrequenciesResponse{}, err
+	}
+	workerRequest.Retention = &retention
+
+	// Workers do not need the scoring options.
+	scoring := workerRequest.ScoringOptions
+	workerRequest.ScoringOptions = ScoringOptions{}
+	if scoring.scored() {
+		return a.score(ctx, workerRequest, scoring)
+	}
+
+	id := workerRequest.ID
+	key := resultKey(id, workerRequest.AnalysisOptions)
+
+	// Check if result is already cached. Streamed documents are not held, so
+	// only their legacy IDs that can be computed from the ID are checked.
+	var document []byte
+	if workerRequest.Blob == "" {
+		document = []byte(workerRequest.Document)
+	}
+	legacyKeys := legacyResultKeys(a.legacyDocumentIDs(id, document), workerRequest.AnalysisOptions)
+	dfr, ok, err := a.cachedReport(ctx, id, key, legacyKeys, retention)
+	if err != nil || ok {
+		return dfr, err
+	}
+
+	if err := a.startJob(ctx, workerRequest, key); err != nil {
+		return dfr, err
 	}
+	return a.waitForReport(ctx, key)
+}
 
-	// Send request to be processed by worker.
+// enqueue sends a request to be processed by a worker.
+func (a *apiService) enqueue(ctx context.Context, workerRequest DocumentID) error {
+	// The submission is only needed by the document store, and the retention
+	// options are resolved to a retention.
+	workerRequest.Submission = Submission{}
+	workerRequest.RetentionOptions = RetentionOptions{}
 	dr, err := json.Marshal(workerRequest)
 	if err != nil {
-		return dfr, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
 	if err := a.q.Push(ctx, a.requestChannel, [][]byte{dr}); err != nil {
-		return dfr, errors.Wrap(err, "unable to publish document request")
+		return errors.Wrap(err, "unable to publish document request")
 	}
 	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Pushed document request %s on channel %s", workerRequest.ID, a.requestChannel))
+	return nil
+}
 
-	// Poll for completion of processing.
+// cachedReport returns the report stored under key, if there is one.
+//
+// The report is refreshed if it has a sliding retention or if the requested
+// retention is longer than the retention it was stored with.
+//
+// If there is no report under key, a report stored under one of legacyKeys,
+// the keys of the document from before IDs were versioned, is migrated to key
+// instead.
+func (a *apiService) cachedReport(ctx context.Context, id, key string, legacyKeys []string, retention Retention) (DocumentFrequenciesResponse, bool, error) {
+	var report DocumentFrequencyReport
+	v, err := shortRetrieve(ctx, a.kv, key)
+	if err != nil {
+		return report.DocumentFrequenciesResponse, false, errors.WithStack(err)
+	}
+	if v == nil {
+		return a.migrateReport(ctx, id, key, legacyKeys, retention)
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API cache hit for document %s", id))
+	if err := json.Unmarshal(v, &report); err != nil {
+		return report.DocumentFrequenciesResponse, true, errors.WithStack(err)
+	}
+	if err := a.refreshReport(ctx, key, report, retention); err != nil {
+		// The report can still be served.
+		_ = a.l.Log("LEVEL", "WARN", "MESSAGE", err.Error())
+	}
+	return report.DocumentFrequenciesResponse, true, nil
+}
+
+// legacyDocumentIDs returns the legacy IDs of a document if they are looked
+// up, see legacyDocumentIDs.
+func (a *apiService) legacyDocumentIDs(id string, document []byte) []string {
+	if !a.legacyIDs {
+		return nil
+	}
+	return legacyDocumentIDs(id, document)
+}
+
+// migrateReport stores the first report found under one of legacyKeys under
+// key with the versioned ID of its document, so that reports cached before
+// IDs were versioned are still used.
+//
+// The legacy report is left in place to expire, since replicas that have not
+// been upgraded may still read it.
+func (a *apiService) migrateReport(ctx context.Context, id, key string, legacyKeys []string, retention Retention) (DocumentFrequenciesResponse, bool, error) {
+	var report DocumentFrequencyReport
+	for _, legacyKey := range legacyKeys {
+		v, err := shortRetrieve(ctx, a.kv, legacyKey)
+		if err != nil {
+			return report.DocumentFrequenciesResponse, false, errors.WithStack(err)
+		}
+		if v == nil {
+			continue
+		}
+		if err := json.Unmarshal(v, &report); err != nil {
+			return report.DocumentFrequenciesResponse, false, errors.Wrapf(err, "invalid legacy report %s", legacyKey)
+		}
+		report.DocumentID = id
+		if report.Retention == nil || retention.longerThan(*report.Retention) {
+			report.Retention = &retention
+		}
+		data, err := json.Marshal(report)
+		if err != nil {
+			return report.DocumentFrequenciesResponse, false, errors.WithStack(err)
+		}
+		if err := a.kv.Store(ctx, key, data, report.Retention.expiration()); err != nil {
+			return report.DocumentFrequenciesResponse, false, errors.Wrapf(err, "unable to migrate legacy report %s", legacyKey)
+		}
+		_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API cache hit for document %s under legacy key %s", id, legacyKey))
+		return report.DocumentFrequenciesResponse, true, nil
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API cache miss for document %s", id))
+	return report.DocumentFrequenciesResponse, false, nil
+}
+
+// waitForReport polls for the report stored under key until it is available,
+// its job fails or the context is done.
+func (a *apiService) waitForReport(ctx context.Context, key string) (DocumentFrequenciesResponse, error) {
+	var dfr DocumentFrequenciesResponse
+	data, err := a.waitFor(ctx, key, key)
+	if err != nil {
+		return dfr, errors.Wrap(err, "unable to get document report")
+	}
+	err = json.Unmarshal(data, &dfr)
+	return dfr, errors.WithStack(err)
+}
+
+// waitFor polls for the value stored under key until it is available, the
+// job for the report stored under job fails or the context is done.
+func (a *apiService) waitFor(ctx context.Context, job, key string) ([]byte, error) {
 	ticker := time.NewTicker(50 * time.Millisecond)
 	defer ticker.Stop()
-	var data []byte
 	for {
 		select {
 		case <-ticker.C:
-			data, err = shortRetrieve(ctx, a.kv, id)
+			data, err := shortRetrieve(ctx, a.kv, key)
 			if err != nil {
-				return dfr, errors.Wrap(err, "unable to get document report")
+				return nil, err
 			}
 			if data != nil {
-				goto RETRIEVED
+				return data, nil
+			}
+			if err := a.failedJob(ctx, job); err != nil {
+				return nil, err
 			}
 		case <-ctx.Done():
 			_ = a.l.Log("LEVEL", "ERROR", "MESSAGE", "Failed to retrieve value with ID")
-			return dfr, errors.WithStack(ctx.Err())
+			return nil, errors.WithStack(ctx.Err())
 		}
 	}
-RETRIEVED:
-	err = json.Unmarshal(data, &dfr)
-	return dfr, errors.WithStack(err)
 }
 
-func newAPIService(q queue.Queue, kv keyvalue.KeyValue, channel string, l log.Logger) *apiService {
+func newAPIService(conf APIServiceConfig) *apiService {
+	maxSize := conf.MaxDocumentSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDocumentSize
+	}
+	retention := conf.Retention
+	if retention == (Retention{}) {
+		retention = DefaultRetention
+	}
 	return &apiService{
-		q:              q,
-		kv:             kv,
-		requestChannel: channel,
-		l:              l,
+		q:                conf.Queue,
+		kv:               conf.KeyVal,
+		blobs:            conf.Blobs,
+		requestChannel:   conf.Channel,
+		maxDocumentSize:  maxSize,
+		archiveLimits:    conf.ArchiveLimits,
+		index:            conf.Index,
+		documents:        conf.Documents,
+		defaultRetention: retention,
+		tenantRetention:  conf.TenantRetention,
+		maxRetention:     conf.MaxRetention,
+		legacyIDs:        conf.LegacyIDs,
+		l:                conf.Log,
 	}
 }
 
-// NewAPIService returns an APIService.
+// NewAPIService returns an APIService that neither streams, searches nor keeps
+// documents.
 func NewAPIService(q queue.Queue, kv keyvalue.KeyValue, channel string, l log.Logger) APIService {
-	return newAPIService(q, kv, channel, l)
+	return NewAPIServiceWithConfig(APIServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+	})
+}
+
+// NewAPIServiceWithConfig returns an APIService that uses the given
+// configuration.
+func NewAPIServiceWithConfig(conf APIServiceConfig) APIService {
+	return newAPIService(conf)
 }
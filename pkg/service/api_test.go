@@ -3,16 +3,21 @@ package service_test
 import (
 	"context"
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
 	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
 	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
 )
 
 func TestAPI(t *testing.T) {
@@ -28,6 +33,9 @@ func TestAPI(t *testing.T) {
 	go func() {
 		for {
 			d, err := q.Pull(ctx, channel)
+			if ctx.Err() != nil {
+				return
+			}
 			require.NoError(t, err, "Pull from queue should succeed.")
 
 			var dfr service.DocumentID
@@ -45,3 +53,124 @@ func TestAPI(t *testing.T) {
 	})
 	require.NoError(t, err, "Processing document should not error.")
 }
+
+func TestAPIContentTypeNormalized(t *testing.T) {
+	const channel = "worker"
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	apiService := service.NewAPIService(q, kv, channel, log.NewNopLogger())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		d, err := q.Pull(ctx, channel)
+		if ctx.Err() != nil {
+			return
+		}
+		require.NoError(t, err, "Pull from queue should succeed.")
+		var doc service.DocumentID
+		require.NoError(t, json.Unmarshal(d, &doc))
+		assert.Empty(t, doc.ContentType, "Declaring the detected type should not change the options.")
+		// The report of the default options is stored under the ID.
+		require.NoError(t, kv.Store(ctx, doc.ID, []byte(`{}`), 0))
+	}()
+
+	_, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:        "This is a document",
+		AnalysisOptions: service.AnalysisOptions{ContentType: "text/plain; charset=utf-8"},
+	})
+	require.NoError(t, err, "Processing document should share the report of the default options.")
+}
+
+func TestAPIStream(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	blobs := blob.NewStore(kv, blob.Config{ChunkSize: 8})
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+		Blobs:   blobs,
+	})
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+		Blobs:   blobs,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		d, err := q.Pull(ctx, channel)
+		require.NoError(t, err, "Pull from queue should succeed.")
+
+		var doc service.DocumentID
+		err = json.Unmarshal(d, &doc)
+		require.NoError(t, err, "Request should unmarshal successfully.")
+		require.NotEmpty(t, doc.Blob, "Request should refer to a blob.")
+
+		_, err = worker.ParseDocument(ctx, doc)
+		require.NoError(t, err, "Parsing document should succeed.")
+	}()
+
+	dfr, err := apiService.ProcessDocumentStream(ctx, service.DocumentStreamRequest{
+		Body: strings.NewReader("one two two three three three"),
+	})
+	require.NoError(t, err, "Processing document should not error.")
+	assert.NotEmpty(t, dfr.DocumentID, "Document should have an ID.")
+	require.Len(t, dfr.Frequencies, 3, "Should have three unique words.")
+	assert.Equal(t, service.Frequency{Word: "three", Frequency: 3}, dfr.Frequencies[0])
+}
+
+func TestAPIStreamTooLarge(t *testing.T) {
+	chunks := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:           queuemock.New(),
+		KeyVal:          keyvaluemock.New(),
+		Log:             log.NewNopLogger(),
+		Channel:         "worker",
+		Blobs:           blob.NewStore(chunks, blob.Config{ChunkSize: 2}),
+		MaxDocumentSize: 4,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := apiService.ProcessDocumentStream(ctx, service.DocumentStreamRequest{
+		Body: strings.NewReader("too large"),
+	})
+	assert.Equal(t, service.ErrDocumentTooLarge, errors.Cause(err), "Document should be rejected.")
+	assert.Zero(t, chunks.Len(), "Chunks of a rejected document should be deleted.")
+}
+
+func TestAPIInvalidAnalysisOptions(t *testing.T) {
+	apiService := service.NewAPIService(queuemock.New(), keyvaluemock.New(), "worker", log.NewNopLogger())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:        "text",
+		AnalysisOptions: service.AnalysisOptions{Language: "xx"},
+	})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "Unsupported languages should be rejected.")
+
+	_, err = apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:        "text",
+		AnalysisOptions: service.AnalysisOptions{Stemmer: "xx"},
+	})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "Unknown stemmers should be rejected.")
+
+	_, err = apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:        "text",
+		AnalysisOptions: service.AnalysisOptions{SurfaceForms: true},
+	})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "Surface forms should require a stemmer.")
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/archive"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+)
+
+// ArchiveRequest is a request for every text file in an archive to be
+// processed.
+type ArchiveRequest struct {
+	Body io.Reader
+	// ContentType is the media type of the archive.
+	ContentType     string
+	DurationSeconds int
+	// JSONPath is applied to the JSON files in the archive.
+	JSONPath string
+	// CSVColumns is applied to the CSV files in the archive.
+	CSVColumns []string
+	// Stemmer and SurfaceForms are applied to every file in the archive.
+	Stemmer      string
+	SurfaceForms bool
+	Submission
+	RetentionOptions
+}
+
+// analysisOptions returns the options that apply to every file.
+func (r ArchiveRequest) analysisOptions() AnalysisOptions {
+	return AnalysisOptions{
+		JSONPath:     r.JSONPath,
+		CSVColumns:   r.CSVColumns,
+		Stemmer:      r.Stemmer,
+		SurfaceForms: r.SurfaceForms,
+	}
+}
+
+// fileTypes are the content types of files in archives by extension.
+var fileTypes = map[string]string{
+	".txt":      extract.TypePlain,
+	".text":     extract.TypePlain,
+	".md":       extract.TypeMarkdown,
+	".markdown": extract.TypeMarkdown,
+	".htm":      extract.TypeHTML,
+	".html":     extract.TypeHTML,
+	".xhtml":    extract.TypeHTML,
+	".json":     extract.TypeJSON,
+	".csv":      extract.TypeCSV,
+}
+
+// fileContentType returns the content type of a file in an archive, and
+// whether the file is a text file at all.
+//
+// An empty content type means that the type is detected by the worker.
+func fileContentType(name string, r *bufio.Reader) (string, bool) {
+	if t, ok := fileTypes[strings.ToLower(path.Ext(name))]; ok {
+		return t, true
+	}
+	// Errors are returned again when the file is stored.
+	prefix, _ := r.Peek(512)
+	return "", strings.HasPrefix(http.DetectContentType(prefix), "text/")
+}
+
+// ProcessArchive processes every text file in a zip or gzipped tar archive.
+//
+// Each file is stored and enqueued as a separate job. The report that is
+// returned holds the report of each file and the combined frequencies of all
+// of the files.
+func (a *apiService) ProcessArchive(ctx context.Context, request ArchiveRequest) (DocumentFrequenciesResponse, error) {
+	var dfr DocumentFrequenciesResponse
+	if a.blobs == nil {
+		return dfr, errors.New("archives are not supported")
+	}
+	if err := request.analysisOptions().validate(); err != nil {
+		return dfr, err
+	}
+	retention, err := a.retention(ctx, request.RetentionOptions)
+	if err != nil {
+		return dfr, err
+	}
+
+	// Reading zip archives requires random access, so the archive is kept in
+	// a temporary file while it is expanded.
+	f, err := ioutil.TempFile("", "archive")
+	if err != nil {
+		return dfr, errors.Wrap(err, "unable to create temporary file for archive")
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	h := newDocumentHash()
+	size, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(request.Body, a.maxDocumentSize+1))
+	if err != nil {
+		return dfr, errors.Wrap(err, "unable to read archive")
+	}
+	if size > a.maxDocumentSize {
+		return dfr, errors.Wrapf(ErrDocumentTooLarge, "limit is %d bytes", a.maxDocumentSize)
+	}
+	ctx, cancel := context.WithTimeout(ctx, ProcessTimeout)
+	defer cancel()
+
+	id := documentHashID(h)
+	key := resultKey(id, request.analysisOptions())
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("API request to process archive %s", id))
+	if err := a.keepDocument(ctx, id, request.ContentType, request.Submission, io.NewSectionReader(f, 0, size)); err != nil {
+		return dfr, err
+	}
+	dfr, ok, err := a.cachedReport(ctx, id, key, legacyResultKeys(a.legacyDocumentIDs(id, nil), request.analysisOptions()), retention)
+	if err != nil || ok {
+		return dfr, err
+	}
+
+	// The job of the archive runs from when it is expanded here until the
+	// workers merge the counts of its files, so that concurrent uploads of
+	// the same archive are only expanded once.
+	err = transitionJob(ctx, a.kv, key, JobRunning, nil)
+	if errors.Cause(err) == errJobTransition {
+		return a.waitForReport(ctx, key)
+	}
+	if err != nil {
+		return dfr, err
+	}
+	queued, err := a.queueArchive(ctx, f, size, request, id, key, retention)
+	if err != nil || !queued {
+		state := JobSucceeded
+		if err != nil {
+			state = JobFailed
+		}
+		if terr := transitionJob(ctx, a.kv, key, state, err); terr != nil {
+			_ = a.l.Log("LEVEL", "WARN", "MESSAGE", terr.Error())
+		}
+	}
+	if err != nil {
+		return dfr, err
+	}
+	if !queued {
+		return DocumentFrequenciesResponse{DocumentID: id}, nil
+	}
+	return a.waitForReport(ctx, key)
+}
+
+// queueArchive stores every text file of an archive as a blob and pushes a
+// request for each of them onto the worker queue. It reports whether there
+// were any files to count.
+func (a *apiService) queueArchive(ctx context.Context, f io.ReaderAt, size int64, request ArchiveRequest, id, key string, retention Retention) (bool, error) {
+	run, err := newShardRun()
+	if err != nil {
+		return false, err
+	}
+	if err := trackShards(ctx, a.kv, id, key, run); err != nil {
+		return false, err
+	}
+	var jobs [][]byte
+	err = archive.Walk(f, size, request.ContentType, a.archiveLimits, func(name string, r io.Reader) error {
+		br := bufio.NewReader(r)
+		contentType, ok := fileContentType(name, br)
+		if !ok {
+			_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Skipping binary file %q in archive %s", name, id))
+			return nil
+		}
+		// Errors are returned again when the file is stored.
+		prefix, _ := br.Peek(extract.SniffLength)
+		contentType = extract.Normalize(contentType, prefix)
+		fileID, err := a.storeBlob(ctx, br)
+		if err != nil {
+			return err
+		}
+		opts := request.analysisOptions()
+		opts.ContentType = contentType
+		job, err := json.Marshal(DocumentID{
+			DocumentRequest: DocumentRequest{
+				DurationSeconds: request.DurationSeconds,
+				AnalysisOptions: opts,
+			},
+			ID:         fileID,
+			Blob:       fileID,
+			Searchable: a.documents != nil,
+			Shard: &Shard{
+				Parent:    id,
+				Key:       key,
+				Run:       run,
+				Index:     len(jobs),
+				Name:      name,
+				Retention: &retention,
+			},
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	switch errors.Cause(err) {
+	case nil:
+	case archive.ErrLimitExceeded:
+		return false, errors.Wrap(ErrDocumentTooLarge, err.Error())
+	case archive.ErrInvalid:
+		return false, errors.Wrap(ErrInvalidDocument, err.Error())
+	default:
+		return false, errors.Wrapf(err, "unable to expand archive %s", id)
+	}
+	if len(jobs) == 0 {
+		return false, nil
+	}
+
+	// The number of files is known up front, so the workers can merge the
+	// results as soon as the last file is counted.
+	if err := a.kv.SetCounter(ctx, shardDoneKey(key, run), 0); err != nil {
+		return false, errors.Wrap(err, "unable to initialize file count")
+	}
+	if err := a.kv.SetCounter(ctx, shardTotalKey(key, run), int64(len(jobs))); err != nil {
+		return false, errors.Wrap(err, "unable to set file total")
+	}
+	if err := a.q.Push(ctx, a.requestChannel, jobs); err != nil {
+		return false, errors.Wrap(err, "unable to publish file requests")
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Pushed %d file requests for archive %s on channel %s", len(jobs), id, a.requestChannel))
+	return true, nil
+}
@@ -0,0 +1,207 @@
+// Package archive implements support for safely reading the files inside zip
+// and gzipped tar archives.
+//
+// Archives are untrusted input, so the number of entries, the total size of
+// the extracted data and the compression ratio are limited while reading to
+// defend against decompression bombs. Sizes recorded in archive headers are
+// never trusted.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Supported media types.
+const (
+	TypeZip  = "application/zip"
+	TypeGzip = "application/gzip"
+)
+
+// Default limits.
+const (
+	DefaultMaxEntries = 1000
+	DefaultMaxSize    = 256 * 1024 * 1024
+	DefaultMaxRatio   = 100
+)
+
+var (
+	// ErrLimitExceeded is returned when an archive exceeds one of its limits.
+	ErrLimitExceeded = errors.New("archive limit exceeded")
+	// ErrInvalid is returned when an archive cannot be read.
+	ErrInvalid = errors.New("invalid archive")
+)
+
+// Limits contains the limits for reading an archive.
+type Limits struct {
+	// MaxEntries is the largest number of entries, including directories.
+	MaxEntries int
+	// MaxSize is the largest total number of bytes in the extracted files.
+	MaxSize int64
+	// MaxRatio is the largest ratio of the total size of the extracted files
+	// to the size of the archive.
+	MaxRatio float64
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultMaxEntries
+	}
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultMaxSize
+	}
+	if l.MaxRatio <= 0 {
+		l.MaxRatio = DefaultMaxRatio
+	}
+	return l
+}
+
+// MediaType returns the archive media type for a content type, or an empty
+// string if it is not an archive type.
+func MediaType(mediaType string) string {
+	switch mediaType {
+	case TypeZip, "application/x-zip-compressed":
+		return TypeZip
+	case TypeGzip, "application/x-gzip", "application/x-tar+gzip", "application/x-gtar":
+		return TypeGzip
+	default:
+		return ""
+	}
+}
+
+// Walk calls fn for each regular file in the archive of the given media type.
+//
+// The reader passed to fn is only valid until fn returns. Directories, links
+// and metadata files of operating systems are skipped, and names are cleaned
+// so that they are always relative paths.
+func Walk(r io.ReaderAt, size int64, mediaType string, limits Limits, fn func(name string, r io.Reader) error) error {
+	limits = limits.withDefaults()
+	budget := &budget{
+		remaining: limits.MaxSize,
+		ratio:     limits.MaxRatio,
+		archive:   size,
+	}
+	switch MediaType(mediaType) {
+	case TypeZip:
+		return walkZip(r, size, limits, budget, fn)
+	case TypeGzip:
+		return walkTarGzip(r, size, limits, budget, fn)
+	default:
+		return errors.Errorf("unsupported archive type %q", mediaType)
+	}
+}
+
+func walkZip(r io.ReaderAt, size int64, limits Limits, b *budget, fn func(string, io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return errors.Wrap(ErrInvalid, err.Error())
+	}
+	if len(zr.File) > limits.MaxEntries {
+		return errors.Wrapf(ErrLimitExceeded, "more than %d entries", limits.MaxEntries)
+	}
+	for _, f := range zr.File {
+		name, ok := cleanName(f.Name)
+		if !ok || !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return errors.Wrapf(ErrInvalid, "unable to open %q: %s", f.Name, err)
+		}
+		err = fn(name, &limitedReader{r: rc, b: b})
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTarGzip(r io.ReaderAt, size int64, limits Limits, b *budget, fn func(string, io.Reader) error) error {
+	gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return errors.Wrap(ErrInvalid, err.Error())
+	}
+	defer gz.Close()
+	// The tar headers count towards the extracted size as well.
+	tr := tar.NewReader(&limitedReader{r: gz, b: b})
+	for entries := 1; ; entries++ {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if errors.Cause(err) == ErrLimitExceeded {
+				return err
+			}
+			return errors.Wrap(ErrInvalid, err.Error())
+		}
+		if entries > limits.MaxEntries {
+			return errors.Wrapf(ErrLimitExceeded, "more than %d entries", limits.MaxEntries)
+		}
+		name, ok := cleanName(h.Name)
+		if !ok || h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanName returns a clean relative path for an entry, and whether the entry
+// should be read at all.
+func cleanName(name string) (string, bool) {
+	name = path.Clean("/" + strings.Replace(name, "\\", "/", -1))
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// budget tracks the number of bytes that may still be extracted from an
+// archive.
+type budget struct {
+	remaining int64
+	ratio     float64
+	archive   int64
+	read      int64
+}
+
+func (b *budget) consume(n int) error {
+	b.remaining -= int64(n)
+	b.read += int64(n)
+	if b.remaining < 0 {
+		return errors.Wrap(ErrLimitExceeded, "extracted data is too large")
+	}
+	if float64(b.read) > b.ratio*float64(b.archive) {
+		return errors.Wrap(ErrLimitExceeded, "compression ratio is too high")
+	}
+	return nil
+}
+
+// limitedReader reads from r until the budget is exhausted.
+type limitedReader struct {
+	r io.Reader
+	b *budget
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if berr := l.b.consume(n); berr != nil {
+		return n, berr
+	}
+	return n, err
+}
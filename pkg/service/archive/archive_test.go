@@ -0,0 +1,139 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/archive"
+)
+
+type file struct {
+	name string
+	data string
+	dir  bool
+}
+
+func makeZip(t *testing.T, files []file) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		name := f.name
+		if f.dir {
+			name += "/"
+		}
+		w, err := zw.Create(name)
+		require.NoError(t, err, "Creating zip entry should succeed.")
+		_, err = io.WriteString(w, f.data)
+		require.NoError(t, err, "Writing zip entry should succeed.")
+	}
+	require.NoError(t, zw.Close(), "Closing zip should succeed.")
+	return buf.Bytes()
+}
+
+func makeTarGzip(t *testing.T, files []file) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		h := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}
+		if f.dir {
+			h = &tar.Header{Name: f.name + "/", Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		require.NoError(t, tw.WriteHeader(h), "Writing tar header should succeed.")
+		_, err := io.WriteString(tw, f.data)
+		require.NoError(t, err, "Writing tar entry should succeed.")
+	}
+	require.NoError(t, tw.Close(), "Closing tar should succeed.")
+	require.NoError(t, gw.Close(), "Closing gzip should succeed.")
+	return buf.Bytes()
+}
+
+func walk(data []byte, mediaType string, limits archive.Limits) (map[string]string, error) {
+	found := make(map[string]string)
+	err := archive.Walk(bytes.NewReader(data), int64(len(data)), mediaType, limits, func(name string, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		found[name] = string(b)
+		return err
+	})
+	return found, err
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	files := []file{
+		{name: "docs", dir: true},
+		{name: "docs/a.txt", data: "first document"},
+		{name: "/b.md", data: "# second"},
+		{name: "../../c.txt", data: "third"},
+		{name: "__MACOSX/docs/._a.txt", data: "metadata"},
+		{name: ".DS_Store", data: "metadata"},
+	}
+	expected := map[string]string{
+		"docs/a.txt": "first document",
+		"b.md":       "# second",
+		"c.txt":      "third",
+	}
+
+	for _, test := range []struct {
+		mediaType string
+		data      []byte
+	}{
+		{"application/zip", makeZip(t, files)},
+		{"application/gzip", makeTarGzip(t, files)},
+	} {
+		found, err := walk(test.data, test.mediaType, archive.Limits{})
+		require.NoError(t, err, "Walking %s archive should succeed.", test.mediaType)
+		assert.Equal(t, expected, found, "Files of %s archive should match.", test.mediaType)
+	}
+}
+
+func TestWalkLimits(t *testing.T) {
+	t.Parallel()
+
+	var many []file
+	for i := 0; i < 5; i++ {
+		many = append(many, file{name: strings.Repeat("x", i+1), data: "text"})
+	}
+	// Highly compressible data, as found in decompression bombs.
+	bomb := []file{{name: "bomb.txt", data: strings.Repeat("a", 1024*1024)}}
+	large := []file{{name: "large.txt", data: strings.Repeat("word ", 1000)}}
+
+	tests := []struct {
+		name   string
+		files  []file
+		limits archive.Limits
+	}{
+		{"Entries", many, archive.Limits{MaxEntries: 4}},
+		{"Ratio", bomb, archive.Limits{}},
+		{"Size", large, archive.Limits{MaxSize: 1000, MaxRatio: 1000}},
+	}
+	for _, test := range tests {
+		for mediaType, data := range map[string][]byte{
+			"application/zip":  makeZip(t, test.files),
+			"application/gzip": makeTarGzip(t, test.files),
+		} {
+			_, err := walk(data, mediaType, test.limits)
+			assert.Equal(t, archive.ErrLimitExceeded, errors.Cause(err), "%s limit should be enforced for %s.", test.name, mediaType)
+		}
+	}
+}
+
+func TestWalkInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, mediaType := range []string{"application/zip", "application/gzip"} {
+		_, err := walk([]byte("not an archive"), mediaType, archive.Limits{})
+		assert.Equal(t, archive.ErrInvalid, errors.Cause(err), "Invalid %s archive should fail.", mediaType)
+	}
+}
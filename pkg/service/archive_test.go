@@ -0,0 +1,104 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+)
+
+func TestAPIArchive(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	blobs := blob.NewStore(kv, blob.Config{})
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+		Blobs:   blobs,
+	})
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+		Blobs:   blobs,
+	})
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string]string{
+		"a.txt":          "apple banana apple",
+		"docs/b.html":    "<p>banana <b>apple</b></p><p>cherry</p>",
+		"image.bin":      "\x00\x01\x02 binary",
+		"docs/.hidden.t": "hidden hidden",
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err, "Creating zip entry should succeed.")
+		_, err = io.WriteString(w, data)
+		require.NoError(t, err, "Writing zip entry should succeed.")
+	}
+	require.NoError(t, zw.Close(), "Closing zip should succeed.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		for {
+			d, err := q.Pull(ctx, channel)
+			if ctx.Err() != nil {
+				return
+			}
+			require.NoError(t, err, "Pull from queue should succeed.")
+			var doc service.DocumentID
+			require.NoError(t, json.Unmarshal(d, &doc), "Request should unmarshal successfully.")
+			_, err = worker.ParseDocument(ctx, doc)
+			require.NoError(t, err, "Parsing file should succeed.")
+		}
+	}()
+
+	dfr, err := apiService.ProcessArchive(ctx, service.ArchiveRequest{
+		Body:        &buf,
+		ContentType: "application/zip",
+	})
+	require.NoError(t, err, "Processing archive should succeed.")
+	assert.NotEmpty(t, dfr.DocumentID, "Archive should have an ID.")
+	assert.Equal(t, []service.Frequency{
+		{Word: "apple", Frequency: 3},
+		{Word: "banana", Frequency: 2},
+		{Word: "cherry", Frequency: 1},
+	}, dfr.Frequencies, "Aggregate frequencies should cover all text files.")
+
+	files := make(map[string][]service.Frequency)
+	for _, f := range dfr.Files {
+		assert.NotEmpty(t, f.DocumentID, "File %s should have an ID.", f.Name)
+		files[f.Name] = f.Frequencies
+	}
+	assert.Equal(t, map[string][]service.Frequency{
+		"a.txt": {
+			{Word: "apple", Frequency: 2},
+			{Word: "banana", Frequency: 1},
+		},
+		"docs/b.html": {
+			{Word: "apple", Frequency: 1},
+			{Word: "banana", Frequency: 1},
+			{Word: "cherry", Frequency: 1},
+		},
+	}, files, "Each text file should have its own report.")
+}
@@ -0,0 +1,286 @@
+// Package blob implements support for storing large binary objects as a
+// sequence of fixed size chunks on top of a key value store.
+//
+// Blobs are written and read in a streaming fashion so that only a single
+// chunk needs to be held in memory at a time.
+package blob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// DefaultChunkSize is the chunk size used when none is configured.
+const DefaultChunkSize = 256 * 1024
+
+// abortTimeout bounds how long Abort spends deleting the chunks of an upload.
+const abortTimeout = 30 * time.Second
+
+// ErrNotFound is returned when a blob does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// Config contains the configuration for a Store.
+type Config struct {
+	// ChunkSize is the maximum number of bytes stored under a single key.
+	ChunkSize int
+	// Expiration is how long chunks and manifests are kept for. If it is 0,
+	// blobs never expire.
+	Expiration time.Duration
+}
+
+// Manifest describes a stored blob.
+type Manifest struct {
+	// Upload is the identifier that the chunks of the blob are stored under.
+	Upload string
+	Size   int64
+	Chunks int
+}
+
+// Store stores and retrieves chunked blobs.
+type Store struct {
+	kv         keyvalue.KeyValue
+	chunkSize  int
+	expiration time.Duration
+}
+
+// NewStore returns a Store that keeps its chunks in kv.
+func NewStore(kv keyvalue.KeyValue, conf Config) *Store {
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = DefaultChunkSize
+	}
+	return &Store{
+		kv:         kv,
+		chunkSize:  conf.ChunkSize,
+		expiration: conf.Expiration,
+	}
+}
+
+func manifestKey(id string) string {
+	return keyspace.Blob(id)
+}
+
+func chunkKey(upload string, n int) string {
+	return keyspace.BlobChunk(upload, n)
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate upload ID")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create starts a new upload.
+//
+// The data written to the returned Writer is not visible to readers until it
+// is committed under an ID.
+func (s *Store) Create(ctx context.Context) (*Writer, error) {
+	upload, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		ctx:    ctx,
+		s:      s,
+		upload: upload,
+		buf:    make([]byte, 0, s.chunkSize),
+	}, nil
+}
+
+// Open opens the blob stored under id for reading.
+//
+// ErrNotFound is returned if there is no blob with the given ID.
+func (s *Store) Open(ctx context.Context, id string) (*Reader, error) {
+	data, err := s.kv.Retrieve(ctx, manifestKey(id))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to retrieve manifest for blob %q", id)
+	}
+	if data == nil {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrapf(err, "invalid manifest for blob %q", id)
+	}
+	return &Reader{
+		ctx: ctx,
+		s:   s,
+		m:   m,
+	}, nil
+}
+
+// Delete deletes the blob stored under id and its chunks.
+//
+// ErrNotFound is returned if there is no blob with the given ID.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	r, err := s.Open(ctx, id)
+	if err != nil {
+		return err
+	}
+	// The manifest is deleted first so that the blob is never readable with
+	// missing chunks.
+	if err := s.kv.Delete(ctx, manifestKey(id)); err != nil {
+		return errors.Wrapf(err, "unable to delete manifest for blob %q", id)
+	}
+	for n := 0; n < r.m.Chunks; n++ {
+		key := chunkKey(r.m.Upload, n)
+		if err := s.kv.Delete(ctx, key); err != nil {
+			return errors.Wrapf(err, "unable to delete blob chunk %q", key)
+		}
+	}
+	return nil
+}
+
+// Writer writes a blob one chunk at a time.
+type Writer struct {
+	ctx    context.Context
+	s      *Store
+	upload string
+	buf    []byte
+	chunks int
+	size   int64
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *Writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	key := chunkKey(w.upload, w.chunks)
+	if err := w.s.kv.Store(w.ctx, key, w.buf, w.s.expiration); err != nil {
+		return errors.Wrapf(err, "unable to store blob chunk %q", key)
+	}
+	w.chunks++
+	w.size += int64(len(w.buf))
+	w.buf = make([]byte, 0, w.s.chunkSize)
+	return nil
+}
+
+// Size returns the number of bytes written so far.
+func (w *Writer) Size() int64 {
+	return w.size + int64(len(w.buf))
+}
+
+// Abort discards the upload, deleting the chunks that were already stored.
+//
+// Writers that are not committed must be aborted, since their chunks are never
+// readable and only expire if the store has an expiration. The chunks are
+// deleted even if the context of the writer is done, as uploads are usually
+// aborted because their request failed.
+func (w *Writer) Abort() error {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	w.buf = w.buf[:0]
+	for n := 0; n < w.chunks; n++ {
+		key := chunkKey(w.upload, n)
+		if err := w.s.kv.Delete(ctx, key); err != nil {
+			return errors.Wrapf(err, "unable to delete blob chunk %q", key)
+		}
+	}
+	w.chunks = 0
+	w.size = 0
+	return nil
+}
+
+// Commit flushes any buffered data and makes the blob readable under id.
+func (w *Writer) Commit(id string) (Manifest, error) {
+	m, err := w.Close()
+	if err != nil {
+		return Manifest{}, err
+	}
+	if err := w.s.Link(w.ctx, id, m); err != nil {
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// Close flushes any buffered data and returns the manifest of the upload,
+// without making the blob readable. The manifest can then be linked under one
+// or more IDs, and the writer must still be aborted if it never is.
+func (w *Writer) Close() (Manifest, error) {
+	if err := w.flush(); err != nil {
+		return Manifest{}, err
+	}
+	return Manifest{
+		Upload: w.upload,
+		Size:   w.size,
+		Chunks: w.chunks,
+	}, nil
+}
+
+// Link makes the blob described by m readable under id. Its chunks must be
+// kept in the same key value store, and they are not copied: they are shared
+// by every ID that the blob is linked under and only expire if the store that
+// wrote them has an expiration. The manifest expires with the expiration of s.
+func (s *Store) Link(ctx context.Context, id string, m Manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.kv.Store(ctx, manifestKey(id), data, s.expiration); err != nil {
+		return errors.Wrapf(err, "unable to store manifest for blob %q", id)
+	}
+	return nil
+}
+
+// Reader reads a blob one chunk at a time.
+type Reader struct {
+	ctx  context.Context
+	s    *Store
+	m    Manifest
+	next int
+	buf  []byte
+}
+
+// Manifest returns the manifest of the blob being read.
+func (r *Reader) Manifest() Manifest {
+	return r.m
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next >= r.m.Chunks {
+			return 0, io.EOF
+		}
+		key := chunkKey(r.m.Upload, r.next)
+		data, err := r.s.kv.Retrieve(r.ctx, key)
+		if err != nil {
+			return 0, errors.Wrapf(err, "unable to retrieve blob chunk %q", key)
+		}
+		if data == nil {
+			return 0, errors.Errorf("missing blob chunk %q", key)
+		}
+		r.buf = data
+		r.next++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,136 @@
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+func TestWriteRead(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	s := blob.NewStore(keyvaluemock.New(), blob.Config{ChunkSize: 4})
+	w, err := s.Create(ctx)
+	require.NoError(t, err, "Creating a blob should succeed.")
+
+	data := []byte("This is some data that spans several chunks.")
+	for _, part := range bytes.SplitAfter(data, []byte(" ")) {
+		_, err := w.Write(part)
+		require.NoError(t, err, "Writing to a blob should succeed.")
+	}
+	assert.EqualValues(t, len(data), w.Size(), "Size should match written data.")
+
+	m, err := w.Commit("id")
+	require.NoError(t, err, "Committing a blob should succeed.")
+	assert.EqualValues(t, len(data), m.Size, "Manifest size should match written data.")
+	assert.Equal(t, (len(data)+3)/4, m.Chunks, "Data should be split into chunks.")
+
+	r, err := s.Open(ctx, "id")
+	require.NoError(t, err, "Opening a blob should succeed.")
+	read, err := ioutil.ReadAll(r)
+	require.NoError(t, err, "Reading a blob should succeed.")
+	assert.Equal(t, data, read, "Read data should match written data.")
+}
+
+func TestEmpty(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	s := blob.NewStore(keyvaluemock.New(), blob.Config{})
+	w, err := s.Create(ctx)
+	require.NoError(t, err, "Creating a blob should succeed.")
+	m, err := w.Commit("empty")
+	require.NoError(t, err, "Committing a blob should succeed.")
+	assert.Equal(t, 0, m.Chunks, "Empty blob should have no chunks.")
+
+	r, err := s.Open(ctx, "empty")
+	require.NoError(t, err, "Opening a blob should succeed.")
+	read, err := ioutil.ReadAll(r)
+	require.NoError(t, err, "Reading a blob should succeed.")
+	assert.Empty(t, read, "Empty blob should have no data.")
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	s := blob.NewStore(keyvaluemock.New(), blob.Config{ChunkSize: 4})
+	w, err := s.Create(ctx)
+	require.NoError(t, err, "Creating a blob should succeed.")
+	_, err = w.Write([]byte("Some data to delete."))
+	require.NoError(t, err, "Writing to a blob should succeed.")
+	_, err = w.Commit("id")
+	require.NoError(t, err, "Committing a blob should succeed.")
+
+	require.NoError(t, s.Delete(ctx, "id"), "Deleting a blob should succeed.")
+
+	// The mock blocks until a value is stored, so a missing blob times out.
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	_, err = s.Open(shortCtx, "id")
+	assert.Error(t, err, "Deleted blob should not be readable.")
+}
+
+func TestAbort(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	kv := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	s := blob.NewStore(kv, blob.Config{ChunkSize: 4})
+	w, err := s.Create(ctx)
+	require.NoError(t, err, "Creating a blob should succeed.")
+	_, err = w.Write([]byte("Some data that is never committed."))
+	require.NoError(t, err, "Writing to a blob should succeed.")
+	require.NotZero(t, kv.Len(), "Full chunks should be stored while writing.")
+
+	// Uploads are aborted after their requests are canceled.
+	cancel()
+	require.NoError(t, w.Abort(), "Aborting a blob should succeed.")
+	assert.Zero(t, kv.Len(), "Aborted blob should leave no chunks behind.")
+}
+
+func TestLink(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	kv := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	s := blob.NewStore(kv, blob.Config{ChunkSize: 4})
+	w, err := s.Create(ctx)
+	require.NoError(t, err, "Creating a blob should succeed.")
+	data := []byte("Some data that is linked twice.")
+	_, err = w.Write(data)
+	require.NoError(t, err, "Writing to a blob should succeed.")
+	m, err := w.Close()
+	require.NoError(t, err, "Closing a blob should succeed.")
+	chunks := kv.Len()
+
+	for _, id := range []string{"first", "second"} {
+		require.NoError(t, s.Link(ctx, id, m), "Linking a blob should succeed.")
+		r, err := s.Open(ctx, id)
+		require.NoError(t, err, "Opening a linked blob should succeed.")
+		read, err := ioutil.ReadAll(r)
+		require.NoError(t, err, "Reading a linked blob should succeed.")
+		assert.Equal(t, data, read, "Linked blob should hold the written data.")
+	}
+	assert.Equal(t, chunks+2, kv.Len(), "Linked blobs should share their chunks.")
+}
@@ -0,0 +1,182 @@
+// Package codec encodes the values that the Redis adapters store, so that
+// values are stored as compact binary instead of base64.
+//
+// Every encoded value starts with a header byte that identifies its encoding,
+// so the encoding of stored values can change without migrating them. The
+// header bytes are not base64 characters, so values stored as base64 by
+// earlier versions are still read.
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Encoding identifies how a value is encoded. It is stored as the header byte
+// of the value.
+type Encoding byte
+
+// Encodings of values.
+const (
+	// Raw values are stored as they are after the header byte.
+	Raw Encoding = 0x00
+	// Gzip values are compressed with gzip.
+	Gzip Encoding = 0x01
+	// Zstd is reserved for values compressed with Zstandard, which is not
+	// supported yet.
+	Zstd Encoding = 0x02
+	// Base64 values are the standard base64 encoding of the value without a
+	// header byte, as stored by earlier versions.
+	Base64 Encoding = 0xff
+)
+
+// String returns the name of the encoding.
+func (e Encoding) String() string {
+	switch e {
+	case Raw:
+		return "raw"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Base64:
+		return "base64"
+	}
+	return "unknown"
+}
+
+// ParseEncoding returns the encoding with the given name.
+func ParseEncoding(name string) (Encoding, error) {
+	for _, e := range []Encoding{Raw, Gzip, Zstd, Base64} {
+		if e.String() == name {
+			return e, nil
+		}
+	}
+	return 0, errors.Errorf("unknown encoding %q", name)
+}
+
+// DefaultThreshold is the size of the smallest value that is compressed when
+// no threshold is configured. Smaller values rarely get smaller.
+const DefaultThreshold = 1024
+
+// Config contains the configuration for a Codec.
+type Config struct {
+	// Encoding is how values are written. Raw, the default, stores values
+	// uncompressed, and Gzip compresses values of at least Threshold bytes.
+	//
+	// Base64 writes values like earlier versions, so that processes that
+	// have not been upgraded can read them during a rollout.
+	Encoding Encoding
+	// Threshold is the size of the smallest value that is compressed.
+	// DefaultThreshold is used if it is 0.
+	Threshold int
+}
+
+// Codec encodes and decodes values. Values of any encoding are decoded,
+// whatever encoding the Codec writes.
+type Codec struct {
+	encoding  Encoding
+	threshold int
+	writers   sync.Pool
+}
+
+// Default is the Codec that writes raw values.
+var Default = &Codec{encoding: Raw, threshold: DefaultThreshold}
+
+// New returns a new Codec.
+func New(conf Config) (*Codec, error) {
+	switch conf.Encoding {
+	case Raw, Gzip, Base64:
+	case Zstd:
+		return nil, errors.New("zstd compression is not supported")
+	default:
+		return nil, errors.Errorf("unknown encoding %#x", byte(conf.Encoding))
+	}
+	if conf.Threshold <= 0 {
+		conf.Threshold = DefaultThreshold
+	}
+	return &Codec{encoding: conf.Encoding, threshold: conf.Threshold}, nil
+}
+
+// Encode encodes a value.
+//
+// Values are only stored compressed if that makes them smaller.
+func (c *Codec) Encode(data []byte) []byte {
+	switch {
+	case c.encoding == Base64:
+		return []byte(base64.StdEncoding.EncodeToString(data))
+	case c.encoding == Gzip && len(data) >= c.threshold:
+		if compressed, ok := c.gzip(data); ok {
+			return compressed
+		}
+	}
+	value := make([]byte, 1+len(data))
+	value[0] = byte(Raw)
+	copy(value[1:], data)
+	return value
+}
+
+// gzip returns data compressed with gzip after its header byte, if that is
+// smaller than storing it raw.
+func (c *Codec) gzip(data []byte) ([]byte, bool) {
+	var b bytes.Buffer
+	b.WriteByte(byte(Gzip))
+	zw, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		zw.Reset(&b)
+	} else {
+		zw = gzip.NewWriter(&b)
+	}
+	defer c.writers.Put(zw)
+	// Writes to a bytes.Buffer do not fail.
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+	if b.Len() >= 1+len(data) {
+		return nil, false
+	}
+	return b.Bytes(), true
+}
+
+// Decode decodes a value of any encoding.
+func (c *Codec) Decode(value []byte) ([]byte, error) {
+	e, err := EncodingOf(value)
+	if err != nil {
+		return nil, err
+	}
+	switch e {
+	case Raw:
+		return value[1:], nil
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(value[1:]))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid gzip value")
+		}
+		data, err := ioutil.ReadAll(zr)
+		return data, errors.Wrap(err, "invalid gzip value")
+	case Zstd:
+		return nil, errors.New("zstd values are not supported")
+	}
+	data, err := base64.StdEncoding.DecodeString(string(value))
+	return data, errors.Wrap(err, "unable to decode value as base64")
+}
+
+// EncodingOf returns the encoding of an encoded value.
+func EncodingOf(value []byte) (Encoding, error) {
+	if len(value) == 0 {
+		// The empty value was stored as the empty base64 string.
+		return Base64, nil
+	}
+	switch e := Encoding(value[0]); e {
+	case Raw, Gzip, Zstd:
+		return e, nil
+	}
+	if value[0] < 0x20 || value[0] >= 0x7f {
+		return 0, errors.Errorf("unknown encoding %#x", value[0])
+	}
+	return Base64, nil
+}
@@ -0,0 +1,92 @@
+package codec_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/codec"
+)
+
+func TestCodec(t *testing.T) {
+	t.Parallel()
+
+	compressible := bytes.Repeat([]byte(`{"Word":"the","Count":1}`), 100)
+	random := make([]byte, 2048)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	gzipCodec, err := codec.New(codec.Config{Encoding: codec.Gzip, Threshold: 100})
+	require.NoError(t, err)
+	legacyCodec, err := codec.New(codec.Config{Encoding: codec.Base64})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		codec    *codec.Codec
+		data     []byte
+		encoding codec.Encoding
+	}{
+		{"Raw", codec.Default, compressible, codec.Raw},
+		{"Empty", codec.Default, []byte{}, codec.Raw},
+		{"Gzip", gzipCodec, compressible, codec.Gzip},
+		{"BelowThreshold", gzipCodec, compressible[:99], codec.Raw},
+		{"Incompressible", gzipCodec, random, codec.Raw},
+		{"Base64", legacyCodec, compressible, codec.Base64},
+	}
+	for _, test := range tests {
+		value := test.codec.Encode(test.data)
+		e, err := codec.EncodingOf(value)
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.encoding, e, "%s: value should have the expected encoding.", test.name)
+		// Every codec decodes every encoding.
+		for _, c := range []*codec.Codec{codec.Default, gzipCodec, legacyCodec} {
+			got, err := c.Decode(value)
+			require.NoError(t, err, test.name)
+			assert.Equal(t, test.data, got, "%s: value should be decoded.", test.name)
+		}
+	}
+
+	value := gzipCodec.Encode(compressible)
+	assert.True(t, len(value) < len(compressible)/10, "Repetitive values should be compressed.")
+	assert.Len(t, codec.Default.Encode(compressible), len(compressible)+1, "Raw values should only have a header byte.")
+}
+
+func TestCodecLegacyValues(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range [][]byte{[]byte(`{"DocumentID":"abc"}`), {0, 1, 2, 0xff}, {}} {
+		legacy := []byte(base64.StdEncoding.EncodeToString(data))
+		got, err := codec.Default.Decode(legacy)
+		require.NoError(t, err, "Base64 values of earlier versions should be decoded.")
+		assert.Equal(t, data, got)
+	}
+
+	_, err := codec.Default.Decode([]byte{0x10, 'a'})
+	assert.Error(t, err, "Unknown header bytes should be rejected.")
+	_, err = codec.Default.Decode([]byte{byte(codec.Gzip), 'a'})
+	assert.Error(t, err, "Invalid compressed values should be rejected.")
+	_, err = codec.Default.Decode([]byte("not base64!"))
+	assert.Error(t, err, "Invalid base64 values should be rejected.")
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	_, err := codec.New(codec.Config{Encoding: codec.Zstd})
+	assert.Error(t, err, "Zstandard is not supported.")
+	_, err = codec.New(codec.Config{Encoding: 0x42})
+	assert.Error(t, err, "Unknown encodings should be rejected.")
+
+	for _, name := range []string{"raw", "gzip", "base64"} {
+		e, err := codec.ParseEncoding(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, e.String())
+	}
+	_, err = codec.ParseEncoding("lz4")
+	assert.Error(t, err)
+}
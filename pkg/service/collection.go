@@ -0,0 +1,442 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+var (
+	// ErrNotFound is returned when a requested resource does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidRequest is returned when a request is malformed.
+	ErrInvalidRequest = errors.New("invalid request")
+)
+
+// DefaultCollectionTopN is the number of terms returned for a collection when
+// no number is requested.
+const DefaultCollectionTopN = 10
+
+// maxCollectionTopN is the largest number of terms returned for a collection.
+const maxCollectionTopN = 1000
+
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+
+// CollectionDocumentRequest is a request for a document to be added to a
+// collection.
+type CollectionDocumentRequest struct {
+	Collection string
+	DocumentRequest
+}
+
+// CollectionRemoveRequest is a request for a document to be removed from a
+// collection.
+type CollectionRemoveRequest struct {
+	Collection string
+	DocumentID string
+}
+
+// CollectionFrequenciesRequest is a request for the most frequent words of a
+// collection.
+type CollectionFrequenciesRequest struct {
+	Collection string
+	// N is the number of words to return. DefaultCollectionTopN is used if it
+	// is 0.
+	N int
+}
+
+// CollectionResponse describes a collection after a document was added to or
+// removed from it.
+type CollectionResponse struct {
+	Collection string
+	DocumentID string
+	Documents  int
+}
+
+// CollectionFrequenciesResponse holds the most frequent words across the
+// documents of a collection.
+type CollectionFrequenciesResponse struct {
+	Collection  string
+	Documents   int
+	Frequencies []CollectionFrequency
+}
+
+// CollectionFrequency describes the frequency of a word in a collection.
+type CollectionFrequency struct {
+	Word      string
+	Frequency int
+	// DocumentFrequency is the number of documents that contain the word.
+	DocumentFrequency int
+}
+
+// collectionStats is the running aggregate for a collection.
+type collectionStats struct {
+	Documents int
+	// Terms is the number of occurrences of each word across all documents.
+	Terms map[string]int
+	// DocumentFrequency is the number of documents that contain each word.
+	DocumentFrequency map[string]int
+	// TotalTerms is the number of words across all documents.
+	TotalTerms int64
+}
+
+func collectionKey(name string) string {
+	return keyspace.Collection(name)
+}
+
+// collectionMembersKey returns the key that the IDs of the documents in a
+// collection are stored under. They are kept apart from the aggregate, which
+// is read for every scored document.
+func collectionMembersKey(name string) string {
+	return keyspace.CollectionMembers(name)
+}
+
+func collectionDocumentKey(name, id string) string {
+	return keyspace.CollectionDocument(name, id)
+}
+
+func validateCollection(name string) error {
+	if !collectionNamePattern.MatchString(name) {
+		return errors.Wrapf(ErrInvalidRequest, "invalid collection name %q", name)
+	}
+	return nil
+}
+
+// add adds the counts of a document to the aggregate.
+func (c *collectionStats) add(words map[string]int) {
+	if c.Terms == nil {
+		c.Terms = make(map[string]int)
+		c.DocumentFrequency = make(map[string]int)
+	}
+	c.Documents++
+	for word, count := range words {
+		c.Terms[word] += count
+		c.DocumentFrequency[word]++
+		c.TotalTerms += int64(count)
+	}
+}
+
+// remove subtracts the counts of a document from the aggregate.
+func (c *collectionStats) remove(words map[string]int) {
+	c.Documents--
+	for word, count := range words {
+		c.Terms[word] -= count
+		if c.Terms[word] <= 0 {
+			delete(c.Terms, word)
+		}
+		c.DocumentFrequency[word]--
+		if c.DocumentFrequency[word] <= 0 {
+			delete(c.DocumentFrequency, word)
+		}
+		c.TotalTerms -= int64(count)
+	}
+}
+
+// documentTerms returns the counts of every word of a document, analyzing the
+// document if the counts are not cached.
+func (a *apiService) documentTerms(ctx context.Context, workerRequest DocumentID) (map[string]int, error) {
+	job := resultKey(workerRequest.ID, workerRequest.AnalysisOptions)
+	key := termsKey(job)
+	data, err := shortRetrieve(ctx, a.kv, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if data == nil {
+		if err := a.startJob(ctx, workerRequest, job); err != nil {
+			return nil, err
+		}
+		data, err = a.waitFor(ctx, job, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get word counts for document %s", workerRequest.ID)
+		}
+	}
+	var words map[string]int
+	err = json.Unmarshal(data, &words)
+	return words, errors.WithStack(err)
+}
+
+// loadCollection retrieves the aggregate for a collection. A collection that
+// does not exist is empty.
+func (a *apiService) loadCollection(ctx context.Context, name string) (collectionStats, error) {
+	var stats collectionStats
+	data, err := a.kv.Retrieve(ctx, collectionKey(name))
+	if err != nil {
+		return stats, errors.Wrapf(err, "unable to retrieve collection %q", name)
+	}
+	if data == nil {
+		return stats, nil
+	}
+	err = json.Unmarshal(data, &stats)
+	return stats, errors.Wrapf(err, "invalid collection %q", name)
+}
+
+// collectionMembers returns the IDs of the documents in a collection.
+func (a *apiService) collectionMembers(ctx context.Context, name string) ([]string, error) {
+	members, err := retrieveStrings(ctx, a.kv, collectionMembersKey(name))
+	return members, errors.Wrapf(err, "unable to retrieve members of collection %q", name)
+}
+
+// addMember adds a document to the members of a collection.
+func (a *apiService) addMember(ctx context.Context, name, id string) error {
+	err := updateStrings(ctx, a.kv, collectionMembersKey(name), 0, func(members []string) ([]string, bool) {
+		if containsString(members, id) {
+			return members, false
+		}
+		return append(members, id), true
+	})
+	return errors.Wrapf(err, "unable to add document %s to members of collection %q", id, name)
+}
+
+// removeMember removes a document from the members of a collection.
+func (a *apiService) removeMember(ctx context.Context, name, id string) error {
+	err := updateStrings(ctx, a.kv, collectionMembersKey(name), 0, func(members []string) ([]string, bool) {
+		if !containsString(members, id) {
+			return members, false
+		}
+		var kept []string
+		for _, member := range members {
+			if member != id {
+				kept = append(kept, member)
+			}
+		}
+		return kept, true
+	})
+	return errors.Wrapf(err, "unable to remove document %s from members of collection %q", id, name)
+}
+
+// updateCollection atomically changes the aggregate for a collection with
+// update, which may be called more than once, and returns the stored
+// aggregate.
+func (a *apiService) updateCollection(ctx context.Context, name string, update func(stats *collectionStats)) (collectionStats, error) {
+	var stats collectionStats
+	err := keyvalue.Update(ctx, a.kv, collectionKey(name), 0, func(data []byte) ([]byte, error) {
+		stats = collectionStats{}
+		if data != nil {
+			if err := json.Unmarshal(data, &stats); err != nil {
+				return nil, errors.Wrapf(err, "invalid collection %q", name)
+			}
+		}
+		update(&stats)
+		data, err := json.Marshal(stats)
+		return data, errors.WithStack(err)
+	})
+	return stats, errors.Wrapf(err, "unable to store collection %q", name)
+}
+
+// AddToCollection analyzes a document and adds its word counts to a
+// collection.
+//
+// Adding a document that is already in the collection has no effect.
+func (a *apiService) AddToCollection(ctx context.Context, request CollectionDocumentRequest) (CollectionResponse, error) {
+	var cr CollectionResponse
+	if err := validateCollection(request.Collection); err != nil {
+		return cr, err
+	}
+	if err := request.AnalysisOptions.validate(); err != nil {
+		return cr, err
+	}
+	retention, err := a.retention(ctx, request.RetentionOptions)
+	if err != nil {
+		return cr, err
+	}
+	id := documentIDOf(request.Document)
+	if err := a.keepDocument(ctx, id, request.ContentType, request.Submission, strings.NewReader(request.Document)); err != nil {
+		return cr, err
+	}
+	words, err := a.documentTerms(ctx, DocumentID{
+		DocumentRequest: request.DocumentRequest,
+		ID:              id,
+		Retention:       &retention,
+	})
+	if err != nil {
+		return cr, err
+	}
+
+	cr = CollectionResponse{
+		Collection: request.Collection,
+		DocumentID: id,
+	}
+	// Documents added before IDs were versioned are members under their
+	// legacy ID, which is needed to remove them.
+	for _, memberID := range a.legacyDocumentIDs(id, []byte(request.Document)) {
+		member, err := shortRetrieve(ctx, a.kv, collectionDocumentKey(request.Collection, memberID))
+		if err != nil {
+			return cr, errors.WithStack(err)
+		}
+		if member != nil {
+			cr.DocumentID = memberID
+			return a.collectionSize(ctx, cr)
+		}
+	}
+
+	// The contribution of the document is kept so that it can be subtracted
+	// when the document is removed. Only the request that stores it adds the
+	// document to the aggregate, so concurrent requests add it once.
+	data, err := json.Marshal(words)
+	if err != nil {
+		return cr, errors.WithStack(err)
+	}
+	key := collectionDocumentKey(request.Collection, id)
+	added, err := a.kv.StoreIfAbsent(ctx, key, data, 0)
+	if err != nil {
+		return cr, errors.Wrapf(err, "unable to add document %s to collection %q", id, request.Collection)
+	}
+	if !added {
+		return a.collectionSize(ctx, cr)
+	}
+	err = a.addMember(ctx, request.Collection, id)
+	var stats collectionStats
+	if err == nil {
+		stats, err = a.updateCollection(ctx, request.Collection, func(stats *collectionStats) {
+			stats.add(words)
+		})
+	}
+	if err != nil {
+		// The document is taken out of the collection again, so that adding
+		// it again after the failure adds it to the aggregate.
+		a.rollback(request.Collection, id, func(ctx context.Context) error {
+			if err := a.kv.Delete(ctx, key); err != nil {
+				return errors.Wrapf(err, "unable to remove document %s from collection %q", id, request.Collection)
+			}
+			return a.removeMember(ctx, request.Collection, id)
+		})
+		return cr, err
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Added document %s to collection %q", id, request.Collection))
+	cr.Documents = stats.Documents
+	return cr, nil
+}
+
+// collectionRollbackTimeout is how long undoing a failed change to a
+// collection may take.
+const collectionRollbackTimeout = 5 * time.Second
+
+// rollback undoes a change to a collection that failed before the aggregate
+// was updated. It is not canceled with the request, which may have failed
+// because it was canceled, and failures are only logged.
+func (a *apiService) rollback(name, id string, undo func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectionRollbackTimeout)
+	defer cancel()
+	if err := undo(ctx); err != nil {
+		_ = a.l.Log("LEVEL", "WARN", "MESSAGE", fmt.Sprintf("Unable to undo change of document %s in collection %q: %s", id, name, err))
+	}
+}
+
+// collectionSize sets the number of documents of the collection of cr.
+func (a *apiService) collectionSize(ctx context.Context, cr CollectionResponse) (CollectionResponse, error) {
+	stats, err := a.loadCollection(ctx, cr.Collection)
+	cr.Documents = stats.Documents
+	return cr, err
+}
+
+// RemoveFromCollection removes a document and its word counts from a
+// collection.
+func (a *apiService) RemoveFromCollection(ctx context.Context, request CollectionRemoveRequest) (CollectionResponse, error) {
+	var cr CollectionResponse
+	if err := validateCollection(request.Collection); err != nil {
+		return cr, err
+	}
+
+	key := collectionDocumentKey(request.Collection, request.DocumentID)
+	data, version, err := a.kv.RetrieveVersion(ctx, key)
+	if err != nil {
+		return cr, errors.WithStack(err)
+	}
+	if data == nil {
+		return cr, errors.Wrapf(ErrNotFound, "document %s is not in collection %q", request.DocumentID, request.Collection)
+	}
+	var words map[string]int
+	if err := json.Unmarshal(data, &words); err != nil {
+		return cr, errors.Wrapf(err, "invalid counts for document %s", request.DocumentID)
+	}
+
+	// Only the request that deletes the document subtracts it from the
+	// aggregate, so concurrent requests subtract it once.
+	removed, err := a.kv.CompareAndDelete(ctx, key, version)
+	if err != nil {
+		return cr, errors.Wrapf(err, "unable to remove document %s from collection %q", request.DocumentID, request.Collection)
+	}
+	if !removed {
+		return cr, errors.Wrapf(ErrNotFound, "document %s is not in collection %q", request.DocumentID, request.Collection)
+	}
+	err = a.removeMember(ctx, request.Collection, request.DocumentID)
+	var stats collectionStats
+	if err == nil {
+		stats, err = a.updateCollection(ctx, request.Collection, func(stats *collectionStats) {
+			stats.remove(words)
+		})
+	}
+	if err != nil {
+		// The document is put back, so that removing it again after the
+		// failure subtracts it from the aggregate.
+		a.rollback(request.Collection, request.DocumentID, func(ctx context.Context) error {
+			if _, err := a.kv.StoreIfAbsent(ctx, key, data, 0); err != nil {
+				return errors.Wrapf(err, "unable to restore document %s in collection %q", request.DocumentID, request.Collection)
+			}
+			return a.addMember(ctx, request.Collection, request.DocumentID)
+		})
+		return cr, err
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Removed document %s from collection %q", request.DocumentID, request.Collection))
+	return CollectionResponse{
+		Collection: request.Collection,
+		DocumentID: request.DocumentID,
+		Documents:  stats.Documents,
+	}, nil
+}
+
+// CollectionFrequencies returns the most frequent words across the documents
+// of a collection.
+func (a *apiService) CollectionFrequencies(ctx context.Context, request CollectionFrequenciesRequest) (CollectionFrequenciesResponse, error) {
+	var cfr CollectionFrequenciesResponse
+	if err := validateCollection(request.Collection); err != nil {
+		return cfr, err
+	}
+	n := request.N
+	if n <= 0 {
+		n = DefaultCollectionTopN
+	}
+	if n > maxCollectionTopN {
+		n = maxCollectionTopN
+	}
+
+	stats, err := a.loadCollection(ctx, request.Collection)
+	if err != nil {
+		return cfr, err
+	}
+	if stats.Documents == 0 {
+		return cfr, errors.Wrapf(ErrNotFound, "collection %q has no documents", request.Collection)
+	}
+
+	frequencies := make([]CollectionFrequency, 0, len(stats.Terms))
+	for word, count := range stats.Terms {
+		frequencies = append(frequencies, CollectionFrequency{
+			Word:              word,
+			Frequency:         count,
+			DocumentFrequency: stats.DocumentFrequency[word],
+		})
+	}
+	sort.Slice(frequencies, func(i, j int) bool {
+		if frequencies[i].Frequency != frequencies[j].Frequency {
+			return frequencies[i].Frequency > frequencies[j].Frequency
+		}
+		return frequencies[i].Word < frequencies[j].Word
+	})
+	if len(frequencies) > n {
+		frequencies = frequencies[:n]
+	}
+	return CollectionFrequenciesResponse{
+		Collection:  request.Collection,
+		Documents:   stats.Documents,
+		Frequencies: frequencies,
+	}, nil
+}
@@ -0,0 +1,198 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// newCollectionService returns an API service with workers that run until the
+// context is done.
+func newCollectionService(ctx context.Context) service.APIService {
+	return newCollectionServiceWith(ctx, keyvaluemock.New())
+}
+
+// newCollectionServiceWith returns an API service with workers that run until
+// the context is done and keep their state in kv.
+func newCollectionServiceWith(ctx context.Context, kv keyvalue.KeyValue) service.APIService {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	apiService := service.NewAPIService(q, kv, channel, l)
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+	})
+	go runWorker(ctx, q, channel, worker)
+	return apiService
+}
+
+// runWorker parses the documents pulled from channel until the context is
+// done.
+func runWorker(ctx context.Context, q *queuemock.QueueMock, channel string, worker service.WorkerService) {
+	for {
+		d, err := q.Pull(ctx, channel)
+		if err != nil {
+			return
+		}
+		var doc service.DocumentID
+		if err := json.Unmarshal(d, &doc); err != nil {
+			return
+		}
+		_, _ = worker.ParseDocument(ctx, doc)
+	}
+}
+
+func TestCollection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	apiService := newCollectionService(ctx)
+
+	add := func(document string) service.CollectionResponse {
+		cr, err := apiService.AddToCollection(ctx, service.CollectionDocumentRequest{
+			Collection:      "books",
+			DocumentRequest: service.DocumentRequest{Document: document},
+		})
+		require.NoError(t, err, "Adding document should succeed.")
+		return cr
+	}
+	first := add("one two two")
+	second := add("two three")
+	assert.Equal(t, 2, second.Documents, "Collection should have two documents.")
+
+	cfr, err := apiService.CollectionFrequencies(ctx, service.CollectionFrequenciesRequest{Collection: "books"})
+	require.NoError(t, err, "Getting frequencies should succeed.")
+	assert.Equal(t, 2, cfr.Documents, "Collection should have two documents.")
+	assert.Equal(t, []service.CollectionFrequency{
+		{Word: "two", Frequency: 3, DocumentFrequency: 2},
+		{Word: "one", Frequency: 1, DocumentFrequency: 1},
+		{Word: "three", Frequency: 1, DocumentFrequency: 1},
+	}, cfr.Frequencies, "Frequencies should be aggregated across documents.")
+
+	cr, err := apiService.RemoveFromCollection(ctx, service.CollectionRemoveRequest{
+		Collection: "books",
+		DocumentID: first.DocumentID,
+	})
+	require.NoError(t, err, "Removing document should succeed.")
+	assert.Equal(t, 1, cr.Documents, "Collection should have one document.")
+
+	cfr, err = apiService.CollectionFrequencies(ctx, service.CollectionFrequenciesRequest{Collection: "books"})
+	require.NoError(t, err, "Getting frequencies should succeed.")
+	assert.Equal(t, []service.CollectionFrequency{
+		{Word: "three", Frequency: 1, DocumentFrequency: 1},
+		{Word: "two", Frequency: 1, DocumentFrequency: 1},
+	}, cfr.Frequencies, "Removed document should no longer contribute.")
+
+	_, err = apiService.RemoveFromCollection(ctx, service.CollectionRemoveRequest{
+		Collection: "books",
+		DocumentID: first.DocumentID,
+	})
+	assert.Equal(t, service.ErrNotFound, errors.Cause(err), "Removing a missing document should fail.")
+
+	_, err = apiService.CollectionFrequencies(ctx, service.CollectionFrequenciesRequest{Collection: "bad name"})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "Invalid collection names should be rejected.")
+}
+
+func TestCollectionMembers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	kv := keyvaluemock.New()
+	apiService := newCollectionServiceWith(ctx, kv)
+
+	var ids []string
+	for _, document := range []string{"one two", "two three"} {
+		cr, err := apiService.AddToCollection(ctx, service.CollectionDocumentRequest{
+			Collection:      "books",
+			DocumentRequest: service.DocumentRequest{Document: document},
+		})
+		require.NoError(t, err, "Adding document should succeed.")
+		ids = append(ids, cr.DocumentID)
+	}
+	data, err := kv.Retrieve(ctx, "collection:books:members")
+	require.NoError(t, err)
+	members, err := json.Marshal(ids)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(members), string(data), "Members should be kept apart from the aggregate.")
+	data, err = kv.Retrieve(ctx, "collection:books")
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), ids[0], "Members should not be in the aggregate.")
+
+	for _, id := range ids {
+		_, err = apiService.RemoveFromCollection(ctx, service.CollectionRemoveRequest{
+			Collection: "books",
+			DocumentID: id,
+		})
+		require.NoError(t, err, "Removing document should succeed.")
+	}
+	data, err = kv.Retrieve(ctx, "collection:books:members")
+	require.NoError(t, err)
+	assert.Nil(t, data, "Removed members should be deleted.")
+}
+
+// failingCollectionKeyValue fails to update the aggregate of collections while
+// fail is set.
+type failingCollectionKeyValue struct {
+	*keyvaluemock.KeyValueMock
+	fail int32
+}
+
+func (f *failingCollectionKeyValue) CompareAndSwap(ctx context.Context, key string, version keyvalue.Version, data []byte, expiration time.Duration) (bool, error) {
+	if key == "collection:books" && atomic.LoadInt32(&f.fail) == 1 {
+		return false, errors.New("unavailable")
+	}
+	return f.KeyValueMock.CompareAndSwap(ctx, key, version, data, expiration)
+}
+
+func TestCollectionRollback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	kv := &failingCollectionKeyValue{KeyValueMock: keyvaluemock.New()}
+	apiService := newCollectionServiceWith(ctx, kv)
+
+	add := func() (service.CollectionResponse, error) {
+		return apiService.AddToCollection(ctx, service.CollectionDocumentRequest{
+			Collection:      "books",
+			DocumentRequest: service.DocumentRequest{Document: "one two"},
+		})
+	}
+	remove := func(id string) (service.CollectionResponse, error) {
+		return apiService.RemoveFromCollection(ctx, service.CollectionRemoveRequest{Collection: "books", DocumentID: id})
+	}
+
+	atomic.StoreInt32(&kv.fail, 1)
+	_, err := add()
+	require.Error(t, err, "Adding document should fail.")
+	atomic.StoreInt32(&kv.fail, 0)
+	cr, err := add()
+	require.NoError(t, err, "Adding document again should succeed.")
+	assert.Equal(t, 1, cr.Documents, "Document should be added after the failure.")
+
+	atomic.StoreInt32(&kv.fail, 1)
+	_, err = remove(cr.DocumentID)
+	require.Error(t, err, "Removing document should fail.")
+	atomic.StoreInt32(&kv.fail, 0)
+	cr, err = remove(cr.DocumentID)
+	require.NoError(t, err, "Removing document again should succeed.")
+	assert.Zero(t, cr.Documents, "Document should be removed after the failure.")
+	_, err = remove(cr.DocumentID)
+	assert.Equal(t, service.ErrNotFound, errors.Cause(err), "Document should only be removed once.")
+
+	cfr, err := apiService.CollectionFrequencies(ctx, service.CollectionFrequenciesRequest{Collection: "books"})
+	assert.Equal(t, service.ErrNotFound, errors.Cause(err), "Collection should be empty.")
+	assert.Zero(t, cfr.Documents)
+}
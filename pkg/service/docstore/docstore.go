@@ -0,0 +1,567 @@
+// Package docstore keeps the original bytes of submitted documents together
+// with metadata about them, keyed by the content hash of each document.
+//
+// The contents are stored as blobs that never expire, so documents are kept
+// until they are deleted.
+package docstore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+const (
+	// DefaultLimit is the number of documents listed when no limit is set.
+	DefaultLimit = 20
+	// MaxLimit is the largest number of documents listed at once.
+	MaxLimit = 100
+)
+
+// ErrNotFound is returned when a document is not stored.
+var ErrNotFound = errors.New("document not found")
+
+// dayLayout formats the days that documents are listed by.
+const dayLayout = "2006-01-02"
+
+// daysKey is the key of the days that documents were stored on, in order.
+var daysKey = keyspace.DocumentDays()
+
+// dayKey returns the key of the IDs of the documents stored on a day, in the
+// order that they were stored.
+func dayKey(day string) string {
+	return keyspace.DocumentDay(day)
+}
+
+// Metadata describes a stored document.
+type Metadata struct {
+	ID string
+	// Submitter is whoever submitted the document first.
+	Submitter   string `json:",omitempty"`
+	ContentType string `json:",omitempty"`
+	Size        int64
+	CreatedAt   time.Time
+	// Tags are the tags given each time the document was submitted.
+	Tags []string `json:",omitempty"`
+}
+
+// HasTag reports if the document is tagged with tag.
+func (m Metadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter selects the documents to list. Empty fields match every document.
+type Filter struct {
+	Submitter string
+	// ContentType matches documents with the media type, ignoring parameters
+	// such as the character set.
+	ContentType string
+	Tag         string
+	// CreatedAfter and CreatedBefore bound the creation time of documents.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Offset        int
+	// Limit is the number of documents to return. DefaultLimit is used if it
+	// is 0.
+	Limit int
+}
+
+func (f Filter) matches(m Metadata) bool {
+	switch {
+	case f.Submitter != "" && m.Submitter != f.Submitter:
+		return false
+	case f.ContentType != "" && mediaType(m.ContentType) != mediaType(f.ContentType):
+		return false
+	case f.Tag != "" && !m.HasTag(f.Tag):
+		return false
+	case !f.CreatedAfter.IsZero() && !m.CreatedAt.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !m.CreatedAt.Before(f.CreatedBefore):
+		return false
+	}
+	return true
+}
+
+// all reports if every document matches the filter.
+func (f Filter) all() bool {
+	return f.Submitter == "" && f.ContentType == "" && f.Tag == "" &&
+		f.CreatedAfter.IsZero() && f.CreatedBefore.IsZero()
+}
+
+// coversDay reports if documents created on a day may match the filter.
+func (f Filter) coversDay(day string) bool {
+	start, err := time.Parse(dayLayout, day)
+	if err != nil {
+		return true
+	}
+	end := start.Add(24 * time.Hour)
+	switch {
+	case !f.CreatedAfter.IsZero() && !end.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !start.Before(f.CreatedBefore):
+		return false
+	}
+	return true
+}
+
+// mediaType returns the media type of a content type without its parameters.
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return t
+}
+
+// Config contains the configuration for a Store.
+type Config struct {
+	// ChunkSize is the chunk size of the stored contents.
+	// blob.DefaultChunkSize is used if it is 0.
+	ChunkSize int
+}
+
+// Store stores documents and their metadata.
+//
+// Documents are listed by the day they were stored on, with a key per day, so
+// listing a page reads the documents of the page only. Changes to the
+// metadata and the lists are compare-and-swapped, so several processes can
+// share a Store.
+//
+// The keyvalue.KeyValue that documents are stored in must return nil for keys
+// without a value.
+type Store struct {
+	kv    keyvalue.KeyValue
+	blobs *blob.Store
+	now   func() time.Time
+}
+
+// NewStore returns a Store that keeps documents in kv.
+func NewStore(kv keyvalue.KeyValue, conf Config) *Store {
+	return &Store{
+		kv:    kv,
+		blobs: blob.NewStore(kv, blob.Config{ChunkSize: conf.ChunkSize}),
+		now:   time.Now,
+	}
+}
+
+func metadataKey(id string) string {
+	return keyspace.Document(id)
+}
+
+// contentID returns the ID of the blob holding the contents of a document.
+func contentID(id string) string {
+	return keyspace.Document(id)
+}
+
+// Put stores the document with the ID in meta, reading its contents from r.
+// The size and creation time in meta are ignored.
+//
+// If the document is already stored, r is not read and the tags in meta are
+// added to the stored document instead. The metadata of the stored document
+// is returned.
+func (s *Store) Put(ctx context.Context, meta Metadata, r io.Reader) (Metadata, error) {
+	if meta.ID == "" {
+		return Metadata{}, errors.New("missing document ID")
+	}
+	_, err := s.Get(ctx, meta.ID)
+	switch errors.Cause(err) {
+	case nil:
+		return s.addTags(ctx, meta.ID, meta.Tags)
+	case ErrNotFound:
+	default:
+		return Metadata{}, err
+	}
+
+	w, err := s.Create(ctx)
+	if err != nil {
+		return Metadata{}, err
+	}
+	var (
+		stored      Metadata
+		m, contents blob.Manifest
+	)
+	if _, err = io.Copy(w, r); err == nil {
+		if m, err = w.Close(); err == nil {
+			if stored, contents, err = s.Adopt(ctx, meta, m); err == nil && contents.Upload == m.Upload {
+				return stored, nil
+			}
+		}
+	}
+	// The chunks never expire, so they are left behind if they can't be
+	// deleted. They are not needed if the same document was stored
+	// concurrently.
+	if aerr := w.Abort(); aerr != nil {
+		if err == nil {
+			err = aerr
+		} else {
+			err = errors.Wrapf(err, "unable to discard contents: %v", aerr)
+		}
+	}
+	if err != nil {
+		return Metadata{}, errors.Wrapf(err, "unable to store document %s", meta.ID)
+	}
+	return stored, nil
+}
+
+// Create starts an upload of the contents of a document, which is stored with
+// Adopt once it is complete. The chunks of the upload never expire, so the
+// writer must be aborted if its contents are not adopted.
+func (s *Store) Create(ctx context.Context) (*blob.Writer, error) {
+	w, err := s.blobs.Create(ctx)
+	return w, errors.WithStack(err)
+}
+
+// Adopt stores the document with the ID in meta, whose contents were uploaded
+// with a writer returned by Create and closed into m. The contents are not
+// copied. The size and creation time in meta are ignored.
+//
+// If the document is already stored, the tags in meta are added to the stored
+// document instead, and its own contents are kept. The metadata and the
+// manifest of the contents of the stored document are returned; if the
+// manifest is not m, the upload was not adopted and must be aborted.
+func (s *Store) Adopt(ctx context.Context, meta Metadata, m blob.Manifest) (Metadata, blob.Manifest, error) {
+	if meta.ID == "" {
+		return Metadata{}, blob.Manifest{}, errors.New("missing document ID")
+	}
+	_, err := s.Get(ctx, meta.ID)
+	switch errors.Cause(err) {
+	case nil:
+		return s.addTagsToContents(ctx, meta.ID, meta.Tags)
+	case ErrNotFound:
+	default:
+		return Metadata{}, blob.Manifest{}, err
+	}
+
+	if err := s.blobs.Link(ctx, contentID(meta.ID), m); err != nil {
+		return Metadata{}, blob.Manifest{}, errors.Wrapf(err, "unable to store contents of document %s", meta.ID)
+	}
+	meta.Size = m.Size
+	meta.CreatedAt = s.now().UTC()
+	meta.Tags = mergeTags(nil, meta.Tags)
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return Metadata{}, blob.Manifest{}, errors.WithStack(err)
+	}
+	added, err := s.kv.StoreIfAbsent(ctx, metadataKey(meta.ID), data, 0)
+	if err != nil {
+		return Metadata{}, blob.Manifest{}, errors.Wrapf(err, "unable to store metadata of document %s", meta.ID)
+	}
+	if !added {
+		// The same document was stored concurrently.
+		return s.addTagsToContents(ctx, meta.ID, meta.Tags)
+	}
+	return meta, m, s.addToDay(ctx, meta)
+}
+
+// addTagsToContents adds tags to a stored document and returns its metadata
+// and the manifest of its contents.
+func (s *Store) addTagsToContents(ctx context.Context, id string, tags []string) (Metadata, blob.Manifest, error) {
+	meta, err := s.addTags(ctx, id, tags)
+	if err != nil {
+		return Metadata{}, blob.Manifest{}, err
+	}
+	r, err := s.blobs.Open(ctx, contentID(id))
+	switch errors.Cause(err) {
+	case nil:
+		return meta, r.Manifest(), nil
+	case blob.ErrNotFound:
+		return meta, blob.Manifest{}, errors.Wrapf(ErrNotFound, "missing contents of document %s", id)
+	default:
+		return meta, blob.Manifest{}, errors.Wrapf(err, "unable to open document %s", id)
+	}
+}
+
+// addTags adds tags to a stored document and returns its metadata. The
+// document is listed again, which finishes a Put that failed after storing
+// the metadata.
+func (s *Store) addTags(ctx context.Context, id string, tags []string) (Metadata, error) {
+	var meta Metadata
+	err := keyvalue.Update(ctx, s.kv, metadataKey(id), 0, func(data []byte) ([]byte, error) {
+		if data == nil {
+			return nil, errors.WithStack(ErrNotFound)
+		}
+		meta = Metadata{}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, errors.Wrapf(err, "invalid metadata of document %s", id)
+		}
+		merged := mergeTags(meta.Tags, tags)
+		if len(merged) == len(meta.Tags) {
+			return nil, keyvalue.ErrUnchanged
+		}
+		meta.Tags = merged
+		data, err := json.Marshal(meta)
+		return data, errors.WithStack(err)
+	})
+	if err != nil {
+		return Metadata{}, errors.Wrapf(err, "unable to store metadata of document %s", id)
+	}
+	return meta, s.addToDay(ctx, meta)
+}
+
+// mergeTags returns the sorted union of the tags, without empty tags.
+func mergeTags(tags, added []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{tags, added} {
+		for _, t := range list {
+			if t == "" || seen[t] {
+				continue
+			}
+			seen[t] = true
+			merged = append(merged, t)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// Get returns the metadata of a document.
+func (s *Store) Get(ctx context.Context, id string) (Metadata, error) {
+	var meta Metadata
+	data, err := s.kv.Retrieve(ctx, metadataKey(id))
+	if err != nil {
+		return meta, errors.Wrapf(err, "unable to retrieve metadata of document %s", id)
+	}
+	if data == nil {
+		return meta, errors.WithStack(ErrNotFound)
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, errors.Wrapf(err, "invalid metadata of document %s", id)
+}
+
+// Open returns the metadata of a document and a reader for its contents.
+func (s *Store) Open(ctx context.Context, id string) (Metadata, io.Reader, error) {
+	meta, err := s.Get(ctx, id)
+	if err != nil {
+		return meta, nil, err
+	}
+	r, err := s.blobs.Open(ctx, contentID(id))
+	switch errors.Cause(err) {
+	case nil:
+		return meta, r, nil
+	case blob.ErrNotFound:
+		return meta, nil, errors.Wrapf(ErrNotFound, "missing contents of document %s", id)
+	default:
+		return meta, nil, errors.Wrapf(err, "unable to open document %s", id)
+	}
+}
+
+// Delete deletes a document and its contents.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	meta, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	// The metadata is deleted last, so that deleting the document again
+	// finishes a deletion that failed.
+	err = updateList(ctx, s.kv, dayKey(day(meta.CreatedAt)), func(ids []string) ([]string, bool) {
+		kept := make([]string, 0, len(ids))
+		for _, v := range ids {
+			if v != id {
+				kept = append(kept, v)
+			}
+		}
+		return kept, len(kept) != len(ids)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to unlist document %s", id)
+	}
+	if err := s.blobs.Delete(ctx, contentID(id)); err != nil && errors.Cause(err) != blob.ErrNotFound {
+		return errors.Wrapf(err, "unable to delete contents of document %s", id)
+	}
+	err = s.kv.Delete(ctx, metadataKey(id))
+	return errors.Wrapf(err, "unable to delete metadata of document %s", id)
+}
+
+// List returns a page of the documents that match the filter, newest first,
+// and the number of documents that match it.
+//
+// Without a filter, only the documents of the page are read. Otherwise the
+// documents of every day that the filter covers are read, a day at a time.
+func (s *Store) List(ctx context.Context, f Filter) ([]Metadata, int, error) {
+	if f.Offset < 0 {
+		return nil, 0, errors.New("offset must not be negative")
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	days, err := retrieveList(ctx, s.kv, daysKey)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to retrieve document days")
+	}
+	var keys []string
+	for i := len(days) - 1; i >= 0; i-- {
+		if f.coversDay(days[i]) {
+			keys = append(keys, dayKey(days[i]))
+		}
+	}
+	if len(keys) == 0 {
+		return []Metadata{}, 0, nil
+	}
+	lists, err := s.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to retrieve document lists")
+	}
+
+	var total int
+	matches := []Metadata{}
+	for i, data := range lists {
+		ids, err := decodeList(keys[i], data)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Newest first.
+		for l, r := 0, len(ids)-1; l < r; l, r = l+1, r-1 {
+			ids[l], ids[r] = ids[r], ids[l]
+		}
+		if f.all() {
+			// The page is read once it is known.
+			start, end := f.Offset-total, f.Offset+limit-total
+			total += len(ids)
+			if end <= 0 || start >= len(ids) {
+				continue
+			}
+			if start < 0 {
+				start = 0
+			}
+			if end > len(ids) {
+				end = len(ids)
+			}
+			page, err := s.getMany(ctx, ids[start:end])
+			if err != nil {
+				return nil, 0, err
+			}
+			matches = append(matches, page...)
+			continue
+		}
+		docs, err := s.getMany(ctx, ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, meta := range docs {
+			if !f.matches(meta) {
+				continue
+			}
+			if total >= f.Offset && len(matches) < limit {
+				matches = append(matches, meta)
+			}
+			total++
+		}
+	}
+	return matches, total, nil
+}
+
+// getMany returns the metadata of several documents in one batch. Documents
+// that were deleted after they were listed are left out.
+func (s *Store) getMany(ctx context.Context, ids []string) ([]Metadata, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = metadataKey(id)
+	}
+	values, err := s.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve metadata of documents")
+	}
+	docs := make([]Metadata, 0, len(ids))
+	for i, data := range values {
+		if data == nil {
+			continue
+		}
+		var meta Metadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, errors.Wrapf(err, "invalid metadata of document %s", ids[i])
+		}
+		docs = append(docs, meta)
+	}
+	return docs, nil
+}
+
+// day returns the day that a document created at t is listed on.
+func day(t time.Time) string {
+	return t.UTC().Format(dayLayout)
+}
+
+// addToDay lists a document on the day it was created on.
+func (s *Store) addToDay(ctx context.Context, meta Metadata) error {
+	d := day(meta.CreatedAt)
+	err := updateList(ctx, s.kv, daysKey, func(days []string) ([]string, bool) {
+		i := sort.SearchStrings(days, d)
+		if i < len(days) && days[i] == d {
+			return days, false
+		}
+		days = append(days, "")
+		copy(days[i+1:], days[i:])
+		days[i] = d
+		return days, true
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to store document days")
+	}
+	err = updateList(ctx, s.kv, dayKey(d), func(ids []string) ([]string, bool) {
+		for _, id := range ids {
+			if id == meta.ID {
+				return ids, false
+			}
+		}
+		return append(ids, meta.ID), true
+	})
+	return errors.Wrapf(err, "unable to list document %s", meta.ID)
+}
+
+// retrieveList retrieves a list of strings, which is empty if there is no
+// value for key.
+func retrieveList(ctx context.Context, kv keyvalue.KeyValue, key string) ([]string, error) {
+	data, err := kv.Retrieve(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return decodeList(key, data)
+}
+
+func decodeList(key string, data []byte) ([]string, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	return list, errors.Wrapf(err, "invalid list %s", key)
+}
+
+// updateList replaces a list of strings with the list returned by update,
+// which reports if it changed the list and may be called more than once. The
+// list is deleted if it becomes empty.
+func updateList(ctx context.Context, kv keyvalue.KeyValue, key string, update func(list []string) ([]string, bool)) error {
+	return keyvalue.Update(ctx, kv, key, 0, func(data []byte) ([]byte, error) {
+		list, err := decodeList(key, data)
+		if err != nil {
+			return nil, err
+		}
+		list, changed := update(list)
+		switch {
+		case !changed:
+			return nil, keyvalue.ErrUnchanged
+		case len(list) == 0:
+			return nil, nil
+		}
+		data, err = json.Marshal(list)
+		return data, errors.WithStack(err)
+	})
+}
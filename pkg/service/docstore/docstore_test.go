@@ -0,0 +1,223 @@
+package docstore_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/docstore"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+func TestPutGetOpen(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := docstore.NewStore(keyvaluemock.New(), docstore.Config{ChunkSize: 4})
+	meta, err := s.Put(ctx, docstore.Metadata{
+		ID:          "a",
+		Submitter:   "alice",
+		ContentType: "text/plain",
+		Tags:        []string{"b", "a", "b"},
+	}, strings.NewReader("some document text"))
+	require.NoError(t, err, "Storing a document should succeed.")
+	assert.EqualValues(t, len("some document text"), meta.Size, "Size should be counted.")
+	assert.False(t, meta.CreatedAt.IsZero(), "Creation time should be set.")
+	assert.Equal(t, []string{"a", "b"}, meta.Tags, "Tags should be sorted and unique.")
+
+	got, err := s.Get(ctx, "a")
+	require.NoError(t, err, "Getting a document should succeed.")
+	assert.Equal(t, meta.Submitter, got.Submitter, "Metadata should be stored.")
+
+	again, err := s.Put(ctx, docstore.Metadata{
+		ID:        "a",
+		Submitter: "bob",
+		Tags:      []string{"c"},
+	}, strings.NewReader("never read"))
+	require.NoError(t, err, "Storing a document again should succeed.")
+	assert.Equal(t, "alice", again.Submitter, "First submitter should be kept.")
+	assert.Equal(t, meta.CreatedAt.Unix(), again.CreatedAt.Unix(), "Creation time should be kept.")
+	assert.Equal(t, []string{"a", "b", "c"}, again.Tags, "Tags should be merged.")
+
+	_, r, err := s.Open(ctx, "a")
+	require.NoError(t, err, "Opening a document should succeed.")
+	content, err := ioutil.ReadAll(r)
+	require.NoError(t, err, "Reading a document should succeed.")
+	assert.Equal(t, "some document text", string(content), "Original contents should be kept.")
+
+	_, err = s.Get(ctx, "missing")
+	assert.Equal(t, docstore.ErrNotFound, errors.Cause(err), "Missing documents should not be found.")
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := docstore.NewStore(keyvaluemock.New(), docstore.Config{})
+	for _, id := range []string{"a", "b"} {
+		_, err := s.Put(ctx, docstore.Metadata{ID: id}, strings.NewReader(id))
+		require.NoError(t, err, "Storing a document should succeed.")
+	}
+
+	require.NoError(t, s.Delete(ctx, "a"), "Deleting a document should succeed.")
+	_, _, err := s.Open(ctx, "a")
+	assert.Equal(t, docstore.ErrNotFound, errors.Cause(err), "Deleted document should not be found.")
+	err = s.Delete(ctx, "a")
+	assert.Equal(t, docstore.ErrNotFound, errors.Cause(err), "Deleting twice should fail.")
+
+	docs, total, err := s.List(ctx, docstore.Filter{})
+	require.NoError(t, err, "Listing documents should succeed.")
+	assert.Equal(t, 1, total, "Deleted document should not be listed.")
+	require.Len(t, docs, 1)
+	assert.Equal(t, "b", docs[0].ID, "Remaining document should be listed.")
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := docstore.NewStore(keyvaluemock.New(), docstore.Config{})
+	for _, meta := range []docstore.Metadata{
+		{ID: "1", Submitter: "alice", ContentType: "text/plain", Tags: []string{"news"}},
+		{ID: "2", Submitter: "bob", ContentType: "text/html; charset=utf-8", Tags: []string{"news", "sport"}},
+		{ID: "3", Submitter: "alice", ContentType: "text/html"},
+	} {
+		_, err := s.Put(ctx, meta, strings.NewReader(meta.ID))
+		require.NoError(t, err, "Storing a document should succeed.")
+	}
+
+	ids := func(f docstore.Filter) []string {
+		docs, _, err := s.List(ctx, f)
+		require.NoError(t, err, "Listing documents should succeed.")
+		var out []string
+		for _, d := range docs {
+			out = append(out, d.ID)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"3", "2", "1"}, ids(docstore.Filter{}), "Newest documents should come first.")
+	assert.Equal(t, []string{"3", "1"}, ids(docstore.Filter{Submitter: "alice"}), "Submitter should filter.")
+	assert.Equal(t, []string{"3", "2"}, ids(docstore.Filter{ContentType: "text/html"}), "Content type should filter.")
+	assert.Equal(t, []string{"2", "1"}, ids(docstore.Filter{Tag: "news"}), "Tag should filter.")
+	assert.Equal(t, []string{"2"}, ids(docstore.Filter{Offset: 1, Limit: 1}), "Offset and limit should page.")
+	assert.Empty(t, ids(docstore.Filter{CreatedAfter: time.Now().Add(time.Hour)}), "Creation time should filter.")
+
+	_, _, err := s.List(ctx, docstore.Filter{Offset: -1})
+	assert.Error(t, err, "Negative offsets should be rejected.")
+}
+
+func TestConcurrentPut(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Each store stands in for a replica sharing the key value store.
+	kv := keyvaluemock.New()
+	errs := make(chan error)
+	const n = 20
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			s := docstore.NewStore(kv, docstore.Config{})
+			_, err := s.Put(ctx, docstore.Metadata{ID: strconv.Itoa(i % 10), Tags: []string{strconv.Itoa(i)}}, strings.NewReader("text"))
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		require.NoError(t, <-errs, "Storing a document should succeed.")
+	}
+
+	s := docstore.NewStore(kv, docstore.Config{})
+	docs, total, err := s.List(ctx, docstore.Filter{Limit: docstore.MaxLimit})
+	require.NoError(t, err, "Listing documents should succeed.")
+	assert.Equal(t, 10, total, "Every document should be listed once.")
+	for _, meta := range docs {
+		i, err := strconv.Atoi(meta.ID)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{strconv.Itoa(i), strconv.Itoa(i + 10)}, meta.Tags, "Tags of every request should be kept.")
+	}
+}
+
+// failingReader returns an error after its data is read.
+type failingReader struct {
+	r io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestPutAbort(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kv := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	s := docstore.NewStore(kv, docstore.Config{ChunkSize: 4})
+	_, err := s.Put(ctx, docstore.Metadata{ID: "a"}, failingReader{strings.NewReader("some document text")})
+	assert.Error(t, err, "Failed reads should fail.")
+	assert.Zero(t, kv.Len(), "Stored chunks should be discarded.")
+	_, err = s.Get(ctx, "a")
+	assert.Equal(t, docstore.ErrNotFound, errors.Cause(err), "Document should not be stored.")
+}
+
+func TestAdopt(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kv := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	s := docstore.NewStore(kv, docstore.Config{ChunkSize: 4})
+	upload := func(text string) (*blob.Writer, blob.Manifest) {
+		w, err := s.Create(ctx)
+		require.NoError(t, err, "Creating an upload should succeed.")
+		_, err = w.Write([]byte(text))
+		require.NoError(t, err, "Writing an upload should succeed.")
+		m, err := w.Close()
+		require.NoError(t, err, "Closing an upload should succeed.")
+		return w, m
+	}
+
+	_, m := upload("some document text")
+	meta, contents, err := s.Adopt(ctx, docstore.Metadata{ID: "a", Tags: []string{"a"}}, m)
+	require.NoError(t, err, "Adopting a document should succeed.")
+	assert.Equal(t, m, contents, "Upload should be adopted.")
+	assert.EqualValues(t, len("some document text"), meta.Size, "Size should be taken from the upload.")
+	stored := kv.Len()
+
+	w, again := upload("some document text")
+	meta, contents, err = s.Adopt(ctx, docstore.Metadata{ID: "a", Tags: []string{"b"}}, again)
+	require.NoError(t, err, "Adopting a stored document should succeed.")
+	assert.Equal(t, m, contents, "Stored contents should be kept.")
+	assert.Equal(t, []string{"a", "b"}, meta.Tags, "Tags should be merged.")
+	require.NoError(t, w.Abort(), "Aborting an upload that was not adopted should succeed.")
+	assert.Equal(t, stored, kv.Len(), "Contents should be stored once.")
+
+	_, r, err := s.Open(ctx, "a")
+	require.NoError(t, err, "Opening an adopted document should succeed.")
+	content, err := ioutil.ReadAll(r)
+	require.NoError(t, err, "Reading an adopted document should succeed.")
+	assert.Equal(t, "some document text", string(content), "Uploaded contents should be kept.")
+}
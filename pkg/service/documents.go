@@ -0,0 +1,339 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/docstore"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// Submission describes who submitted a document, for the document store.
+type Submission struct {
+	Submitter string   `json:"submitter,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// DocumentLookupRequest is a request for a stored document.
+type DocumentLookupRequest struct {
+	DocumentID string
+}
+
+// StoredDocumentResponse holds the metadata of a stored document.
+type StoredDocumentResponse struct {
+	docstore.Metadata
+}
+
+// DocumentContentResponse holds the metadata and the original contents of a
+// stored document.
+type DocumentContentResponse struct {
+	docstore.Metadata
+	// Content reads the contents of the document. It must be read before the
+	// context of the request is done.
+	Content io.Reader `json:"-"`
+}
+
+// ListDocumentsRequest is a request for a page of the stored documents.
+type ListDocumentsRequest struct {
+	docstore.Filter
+}
+
+// ListDocumentsResponse holds a page of the stored documents, newest first.
+type ListDocumentsResponse struct {
+	// Total is the number of documents that match the filter.
+	Total     int
+	Offset    int
+	Documents []docstore.Metadata
+}
+
+// resultsKey returns the key of the list of keys that results for a document
+// are stored under, so that they can be deleted with the document.
+func resultsKey(id string) string {
+	return keyspace.Results(id)
+}
+
+// trackResult adds key to the list of keys that results for its document are
+// stored under, keeping the list for at least expiration.
+func (w *workerService) trackResult(ctx context.Context, key string, expiration time.Duration) error {
+	id := documentIDOfKey(key)
+	err := updateStrings(ctx, w.kv, resultsKey(id), expiration, func(keys []string) ([]string, bool) {
+		if containsString(keys, key) {
+			return keys, false
+		}
+		return append(keys, key), true
+	})
+	return errors.Wrapf(err, "unable to track results of document %s", id)
+}
+
+// shardRunsKey returns the key of the list of runs that a document was split
+// in, so that the keys of the runs can be deleted with the document.
+func shardRunsKey(id string) string {
+	return keyspace.ShardRuns(id)
+}
+
+// trackShards adds a run of the shards of the report stored under key to the
+// list of runs of its document. Runs are listed as "<run>:<key>".
+func trackShards(ctx context.Context, kv keyvalue.KeyValue, id, key, run string) error {
+	entry := run + ":" + key
+	err := updateStrings(ctx, kv, shardRunsKey(id), shardExpiration, func(runs []string) ([]string, bool) {
+		if containsString(runs, entry) {
+			return runs, false
+		}
+		return append(runs, entry), true
+	})
+	return errors.Wrapf(err, "unable to track shards of document %s", id)
+}
+
+// keepDocument stores a submitted document in the document store, if there is
+// one.
+func (a *apiService) keepDocument(ctx context.Context, id, contentType string, s Submission, r io.Reader) error {
+	if a.documents == nil {
+		return nil
+	}
+	_, err := a.documents.Put(ctx, docstore.Metadata{
+		ID:          id,
+		Submitter:   s.Submitter,
+		ContentType: contentType,
+		Tags:        s.Tags,
+	}, r)
+	return errors.Wrapf(err, "unable to keep document %s", id)
+}
+
+// keepStream stores a streamed document read from r in the document store and
+// returns its ID. The document store adopts the upload, and the stored
+// contents are linked as the blob under the ID of the document, so streamed
+// documents are only written once.
+//
+// ErrDocumentTooLarge is returned if r holds more than maxDocumentSize bytes.
+func (a *apiService) keepStream(ctx context.Context, r io.Reader, contentType string, s Submission) (string, error) {
+	w, err := a.documents.Create(ctx)
+	if err != nil {
+		return "", err
+	}
+	id, m, err := a.writeBlob(w, r)
+	if err == nil {
+		var contents blob.Manifest
+		_, contents, err = a.documents.Adopt(ctx, docstore.Metadata{
+			ID:          id,
+			Submitter:   s.Submitter,
+			ContentType: contentType,
+			Tags:        s.Tags,
+		}, m)
+		if err == nil {
+			if contents.Upload != m.Upload {
+				// The document was already stored.
+				if aerr := w.Abort(); aerr != nil {
+					_ = a.l.Log("LEVEL", "WARN", "MESSAGE", aerr.Error())
+				}
+			}
+			// The stored contents are not discarded if they can't be linked,
+			// as they belong to the document store.
+			if err := a.blobs.Link(ctx, id, contents); err != nil {
+				return "", errors.Wrap(err, "unable to store document")
+			}
+			return id, nil
+		}
+		err = errors.Wrapf(err, "unable to keep document %s", id)
+	}
+	if aerr := w.Abort(); aerr != nil {
+		_ = a.l.Log("LEVEL", "WARN", "MESSAGE", aerr.Error())
+	}
+	return "", err
+}
+
+// storedDocumentError converts errors of the document store to errors of the
+// service.
+func storedDocumentError(id string, err error) error {
+	if errors.Cause(err) == docstore.ErrNotFound {
+		return errors.Wrapf(ErrNotFound, "document %s", id)
+	}
+	return err
+}
+
+// Document returns the metadata of a stored document.
+func (a *apiService) Document(ctx context.Context, request DocumentLookupRequest) (StoredDocumentResponse, error) {
+	if a.documents == nil {
+		return StoredDocumentResponse{}, errors.New("document storage is not supported")
+	}
+	meta, err := a.documents.Get(ctx, request.DocumentID)
+	return StoredDocumentResponse{Metadata: meta}, storedDocumentError(request.DocumentID, err)
+}
+
+// DocumentContent returns the original contents of a stored document.
+func (a *apiService) DocumentContent(ctx context.Context, request DocumentLookupRequest) (DocumentContentResponse, error) {
+	if a.documents == nil {
+		return DocumentContentResponse{}, errors.New("document storage is not supported")
+	}
+	meta, r, err := a.documents.Open(ctx, request.DocumentID)
+	return DocumentContentResponse{Metadata: meta, Content: r}, storedDocumentError(request.DocumentID, err)
+}
+
+// ListDocuments returns a page of the stored documents that match a filter.
+func (a *apiService) ListDocuments(ctx context.Context, request ListDocumentsRequest) (ListDocumentsResponse, error) {
+	if a.documents == nil {
+		return ListDocumentsResponse{}, errors.New("document storage is not supported")
+	}
+	if request.Offset < 0 {
+		return ListDocumentsResponse{}, errors.Wrap(ErrInvalidRequest, "offset must not be negative")
+	}
+	docs, total, err := a.documents.List(ctx, request.Filter)
+	if err != nil {
+		return ListDocumentsResponse{}, err
+	}
+	return ListDocumentsResponse{
+		Total:     total,
+		Offset:    request.Offset,
+		Documents: docs,
+	}, nil
+}
+
+// DeleteDocument deletes a stored document along with its cached reports,
+// word counts and signatures, and removes it from the near-duplicate and
+// search indexes.
+//
+// Collections that the document was added to keep its counts until it is
+// removed from them.
+func (a *apiService) DeleteDocument(ctx context.Context, request DocumentLookupRequest) (StoredDocumentResponse, error) {
+	id := request.DocumentID
+	if a.documents == nil {
+		return StoredDocumentResponse{}, errors.New("document storage is not supported")
+	}
+	meta, err := a.documents.Get(ctx, id)
+	if err != nil {
+		return StoredDocumentResponse{}, storedDocumentError(id, err)
+	}
+	// Reports of small documents sent as JSON before IDs were versioned are
+	// keyed by the document itself.
+	var document []byte
+	if a.legacyIDs && meta.Size <= maxLegacyDocumentSize {
+		_, r, err := a.documents.Open(ctx, id)
+		if err != nil {
+			return StoredDocumentResponse{}, storedDocumentError(id, err)
+		}
+		if document, err = ioutil.ReadAll(r); err != nil {
+			return StoredDocumentResponse{}, errors.Wrapf(err, "unable to read document %s", id)
+		}
+	}
+
+	// The results are deleted first so that the deletion can be retried if it
+	// fails part way.
+	if err := a.deleteResults(ctx, id, document); err != nil {
+		return StoredDocumentResponse{}, errors.Wrapf(err, "unable to delete results of document %s", id)
+	}
+	if a.index != nil {
+		if err := a.index.Remove(ctx, id); err != nil {
+			return StoredDocumentResponse{}, errors.Wrapf(err, "unable to remove document %s from the search index", id)
+		}
+	}
+	if err := a.documents.Delete(ctx, id); err != nil {
+		return StoredDocumentResponse{}, storedDocumentError(id, err)
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Deleted document %s", id))
+	return StoredDocumentResponse{Metadata: meta}, nil
+}
+
+// deleteResults deletes everything that the workers stored for a document,
+// including the reports stored under the IDs that the document had before IDs
+// were versioned, so that submitting the document again analyzes it again.
+func (a *apiService) deleteResults(ctx context.Context, id string, document []byte) error {
+	if err := a.deleteShards(ctx, id); err != nil {
+		return err
+	}
+	keys, err := retrieveStrings(ctx, a.kv, resultsKey(id))
+	if err != nil {
+		return err
+	}
+	// The report with the default options is deleted even if the list of
+	// results expired before it.
+	if !containsString(keys, id) {
+		keys = append(keys, id)
+	}
+	// Legacy reports have the variant of the options they were analyzed with.
+	for _, key := range keys {
+		for _, legacy := range a.legacyDocumentIDs(id, document) {
+			keys = append(keys, legacy+strings.TrimPrefix(key, id))
+		}
+	}
+	for _, key := range keys {
+		sig, err := a.loadSignature(ctx, key)
+		if err != nil {
+			return err
+		}
+		if sig != nil {
+			if err := a.removeNearDuplicate(ctx, key, *sig); err != nil {
+				return err
+			}
+		}
+		for _, k := range []string{key, jobKey(key), termsKey(key), signatureKey(key)} {
+			if err := a.kv.Delete(ctx, k); err != nil {
+				return errors.Wrapf(err, "unable to delete %s", k)
+			}
+		}
+	}
+	return errors.WithStack(a.kv.Delete(ctx, resultsKey(id)))
+}
+
+// deleteShards deletes the counters and partial counts of every run that a
+// document was split in. Each run is claimed first, so that shards that are
+// still being counted are not merged into a report.
+func (a *apiService) deleteShards(ctx context.Context, id string) error {
+	runs, err := retrieveStrings(ctx, a.kv, shardRunsKey(id))
+	if err != nil {
+		return err
+	}
+	for _, entry := range runs {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		run, key := parts[0], parts[1]
+		if _, err := a.kv.StoreIfAbsent(ctx, shardMergedKey(key, run), []byte(id), shardExpiration); err != nil {
+			return errors.Wrapf(err, "unable to claim shards of document %s", id)
+		}
+		keys := []string{shardTotalKey(key, run), shardDoneKey(key, run)}
+		// The total is missing once the run is merged, and then the partial
+		// counts are deleted already.
+		if total, err := a.kv.GetCounter(ctx, shardTotalKey(key, run)); err == nil {
+			for i := 0; i < int(total); i++ {
+				keys = append(keys, shardPartialKey(key, run, i))
+			}
+		}
+		for _, k := range keys {
+			if err := a.kv.Delete(ctx, k); err != nil {
+				return errors.Wrapf(err, "unable to delete %s", k)
+			}
+		}
+	}
+	return errors.WithStack(a.kv.Delete(ctx, shardRunsKey(id)))
+}
+
+// removeNearDuplicate removes the document whose report is stored under key
+// from the buckets of the near-duplicate index.
+func (a *apiService) removeNearDuplicate(ctx context.Context, key string, sig signature) error {
+	for _, band := range sig.MinHash.Bands() {
+		err := updateStrings(ctx, a.kv, nearDuplicateBucketKey(band), analysisExpiration, func(bucket []string) ([]string, bool) {
+			if !containsString(bucket, key) {
+				return bucket, false
+			}
+			var kept []string
+			for _, k := range bucket {
+				if k != key {
+					kept = append(kept, k)
+				}
+			}
+			return kept, true
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to update near-duplicate index")
+		}
+	}
+	return nil
+}
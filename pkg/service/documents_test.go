@@ -0,0 +1,229 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/docstore"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/search"
+)
+
+func TestDocumentStore(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	// The API and the workers share the index through the key value store.
+	index := search.NewIndex(kv, search.Config{})
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       l,
+		Channel:   channel,
+		Index:     index,
+		Documents: docstore.NewStore(kv, docstore.Config{}),
+	})
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+		Index:   search.NewIndex(kv, search.Config{}),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go runWorker(ctx, q, channel, worker)
+
+	const text = "The quick brown fox jumps over the lazy dog."
+	dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:   text,
+		Submission: service.Submission{Submitter: "alice", Tags: []string{"animals"}},
+	})
+	require.NoError(t, err, "Processing document should succeed.")
+	_, err = apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:        text,
+		AnalysisOptions: service.AnalysisOptions{Stemmer: "en"},
+	})
+	require.NoError(t, err, "Processing document with other options should succeed.")
+	id := dfr.DocumentID
+
+	sdr, err := apiService.Document(ctx, service.DocumentLookupRequest{DocumentID: id})
+	require.NoError(t, err, "Getting document should succeed.")
+	assert.Equal(t, "alice", sdr.Submitter, "Submitter should be kept.")
+	assert.Equal(t, []string{"animals"}, sdr.Tags, "Tags should be kept.")
+	assert.EqualValues(t, len(text), sdr.Size, "Size should be kept.")
+
+	dcr, err := apiService.DocumentContent(ctx, service.DocumentLookupRequest{DocumentID: id})
+	require.NoError(t, err, "Getting document content should succeed.")
+	content, err := ioutil.ReadAll(dcr.Content)
+	require.NoError(t, err, "Reading document content should succeed.")
+	assert.Equal(t, text, string(content), "Original document should be kept.")
+
+	ldr, err := apiService.ListDocuments(ctx, service.ListDocumentsRequest{Filter: docstore.Filter{Tag: "animals"}})
+	require.NoError(t, err, "Listing documents should succeed.")
+	assert.Equal(t, 1, ldr.Total, "Tagged document should be listed.")
+
+	sr := searchFor(ctx, t, apiService, service.SearchRequest{Query: "fox"}, 1)
+	require.Equal(t, 1, sr.Total, "Kept document should be indexed.")
+
+	_, err = apiService.DeleteDocument(ctx, service.DocumentLookupRequest{DocumentID: id})
+	require.NoError(t, err, "Deleting document should succeed.")
+
+	_, err = apiService.Document(ctx, service.DocumentLookupRequest{DocumentID: id})
+	assert.Equal(t, service.ErrNotFound, errors.Cause(err), "Deleted document should not be found.")
+	_, err = apiService.DeleteDocument(ctx, service.DocumentLookupRequest{DocumentID: id})
+	assert.Equal(t, service.ErrNotFound, errors.Cause(err), "Deleting twice should fail.")
+	_, err = apiService.NearDuplicates(ctx, service.NearDuplicatesRequest{DocumentID: id})
+	assert.Equal(t, service.ErrNotFound, errors.Cause(err), "Counts of deleted document should be gone.")
+	results, err := index.Search(ctx, "fox", search.Options{})
+	require.NoError(t, err, "Searching should succeed.")
+	assert.Zero(t, results.Total, "Deleted document should not be indexed.")
+	for _, key := range []string{id, "job:" + id, "results:" + id} {
+		v, err := kv.Retrieve(ctx, key)
+		require.NoError(t, err, "Retrieving should succeed.")
+		assert.Nil(t, v, "%s should be deleted.", key)
+	}
+}
+
+func TestDeleteDocumentResubmit(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       l,
+		Channel:   channel,
+		Documents: docstore.NewStore(kv, docstore.Config{}),
+		LegacyIDs: true,
+	})
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       l,
+		Channel:   channel,
+		ShardSize: 8,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go runWorker(ctx, q, channel, worker)
+
+	// The document is sharded.
+	const text = "This is a test document"
+	dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{Document: text})
+	require.NoError(t, err, "Processing document should succeed.")
+	id := dfr.DocumentID
+	data, err := kv.Retrieve(ctx, "results:"+id+":shards")
+	require.NoError(t, err)
+	var runs []string
+	require.NoError(t, json.Unmarshal(data, &runs))
+	require.Len(t, runs, 1, "Run of the shards should be tracked.")
+	run := strings.SplitN(runs[0], ":", 2)[0]
+
+	// A report from before IDs were versioned, and the state of a split
+	// that did not finish.
+	const legacyKey = "VGhpcyBpcyBhIHRlc3QgZG9jdW1lbnTjsMRCmPwcFJr79MiZb7kkJ65B5GSbk0yklZkbeFK4VQ=="
+	data, err = json.Marshal(service.DocumentFrequenciesResponse{
+		DocumentID:  legacyKey,
+		Frequencies: []service.Frequency{{Word: "legacy", Frequency: 1}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, kv.Store(ctx, legacyKey, data, time.Minute))
+	require.NoError(t, kv.SetCounter(ctx, keyspace.ShardCounter(id, run, "total"), 2))
+	require.NoError(t, kv.Store(ctx, keyspace.ShardPartial(id, run, 1), []byte("{}"), time.Minute))
+
+	_, err = apiService.DeleteDocument(ctx, service.DocumentLookupRequest{DocumentID: id})
+	require.NoError(t, err, "Deleting document should succeed.")
+	for _, key := range []string{legacyKey, "results:" + id + ":shards", keyspace.ShardPartial(id, run, 1)} {
+		data, err := kv.Retrieve(ctx, key)
+		require.NoError(t, err)
+		assert.Nil(t, data, "Key %s should be deleted.", key)
+	}
+	_, err = kv.GetCounter(ctx, keyspace.ShardCounter(id, run, "total"))
+	assert.Error(t, err, "Shard counters should be deleted.")
+
+	dfr, err = apiService.ProcessDocument(ctx, service.DocumentRequest{Document: text})
+	require.NoError(t, err, "Processing document again should succeed.")
+	var words []string
+	for _, f := range dfr.Frequencies {
+		words = append(words, f.Word)
+	}
+	assert.NotContains(t, words, "legacy", "Deleted legacy report should not be migrated.")
+	assert.Contains(t, words, "test", "Document should be analyzed again.")
+}
+
+func TestDocumentStoreStream(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	blobs := blob.NewStore(kv, blob.Config{ChunkSize: 4, Expiration: time.Minute})
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       l,
+		Channel:   channel,
+		Blobs:     blobs,
+		Documents: docstore.NewStore(kv, docstore.Config{ChunkSize: 4}),
+	})
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+		Blobs:   blobs,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go runWorker(ctx, q, channel, worker)
+
+	manifest := func(id string) blob.Manifest {
+		r, err := blobs.Open(ctx, id)
+		require.NoError(t, err, "Opening blob %s should succeed.", id)
+		return r.Manifest()
+	}
+
+	const text = "one two two three three three"
+	var upload string
+	for _, tag := range []string{"first", "second"} {
+		dfr, err := apiService.ProcessDocumentStream(ctx, service.DocumentStreamRequest{
+			Body:       strings.NewReader(text),
+			Submission: service.Submission{Tags: []string{tag}},
+		})
+		require.NoError(t, err, "Processing streamed document should succeed.")
+		require.Len(t, dfr.Frequencies, 3, "Streamed document should be analyzed.")
+		stored := manifest(keyspace.Document(dfr.DocumentID))
+		if upload == "" {
+			upload = stored.Upload
+		}
+		assert.Equal(t, upload, stored.Upload, "Contents should be stored once.")
+		assert.Equal(t, stored, manifest(dfr.DocumentID), "Blob should share the stored contents.")
+
+		dcr, err := apiService.DocumentContent(ctx, service.DocumentLookupRequest{DocumentID: dfr.DocumentID})
+		require.NoError(t, err, "Getting document content should succeed.")
+		content, err := ioutil.ReadAll(dcr.Content)
+		require.NoError(t, err, "Reading document content should succeed.")
+		assert.Equal(t, text, string(content), "Streamed document should be kept.")
+		assert.Contains(t, dcr.Tags, tag, "Tags should be kept.")
+	}
+}
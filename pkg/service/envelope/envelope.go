@@ -0,0 +1,186 @@
+// Package envelope encrypts the values of key value stores and the messages of
+// queues at rest, with envelope encryption.
+//
+// Every value is encrypted with AES-GCM under a random data key of its own,
+// and the data key is encrypted under a key encryption key of a Keyring. The
+// header of the encrypted value holds the ID of the key encryption key, so
+// keys can be rotated while values encrypted under earlier keys are still
+// read. Values are bound to the key or queue that they are stored under, so
+// they can't be moved to another one.
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrPlaintext is returned when a value is not encrypted and plaintext
+	// values are not allowed.
+	ErrPlaintext = errors.New("value is not encrypted")
+	// ErrUnknownKey is returned when a value is encrypted under a key that is
+	// not in the keyring.
+	ErrUnknownKey = errors.New("value is encrypted under an unknown key")
+)
+
+// magic starts the header of every encrypted value, so that values that were
+// stored before encryption was enabled can be told apart.
+var magic = []byte{0x9e, 'E', 'N', 'C'}
+
+// version is the version of the format of encrypted values.
+const version = 1
+
+const (
+	dataKeySize = 32
+	nonceSize   = 12
+	tagSize     = 16
+)
+
+// Config contains the configuration for encrypting stores and queues.
+type Config struct {
+	Keyring *Keyring
+	// AllowPlaintext reads values that are not encrypted as they are. It is
+	// meant for rolling out encryption, until the values stored before are
+	// re-encrypted or expire.
+	AllowPlaintext bool
+}
+
+// sealer encrypts and decrypts values.
+//
+// Encrypted values are the header followed by the encrypted data key and the
+// encrypted value:
+//
+//	magic | version | key ID length | key ID |
+//	data key nonce | encrypted data key | value nonce | encrypted value
+//
+// The data key is encrypted under the key encryption key with the header as
+// additional data, and the value under the data key with the header and the
+// context, such as the key of the value, as additional data.
+type sealer struct {
+	keyring        *Keyring
+	allowPlaintext bool
+}
+
+func newSealer(conf Config) *sealer {
+	if conf.Keyring == nil {
+		panic("nil keyring")
+	}
+	return &sealer{keyring: conf.Keyring, allowPlaintext: conf.AllowPlaintext}
+}
+
+// header returns the header of values encrypted under the key with ID id.
+func header(id string) []byte {
+	h := make([]byte, 0, len(magic)+2+len(id))
+	h = append(h, magic...)
+	h = append(h, version, byte(len(id)))
+	return append(h, id...)
+}
+
+// seal encrypts data for the context under the primary key.
+func (s *sealer) seal(data, context []byte) ([]byte, error) {
+	id := s.keyring.primary
+	kek := s.keyring.keys[id]
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, errors.Wrap(err, "unable to generate data key")
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	h := header(id)
+	size := len(h) + nonceSize + dataKeySize + tagSize + nonceSize + len(data) + tagSize
+	value := make([]byte, 0, size)
+	value = append(value, h...)
+	keyNonce, err := nonce()
+	if err != nil {
+		return nil, err
+	}
+	value = append(value, keyNonce...)
+	value = kek.Seal(value, keyNonce, dataKey, h)
+	valueNonce, err := nonce()
+	if err != nil {
+		return nil, err
+	}
+	value = append(value, valueNonce...)
+	return dek.Seal(value, valueNonce, data, additionalData(h, context)), nil
+}
+
+// open decrypts a value that was encrypted for the context.
+func (s *sealer) open(value, context []byte) ([]byte, error) {
+	id, ok, err := keyID(value)
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok && s.allowPlaintext:
+		return value, nil
+	case !ok:
+		return nil, ErrPlaintext
+	}
+	kek, ok := s.keyring.keys[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", id)
+	}
+
+	h := value[:len(magic)+2+len(id)]
+	rest := value[len(h):]
+	if len(rest) < nonceSize+dataKeySize+tagSize+nonceSize+tagSize {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	keyNonce, rest := rest[:nonceSize], rest[nonceSize:]
+	sealedKey, rest := rest[:dataKeySize+tagSize], rest[dataKeySize+tagSize:]
+	dataKey, err := kek.Open(nil, keyNonce, sealedKey, h)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt data key under key %q", id)
+	}
+	dek, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	valueNonce, sealed := rest[:nonceSize], rest[nonceSize:]
+	data, err := dek.Open(nil, valueNonce, sealed, additionalData(h, context))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt value")
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, nil
+}
+
+// keyID returns the ID of the key that a value is encrypted under, and
+// whether the value is encrypted.
+func keyID(value []byte) (string, bool, error) {
+	if !bytes.HasPrefix(value, magic) {
+		return "", false, nil
+	}
+	if len(value) < len(magic)+2 {
+		return "", false, errors.New("encrypted value is truncated")
+	}
+	if v := value[len(magic)]; v != version {
+		return "", false, errors.Errorf("unknown version %d of encrypted value", v)
+	}
+	n := int(value[len(magic)+1])
+	start := len(magic) + 2
+	if n == 0 || len(value) < start+n {
+		return "", false, errors.New("encrypted value has an invalid key ID")
+	}
+	return string(value[start : start+n]), true, nil
+}
+
+// additionalData returns the additional data that a value is encrypted with.
+func additionalData(header, context []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(context))
+	ad = append(ad, header...)
+	return append(ad, context...)
+}
+
+func nonce() ([]byte, error) {
+	n := make([]byte, nonceSize)
+	_, err := io.ReadFull(rand.Reader, n)
+	return n, errors.Wrap(err, "unable to generate nonce")
+}
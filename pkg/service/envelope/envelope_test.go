@@ -0,0 +1,146 @@
+package envelope_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/envelope"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// newKeyring returns a keyring of 32 byte keys with the given IDs, filled with
+// their first letter, whose primary key is the first one.
+func newKeyring(t *testing.T, ids ...string) *envelope.Keyring {
+	keys := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte{id[0]}, 32)
+	}
+	kr, err := envelope.NewKeyring(ids[0], keys)
+	require.NoError(t, err, "Creating keyring should succeed.")
+	return kr
+}
+
+func TestLoadKeyring(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "keyring")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyring.json")
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, ioutil.WriteFile(path,
+		[]byte(`{"primary": "b", "keys": {"a": "`+key+`", "b": "`+key+`"}}`), 0600))
+
+	kr, err := envelope.LoadKeyring(path)
+	require.NoError(t, err, "Loading keyring should succeed.")
+	assert.Equal(t, "b", kr.Primary())
+	assert.Equal(t, []string{"a", "b"}, kr.IDs())
+
+	_, err = envelope.LoadKeyring(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err, "Missing keyring should not be loaded.")
+}
+
+func TestParseKeyringErrors(t *testing.T) {
+	t.Parallel()
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	for name, data := range map[string]string{
+		"invalid JSON":    `{`,
+		"missing primary": `{"primary": "b", "keys": {"a": "` + key + `"}}`,
+		"not base64":      `{"primary": "a", "keys": {"a": "!"}}`,
+		"short key":       `{"primary": "a", "keys": {"a": "AAAA"}}`,
+		"empty ID":        `{"primary": "a", "keys": {"a": "` + key + `", "": "` + key + `"}}`,
+	} {
+		_, err := envelope.ParseKeyring([]byte(data))
+		assert.Error(t, err, "Keyring with %s should be rejected.", name)
+	}
+}
+
+func TestEncryptedAtRest(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	inner := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	kv := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "a")})
+
+	secret := []byte("a secret document")
+	require.NoError(t, kv.Store(ctx, "key", secret, 0))
+	stored, err := inner.Retrieve(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(stored, secret), "Stored value should be encrypted.")
+
+	require.NoError(t, kv.Store(ctx, "other", secret, 0))
+	other, _ := inner.Retrieve(ctx, "other")
+	assert.NotEqual(t, stored, other, "Equal values should be encrypted differently.")
+
+	// Moving an encrypted value to another key must not be accepted.
+	require.NoError(t, inner.Store(ctx, "moved", stored, 0))
+	_, err = kv.Retrieve(ctx, "moved")
+	assert.Error(t, err, "Value should be bound to its key.")
+
+	tampered := append([]byte(nil), stored...)
+	tampered[len(tampered)-1] ^= 1
+	require.NoError(t, inner.Store(ctx, "key", tampered, 0))
+	_, err = kv.Retrieve(ctx, "key")
+	assert.Error(t, err, "Changed value should not be decrypted.")
+}
+
+func TestKeyRotation(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	inner := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	old := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "a")})
+	require.NoError(t, old.Store(ctx, "key", []byte("value"), 0))
+
+	rotated := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "b", "a")})
+	got, err := rotated.Retrieve(ctx, "key")
+	require.NoError(t, err, "Values under earlier keys should be decrypted.")
+	assert.Equal(t, []byte("value"), got)
+
+	ok, err := rotated.Reencrypt(ctx, "key", 0)
+	require.NoError(t, err)
+	assert.True(t, ok, "Value under an earlier key should be re-encrypted.")
+	ok, err = rotated.Reencrypt(ctx, "key", 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "Value under the primary key should be kept.")
+	ok, err = rotated.Reencrypt(ctx, "missing", 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "Missing key should not be stored.")
+
+	retired := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "b")})
+	got, err = retired.Retrieve(ctx, "key")
+	require.NoError(t, err, "Re-encrypted value should not need the earlier key.")
+	assert.Equal(t, []byte("value"), got)
+
+	_, err = old.Retrieve(ctx, "key")
+	assert.Equal(t, envelope.ErrUnknownKey, errors.Cause(err), "Value under a missing key should not be decrypted.")
+}
+
+func TestPlaintextRollout(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	inner := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	require.NoError(t, inner.Store(ctx, "key", []byte("plain"), 0))
+
+	strict := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "a")})
+	_, err := strict.Retrieve(ctx, "key")
+	assert.Equal(t, envelope.ErrPlaintext, errors.Cause(err), "Plaintext should be rejected by default.")
+
+	rollout := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "a"), AllowPlaintext: true})
+	got, err := rollout.Retrieve(ctx, "key")
+	require.NoError(t, err, "Plaintext should be read during a rollout.")
+	assert.Equal(t, []byte("plain"), got)
+
+	ok, err := strict.Reencrypt(ctx, "key", 0)
+	require.NoError(t, err)
+	assert.True(t, ok, "Plaintext should be encrypted.")
+	got, err = strict.Retrieve(ctx, "key")
+	require.NoError(t, err, "Encrypted plaintext should be read.")
+	assert.Equal(t, []byte("plain"), got)
+}
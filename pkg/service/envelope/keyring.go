@@ -0,0 +1,113 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// maxKeyIDLength is the length of the longest key ID, which is stored in a
+// single byte of the header of encrypted values.
+const maxKeyIDLength = 255
+
+// Keyring holds the key encryption keys by their ID.
+//
+// Values are encrypted under the primary key, and decrypted with the key whose
+// ID is in their header, so keys are rotated by adding a new primary key and
+// keeping the old keys until every value has been re-encrypted.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// keyringFile is the JSON format of keyring files:
+//
+//	{
+//		"primary": "2020-02",
+//		"keys": {
+//			"2020-01": "<base64 of 32 random bytes>",
+//			"2020-02": "<base64 of 32 random bytes>"
+//		}
+//	}
+type keyringFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads a keyring from a JSON file.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read keyring")
+	}
+	kr, err := ParseKeyring(data)
+	return kr, errors.Wrapf(err, "invalid keyring %s", path)
+}
+
+// ParseKeyring parses a keyring in the JSON format of keyring files.
+func ParseKeyring(data []byte) (*Keyring, error) {
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %q is not base64", id)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(f.Primary, keys)
+}
+
+// NewKeyring returns a keyring of AES keys by their ID, which encrypts under
+// the key with the ID primary. Keys must be 16, 24 or 32 bytes long, for
+// AES-128, AES-192 or AES-256.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, errors.Errorf("no primary key %q", primary)
+	}
+	kr := &Keyring{primary: primary, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyIDLength {
+			return nil, errors.Errorf("key ID %q must have 1 to %d bytes", id, maxKeyIDLength)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %q", id)
+		}
+		kr.keys[id] = aead
+	}
+	return kr, nil
+}
+
+// Primary returns the ID of the key that values are encrypted under.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// IDs returns the IDs of the keys of the keyring in order.
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// newAEAD returns AES-GCM with key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.WithStack(err)
+}
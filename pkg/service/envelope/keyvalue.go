@@ -0,0 +1,232 @@
+package envelope
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// Ensure KeyValue implements the KeyValue interface.
+var _ keyvalue.KeyValue = (*KeyValue)(nil)
+
+// KeyValue encrypts the values of a wrapped KeyValue.
+//
+// Values are bound to their key. Versions are those of the decrypted values,
+// so they do not change when a value is re-encrypted. Counters are stored
+// unencrypted, since they are incremented by the wrapped store.
+type KeyValue struct {
+	kv keyvalue.KeyValue
+	s  *sealer
+}
+
+// NewKeyValue returns a KeyValue that encrypts the values of kv.
+func NewKeyValue(kv keyvalue.KeyValue, conf Config) *KeyValue {
+	return &KeyValue{kv: kv, s: newSealer(conf)}
+}
+
+func (k *KeyValue) seal(key string, data []byte) ([]byte, error) {
+	sealed, err := k.s.seal(data, []byte(key))
+	return sealed, errors.Wrapf(err, "unable to encrypt key %q", key)
+}
+
+func (k *KeyValue) open(key string, value []byte) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := k.s.open(value, []byte(key))
+	return data, errors.Wrapf(err, "unable to decrypt key %q", key)
+}
+
+// Store encrypts and stores a key value pair.
+func (k *KeyValue) Store(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	sealed, err := k.seal(key, data)
+	if err != nil {
+		return err
+	}
+	return k.kv.Store(ctx, key, sealed, expiration)
+}
+
+// Retrieve retrieves and decrypts the value for a key.
+func (k *KeyValue) Retrieve(ctx context.Context, key string) ([]byte, error) {
+	value, err := k.kv.Retrieve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return k.open(key, value)
+}
+
+// Delete deletes a key.
+func (k *KeyValue) Delete(ctx context.Context, key string) error {
+	return k.kv.Delete(ctx, key)
+}
+
+// RetrieveVersion retrieves and decrypts the value for a key and its version.
+func (k *KeyValue) RetrieveVersion(ctx context.Context, key string) ([]byte, keyvalue.Version, error) {
+	data, err := k.Retrieve(ctx, key)
+	if err != nil || data == nil {
+		return nil, keyvalue.NoVersion, err
+	}
+	return data, keyvalue.VersionOf(data), nil
+}
+
+// StoreIfAbsent encrypts and stores a key value pair if the key has no value.
+func (k *KeyValue) StoreIfAbsent(ctx context.Context, key string, data []byte, expiration time.Duration) (bool, error) {
+	sealed, err := k.seal(key, data)
+	if err != nil {
+		return false, err
+	}
+	return k.kv.StoreIfAbsent(ctx, key, sealed, expiration)
+}
+
+// CompareAndSwap encrypts and stores a key value pair if the decrypted value
+// of the key still has the given version.
+func (k *KeyValue) CompareAndSwap(ctx context.Context, key string, version keyvalue.Version, data []byte, expiration time.Duration) (bool, error) {
+	stored, ok, err := k.storedVersion(ctx, key, version)
+	if !ok || err != nil {
+		return false, err
+	}
+	sealed, err := k.seal(key, data)
+	if err != nil {
+		return false, err
+	}
+	return k.kv.CompareAndSwap(ctx, key, stored, sealed, expiration)
+}
+
+// CompareAndDelete deletes a key if its decrypted value still has the given
+// version.
+func (k *KeyValue) CompareAndDelete(ctx context.Context, key string, version keyvalue.Version) (bool, error) {
+	if version == keyvalue.NoVersion {
+		return false, nil
+	}
+	stored, ok, err := k.storedVersion(ctx, key, version)
+	if !ok || err != nil {
+		return false, err
+	}
+	return k.kv.CompareAndDelete(ctx, key, stored)
+}
+
+// storedVersion returns the version of the encrypted value of key in the
+// wrapped store if its decrypted value has the given version. The wrapped
+// store compares against it, so the value is only changed if it was not
+// changed since it was decrypted.
+func (k *KeyValue) storedVersion(ctx context.Context, key string, version keyvalue.Version) (keyvalue.Version, bool, error) {
+	if version == keyvalue.NoVersion {
+		return keyvalue.NoVersion, true, nil
+	}
+	value, stored, err := k.kv.RetrieveVersion(ctx, key)
+	if err != nil || value == nil {
+		return keyvalue.NoVersion, false, err
+	}
+	data, err := k.open(key, value)
+	if err != nil {
+		return keyvalue.NoVersion, false, err
+	}
+	return stored, keyvalue.VersionOf(data) == version, nil
+}
+
+// RetrieveMany retrieves and decrypts the values for several keys.
+func (k *KeyValue) RetrieveMany(ctx context.Context, keys []string) ([][]byte, error) {
+	values, err := k.kv.RetrieveMany(ctx, keys)
+	errs, ok := err.(keyvalue.BatchError)
+	if err != nil && !ok {
+		return nil, err
+	}
+	if errs == nil {
+		errs = make(keyvalue.BatchError, len(keys))
+	}
+	failed := false
+	for i, value := range values {
+		if errs[i] == nil {
+			values[i], errs[i] = k.open(keys[i], value)
+		}
+		failed = failed || errs[i] != nil
+	}
+	if failed {
+		return values, errs
+	}
+	return values, nil
+}
+
+// StoreMany encrypts and stores several key value pairs. Pairs that fail to
+// be encrypted are not stored.
+func (k *KeyValue) StoreMany(ctx context.Context, entries []keyvalue.Entry) error {
+	errs := make(keyvalue.BatchError, len(entries))
+	sealed := make([]keyvalue.Entry, 0, len(entries))
+	// index holds the index in entries of each sealed entry.
+	index := make([]int, 0, len(entries))
+	for i, e := range entries {
+		value, err := k.seal(e.Key, e.Data)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		sealed = append(sealed, keyvalue.Entry{Key: e.Key, Data: value, Expiration: e.Expiration})
+		index = append(index, i)
+	}
+	failed := len(sealed) < len(entries)
+	if len(sealed) > 0 {
+		err := k.kv.StoreMany(ctx, sealed)
+		storeErrs, ok := err.(keyvalue.BatchError)
+		switch {
+		case err != nil && !ok:
+			return err
+		case ok:
+			for j, err := range storeErrs {
+				errs[index[j]] = err
+				failed = failed || err != nil
+			}
+		}
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+// SetCounter sets an unencrypted counter.
+func (k *KeyValue) SetCounter(ctx context.Context, key string, value int64) error {
+	return k.kv.SetCounter(ctx, key, value)
+}
+
+// GetCounter gets an unencrypted counter.
+func (k *KeyValue) GetCounter(ctx context.Context, key string) (int64, error) {
+	return k.kv.GetCounter(ctx, key)
+}
+
+// IncrementCounter increments an unencrypted counter.
+func (k *KeyValue) IncrementCounter(ctx context.Context, key string) error {
+	return k.kv.IncrementCounter(ctx, key)
+}
+
+// Reencrypt encrypts the value of a key under the primary key of the keyring
+// if it is encrypted under another key or, during a rollout, not encrypted at
+// all. The value is kept for expiration, or forever if it is 0, and it is left
+// alone if it changes in the meantime, since it was then stored under the
+// primary key. Reencrypt reports whether the value was rewritten.
+func (k *KeyValue) Reencrypt(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	value, stored, err := k.kv.RetrieveVersion(ctx, key)
+	if err != nil || value == nil {
+		return false, err
+	}
+	id, encrypted, err := keyID(value)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to decrypt key %q", key)
+	}
+	if encrypted && id == k.s.keyring.primary {
+		return false, nil
+	}
+	data := value
+	if encrypted {
+		if data, err = k.open(key, value); err != nil {
+			return false, err
+		}
+	}
+	sealed, err := k.seal(key, data)
+	if err != nil {
+		return false, err
+	}
+	return k.kv.CompareAndSwap(ctx, key, stored, sealed, expiration)
+}
@@ -0,0 +1,63 @@
+package envelope_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/envelope"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue/keyvaluetest"
+)
+
+func TestKeyValueConformance(t *testing.T) {
+	t.Parallel()
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		inner := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+		return envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "a")}), func() {}
+	})
+}
+
+func TestKeyValueVersions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	inner := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	kv := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "b", "a")})
+
+	require.NoError(t, kv.Store(ctx, "key", []byte("value"), 0))
+	_, version, err := kv.RetrieveVersion(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, keyvalue.VersionOf([]byte("value")), version, "Version should be of the decrypted value.")
+
+	// Re-encrypting changes the stored value but not its version.
+	old := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "a")})
+	require.NoError(t, old.Store(ctx, "key", []byte("value"), 0))
+	ok, err := kv.Reencrypt(ctx, "key", 0)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = kv.CompareAndSwap(ctx, "key", version, []byte("new"), 0)
+	require.NoError(t, err)
+	assert.True(t, ok, "Version should still match after re-encryption.")
+
+	ok, err = kv.CompareAndSwap(ctx, "key", version, []byte("newer"), 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "Changed value should not match the earlier version.")
+	ok, err = kv.CompareAndDelete(ctx, "key", keyvalue.VersionOf([]byte("new")))
+	require.NoError(t, err)
+	assert.True(t, ok, "Value should be deleted by its version.")
+}
+
+func TestKeyValueCounters(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	inner := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	kv := envelope.NewKeyValue(inner, envelope.Config{Keyring: newKeyring(t, "a")})
+
+	require.NoError(t, kv.SetCounter(ctx, "counter", 1))
+	require.NoError(t, kv.IncrementCounter(ctx, "counter"))
+	n, err := inner.GetCounter(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n, "Counters should be stored unencrypted.")
+}
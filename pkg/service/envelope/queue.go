@@ -0,0 +1,50 @@
+package envelope
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+)
+
+// Ensure Queue implements the Queue interface.
+var _ queue.Queue = (*Queue)(nil)
+
+// Queue encrypts the messages of a wrapped Queue. Messages are bound to their
+// channel.
+//
+// Messages are only kept until they are pulled, so they are not re-encrypted
+// when keys are rotated; old keys should be kept until the queues have been
+// drained of the messages encrypted under them.
+type Queue struct {
+	q queue.Queue
+	s *sealer
+}
+
+// NewQueue returns a Queue that encrypts the messages of q.
+func NewQueue(q queue.Queue, conf Config) *Queue {
+	return &Queue{q: q, s: newSealer(conf)}
+}
+
+// Push encrypts and pushes messages onto a channel.
+func (q *Queue) Push(ctx context.Context, channel string, data [][]byte) error {
+	sealed := make([][]byte, len(data))
+	for i, d := range data {
+		var err error
+		if sealed[i], err = q.s.seal(d, []byte(channel)); err != nil {
+			return errors.Wrap(err, "unable to encrypt message")
+		}
+	}
+	return q.q.Push(ctx, channel, sealed)
+}
+
+// Pull pulls and decrypts a message from a channel.
+func (q *Queue) Pull(ctx context.Context, channel string) ([]byte, error) {
+	value, err := q.q.Pull(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	data, err := q.s.open(value, []byte(channel))
+	return data, errors.Wrap(err, "unable to decrypt message")
+}
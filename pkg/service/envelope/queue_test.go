@@ -0,0 +1,48 @@
+package envelope_test
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/envelope"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redistest"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue/queuetest"
+)
+
+func TestQueueConformance(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T) (queue.Queue, func()) {
+		inner := queue.NewRedisAdapter(redistest.Connect(t))
+		return envelope.NewQueue(inner, envelope.Config{Keyring: newKeyring(t, "a")}), func() {}
+	})
+}
+
+func TestQueueEncryptedAtRest(t *testing.T) {
+	t.Parallel()
+	inner := queue.NewRedisAdapter(redistest.Connect(t))
+	q := envelope.NewQueue(inner, envelope.Config{Keyring: newKeyring(t, "a")})
+	channel := t.Name() + strconv.FormatInt(time.Now().UnixNano(), 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	secret := []byte("a secret document")
+	require.NoError(t, q.Push(ctx, channel, [][]byte{secret, secret}))
+	stored, err := inner.Pull(ctx, channel)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(stored, secret), "Queued message should be encrypted.")
+
+	got, err := q.Pull(ctx, channel)
+	require.NoError(t, err)
+	assert.Equal(t, secret, got, "Message should be decrypted.")
+
+	// Moving an encrypted message to another channel must not be accepted.
+	require.NoError(t, inner.Push(ctx, channel+"other", [][]byte{stored}))
+	_, err = q.Pull(ctx, channel+"other")
+	assert.Error(t, err, "Message should be bound to its channel.")
+}
@@ -0,0 +1,79 @@
+package extract
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// CSV is an Extractor for CSV documents.
+type CSV struct {
+	// Columns are the names of the columns to extract, as given in the first
+	// row. Every field of every row is extracted if there are none.
+	Columns []string
+}
+
+// Extract writes the fields of the selected columns of the CSV document read
+// from src to dst.
+func (c CSV) Extract(dst io.Writer, src io.Reader) error {
+	r := csv.NewReader(src)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.ReuseRecord = true
+
+	var indexes []int
+	if len(c.Columns) > 0 {
+		header, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read CSV header")
+		}
+		positions := make(map[string]int, len(header))
+		for i, name := range header {
+			if _, ok := positions[name]; !ok {
+				positions[name] = i
+			}
+		}
+		for _, name := range c.Columns {
+			i, ok := positions[name]
+			if !ok {
+				return errors.Errorf("missing CSV column %q", name)
+			}
+			indexes = append(indexes, i)
+		}
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read CSV record")
+		}
+		if indexes == nil {
+			for _, field := range record {
+				if err := writeField(dst, field); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for _, i := range indexes {
+			if i >= len(record) {
+				continue
+			}
+			if err := writeField(dst, record[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeField(dst io.Writer, field string) error {
+	_, err := io.WriteString(dst, field+"\n")
+	return errors.WithStack(err)
+}
@@ -0,0 +1,40 @@
+package extract_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+)
+
+const csvDocument = `id,name,comment
+1,Ann,"Great, thanks"
+2,Bob,"Multi
+line"
+3,Cy
+`
+
+func TestCSV(t *testing.T) {
+	t.Parallel()
+
+	t.Run("All Columns", func(t *testing.T) {
+		t.Parallel()
+		text := extractString(t, extract.CSV{}, csvDocument)
+		assert.Equal(t, []string{"id", "name", "comment", "1", "Ann", "Great,", "thanks", "2", "Bob", "Multi", "line", "3", "Cy"}, strings.Fields(text))
+	})
+
+	t.Run("Selected Columns", func(t *testing.T) {
+		t.Parallel()
+		text := extractString(t, extract.CSV{Columns: []string{"comment", "name"}}, csvDocument)
+		assert.Equal(t, []string{"Great,", "thanks", "Ann", "Multi", "line", "Bob", "Cy"}, strings.Fields(text))
+	})
+
+	t.Run("Missing Column", func(t *testing.T) {
+		t.Parallel()
+		var sb strings.Builder
+		err := extract.CSV{Columns: []string{"missing"}}.Extract(&sb, strings.NewReader(csvDocument))
+		assert.Error(t, err, "Missing columns should fail.")
+	})
+}
@@ -0,0 +1,146 @@
+// Package extract implements support for turning documents of different
+// formats into the plain text that words are counted from.
+package extract
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Supported media types.
+const (
+	TypePlain    = "text/plain"
+	TypeHTML     = "text/html"
+	TypeMarkdown = "text/markdown"
+	TypeJSON     = "application/json"
+	TypeCSV      = "text/csv"
+)
+
+// SniffLength is the number of bytes looked at to detect the type of a
+// document.
+const SniffLength = 512
+
+// Extractor wraps the method for extracting plain text from a document.
+type Extractor interface {
+	// Extract writes the plain text of the document read from src to dst.
+	Extract(dst io.Writer, src io.Reader) error
+}
+
+// Options contains the format specific options for extraction.
+type Options struct {
+	// JSONPath selects the parts of a JSON document to extract text from.
+	JSONPath string
+	// CSVColumns are the names of the columns to extract text from. The first
+	// row of the document is used as the header if any are set.
+	CSVColumns []string
+}
+
+// Plain is an Extractor for documents that are already plain text.
+type Plain struct{}
+
+// Extract copies src to dst unchanged.
+func (Plain) Extract(dst io.Writer, src io.Reader) error {
+	_, err := io.Copy(dst, src)
+	return errors.WithStack(err)
+}
+
+// MediaType normalizes a content type to a lower case media type without
+// parameters.
+func MediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// aliases are other names of the supported media types.
+var aliases = map[string]string{
+	"application/xhtml+xml": TypeHTML,
+	"text/x-markdown":       TypeMarkdown,
+	"text/json":             TypeJSON,
+}
+
+// Canonical returns the supported media type that a content type selects, or
+// its media type if it is not supported.
+func Canonical(contentType string) string {
+	mediaType := MediaType(contentType)
+	if t, ok := aliases[mediaType]; ok {
+		return t
+	}
+	return mediaType
+}
+
+// Normalize returns the content type that a document starting with prefix is
+// extracted as, or an empty content type if the type would be detected from
+// prefix anyway. Documents that are extracted the same way therefore have the
+// same normalized content type whether their type was declared or not.
+//
+// prefix should be the first 512 bytes of the document, or all of it if it is
+// shorter.
+func Normalize(contentType string, prefix []byte) string {
+	t := Canonical(contentType)
+	if t == "" || t == Sniff(prefix) {
+		return ""
+	}
+	return t
+}
+
+// New returns the Extractor for a content type.
+func New(contentType string, opts Options) (Extractor, error) {
+	switch Canonical(contentType) {
+	case "", TypePlain:
+		return Plain{}, nil
+	case TypeHTML:
+		return HTML{}, nil
+	case TypeMarkdown:
+		return Markdown{}, nil
+	case TypeJSON:
+		return NewJSON(opts.JSONPath)
+	case TypeCSV:
+		return CSV{Columns: opts.CSVColumns}, nil
+	default:
+		return nil, errors.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// Reader returns a reader for the plain text of the document read from src.
+//
+// If contentType is empty, the type is detected from the start of the
+// document.
+func Reader(src io.Reader, contentType string, opts Options) (io.ReadCloser, error) {
+	if contentType == "" {
+		br := bufio.NewReaderSize(src, SniffLength)
+		// Errors are returned again when the rest of the document is read.
+		prefix, _ := br.Peek(SniffLength)
+		contentType = Sniff(prefix)
+		src = br
+	}
+	e, err := New(contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := e.(Plain); ok {
+		return nopCloser{src}, nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := e.Extract(pw, src)
+		_ = pw.CloseWithError(errors.Wrapf(err, "unable to extract text from %s document", MediaType(contentType)))
+	}()
+	return pr, nil
+}
+
+type nopCloser struct {
+	io.Reader
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,98 @@
+package extract_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+)
+
+func TestSniff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		doc      string
+		expected string
+	}{
+		{"This is a a test document", extract.TypePlain},
+		{"", extract.TypePlain},
+		{"[citation needed] is a phrase", extract.TypePlain},
+		{"[1] reference", extract.TypePlain},
+		{"{not json}", extract.TypePlain},
+		{`  {"a": ["b", "c"`, extract.TypeJSON},
+		{`[1, 2, 3]`, extract.TypeJSON},
+		{"<!DOCTYPE html><p>hi</p>", extract.TypeHTML},
+		{"<p>paragraph</p>", extract.TypeHTML},
+		{"# Heading\n\nSome text", extract.TypeMarkdown},
+		{"Read [this](https://example.com).", extract.TypeMarkdown},
+		{"#hashtag text", extract.TypePlain},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, extract.Sniff([]byte(test.doc)), "Unexpected type for %q.", test.doc)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		contentType string
+		doc         string
+		expected    string
+	}{
+		{"", "plain text", ""},
+		{"text/plain; charset=utf-8", "plain text", ""},
+		{"TEXT/PLAIN", "plain text", ""},
+		{"text/plain", "<p>markup</p>", extract.TypePlain},
+		{"text/x-markdown", "# Heading", ""},
+		{"text/json", "plain text", extract.TypeJSON},
+		{"text/csv", "a,b", extract.TypeCSV},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, extract.Normalize(test.contentType, []byte(test.doc)),
+			"Unexpected type for %q declared as %q.", test.doc, test.contentType)
+	}
+}
+
+func TestReader(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Declared", func(t *testing.T) {
+		t.Parallel()
+		r, err := extract.Reader(strings.NewReader(`{"a": "b c"}`), "application/json; charset=utf-8", extract.Options{})
+		require.NoError(t, err, "Creating reader should succeed.")
+		defer r.Close()
+		text, err := ioutil.ReadAll(r)
+		require.NoError(t, err, "Reading should succeed.")
+		assert.Equal(t, []string{"b", "c"}, strings.Fields(string(text)))
+	})
+
+	t.Run("Sniffed", func(t *testing.T) {
+		t.Parallel()
+		r, err := extract.Reader(strings.NewReader("<p>one <i>two</i></p>"), "", extract.Options{})
+		require.NoError(t, err, "Creating reader should succeed.")
+		defer r.Close()
+		text, err := ioutil.ReadAll(r)
+		require.NoError(t, err, "Reading should succeed.")
+		assert.Equal(t, []string{"one", "two"}, strings.Fields(string(text)))
+	})
+
+	t.Run("Extraction Error", func(t *testing.T) {
+		t.Parallel()
+		r, err := extract.Reader(strings.NewReader(`{"a": `), extract.TypeJSON, extract.Options{})
+		require.NoError(t, err, "Creating reader should succeed.")
+		defer r.Close()
+		_, err = ioutil.ReadAll(r)
+		assert.Error(t, err, "Extraction errors should be returned by reads.")
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		t.Parallel()
+		_, err := extract.Reader(strings.NewReader(""), "image/png", extract.Options{})
+		assert.Error(t, err, "Unsupported types should fail.")
+	})
+}
@@ -0,0 +1,108 @@
+package extract
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTML is an Extractor for HTML documents.
+//
+// Tags, comments, scripts and styles are removed and character references
+// are decoded.
+type HTML struct{}
+
+// skippedElements are elements whose contents are not text of the document.
+var skippedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Math:     true,
+}
+
+// inlineElements are elements that do not separate the words around them.
+var inlineElements = map[atom.Atom]bool{
+	atom.A:      true,
+	atom.Abbr:   true,
+	atom.B:      true,
+	atom.Bdi:    true,
+	atom.Bdo:    true,
+	atom.Cite:   true,
+	atom.Code:   true,
+	atom.Data:   true,
+	atom.Dfn:    true,
+	atom.Em:     true,
+	atom.Font:   true,
+	atom.I:      true,
+	atom.Kbd:    true,
+	atom.Mark:   true,
+	atom.Q:      true,
+	atom.S:      true,
+	atom.Samp:   true,
+	atom.Small:  true,
+	atom.Span:   true,
+	atom.Strong: true,
+	atom.Sub:    true,
+	atom.Sup:    true,
+	atom.Time:   true,
+	atom.U:      true,
+	atom.Var:    true,
+}
+
+// Extract writes the text content of the HTML document read from src to dst.
+func (HTML) Extract(dst io.Writer, src io.Reader) error {
+	z := html.NewTokenizer(src)
+	skipDepth := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return errors.WithStack(z.Err())
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			if _, err := dst.Write(z.Text()); err != nil {
+				return errors.WithStack(err)
+			}
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			if skippedElements[a] {
+				skipDepth++
+			}
+			if err := separate(dst, a); err != nil {
+				return err
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			if skippedElements[a] && skipDepth > 0 {
+				skipDepth--
+			}
+			if err := separate(dst, a); err != nil {
+				return err
+			}
+		case html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			if err := separate(dst, atom.Lookup(name)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// separate writes whitespace for elements that separate words.
+func separate(dst io.Writer, a atom.Atom) error {
+	if inlineElements[a] {
+		return nil
+	}
+	_, err := io.WriteString(dst, "\n")
+	return errors.WithStack(err)
+}
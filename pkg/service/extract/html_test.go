@@ -0,0 +1,38 @@
+package extract_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+)
+
+func extractString(t *testing.T, e extract.Extractor, doc string) string {
+	var sb strings.Builder
+	err := e.Extract(&sb, strings.NewReader(doc))
+	require.NoError(t, err, "Extraction should succeed.")
+	return sb.String()
+}
+
+func TestHTML(t *testing.T) {
+	t.Parallel()
+
+	doc := `<!DOCTYPE html>
+<html>
+<head>
+	<title>The Title</title>
+	<style>body { color: red; }</style>
+	<script>var ignored = "script";</script>
+</head>
+<body>
+	<!-- a comment -->
+	<p>Fish &amp; chips<br>caf&eacute; <b>bold</b>ly</p><div>next</div>
+	<img src="https://example.com/image.png" alt="image">
+</body>
+</html>`
+	text := extractString(t, extract.HTML{}, doc)
+	assert.Equal(t, []string{"The", "Title", "Fish", "&", "chips", "café", "boldly", "next"}, strings.Fields(text))
+}
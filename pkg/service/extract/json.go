@@ -0,0 +1,289 @@
+package extract
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JSON is an Extractor for JSON documents.
+//
+// Only string values are extracted. Object keys, numbers, booleans and nulls
+// are not text of the document.
+type JSON struct {
+	path []pathStep
+}
+
+// NewJSON returns a JSON Extractor that only extracts the string values
+// selected by path.
+//
+// The supported JSONPath subset is the root ($), child members (.name or
+// ['name']), array indexes ([0], negative indexes count from the end),
+// wildcards (.* or [*]) and recursive descent (..name or ..*). An empty path
+// selects the whole document.
+func NewJSON(path string) (JSON, error) {
+	if path == "" {
+		return JSON{}, nil
+	}
+	steps, err := parsePath(path)
+	if err != nil {
+		return JSON{}, err
+	}
+	return JSON{path: steps}, nil
+}
+
+// Extract writes the string values of the JSON document read from src to dst.
+func (j JSON) Extract(dst io.Writer, src io.Reader) error {
+	if j.path == nil {
+		return extractJSONStream(dst, src)
+	}
+
+	// Selecting values requires the whole document.
+	dec := json.NewDecoder(src)
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return errors.Wrap(err, "invalid JSON document")
+	}
+	return selectPath(doc, j.path, func(v interface{}) error {
+		return writeStrings(dst, v)
+	})
+}
+
+// extractJSONStream writes the string values of a JSON document to dst
+// without decoding the whole document at once.
+func extractJSONStream(dst io.Writer, src io.Reader) error {
+	type frame struct {
+		object    bool
+		expectKey bool
+	}
+	dec := json.NewDecoder(src)
+	dec.UseNumber()
+	var stack []frame
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && len(stack) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "invalid JSON document")
+		}
+
+		_, isString := tok.(string)
+		if isString && len(stack) > 0 && stack[len(stack)-1].object && stack[len(stack)-1].expectKey {
+			stack[len(stack)-1].expectKey = false
+			continue
+		}
+
+		valueDone := true
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{':
+				stack = append(stack, frame{object: true, expectKey: true})
+				valueDone = false
+			case '[':
+				stack = append(stack, frame{})
+				valueDone = false
+			default:
+				stack = stack[:len(stack)-1]
+			}
+		case string:
+			if err := writeField(dst, v); err != nil {
+				return err
+			}
+		}
+		if valueDone && len(stack) > 0 && stack[len(stack)-1].object {
+			stack[len(stack)-1].expectKey = true
+		}
+	}
+}
+
+// writeStrings writes every string value within v to dst.
+func writeStrings(dst io.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		return writeField(dst, v)
+	case []interface{}:
+		for _, e := range v {
+			if err := writeStrings(dst, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// Keep the output stable regardless of map ordering.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeStrings(dst, v[k]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type pathStep struct {
+	// name is the member to select. It is empty for array indexes and "*" for
+	// wildcards.
+	name      string
+	index     int
+	recursive bool
+}
+
+// parsePath parses a JSONPath expression.
+func parsePath(path string) ([]pathStep, error) {
+	invalid := func(reason string) error {
+		return errors.Errorf("invalid JSONPath %q: %s", path, reason)
+	}
+
+	p := strings.TrimSpace(path)
+	p = strings.TrimPrefix(p, "$")
+	steps := []pathStep{}
+	for len(p) > 0 {
+		recursive := false
+		switch {
+		case strings.HasPrefix(p, ".."):
+			recursive = true
+			p = p[2:]
+		case p[0] == '.':
+			p = p[1:]
+		case p[0] == '[':
+		default:
+			return nil, invalid("expected '.' or '['")
+		}
+
+		if len(p) > 0 && p[0] == '[' {
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, invalid("missing ']'")
+			}
+			inner := strings.TrimSpace(p[1:end])
+			p = p[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, pathStep{name: "*", recursive: recursive})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, pathStep{name: inner[1 : len(inner)-1], recursive: recursive})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, invalid("unsupported selector [" + inner + "]")
+				}
+				steps = append(steps, pathStep{index: i, recursive: recursive})
+			}
+			continue
+		}
+
+		end := strings.IndexAny(p, ".[")
+		if end < 0 {
+			end = len(p)
+		}
+		name := p[:end]
+		if name == "" {
+			return nil, invalid("empty member name")
+		}
+		p = p[end:]
+		steps = append(steps, pathStep{name: name, recursive: recursive})
+	}
+	if len(steps) > maxPathSteps {
+		return nil, invalid("too many steps")
+	}
+	return steps, nil
+}
+
+// maxPathSteps is the largest number of steps of a JSONPath, so that the
+// steps matched at a value fit in a bit set.
+const maxPathSteps = 63
+
+// maxSelectDepth is the deepest that values are selected from. Deeper values
+// fail the extraction instead of growing the stack without bound.
+const maxSelectDepth = 512
+
+// selectPath calls fn with the values within doc that are selected by steps.
+//
+// The document is walked once, keeping for each value the set of steps that
+// the path to it matches, so the time taken grows with the size of the
+// document and the number of steps only. A selected value is not walked any
+// further: the text of a value includes the text of the values nested within
+// it, so they are only passed to fn once even if they are selected too, e.g.
+// "$..*" selects both an object and its members.
+func selectPath(doc interface{}, steps []pathStep, fn func(interface{}) error) error {
+	return selectValue(doc, steps, 1, 0, fn)
+}
+
+// selectValue calls fn with v, or with the values selected within it. states
+// holds a bit for each number of steps that the path to v matches.
+func selectValue(v interface{}, steps []pathStep, states uint64, depth int, fn func(interface{}) error) error {
+	if states&(1<<uint(len(steps))) != 0 {
+		return fn(v)
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if depth >= maxSelectDepth {
+			return errors.Errorf("JSON document is nested deeper than %d values", maxSelectDepth)
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			next := nextStates(steps, states, func(step pathStep) bool {
+				return step.name == "*" || step.name != "" && step.name == k
+			})
+			if next == 0 {
+				continue
+			}
+			if err := selectValue(v[k], steps, next, depth+1, fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if depth >= maxSelectDepth {
+			return errors.Errorf("JSON document is nested deeper than %d values", maxSelectDepth)
+		}
+		for i, e := range v {
+			next := nextStates(steps, states, func(step pathStep) bool {
+				return step.name == "*" || step.name == "" && (step.index == i || step.index+len(v) == i)
+			})
+			if next == 0 {
+				continue
+			}
+			if err := selectValue(e, steps, next, depth+1, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nextStates returns the steps matched at a value nested directly within a
+// value that matches states, where matches reports whether the nested value
+// is selected by a step. Recursive steps also stay unmatched, so that they
+// can match values nested deeper.
+func nextStates(steps []pathStep, states uint64, matches func(pathStep) bool) uint64 {
+	var next uint64
+	for i, step := range steps {
+		if states&(1<<uint(i)) == 0 {
+			continue
+		}
+		if matches(step) {
+			next |= 1 << uint(i+1)
+		}
+		if step.recursive {
+			next |= 1 << uint(i)
+		}
+	}
+	return next
+}
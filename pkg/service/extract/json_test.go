@@ -0,0 +1,85 @@
+package extract_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+)
+
+const jsonDocument = `{
+	"title": "A title",
+	"count": 3,
+	"draft": false,
+	"tags": ["one", "two"],
+	"comments": [
+		{"author": "ann", "body": "first comment", "score": 1.5},
+		{"author": "bob", "body": "second comment", "extra": null}
+	]
+}`
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		path     string
+		expected []string
+	}{
+		{"Whole Document", "", []string{"A", "title", "one", "two", "ann", "first", "comment", "bob", "second", "comment"}},
+		{"Root", "$", []string{"ann", "first", "comment", "bob", "second", "comment", "one", "two", "A", "title"}},
+		{"Member", "$.title", []string{"A", "title"}},
+		{"Bracket Member", "$['title']", []string{"A", "title"}},
+		{"Index", "$.tags[1]", []string{"two"}},
+		{"Negative Index", "$.comments[-1].body", []string{"second", "comment"}},
+		{"Wildcard", "$.comments[*].body", []string{"first", "comment", "second", "comment"}},
+		{"Member Wildcard", "$.comments[0].*", []string{"ann", "first", "comment"}},
+		{"Recursive", "$..author", []string{"ann", "bob"}},
+		{"Recursive Wildcard", "$..*", []string{"ann", "first", "comment", "bob", "second", "comment", "one", "two", "A", "title"}},
+		{"Nested Recursive", "$..comments..body", []string{"first", "comment", "second", "comment"}},
+		{"Overlapping", "$..comments[0]..*", []string{"ann", "first", "comment"}},
+		{"Missing", "$.missing", []string{}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			e, err := extract.NewJSON(test.path)
+			require.NoError(t, err, "Path should be valid.")
+			text := extractString(t, e, jsonDocument)
+			assert.Equal(t, test.expected, strings.Fields(text))
+		})
+	}
+}
+
+func TestJSONInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := extract.NewJSON("$.a[?(@.b)]")
+	assert.Error(t, err, "Filters should be unsupported.")
+
+	var sb strings.Builder
+	err = extract.JSON{}.Extract(&sb, strings.NewReader(`{"a": }`))
+	assert.Error(t, err, "Invalid documents should fail.")
+}
+
+func TestJSONDeeplyNested(t *testing.T) {
+	t.Parallel()
+
+	nested := func(depth int) string {
+		return strings.Repeat(`{"a": `, depth) + `{"b": "deep"}` + strings.Repeat("}", depth)
+	}
+	e, err := extract.NewJSON("$..a..b")
+	require.NoError(t, err, "Path should be valid.")
+	// Each recursive step matches at every depth, but the document is only
+	// walked once.
+	text := extractString(t, e, nested(500))
+	assert.Equal(t, []string{"deep"}, strings.Fields(text))
+
+	var sb strings.Builder
+	err = e.Extract(&sb, strings.NewReader(nested(1000)))
+	assert.Error(t, err, "Documents nested too deeply should fail.")
+}
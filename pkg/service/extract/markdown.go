@@ -0,0 +1,111 @@
+package extract
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Markdown is an Extractor for Markdown documents.
+//
+// Block markers, emphasis, link targets, URLs and inline HTML are removed.
+// The text of links and images, and the contents of code blocks are kept.
+type Markdown struct{}
+
+var (
+	markdownRule       = regexp.MustCompile(`^\s{0,3}([-*_=]\s*){3,}$`)
+	markdownTableRule  = regexp.MustCompile(`^\s*\|?(\s*:?-+:?\s*\|)+\s*(:?-+:?\s*)?$`)
+	markdownReference  = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s*\S+.*$`)
+	markdownBlockQuote = regexp.MustCompile(`^\s{0,3}(>\s?)+`)
+	markdownListItem   = regexp.MustCompile(`^\s*([-*+]|\d{1,9}[.)])\s+(\[[ xX]\]\s+)?`)
+	markdownHeading    = regexp.MustCompile(`^\s{0,3}#{1,6}(\s+|$)`)
+	markdownClosingATX = regexp.MustCompile(`\s+#+\s*$`)
+	markdownImage      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink       = regexp.MustCompile(`\[([^\]]*)\](\([^)]*\)|\[[^\]]*\])`)
+	markdownAutoLink   = regexp.MustCompile(`<[a-zA-Z][a-zA-Z0-9+.-]*:[^>\s]*>`)
+	markdownInlineHTML = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	markdownURL        = regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://\S+`)
+	markdownEscape     = regexp.MustCompile("\\\\([!-/:-@\\[-`{-~])")
+)
+
+// markdownEmphasis are the characters that surround emphasized text.
+const markdownEmphasis = "*_~`"
+
+// Extract writes the plain text of the Markdown document read from src to
+// dst.
+func (Markdown) Extract(dst io.Writer, src io.Reader) error {
+	br := bufio.NewReader(src)
+	var fence string
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimRight(line, "\r\n")
+			var text string
+			text, fence = markdownLine(line, fence)
+			if _, err := io.WriteString(dst, text+"\n"); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// markdownLine returns the plain text of a line and the fence of the code
+// block that the next line is in, if any.
+func markdownLine(line, fence string) (string, string) {
+	trimmed := strings.TrimSpace(line)
+	if fence != "" {
+		if strings.HasPrefix(trimmed, fence) {
+			return "", ""
+		}
+		// Code is kept as it is.
+		return line, fence
+	}
+	if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+		return "", trimmed[:3]
+	}
+	if markdownRule.MatchString(line) || markdownTableRule.MatchString(line) || markdownReference.MatchString(line) {
+		return "", ""
+	}
+
+	line = markdownBlockQuote.ReplaceAllString(line, "")
+	line = markdownListItem.ReplaceAllString(line, "")
+	if markdownHeading.MatchString(line) {
+		line = markdownHeading.ReplaceAllString(line, "")
+		line = markdownClosingATX.ReplaceAllString(line, "")
+	}
+	line = markdownImage.ReplaceAllString(line, "$1")
+	line = markdownLink.ReplaceAllString(line, "$1")
+	line = markdownAutoLink.ReplaceAllString(line, "")
+	line = markdownInlineHTML.ReplaceAllString(line, "")
+	line = markdownURL.ReplaceAllString(line, "")
+	line = strings.Replace(line, "|", " ", -1)
+
+	words := strings.Fields(line)
+	for i, w := range words {
+		words[i] = trimEmphasis(w)
+	}
+	line = strings.Join(words, " ")
+	return markdownEscape.ReplaceAllString(line, "$1"), ""
+}
+
+// trimEmphasis removes emphasis characters from the ends of a word, except for
+// escaped ones.
+func trimEmphasis(word string) string {
+	word = strings.TrimLeft(word, markdownEmphasis)
+	for len(word) > 0 && strings.IndexByte(markdownEmphasis, word[len(word)-1]) >= 0 {
+		if len(word) > 1 && word[len(word)-2] == '\\' {
+			break
+		}
+		word = word[:len(word)-1]
+	}
+	return word
+}
@@ -0,0 +1,42 @@
+package extract_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+)
+
+func TestMarkdown(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		doc      string
+		expected []string
+	}{
+		{"Heading", "## Some *heading* ##", []string{"Some", "heading"}},
+		{"Emphasis", "**bold** _it_ ~~gone~~ `code` snake_case", []string{"bold", "it", "gone", "code", "snake_case"}},
+		{"Link", "See [the docs](https://example.com/docs \"Docs\").", []string{"See", "the", "docs."}},
+		{"Reference Link", "See [the docs][1].\n\n[1]: https://example.com/docs", []string{"See", "the", "docs."}},
+		{"Image", "![alt text](image.png)", []string{"alt", "text"}},
+		{"Auto Link", "Go to <https://example.com> or https://example.org/page now", []string{"Go", "to", "or", "now"}},
+		{"Lists", "- one\n* two\n1. three\n- [x] four", []string{"one", "two", "three", "four"}},
+		{"Block Quote", "> > quoted text", []string{"quoted", "text"}},
+		{"Rules", "Title\n=====\n\n---\ntext", []string{"Title", "text"}},
+		{"Table", "| a | b |\n|---|:-:|\n| c | d |", []string{"a", "b", "c", "d"}},
+		{"Code Block", "```go\nfunc *main\n```\nafter", []string{"func", "*main", "after"}},
+		{"Inline HTML", "some <span class=\"x\">html</span>", []string{"some", "html"}},
+		{"Escapes", `\*not emphasis\*`, []string{"*not", "emphasis*"}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			text := extractString(t, extract.Markdown{}, test.doc)
+			assert.Equal(t, test.expected, strings.Fields(text))
+		})
+	}
+}
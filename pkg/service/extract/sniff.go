@@ -0,0 +1,47 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var markdownPattern = regexp.MustCompile("(?m)^(#{1,6} |```|~~~)|\\[[^\\]\n]+\\]\\([^)\n]+\\)")
+
+// Sniff detects the media type of a document from its first bytes.
+//
+// Only formats that can be recognized reliably are detected, anything else is
+// treated as plain text. CSV documents must always be declared.
+func Sniff(prefix []byte) string {
+	trimmed := bytes.TrimLeft(prefix, "\ufeff \t\r\n")
+	if len(trimmed) == 0 {
+		return TypePlain
+	}
+	if (trimmed[0] == '{' || trimmed[0] == '[') && looksLikeJSON(trimmed) {
+		return TypeJSON
+	}
+	if strings.HasPrefix(http.DetectContentType(trimmed), TypeHTML) {
+		return TypeHTML
+	}
+	if markdownPattern.Match(trimmed) {
+		return TypeMarkdown
+	}
+	return TypePlain
+}
+
+// looksLikeJSON checks if prefix is valid JSON or the start of valid JSON.
+func looksLikeJSON(prefix []byte) bool {
+	dec := json.NewDecoder(bytes.NewReader(prefix))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
@@ -1,13 +1,80 @@
 package service
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
+	"hash"
+	"io"
+	"strings"
 )
 
-func createStringSHA256(s string) string {
-	sha := sha256.New()
-	sum := sha.Sum([]byte(s))
-	b64SHA256 := base64.StdEncoding.EncodeToString(sum)
-	return b64SHA256
+// documentIDPrefix starts the IDs of documents with the version of the ID
+// scheme and the hash function, so that the scheme can change without the
+// IDs of different schemes colliding.
+//
+// A v1 ID is the prefix followed by the unpadded URL safe base64 encoding of
+// the SHA-256 hash of the document. The keys of reports add the hash of the
+// analysis options to the ID, see resultKey.
+const documentIDPrefix = "v1:sha256:"
+
+// documentIDOf returns the ID of a document.
+func documentIDOf(document string) string {
+	h := newDocumentHash()
+	_, _ = io.WriteString(h, document)
+	return documentHashID(h)
+}
+
+// newDocumentHash returns a hash for computing the ID of a document that is
+// read incrementally.
+func newDocumentHash() hash.Hash {
+	return sha256.New()
+}
+
+// documentHashID returns the document ID for the data written to h.
+func documentHashID(h hash.Hash) string {
+	return documentIDPrefix + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// maxLegacyDocumentSize is the size of the largest document whose legacy ID
+// is computed from the document itself. Larger documents are not looked up
+// under it, since their key would be larger than the document.
+const maxLegacyDocumentSize = 4 << 10
+
+// legacyDocumentIDs returns the IDs that the document with the given v1 ID
+// had before IDs were versioned, so that reports stored under them can still
+// be read.
+//
+// Streamed documents and archives were identified by the standard base64
+// encoding of their hash. Documents sent as JSON were identified by the
+// encoding of the document followed by the hash of no data, which can only be
+// computed from the document itself, so it is only returned if document is
+// not nil and at most maxLegacyDocumentSize bytes.
+func legacyDocumentIDs(id string, document []byte) []string {
+	var ids []string
+	if sum, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(id, documentIDPrefix)); err == nil && strings.HasPrefix(id, documentIDPrefix) {
+		ids = append(ids, base64.StdEncoding.EncodeToString(sum))
+	}
+	if document != nil && len(document) <= maxLegacyDocumentSize {
+		empty := sha256.Sum256(nil)
+		var b bytes.Buffer
+		b.Grow(len(document) + len(empty))
+		b.Write(document)
+		b.Write(empty[:])
+		legacy := base64.StdEncoding.EncodeToString(b.Bytes())
+		if len(ids) == 0 || ids[0] != legacy {
+			ids = append(ids, legacy)
+		}
+	}
+	return ids
+}
+
+// legacyResultKeys returns the keys that the report for a document analyzed
+// with opts was stored under with each of the legacy IDs of the document.
+func legacyResultKeys(ids []string, opts AnalysisOptions) []string {
+	keys := make([]string, len(ids))
+	for i, legacy := range ids {
+		keys[i] = resultKey(legacy, opts)
+	}
+	return keys
 }
@@ -0,0 +1,159 @@
+package service_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+)
+
+// TestDocumentIDs pins the IDs of documents and the keys of their reports,
+// which must not change without a new version of the ID scheme.
+func TestDocumentIDs(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	apiService := service.NewAPIService(q, kv, channel, l)
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go runWorker(ctx, q, channel, worker)
+
+	tests := []struct {
+		document string
+		options  service.AnalysisOptions
+		id       string
+		key      string
+	}{
+		{
+			document: "",
+			id:       "v1:sha256:47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU",
+			key:      "v1:sha256:47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU",
+		},
+		{
+			document: "This is a test document",
+			id:       "v1:sha256:xBy78sIWGeHVHdcp29ndc2k2cqwONYv82kZ4J7pBvfc",
+			key:      "v1:sha256:xBy78sIWGeHVHdcp29ndc2k2cqwONYv82kZ4J7pBvfc",
+		},
+		{
+			document: "This is a test document",
+			options:  service.AnalysisOptions{ContentType: "text/html; charset=utf-8"},
+			id:       "v1:sha256:xBy78sIWGeHVHdcp29ndc2k2cqwONYv82kZ4J7pBvfc",
+			key:      "v1:sha256:xBy78sIWGeHVHdcp29ndc2k2cqwONYv82kZ4J7pBvfc:EAlk5UtC3_DEgJzo",
+		},
+		{
+			document: "This is a test document",
+			options:  service.AnalysisOptions{Stemmer: "en"},
+			id:       "v1:sha256:xBy78sIWGeHVHdcp29ndc2k2cqwONYv82kZ4J7pBvfc",
+			key:      "v1:sha256:xBy78sIWGeHVHdcp29ndc2k2cqwONYv82kZ4J7pBvfc:-xCfvzVXA5iwgZZ7",
+		},
+	}
+	for _, test := range tests {
+		dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+			Document:        test.document,
+			AnalysisOptions: test.options,
+		})
+		require.NoError(t, err, "Processing document should succeed.")
+		assert.Equal(t, test.id, dfr.DocumentID, "Document ID should not change.")
+		data, err := kv.Retrieve(ctx, test.key)
+		require.NoError(t, err, "Retrieving report should succeed.")
+		assert.NotNil(t, data, "Report should be stored under %s.", test.key)
+	}
+}
+
+func TestLegacyDocumentIDs(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	// No worker runs, so only migrated reports can be returned.
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       l,
+		Channel:   channel,
+		Blobs:     blob.NewStore(kv, blob.Config{}),
+		LegacyIDs: true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	storeLegacy := func(key string, word string) {
+		data, err := json.Marshal(service.DocumentFrequenciesResponse{
+			DocumentID:  key,
+			Frequencies: []service.Frequency{{Word: word, Frequency: 1}},
+		})
+		require.NoError(t, err, "Marshaling report should succeed.")
+		require.NoError(t, kv.Store(ctx, key, data, time.Minute), "Storing legacy report should succeed.")
+	}
+	// Documents sent as JSON were identified by the document followed by the
+	// hash of no data.
+	storeLegacy("VGhpcyBpcyBhIHRlc3QgZG9jdW1lbnTjsMRCmPwcFJr79MiZb7kkJ65B5GSbk0yklZkbeFK4VQ==", "json")
+
+	const id = "v1:sha256:xBy78sIWGeHVHdcp29ndc2k2cqwONYv82kZ4J7pBvfc"
+	dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{Document: "This is a test document"})
+	require.NoError(t, err, "Processing document should succeed.")
+	assert.Equal(t, id, dfr.DocumentID, "Legacy report should have the versioned ID.")
+	assert.Equal(t, "json", dfr.Frequencies[0].Word, "Legacy report should be returned.")
+	data, err := kv.Retrieve(ctx, id)
+	require.NoError(t, err, "Retrieving report should succeed.")
+	assert.Contains(t, string(data), id, "Legacy report should be migrated to the versioned ID.")
+
+	// Streamed documents were identified by the hash of the document.
+	const streamed = "This is a streamed test document"
+	storeLegacy("jvi0SKeFY/u+ZkIy6/JrFqBYHfi9HE5+Fg3R1d952AA=", "stream")
+	dfr, err = apiService.ProcessDocumentStream(ctx, service.DocumentStreamRequest{Body: strings.NewReader(streamed)})
+	require.NoError(t, err, "Processing streamed document should succeed.")
+	assert.Equal(t, "v1:sha256:jvi0SKeFY_u-ZkIy6_JrFqBYHfi9HE5-Fg3R1d952AA", dfr.DocumentID, "Legacy report should have the versioned ID.")
+	assert.Equal(t, "stream", dfr.Frequencies[0].Word, "Legacy report should be returned.")
+
+	// Large documents are not looked up under the document itself.
+	large := strings.Repeat("large ", 1<<10)
+	emptyHash := sha256.Sum256(nil)
+	storeLegacy(base64.StdEncoding.EncodeToString(append([]byte(large), emptyHash[:]...)), "large")
+	lookup, cancelLookup := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelLookup()
+	_, err = apiService.ProcessDocument(lookup, service.DocumentRequest{Document: large})
+	assert.Error(t, err, "Legacy report of a large document should not be returned.")
+}
+
+func TestLegacyDocumentIDsDisabled(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	// No worker runs, so only migrated reports can be returned.
+	apiService := service.NewAPIService(q, kv, channel, l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	data, err := json.Marshal(service.DocumentFrequenciesResponse{
+		Frequencies: []service.Frequency{{Word: "json", Frequency: 1}},
+	})
+	require.NoError(t, err, "Marshaling report should succeed.")
+	require.NoError(t, kv.Store(ctx, "VGhpcyBpcyBhIHRlc3QgZG9jdW1lbnTjsMRCmPwcFJr79MiZb7kkJ65B5GSbk0yklZkbeFK4VQ==", data, time.Minute))
+
+	_, err = apiService.ProcessDocument(ctx, service.DocumentRequest{Document: "This is a test document"})
+	assert.Error(t, err, "Legacy reports should not be looked up unless enabled.")
+}
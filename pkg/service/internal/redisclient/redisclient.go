@@ -0,0 +1,29 @@
+// Package redisclient implements support for the Redis clients that the
+// adapters accept: single nodes and Sentinel failover with a redis.Client, and
+// Redis Cluster with a redis.ClusterClient.
+package redisclient
+
+import (
+	"context"
+
+	"github.com/go-redis/redis"
+)
+
+// WithContext returns c with commands bound to ctx. redis.UniversalClient
+// does not have WithContext, so other clients are returned as they are.
+func WithContext(ctx context.Context, c redis.UniversalClient) redis.UniversalClient {
+	switch c := c.(type) {
+	case *redis.Client:
+		return c.WithContext(ctx)
+	case *redis.ClusterClient:
+		return c.WithContext(ctx)
+	}
+	return c
+}
+
+// IsCluster reports whether c is a Redis Cluster client, whose multi-key
+// commands must only name keys of the same slot.
+func IsCluster(c redis.UniversalClient) bool {
+	_, ok := c.(*redis.ClusterClient)
+	return ok
+}
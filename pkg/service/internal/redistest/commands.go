@@ -0,0 +1,709 @@
+package redistest
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+)
+
+// command is a command that the Server implements.
+type command struct {
+	// minArgs and maxArgs are the number of arguments after the name of the
+	// command. maxArgs is -1 if there is no limit.
+	minArgs, maxArgs int
+	// pubSub is set for the commands that are allowed while subscribed.
+	pubSub bool
+	// transaction is set for the commands that control transactions, which
+	// are run instead of queued in a transaction.
+	transaction bool
+	// run runs the command with s.mu held.
+	run func(s *Server, c *conn, args [][]byte) interface{}
+}
+
+// commands are the commands that the Server implements, by lowercase name.
+var commands = map[string]command{
+	"ping":        {0, 1, true, false, cmdPing},
+	"echo":        {1, 1, false, false, cmdEcho},
+	"auth":        {1, 2, false, false, cmdOK},
+	"select":      {1, 1, false, false, cmdSelect},
+	"flushdb":     {0, 1, false, false, cmdFlush},
+	"flushall":    {0, 1, false, false, cmdFlush},
+	"get":         {1, 1, false, false, cmdGet},
+	"mget":        {1, -1, false, false, cmdMGet},
+	"set":         {2, -1, false, false, cmdSet},
+	"setnx":       {2, 2, false, false, cmdSetNX},
+	"del":         {1, -1, false, false, cmdDel},
+	"exists":      {1, -1, false, false, cmdExists},
+	"type":        {1, 1, false, false, cmdType},
+	"scan":        {1, -1, false, false, cmdScan},
+	"incr":        {1, 1, false, false, cmdIncr},
+	"incrby":      {2, 2, false, false, cmdIncrBy},
+	"expire":      {2, 2, false, false, cmdExpire(time.Second)},
+	"pexpire":     {2, 2, false, false, cmdExpire(time.Millisecond)},
+	"ttl":         {1, 1, false, false, cmdTTL(time.Second)},
+	"pttl":        {1, 1, false, false, cmdTTL(time.Millisecond)},
+	"persist":     {1, 1, false, false, cmdPersist},
+	"rpush":       {2, -1, false, false, cmdPush(false)},
+	"lpush":       {2, -1, false, false, cmdPush(true)},
+	"lpop":        {1, 1, false, false, cmdPop(true)},
+	"rpop":        {1, 1, false, false, cmdPop(false)},
+	"llen":        {1, 1, false, false, cmdLLen},
+	"lrange":      {3, 3, false, false, cmdLRange},
+	"blpop":       {2, -1, false, false, cmdBLPop},
+	"publish":     {2, 2, false, false, cmdPublish},
+	"subscribe":   {1, -1, true, false, cmdSubscribe},
+	"unsubscribe": {0, -1, true, false, cmdUnsubscribe},
+	"watch":       {1, -1, false, true, cmdWatch},
+	"unwatch":     {0, 0, false, true, cmdUnwatch},
+	"multi":       {0, 0, false, true, cmdMulti},
+	"exec":        {0, 0, false, true, cmdExec},
+	"discard":     {0, 0, false, true, cmdDiscard},
+	"cluster":     {1, -1, false, false, cmdCluster},
+}
+
+func init() {
+	// COMMAND describes the other commands, so it is added once they are.
+	commands["command"] = command{0, -1, false, false, cmdCommand}
+}
+
+// multiKey are the commands on several keys, by the number of arguments that
+// follow their keys.
+var multiKey = map[string]int{
+	"mget":   0,
+	"del":    0,
+	"exists": 0,
+	"watch":  0,
+	"blpop":  1,
+}
+
+// crossSlot reports whether a command names keys of different slots, which a
+// cluster rejects.
+func crossSlot(name string, args [][]byte) bool {
+	trailing, ok := multiKey[name]
+	if !ok || len(args)-trailing < 2 {
+		return false
+	}
+	slot := keyspace.Slot(string(args[0]))
+	for _, key := range args[1 : len(args)-trailing] {
+		if keyspace.Slot(string(key)) != slot {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors replied by several commands.
+const (
+	errNotInteger = errorReply("ERR value is not an integer or out of range")
+	errSyntax     = errorReply("ERR syntax error")
+)
+
+func cmdOK(*Server, *conn, [][]byte) interface{} {
+	return statusReply("OK")
+}
+
+func cmdPing(_ *Server, c *conn, args [][]byte) interface{} {
+	if len(c.subscriptions) > 0 {
+		payload := []byte{}
+		if len(args) == 1 {
+			payload = args[0]
+		}
+		return []interface{}{[]byte("pong"), payload}
+	}
+	if len(args) == 1 {
+		return args[0]
+	}
+	return statusReply("PONG")
+}
+
+func cmdEcho(_ *Server, _ *conn, args [][]byte) interface{} {
+	return args[0]
+}
+
+func cmdSelect(_ *Server, _ *conn, args [][]byte) interface{} {
+	if string(args[0]) != "0" {
+		return errorReply("ERR DB index is out of range")
+	}
+	return statusReply("OK")
+}
+
+func cmdFlush(s *Server, _ *conn, _ [][]byte) interface{} {
+	s.flush()
+	return statusReply("OK")
+}
+
+func cmdGet(s *Server, _ *conn, args [][]byte) interface{} {
+	i := s.get(string(args[0]))
+	switch {
+	case i == nil:
+		return nilReply{}
+	case i.isList():
+		return errWrongType
+	}
+	return i.str
+}
+
+// cmdMGet replies with the values of keys, with nil for keys that are
+// missing or hold lists.
+func cmdMGet(s *Server, _ *conn, args [][]byte) interface{} {
+	values := make([]interface{}, len(args))
+	for n, key := range args {
+		i := s.get(string(key))
+		if i == nil || i.isList() {
+			values[n] = nilReply{}
+			continue
+		}
+		values[n] = i.str
+	}
+	return values
+}
+
+func cmdSet(s *Server, _ *conn, args [][]byte) interface{} {
+	key, value := string(args[0]), args[1]
+	var expiration time.Duration
+	var nx, xx bool
+	for opts := args[2:]; len(opts) > 0; opts = opts[1:] {
+		switch strings.ToLower(string(opts[0])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if len(opts) < 2 {
+				return errSyntax
+			}
+			n, err := strconv.ParseInt(string(opts[1]), 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			if n <= 0 {
+				return errorReply("ERR invalid expire time in set")
+			}
+			unit := time.Second
+			if strings.ToLower(string(opts[0])) == "px" {
+				unit = time.Millisecond
+			}
+			expiration = time.Duration(n) * unit
+			opts = opts[1:]
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+
+	exists := s.get(key) != nil
+	if (nx && exists) || (xx && !exists) {
+		return nilReply{}
+	}
+	i := &item{str: append([]byte{}, value...)}
+	if expiration > 0 {
+		i.deadline = time.Now().Add(expiration)
+	}
+	s.keys[key] = i
+	s.touch(key)
+	return statusReply("OK")
+}
+
+func cmdSetNX(s *Server, c *conn, args [][]byte) interface{} {
+	if _, ok := cmdSet(s, c, [][]byte{args[0], args[1], []byte("nx")}).(nilReply); ok {
+		return int64(0)
+	}
+	return int64(1)
+}
+
+func cmdDel(s *Server, _ *conn, args [][]byte) interface{} {
+	var n int64
+	for _, key := range args {
+		if s.get(string(key)) != nil {
+			delete(s.keys, string(key))
+			s.touch(string(key))
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Server, _ *conn, args [][]byte) interface{} {
+	var n int64
+	for _, key := range args {
+		if s.get(string(key)) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdType(s *Server, _ *conn, args [][]byte) interface{} {
+	i := s.get(string(args[0]))
+	switch {
+	case i == nil:
+		return statusReply("none")
+	case i.isList():
+		return statusReply("list")
+	}
+	return statusReply("string")
+}
+
+// cmdScan replies with every key matching the MATCH pattern at once, which
+// Redis allows since COUNT is only a hint. The cursor that it returns is
+// always 0, so keys that are deleted during a scan can't make it skip others.
+func cmdScan(s *Server, _ *conn, args [][]byte) interface{} {
+	if _, err := strconv.ParseUint(string(args[0]), 10, 64); err != nil {
+		return errorReply("ERR invalid cursor")
+	}
+	pattern := "*"
+	for opts := args[1:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			return errSyntax
+		}
+		switch strings.ToLower(string(opts[0])) {
+		case "match":
+			pattern = string(opts[1])
+		case "count":
+			if n, err := strconv.Atoi(string(opts[1])); err != nil || n < 1 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+	var keys []string
+	for key := range s.keys {
+		if s.get(key) != nil && matchGlob(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	replies := make([]interface{}, len(keys))
+	for n, key := range keys {
+		replies[n] = []byte(key)
+	}
+	return []interface{}{[]byte("0"), replies}
+}
+
+// matchGlob reports whether s matches the glob pattern. It supports the '*'
+// and '?' wildcards and backslash escapes of Redis patterns, but not
+// character classes.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+func cmdIncr(s *Server, c *conn, args [][]byte) interface{} {
+	return cmdIncrBy(s, c, [][]byte{args[0], []byte("1")})
+}
+
+func cmdIncrBy(s *Server, _ *conn, args [][]byte) interface{} {
+	key := string(args[0])
+	by, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	i := s.get(key)
+	if i == nil {
+		i = &item{str: []byte("0")}
+		s.keys[key] = i
+	}
+	if i.isList() {
+		return errWrongType
+	}
+	n, err := strconv.ParseInt(string(i.str), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	// The expiration of the key is kept.
+	n += by
+	i.str = []byte(strconv.FormatInt(n, 10))
+	s.touch(key)
+	return n
+}
+
+func cmdExpire(unit time.Duration) func(*Server, *conn, [][]byte) interface{} {
+	return func(s *Server, _ *conn, args [][]byte) interface{} {
+		key := string(args[0])
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return errNotInteger
+		}
+		i := s.get(key)
+		if i == nil {
+			return int64(0)
+		}
+		if n <= 0 {
+			delete(s.keys, key)
+		} else {
+			i.deadline = time.Now().Add(time.Duration(n) * unit)
+		}
+		s.touch(key)
+		return int64(1)
+	}
+}
+
+func cmdTTL(unit time.Duration) func(*Server, *conn, [][]byte) interface{} {
+	return func(s *Server, _ *conn, args [][]byte) interface{} {
+		i := s.get(string(args[0]))
+		switch {
+		case i == nil:
+			return int64(-2)
+		case i.deadline.IsZero():
+			return int64(-1)
+		}
+		// Round like Redis does.
+		return int64((time.Until(i.deadline) + unit/2) / unit)
+	}
+}
+
+func cmdPersist(s *Server, _ *conn, args [][]byte) interface{} {
+	i := s.get(string(args[0]))
+	if i == nil || i.deadline.IsZero() {
+		return int64(0)
+	}
+	i.deadline = time.Time{}
+	s.touch(string(args[0]))
+	return int64(1)
+}
+
+func cmdPush(left bool) func(*Server, *conn, [][]byte) interface{} {
+	return func(s *Server, _ *conn, args [][]byte) interface{} {
+		key := string(args[0])
+		i := s.get(key)
+		if i == nil {
+			i = &item{list: make([][]byte, 0, len(args)-1)}
+			s.keys[key] = i
+		}
+		if !i.isList() {
+			return errWrongType
+		}
+		for _, v := range args[1:] {
+			v = append([]byte{}, v...)
+			if left {
+				i.list = append([][]byte{v}, i.list...)
+			} else {
+				i.list = append(i.list, v)
+			}
+		}
+		s.touch(key)
+		s.wake()
+		return int64(len(i.list))
+	}
+}
+
+// pop removes an element from the list at key, or returns nil if there is no
+// list. s.mu must be held.
+func (s *Server) pop(key string, left bool) ([]byte, interface{}) {
+	i := s.get(key)
+	if i == nil {
+		return nil, nil
+	}
+	if !i.isList() {
+		return nil, errWrongType
+	}
+	var v []byte
+	if left {
+		v, i.list = i.list[0], i.list[1:]
+	} else {
+		v, i.list = i.list[len(i.list)-1], i.list[:len(i.list)-1]
+	}
+	if len(i.list) == 0 {
+		delete(s.keys, key)
+	}
+	s.touch(key)
+	return v, nil
+}
+
+func cmdPop(left bool) func(*Server, *conn, [][]byte) interface{} {
+	return func(s *Server, _ *conn, args [][]byte) interface{} {
+		v, errReply := s.pop(string(args[0]), left)
+		switch {
+		case errReply != nil:
+			return errReply
+		case v == nil:
+			return nilReply{}
+		}
+		return v
+	}
+}
+
+func cmdLLen(s *Server, _ *conn, args [][]byte) interface{} {
+	i := s.get(string(args[0]))
+	switch {
+	case i == nil:
+		return int64(0)
+	case !i.isList():
+		return errWrongType
+	}
+	return int64(len(i.list))
+}
+
+func cmdLRange(s *Server, _ *conn, args [][]byte) interface{} {
+	start, err1 := strconv.Atoi(string(args[1]))
+	stop, err2 := strconv.Atoi(string(args[2]))
+	if err1 != nil || err2 != nil {
+		return errNotInteger
+	}
+	i := s.get(string(args[0]))
+	if i == nil {
+		return []interface{}{}
+	}
+	if !i.isList() {
+		return errWrongType
+	}
+	n := len(i.list)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	values := []interface{}{}
+	for j := start; j <= stop; j++ {
+		values = append(values, i.list[j])
+	}
+	return values
+}
+
+// cmdBLPop pops from the first of the lists that is not empty, waiting until
+// one is pushed to or the timeout, in seconds, passes. A timeout of 0 waits
+// forever. It does not wait in a transaction, like in Redis.
+func cmdBLPop(s *Server, c *conn, args [][]byte) interface{} {
+	keys := args[:len(args)-1]
+	seconds, err := strconv.ParseFloat(string(args[len(args)-1]), 64)
+	if err != nil {
+		return errorReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return errorReply("ERR timeout is negative")
+	}
+	var timeout <-chan time.Time
+	if seconds > 0 {
+		timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		for _, key := range keys {
+			v, errReply := s.pop(string(key), true)
+			if errReply != nil {
+				return errReply
+			}
+			if v != nil {
+				return []interface{}{key, v}
+			}
+		}
+		if c.multi {
+			return nilArrayReply{}
+		}
+		pushed := s.pushed
+		s.mu.Unlock()
+
+		woken := false
+		select {
+		case <-pushed:
+			woken = true
+		case <-timeout:
+		case <-s.done:
+		}
+		s.mu.Lock()
+		if !woken {
+			return nilArrayReply{}
+		}
+	}
+}
+
+func cmdPublish(s *Server, _ *conn, args [][]byte) interface{} {
+	channel := string(args[0])
+	message := []interface{}{[]byte("message"), args[0], args[1]}
+	var subscribers []*conn
+	for c := range s.subscribers[channel] {
+		subscribers = append(subscribers, c)
+	}
+	// The lock is not held while writing to subscribers, which may be slow
+	// to read.
+	s.mu.Unlock()
+	defer s.mu.Lock()
+	for _, c := range subscribers {
+		// A subscriber that can't be written to is closing.
+		_ = c.reply(message)
+	}
+	return int64(len(subscribers))
+}
+
+func cmdSubscribe(s *Server, c *conn, args [][]byte) interface{} {
+	var replies multiReply
+	for _, channel := range args {
+		if s.subscribers[string(channel)] == nil {
+			s.subscribers[string(channel)] = make(map[*conn]struct{})
+		}
+		s.subscribers[string(channel)][c] = struct{}{}
+		c.subscriptions[string(channel)] = struct{}{}
+		replies = append(replies, []interface{}{[]byte("subscribe"), channel, int64(len(c.subscriptions))})
+	}
+	return replies
+}
+
+func cmdUnsubscribe(s *Server, c *conn, args [][]byte) interface{} {
+	var channels []string
+	for _, channel := range args {
+		channels = append(channels, string(channel))
+	}
+	if len(channels) == 0 {
+		channels = s.unsubscribe(c, nil)
+		if len(channels) == 0 {
+			return []interface{}{[]byte("unsubscribe"), nilReply{}, int64(0)}
+		}
+		// Reply with the number of subscriptions left after each channel.
+		for _, channel := range channels {
+			c.subscriptions[channel] = struct{}{}
+		}
+	}
+	var replies multiReply
+	for _, channel := range channels {
+		s.unsubscribe(c, []string{channel})
+		replies = append(replies, []interface{}{[]byte("unsubscribe"), []byte(channel), int64(len(c.subscriptions))})
+	}
+	return replies
+}
+
+func cmdWatch(s *Server, c *conn, args [][]byte) interface{} {
+	if c.multi {
+		return errorReply("ERR WATCH inside MULTI is not allowed")
+	}
+	for _, key := range args {
+		key := string(key)
+		if s.watchers[key] == nil {
+			s.watchers[key] = make(map[*conn]struct{})
+		}
+		s.watchers[key][c] = struct{}{}
+		c.watching[key] = struct{}{}
+	}
+	return statusReply("OK")
+}
+
+func cmdUnwatch(s *Server, c *conn, _ [][]byte) interface{} {
+	s.unwatch(c)
+	return statusReply("OK")
+}
+
+func cmdMulti(_ *Server, c *conn, _ [][]byte) interface{} {
+	if c.multi {
+		return errorReply("ERR MULTI calls can not be nested")
+	}
+	c.multi = true
+	return statusReply("OK")
+}
+
+// cmdExec runs the queued commands of a transaction, unless a watched key
+// changed since it was watched.
+func cmdExec(s *Server, c *conn, _ [][]byte) interface{} {
+	if !c.multi {
+		return errorReply("ERR EXEC without MULTI")
+	}
+	defer s.discard(c)
+	switch {
+	case c.aborted:
+		return errorReply("EXECABORT Transaction discarded because of previous errors.")
+	case c.dirty:
+		return nilArrayReply{}
+	}
+	replies := []interface{}{}
+	for _, q := range c.queued {
+		replies = append(replies, q.cmd.run(s, c, q.args))
+	}
+	return replies
+}
+
+func cmdDiscard(s *Server, c *conn, _ [][]byte) interface{} {
+	if !c.multi {
+		return errorReply("ERR DISCARD without MULTI")
+	}
+	s.discard(c)
+	return statusReply("OK")
+}
+
+// cmdCluster implements CLUSTER SLOTS, which cluster clients find the nodes
+// serving each slot with. The node of a Server serves every slot.
+func cmdCluster(s *Server, _ *conn, args [][]byte) interface{} {
+	if !s.cluster {
+		return errorReply("ERR This instance has cluster support disabled")
+	}
+	if strings.ToLower(string(args[0])) != "slots" {
+		return errorReply("ERR unknown subcommand '" + string(args[0]) + "'")
+	}
+	host, port, err := net.SplitHostPort(s.Addr())
+	if err != nil {
+		return errorReply("ERR " + err.Error())
+	}
+	p, _ := strconv.ParseInt(port, 10, 64)
+	node := []interface{}{[]byte(host), p}
+	return []interface{}{[]interface{}{int64(0), int64(keyspace.Slots - 1), node}}
+}
+
+// keyless are the commands that take no keys.
+var keyless = map[string]bool{
+	"ping": true, "echo": true, "auth": true, "select": true, "flushdb": true, "flushall": true,
+	"scan": true, "publish": true, "subscribe": true, "unsubscribe": true, "unwatch": true,
+	"multi": true, "exec": true, "discard": true, "cluster": true, "command": true,
+}
+
+// cmdCommand replies with the arity and the positions of the keys of every
+// command, which cluster clients route commands by.
+func cmdCommand(*Server, *conn, [][]byte) interface{} {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	infos := make([]interface{}, len(names))
+	for i, name := range names {
+		cmd := commands[name]
+		arity := int64(cmd.minArgs + 1)
+		if cmd.maxArgs != cmd.minArgs {
+			arity = -arity
+		}
+		var first, last, step int64
+		if !keyless[name] {
+			first, last, step = 1, 1, 1
+			if trailing, ok := multiKey[name]; ok {
+				last = int64(-1 - trailing)
+			}
+		}
+		infos[i] = []interface{}{[]byte(name), arity, []interface{}{}, first, last, step}
+	}
+	return infos
+}
@@ -1,8 +1,12 @@
 // Package redistest implements support code for testing with Redis.
+//
+// Tests run against the Redis server at REDIS_IP or REDIS_ADDRESS if either is
+// set, and against an in-process Server otherwise.
 package redistest
 
 import (
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,6 +25,9 @@ func GetCredentials() (rc RedisCredentials, ok bool) {
 	u := os.Getenv("REDIS_USER")
 	p := os.Getenv("REDIS_PASS")
 	i := os.Getenv("REDIS_IP")
+	if i == "" {
+		i = os.Getenv("REDIS_ADDRESS")
+	}
 	if len(i) > 0 {
 		return RedisCredentials{
 			Username: u,
@@ -31,11 +38,36 @@ func GetCredentials() (rc RedisCredentials, ok bool) {
 	return RedisCredentials{}, false
 }
 
+var (
+	serverOnce sync.Once
+	server     *Server
+	serverErr  error
+
+	clusterOnce sync.Once
+	cluster     *Server
+	clusterErr  error
+)
+
+// sharedServer returns the Server that is shared by the tests of a package
+// when there are no Redis credentials. It runs until the tests exit.
+func sharedServer(t testing.TB) *Server {
+	serverOnce.Do(func() {
+		server, serverErr = NewServer()
+	})
+	if serverErr != nil {
+		t.Fatalf("Unable to start Redis stand-in: %v", serverErr)
+	}
+	return server
+}
+
 // Connect connects to Redis and returns the Client object.
-func Connect(t *testing.T) *redis.Client {
+//
+// Without Redis credentials, it connects to an in-process Server instead.
+// Tests share the server, so they must use keys of their own.
+func Connect(t testing.TB) *redis.Client {
 	creds, ok := GetCredentials()
 	if !ok {
-		t.Skip("Missing Redis credentials")
+		creds = RedisCredentials{IP: sharedServer(t).Addr()}
 	}
 
 	client := redis.NewClient(&redis.Options{
@@ -49,3 +81,26 @@ func Connect(t *testing.T) *redis.Client {
 	})
 	return client
 }
+
+// ConnectCluster connects to an in-process Server in cluster mode and returns
+// the ClusterClient object. Tests share the server, so they must use keys of
+// their own.
+func ConnectCluster(t testing.TB) *redis.ClusterClient {
+	clusterOnce.Do(func() {
+		cluster, clusterErr = NewClusterServer()
+	})
+	if clusterErr != nil {
+		t.Fatalf("Unable to start Redis Cluster stand-in: %v", clusterErr)
+	}
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{cluster.Addr()},
+		// The pool of cluster clients is half as large as that of other
+		// clients by default, which blocking pulls of concurrent tests use
+		// up on a single CPU.
+		PoolSize:     20,
+		MaxRetries:   3,
+		DialTimeout:  10 * time.Second,
+		ReadTimeout:  2 * time.Second,
+		WriteTimeout: 2 * time.Second,
+	})
+}
@@ -0,0 +1,399 @@
+package redistest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Server is an in-process stand-in for Redis, so that the Redis adapters can
+// be tested without a Redis server.
+//
+// It speaks RESP over TCP and implements the commands that the adapters use,
+// with the replies and errors of Redis. It keeps a single database in memory.
+// Commands that it does not know are rejected, so a new command used by an
+// adapter must be added to commands.
+//
+// A Server started by NewClusterServer is a Redis Cluster of a single node
+// serving every slot, which rejects commands on keys of different slots like
+// a cluster of several nodes would.
+type Server struct {
+	l       net.Listener
+	done    chan struct{}
+	wg      sync.WaitGroup
+	cluster bool
+
+	mu    sync.Mutex
+	keys  map[string]*item
+	conns map[*conn]struct{}
+	// subscribers are the connections subscribed to each channel.
+	subscribers map[string]map[*conn]struct{}
+	// watchers are the connections watching each key.
+	watchers map[string]map[*conn]struct{}
+	// pushed is closed and replaced whenever a list is pushed to, to wake
+	// blocked pops.
+	pushed chan struct{}
+}
+
+// item is the value of a key.
+type item struct {
+	str []byte
+	// list is set for lists, which are removed when they are empty.
+	list [][]byte
+	// deadline is when the key expires, or zero if it does not.
+	deadline time.Time
+}
+
+func (i *item) isList() bool {
+	return i.list != nil
+}
+
+// NewServer starts a Server on a random local port.
+func NewServer() (*Server, error) {
+	return newServer(false)
+}
+
+// NewClusterServer starts a Server in cluster mode on a random local port.
+func NewClusterServer() (*Server, error) {
+	return newServer(true)
+}
+
+func newServer(cluster bool) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to listen")
+	}
+	s := &Server{
+		l:           l,
+		done:        make(chan struct{}),
+		cluster:     cluster,
+		keys:        make(map[string]*item),
+		conns:       make(map[*conn]struct{}),
+		subscribers: make(map[string]map[*conn]struct{}),
+		watchers:    make(map[string]map[*conn]struct{}),
+		pushed:      make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address that the server listens on.
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() error {
+	err := s.l.Close()
+	close(s.done)
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return errors.Wrap(err, "unable to close listener")
+}
+
+// FlushAll deletes every key.
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+}
+
+// flush deletes every key. s.mu must be held.
+func (s *Server) flush() {
+	s.keys = make(map[string]*item)
+	for _, watchers := range s.watchers {
+		for c := range watchers {
+			c.dirty = true
+		}
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{
+			nc:            nc,
+			r:             bufio.NewReader(nc),
+			w:             bufio.NewWriter(nc),
+			subscriptions: make(map[string]struct{}),
+			watching:      make(map[string]struct{}),
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// conn is a client connection.
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+
+	// wmu guards w, since messages are published to the connection by
+	// the connections of publishers.
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	// The remaining fields are guarded by the server lock.
+
+	subscriptions map[string]struct{}
+	// watching are the watched keys, and dirty is set when one of them
+	// changes.
+	watching map[string]struct{}
+	dirty    bool
+	// multi is set in a transaction, whose commands are queued until EXEC.
+	// aborted is set if a command could not be queued.
+	multi   bool
+	aborted bool
+	queued  []queuedCommand
+}
+
+// queuedCommand is a command queued in a transaction.
+type queuedCommand struct {
+	cmd  command
+	args [][]byte
+}
+
+func (s *Server) handle(c *conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		s.unsubscribe(c, nil)
+		s.unwatch(c)
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = c.nc.Close()
+	}()
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+			_ = c.reply(errorReply("ERR Protocol error: " + err.Error()))
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToLower(string(args[0]))
+		if name == "quit" {
+			_ = c.reply(statusReply("OK"))
+			return
+		}
+		if err := c.reply(s.execute(c, name, args[1:])); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a command and returns its reply.
+func (s *Server) execute(c *conn, name string, args [][]byte) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd, ok := commands[name]
+	if !ok {
+		c.aborted = c.multi
+		return errorReply("ERR unknown command '" + name + "'")
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		c.aborted = c.multi
+		return errorReply("ERR wrong number of arguments for '" + name + "' command")
+	}
+	if s.cluster && crossSlot(name, args) {
+		c.aborted = c.multi
+		return errorReply("CROSSSLOT Keys in request don't hash to the same slot")
+	}
+	if len(c.subscriptions) > 0 && !cmd.pubSub {
+		return errorReply("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+	}
+	if c.multi && !cmd.transaction {
+		c.queued = append(c.queued, queuedCommand{cmd: cmd, args: args})
+		return statusReply("QUEUED")
+	}
+	return cmd.run(s, c, args)
+}
+
+// Replies other than these types are written as follows: int64 as an integer,
+// []byte as a bulk string and []interface{} as an array.
+type (
+	statusReply string
+	errorReply  string
+	// nilReply is the null bulk string.
+	nilReply struct{}
+	// nilArrayReply is the null array, which blocking pops reply with when
+	// they time out.
+	nilArrayReply struct{}
+	// multiReply is several replies, such as the confirmations of a
+	// subscription to several channels.
+	multiReply []interface{}
+)
+
+// errWrongType is the reply to commands on keys of the wrong type.
+const errWrongType = errorReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+func (c *conn) reply(r interface{}) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if m, ok := r.(multiReply); ok {
+		for _, r := range m {
+			writeReply(c.w, r)
+		}
+	} else {
+		writeReply(c.w, r)
+	}
+	return c.w.Flush()
+}
+
+func writeReply(w *bufio.Writer, r interface{}) {
+	switch r := r.(type) {
+	case statusReply:
+		_, _ = w.WriteString("+" + string(r) + "\r\n")
+	case errorReply:
+		_, _ = w.WriteString("-" + string(r) + "\r\n")
+	case int64:
+		_, _ = w.WriteString(":" + strconv.FormatInt(r, 10) + "\r\n")
+	case nilReply:
+		_, _ = w.WriteString("$-1\r\n")
+	case nilArrayReply:
+		_, _ = w.WriteString("*-1\r\n")
+	case []byte:
+		_, _ = w.WriteString("$" + strconv.Itoa(len(r)) + "\r\n")
+		_, _ = w.Write(r)
+		_, _ = w.WriteString("\r\n")
+	case []interface{}:
+		_, _ = w.WriteString("*" + strconv.Itoa(len(r)) + "\r\n")
+		for _, v := range r {
+			writeReply(w, v)
+		}
+	default:
+		panic(errors.Errorf("unknown reply type %T", r))
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, errors.Errorf("expected '*', got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, errors.New("invalid multibulk length")
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.Errorf("expected '$', got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.New("invalid bulk length")
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// get returns the live item for key. s.mu must be held.
+func (s *Server) get(key string) *item {
+	i, ok := s.keys[key]
+	if !ok {
+		return nil
+	}
+	if !i.deadline.IsZero() && !time.Now().Before(i.deadline) {
+		delete(s.keys, key)
+		return nil
+	}
+	return i
+}
+
+// unsubscribe unsubscribes c from channels, or from every channel if channels
+// is nil, returning the unsubscribed channels. s.mu must be held.
+func (s *Server) unsubscribe(c *conn, channels []string) []string {
+	if channels == nil {
+		for channel := range c.subscriptions {
+			channels = append(channels, channel)
+		}
+	}
+	for _, channel := range channels {
+		delete(c.subscriptions, channel)
+		delete(s.subscribers[channel], c)
+		if len(s.subscribers[channel]) == 0 {
+			delete(s.subscribers, channel)
+		}
+	}
+	return channels
+}
+
+// touch marks the connections watching key as dirty. It is called by every
+// command that changes a key. s.mu must be held.
+func (s *Server) touch(key string) {
+	for c := range s.watchers[key] {
+		c.dirty = true
+	}
+}
+
+// unwatch stops c from watching keys. s.mu must be held.
+func (s *Server) unwatch(c *conn) {
+	for key := range c.watching {
+		delete(s.watchers[key], c)
+		if len(s.watchers[key]) == 0 {
+			delete(s.watchers, key)
+		}
+	}
+	c.watching = make(map[string]struct{})
+	c.dirty = false
+}
+
+// discard ends the transaction of c, which also unwatches its keys. s.mu must
+// be held.
+func (s *Server) discard(c *conn) {
+	c.multi, c.aborted, c.queued = false, false, nil
+	s.unwatch(c)
+}
+
+// wake wakes the blocked pops. s.mu must be held.
+func (s *Server) wake() {
+	close(s.pushed)
+	s.pushed = make(chan struct{})
+}
@@ -0,0 +1,210 @@
+package redistest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redistest"
+)
+
+func newClient(t *testing.T) (*redis.Client, func()) {
+	s, err := redistest.NewServer()
+	require.NoError(t, err, "Starting server should succeed.")
+	c := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	return c, func() {
+		_ = c.Close()
+		_ = s.Close()
+	}
+}
+
+func TestServerStrings(t *testing.T) {
+	t.Parallel()
+	c, cleanup := newClient(t)
+	defer cleanup()
+
+	require.NoError(t, c.Ping().Err(), "Ping should succeed.")
+	_, err := c.Get("missing").Result()
+	assert.Equal(t, redis.Nil, err, "Missing keys should be nil.")
+
+	ok, err := c.SetNX("key", "a", 0).Result()
+	require.NoError(t, err)
+	assert.True(t, ok, "SET NX should set missing keys.")
+	ok, _ = c.SetNX("key", "b", 0).Result()
+	assert.False(t, ok, "SET NX should not replace keys.")
+	ok, _ = c.SetXX("other", "b", 0).Result()
+	assert.False(t, ok, "SET XX should not set missing keys.")
+	v, _ := c.Get("key").Result()
+	assert.Equal(t, "a", v)
+	require.NoError(t, c.RPush("list", "a").Err())
+	values, err := c.MGet("key", "missing", "list").Result()
+	require.NoError(t, err, "MGET should succeed.")
+	assert.Equal(t, []interface{}{"a", nil, nil}, values, "Missing keys and lists should be nil.")
+
+	require.NoError(t, c.Set("key", "b", 1500*time.Millisecond).Err())
+	ttl, err := c.PTTL("key").Result()
+	require.NoError(t, err)
+	assert.InDelta(t, float64(1500*time.Millisecond), float64(ttl), float64(100*time.Millisecond), "Key should expire.")
+	assert.True(t, c.Persist("key").Val(), "Persisting should remove the expiration.")
+	assert.Equal(t, -time.Second, c.TTL("key").Val(), "Persisted key should not expire.")
+
+	assert.Error(t, c.Incr("key").Err(), "Values that are not numbers should not be incremented.")
+	assert.Equal(t, int64(5), c.IncrBy("counter", 5).Val())
+	assert.Equal(t, int64(2), c.Del("key", "counter", "missing").Val(), "Only existing keys should be counted.")
+}
+
+func TestServerScan(t *testing.T) {
+	t.Parallel()
+	c, cleanup := newClient(t)
+	defer cleanup()
+
+	require.NoError(t, c.Set("a:1", "x", 0).Err())
+	require.NoError(t, c.Set("a:2", "x", 0).Err())
+	require.NoError(t, c.Set("a*:1", "x", 0).Err())
+	require.NoError(t, c.RPush("a:queue", "x").Err())
+	require.NoError(t, c.Set("b:1", "x", 0).Err())
+
+	keys, cursor, err := c.Scan(0, "a:*", 10).Result()
+	require.NoError(t, err, "SCAN should succeed.")
+	assert.Equal(t, uint64(0), cursor, "Every key should be returned at once.")
+	assert.Equal(t, []string{"a:1", "a:2", "a:queue"}, keys, "Only matching keys should be returned.")
+	keys, _, _ = c.Scan(0, `a\*:?`, 0).Result()
+	assert.Equal(t, []string{"a*:1"}, keys, "Escaped wildcards should match themselves.")
+
+	assert.Equal(t, "string", c.Type("a:1").Val())
+	assert.Equal(t, "list", c.Type("a:queue").Val())
+	assert.Equal(t, "none", c.Type("missing").Val())
+}
+
+func TestServerLists(t *testing.T) {
+	t.Parallel()
+	c, cleanup := newClient(t)
+	defer cleanup()
+
+	require.NoError(t, c.RPush("list", "b", "c").Err())
+	require.NoError(t, c.LPush("list", "a").Err())
+	assert.Equal(t, []string{"a", "b", "c"}, c.LRange("list", 0, -1).Val())
+	assert.Equal(t, int64(3), c.LLen("list").Val())
+	assert.Equal(t, "c", c.RPop("list").Val())
+	assert.Equal(t, []string{"list", "a"}, c.BLPop(time.Second, "empty", "list").Val(), "BLPOP should pop from the first list that has elements.")
+
+	err := c.Get("list").Err()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "WRONGTYPE", "Lists should not be read as strings.")
+
+	require.NoError(t, c.LPop("list").Err())
+	assert.Equal(t, int64(0), c.Exists("list").Val(), "Empty lists should be removed.")
+
+	start := time.Now()
+	assert.Equal(t, redis.Nil, c.BLPop(time.Second, "list").Err(), "BLPOP should time out.")
+	assert.True(t, time.Since(start) >= time.Second, "BLPOP should wait for the timeout.")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = c.RPush("list", "late").Err()
+	}()
+	assert.Equal(t, []string{"list", "late"}, c.BLPop(0, "list").Val(), "BLPOP should wait for a push.")
+
+	assert.Error(t, c.Do("nosuchcommand").Err(), "Unknown commands should be rejected.")
+}
+
+func TestServerPubSub(t *testing.T) {
+	t.Parallel()
+	c, cleanup := newClient(t)
+	defer cleanup()
+
+	ps := c.Subscribe("a", "b")
+	defer func() {
+		_ = ps.Close()
+	}()
+	for i := 0; i < 2; i++ {
+		_, err := ps.ReceiveTimeout(time.Second)
+		require.NoError(t, err, "Subscription should be confirmed.")
+	}
+	require.NoError(t, ps.Ping("hello"), "Ping should succeed while subscribed.")
+	msg, err := ps.ReceiveTimeout(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, &redis.Pong{Payload: "hello"}, msg)
+
+	assert.Equal(t, int64(1), c.Publish("b", "message").Val(), "Message should be published to the subscriber.")
+	msg, err = ps.ReceiveTimeout(time.Second)
+	require.NoError(t, err)
+	require.IsType(t, &redis.Message{}, msg)
+	assert.Equal(t, "message", msg.(*redis.Message).Payload)
+
+	require.NoError(t, ps.Unsubscribe("b"))
+	_, err = ps.ReceiveTimeout(time.Second)
+	require.NoError(t, err, "Unsubscription should be confirmed.")
+	assert.Equal(t, int64(0), c.Publish("b", "message").Val(), "Unsubscribed channels should not receive messages.")
+}
+
+func TestServerTransactions(t *testing.T) {
+	t.Parallel()
+	c, cleanup := newClient(t)
+	defer cleanup()
+
+	cmds, err := c.TxPipelined(func(p redis.Pipeliner) error {
+		p.Set("key", "a", 0)
+		p.Incr("counter")
+		return nil
+	})
+	require.NoError(t, err, "Transaction should succeed.")
+	require.Len(t, cmds, 2)
+	assert.Equal(t, int64(1), cmds[1].(*redis.IntCmd).Val(), "Replies should be returned for each command.")
+
+	err = c.Watch(func(tx *redis.Tx) error {
+		require.NoError(t, tx.Get("key").Err())
+		_, err := tx.Pipelined(func(p redis.Pipeliner) error {
+			p.Set("key", "b", 0)
+			return nil
+		})
+		return err
+	}, "key")
+	require.NoError(t, err, "Transaction on unchanged watched key should succeed.")
+	assert.Equal(t, "b", c.Get("key").Val())
+
+	err = c.Watch(func(tx *redis.Tx) error {
+		require.NoError(t, c.Set("key", "changed", 0).Err())
+		_, err := tx.Pipelined(func(p redis.Pipeliner) error {
+			p.Set("key", "c", 0)
+			return nil
+		})
+		return err
+	}, "key")
+	assert.Equal(t, redis.TxFailedErr, err, "Transaction on changed watched key should fail.")
+	assert.Equal(t, "changed", c.Get("key").Val(), "Failed transaction should not write.")
+
+	_, err = c.TxPipelined(func(p redis.Pipeliner) error {
+		p.Do("nosuchcommand")
+		return nil
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "EXECABORT", "Transactions with unknown commands should be discarded.")
+}
+
+func TestServerCluster(t *testing.T) {
+	t.Parallel()
+	s, err := redistest.NewClusterServer()
+	require.NoError(t, err, "Starting server should succeed.")
+	defer s.Close()
+	c := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{s.Addr()}})
+	defer c.Close()
+
+	require.NoError(t, c.Ping().Err(), "Ping should succeed.")
+	require.NoError(t, c.Set("{user}:a", "a", 0).Err())
+	require.NoError(t, c.Set("{user}:b", "b", 0).Err())
+	values, err := c.MGet("{user}:a", "{user}:b").Result()
+	require.NoError(t, err, "MGET of keys of one slot should succeed.")
+	assert.Equal(t, []interface{}{"a", "b"}, values)
+	_, err = c.MGet("foo", "bar").Result()
+	assert.Error(t, err, "MGET of keys of different slots should fail.")
+	assert.Error(t, c.Del("foo", "bar").Err(), "DEL of keys of different slots should fail.")
+
+	plain, cleanup := newClient(t)
+	defer cleanup()
+	assert.Error(t, plain.ClusterSlots().Err(), "Servers should not be clusters by default.")
+	assert.NoError(t, plain.MGet("foo", "bar").Err(), "Servers should not check slots by default.")
+}
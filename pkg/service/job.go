@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// ErrJobFailed is returned when the worker analyzing a document failed.
+var ErrJobFailed = errors.New("job failed")
+
+// JobState is the state of the job that produces the report stored under a
+// key.
+type JobState string
+
+// States of jobs.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// jobTransitions are the states that a job may change to from each state. A
+// job without a state has never run, or ran long enough ago that its state
+// expired.
+//
+// Jobs are run by the worker that moves them to JobRunning, so a job is never
+// run by two workers at once, and requests for a job that is queued are not
+// queued again. Finished jobs may run again, such as once their report
+// expires.
+var jobTransitions = map[JobState][]JobState{
+	"":           {JobQueued, JobRunning},
+	JobQueued:    {JobRunning, JobFailed},
+	JobRunning:   {JobSucceeded, JobFailed},
+	JobSucceeded: {JobQueued, JobRunning},
+	JobFailed:    {JobQueued, JobRunning},
+}
+
+// jobTimeout is how long a job may be queued or running before it is assumed
+// to be lost, since a worker that stops while running a job never finishes
+// it. Lost jobs may be queued or run again.
+const jobTimeout = 5 * time.Minute
+
+// MaxJobStatusDocuments is the largest number of documents whose jobs may be
+// looked up by a single request.
+const MaxJobStatusDocuments = 1000
+
+// JobStatusRequest is a request for the state of the jobs analyzing several
+// documents.
+type JobStatusRequest struct {
+	// Documents are the IDs of the documents.
+	Documents []string `json:"documents"`
+	// AnalysisOptions are the options that the documents were submitted with,
+	// since documents analyzed with different options have separate jobs.
+	AnalysisOptions
+}
+
+// JobStatusResponse holds the state of the job of each requested document, in
+// the order of the request.
+type JobStatusResponse struct {
+	Jobs []DocumentJob
+}
+
+// DocumentJob is the state of the job analyzing a document.
+type DocumentJob struct {
+	DocumentID string
+	// State is empty if the document was never analyzed with the requested
+	// options, or was analyzed long enough ago that its state expired.
+	State   JobState `json:",omitempty"`
+	Updated time.Time
+	// Error is the reason that a failed job failed.
+	Error string `json:",omitempty"`
+}
+
+// errJobTransition is returned when a job is not in a state that it can be
+// moved from to the requested state.
+var errJobTransition = errors.New("invalid job state transition")
+
+// jobStatus is the stored state of a job.
+type jobStatus struct {
+	State   JobState
+	Updated time.Time
+	// Error is the reason that a failed job failed.
+	Error string `json:",omitempty"`
+}
+
+// canTransition reports if the job may move to state at now.
+func (s jobStatus) canTransition(state JobState, now time.Time) bool {
+	for _, allowed := range jobTransitions[s.State] {
+		if allowed == state {
+			return true
+		}
+	}
+	lost := (s.State == JobQueued || s.State == JobRunning) && now.Sub(s.Updated) > jobTimeout
+	return lost && (state == JobQueued || state == JobRunning)
+}
+
+// jobKey returns the key that the state of the job producing the report
+// stored under key is stored under.
+func jobKey(key string) string {
+	return keyspace.Job(key)
+}
+
+// loadJob retrieves the state of the job for the report stored under key.
+func loadJob(ctx context.Context, kv keyvalue.KeyValue, key string) (jobStatus, error) {
+	var status jobStatus
+	data, err := shortRetrieve(ctx, kv, jobKey(key))
+	if err != nil || data == nil {
+		return status, err
+	}
+	err = json.Unmarshal(data, &status)
+	return status, errors.Wrapf(err, "invalid state for job %s", key)
+}
+
+// transitionJob atomically moves the job for the report stored under key to
+// state, recording jobErr as the reason for failed jobs. It returns an error
+// with errJobTransition as the cause if the job can't be moved from its
+// current state.
+func transitionJob(ctx context.Context, kv keyvalue.KeyValue, key string, state JobState, jobErr error) error {
+	err := keyvalue.Update(ctx, kv, jobKey(key), analysisExpiration, func(data []byte) ([]byte, error) {
+		var current jobStatus
+		if data != nil {
+			if err := json.Unmarshal(data, &current); err != nil {
+				return nil, errors.Wrapf(err, "invalid state for job %s", key)
+			}
+		}
+		now := time.Now()
+		if !current.canTransition(state, now) {
+			return nil, errors.Wrapf(errJobTransition, "job %s is %s, not moving to %s", key, current.State, state)
+		}
+		next := jobStatus{State: state, Updated: now}
+		if jobErr != nil {
+			next.Error = jobErr.Error()
+		}
+		data, err := json.Marshal(next)
+		return data, errors.WithStack(err)
+	})
+	return errors.Wrapf(err, "unable to update state of job %s", key)
+}
+
+// startJob queues a request for a worker to produce the report stored under
+// key, unless the job is already queued or running.
+func (a *apiService) startJob(ctx context.Context, workerRequest DocumentID, key string) error {
+	err := transitionJob(ctx, a.kv, key, JobQueued, nil)
+	if errors.Cause(err) == errJobTransition {
+		_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Job %s is already in progress", key))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.enqueue(ctx, workerRequest); err != nil {
+		if ferr := transitionJob(ctx, a.kv, key, JobFailed, err); ferr != nil {
+			_ = a.l.Log("LEVEL", "WARN", "MESSAGE", ferr.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// failedJob returns an error if the job for the report stored under key
+// failed.
+func (a *apiService) failedJob(ctx context.Context, key string) error {
+	status, err := loadJob(ctx, a.kv, key)
+	if err != nil {
+		return err
+	}
+	if status.State == JobFailed {
+		return errors.Wrapf(ErrJobFailed, "job %s: %s", key, status.Error)
+	}
+	return nil
+}
+
+// finishJob moves a running job to JobSucceeded, or to JobFailed if jobErr is
+// set. Errors are logged since the report, if any, is already stored.
+//
+// Jobs that are no longer running were finished by another worker, such as
+// when several workers merge the shards of a document.
+func (w *workerService) finishJob(ctx context.Context, key string, jobErr error) {
+	state := JobSucceeded
+	if jobErr != nil {
+		state = JobFailed
+	}
+	err := transitionJob(ctx, w.kv, key, state, jobErr)
+	switch {
+	case errors.Cause(err) == errJobTransition:
+		_ = w.log.Log("LEVEL", "DEBUG", "MESSAGE", err.Error())
+	case err != nil:
+		_ = w.log.Log("LEVEL", "WARN", "MESSAGE", err.Error())
+	}
+}
+
+// JobStatus returns the state of the jobs analyzing several documents. The
+// states are read in one batch, so that polling many documents costs a single
+// round trip.
+func (a *apiService) JobStatus(ctx context.Context, request JobStatusRequest) (JobStatusResponse, error) {
+	var jsr JobStatusResponse
+	switch {
+	case len(request.Documents) == 0:
+		return jsr, errors.Wrap(ErrInvalidRequest, "no documents requested")
+	case len(request.Documents) > MaxJobStatusDocuments:
+		return jsr, errors.Wrapf(ErrInvalidRequest, "at most %d documents may be requested", MaxJobStatusDocuments)
+	}
+	if err := request.AnalysisOptions.validate(); err != nil {
+		return jsr, err
+	}
+
+	keys := make([]string, len(request.Documents))
+	for i, id := range request.Documents {
+		if id == "" {
+			return jsr, errors.Wrap(ErrInvalidRequest, "document IDs must not be empty")
+		}
+		keys[i] = jobKey(resultKey(id, request.AnalysisOptions))
+	}
+	values, err := a.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return jsr, errors.Wrap(err, "unable to retrieve job states")
+	}
+	jsr.Jobs = make([]DocumentJob, len(values))
+	for i, data := range values {
+		id := request.Documents[i]
+		jsr.Jobs[i].DocumentID = id
+		if data == nil {
+			continue
+		}
+		var status jobStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			return jsr, errors.Wrapf(err, "invalid state for job of document %s", id)
+		}
+		jsr.Jobs[i].State = status.State
+		jsr.Jobs[i].Updated = status.Updated
+		jsr.Jobs[i].Error = status.Error
+	}
+	return jsr, nil
+}
@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+func TestJobQueuedOnce(t *testing.T) {
+	const channel = "worker"
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	apiService := service.NewAPIService(q, kv, channel, log.NewNopLogger())
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     log.NewNopLogger(),
+		Channel: channel,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	const requests = 5
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+				Document:        "one two two",
+				DurationSeconds: 1,
+			})
+			assert.NoError(t, err, "Processing document should succeed.")
+			assert.Len(t, dfr.Frequencies, 2)
+		}()
+	}
+
+	d, err := q.Pull(ctx, channel)
+	require.NoError(t, err, "Pull from queue should succeed.")
+	var doc service.DocumentID
+	require.NoError(t, json.Unmarshal(d, &doc))
+	_, err = worker.ParseDocument(ctx, doc)
+	require.NoError(t, err, "Parsing document should succeed.")
+	wg.Wait()
+
+	pullCtx, pullCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer pullCancel()
+	_, err = q.Pull(pullCtx, channel)
+	assert.Error(t, err, "Document should only be queued once.")
+}
+
+func TestJobFailed(t *testing.T) {
+	const channel = "worker"
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	apiService := service.NewAPIService(q, kv, channel, log.NewNopLogger())
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:        q,
+		KeyVal:       kv,
+		Log:          log.NewNopLogger(),
+		Channel:      channel,
+		MaxTokenSize: bufio.MaxScanTokenSize,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		d, err := q.Pull(ctx, channel)
+		if ctx.Err() != nil {
+			return
+		}
+		require.NoError(t, err, "Pull from queue should succeed.")
+		var doc service.DocumentID
+		require.NoError(t, json.Unmarshal(d, &doc))
+		_, err = worker.ParseDocument(ctx, doc)
+		assert.Error(t, err, "Words longer than the token size should fail.")
+	}()
+
+	_, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document: strings.Repeat("a", bufio.MaxScanTokenSize+1),
+	})
+	assert.Equal(t, service.ErrJobFailed, errors.Cause(err), "Failed job should be reported.")
+	assert.NoError(t, ctx.Err(), "Failure should be reported before the request times out.")
+}
+
+func TestJobStatus(t *testing.T) {
+	const channel = "worker"
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	apiService := service.NewAPIService(q, kv, channel, log.NewNopLogger())
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     log.NewNopLogger(),
+		Channel: channel,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go func() {
+		d, err := q.Pull(ctx, channel)
+		if ctx.Err() != nil {
+			return
+		}
+		require.NoError(t, err, "Pull from queue should succeed.")
+		var doc service.DocumentID
+		require.NoError(t, json.Unmarshal(d, &doc))
+		_, err = worker.ParseDocument(ctx, doc)
+		assert.NoError(t, err, "Parsing document should succeed.")
+	}()
+	dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{Document: "one two"})
+	require.NoError(t, err, "Processing document should succeed.")
+
+	jsr, err := apiService.JobStatus(ctx, service.JobStatusRequest{
+		Documents: []string{dfr.DocumentID, "unknown"},
+	})
+	require.NoError(t, err, "Job status should be looked up.")
+	require.Len(t, jsr.Jobs, 2, "Every document should have a job.")
+	assert.Equal(t, dfr.DocumentID, jsr.Jobs[0].DocumentID)
+	assert.Equal(t, service.JobSucceeded, jsr.Jobs[0].State, "Analyzed document should have succeeded.")
+	assert.Equal(t, "unknown", jsr.Jobs[1].DocumentID)
+	assert.Empty(t, jsr.Jobs[1].State, "Unknown document should have no state.")
+
+	jsr, err = apiService.JobStatus(ctx, service.JobStatusRequest{
+		Documents:       []string{dfr.DocumentID},
+		AnalysisOptions: service.AnalysisOptions{Stemmer: "en"},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, jsr.Jobs[0].State, "Jobs with other options should be separate.")
+
+	_, err = apiService.JobStatus(ctx, service.JobStatusRequest{})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "Documents should be required.")
+}
@@ -0,0 +1,169 @@
+package keyspace
+
+import (
+	"strconv"
+	"strings"
+)
+
+// otherPrefixes are the prefixes of the keys of KindOther. They are only added
+// by otherPrefix, so that every builder of such keys is classified by KindOf.
+var otherPrefixes []string
+
+// otherPrefix adds a prefix of keys of KindOther and returns it.
+func otherPrefix(prefix string) string {
+	otherPrefixes = append(otherPrefixes, prefix)
+	return prefix
+}
+
+var (
+	blobPrefix       = otherPrefix("blob:")
+	collectionPrefix = otherPrefix("collection:")
+	documentPrefix   = otherPrefix("document:")
+	documentsPrefix  = otherPrefix("documents:")
+	lshPrefix        = otherPrefix("lsh:")
+	resultsPrefix    = otherPrefix("results:")
+	searchPrefix     = otherPrefix("search:")
+	signaturePrefix  = otherPrefix("signature:")
+	termsPrefix      = otherPrefix("terms:")
+)
+
+// Blob returns the key of the manifest of a committed blob.
+func Blob(id string) string {
+	return blobPrefix + id
+}
+
+// BlobChunk returns the key of a chunk of the blob uploaded as upload.
+func BlobChunk(upload string, n int) string {
+	return blobPrefix + upload + ":" + strconv.Itoa(n)
+}
+
+// uploadIDLength is the length of the hexadecimal upload IDs of blob chunks.
+const uploadIDLength = 32
+
+// isBlobChunk reports if key was built by BlobChunk.
+func isBlobChunk(key string) bool {
+	if !strings.HasPrefix(key, blobPrefix) {
+		return false
+	}
+	rest := key[len(blobPrefix):]
+	i := strings.LastIndex(rest, ":")
+	if i != uploadIDLength {
+		return false
+	}
+	for _, c := range rest[:i] {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	_, err := strconv.Atoi(rest[i+1:])
+	return err == nil
+}
+
+// Uncached reports if a key is not worth caching in memory: the chunks of
+// blobs, which are large and rarely read twice, and the keys of the search
+// index, which are many and changed in batches.
+func Uncached(key string) bool {
+	return strings.HasPrefix(key, searchPrefix) || isBlobChunk(key)
+}
+
+// Terms returns the key that the counts of every word of the document whose
+// report is stored under resultKey are stored under.
+func Terms(resultKey string) string {
+	return termsPrefix + resultKey
+}
+
+// Signature returns the key of the signature of the document whose report is
+// stored under resultKey.
+func Signature(resultKey string) string {
+	return signaturePrefix + resultKey
+}
+
+// NearDuplicateBucket returns the key of the reports whose signatures have a
+// band in common.
+func NearDuplicateBucket(band string) string {
+	return lshPrefix + band
+}
+
+// Results returns the key of the list of the keys that the reports of a
+// document are stored under.
+func Results(id string) string {
+	return resultsPrefix + id
+}
+
+// ShardRuns returns the key of the list of the runs that a document was split
+// in.
+func ShardRuns(id string) string {
+	return Results(id) + ":shards"
+}
+
+// Collection returns the key of the aggregate of a collection.
+func Collection(name string) string {
+	return collectionPrefix + name
+}
+
+// CollectionMembers returns the key of the IDs of the documents in a
+// collection.
+func CollectionMembers(name string) string {
+	return collectionPrefix + name + ":members"
+}
+
+// CollectionDocument returns the key of the counts that a document added to
+// a collection.
+func CollectionDocument(name, id string) string {
+	return collectionPrefix + name + ":document:" + id
+}
+
+// Document returns the key of the metadata of a stored document. It is also
+// the ID of the blob holding the contents of the document.
+func Document(id string) string {
+	return documentPrefix + id
+}
+
+// DocumentDays returns the key of the days that documents were stored on.
+func DocumentDays() string {
+	return documentsPrefix + "days"
+}
+
+// DocumentDay returns the key of the IDs of the documents stored on a day.
+func DocumentDay(day string) string {
+	return documentsPrefix + "day:" + day
+}
+
+// SearchStats returns the key of the number and total length of the
+// documents in the search index.
+func SearchStats() string {
+	return searchPrefix + "stats"
+}
+
+// SearchTerm returns the key of the indexed documents in a bucket that
+// contain the term with the given hash.
+func SearchTerm(hash string, bucket int) string {
+	return searchPrefix + "term:" + hash + ":" + strconv.Itoa(bucket)
+}
+
+// SearchPositions returns the key of the positions of the term with the given
+// hash in a part of an indexed document.
+func SearchPositions(hash, id string, part int) string {
+	return searchPrefix + "positions:" + hash + ":" + strconv.Itoa(part) + ":" + id
+}
+
+// SearchDocuments returns the key of the IDs of the indexed documents in a
+// bucket.
+func SearchDocuments(bucket int) string {
+	return searchPrefix + "documents:" + strconv.Itoa(bucket)
+}
+
+// SearchDocument returns the key of an indexed document.
+func SearchDocument(id string) string {
+	return searchPrefix + "document:" + id
+}
+
+// SearchWords returns the key of the words of a part of an indexed document.
+func SearchWords(id string, part int) string {
+	return searchPrefix + "words:" + id + ":" + strconv.Itoa(part)
+}
+
+// SearchFiles returns the key of the IDs of the indexed files of an archive.
+func SearchFiles(parent string) string {
+	return searchPrefix + "files:" + parent
+}
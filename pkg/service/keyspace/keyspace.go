@@ -0,0 +1,245 @@
+// Package keyspace builds the keys that the services keep their state under,
+// and separates the keys of services or environments that share a store.
+//
+// The services build keys with the builders of this package, without a
+// namespace. The Redis adapters of the keyvalue and queue packages then add
+// the Namespace that they are configured with to every key, so two
+// environments sharing a Redis server only need different namespaces.
+package keyspace
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Separator separates a namespace from the keys in it.
+const Separator = ":"
+
+// WorkerQueue is the queue that documents are sent to workers on.
+const WorkerQueue = "worker_document_parser"
+
+// Namespace is the prefix of the keys of one service or environment.
+//
+// The empty Namespace leaves keys as they are, which is how keys were stored
+// before namespaces were added.
+type Namespace string
+
+// Validate returns an error if the namespace can't be used.
+//
+// Namespaces can't contain braces, since Redis Cluster would take them for the
+// hash tag of every key in the namespace. They can't contain the Separator
+// either, so that the keys of one namespace are never in another, such as
+// "prod" and "prod:eu", nor the characters of Redis glob patterns.
+func (n Namespace) Validate() error {
+	if strings.ContainsAny(string(n), " \t\r\n") {
+		return errors.Errorf("namespace %q contains whitespace", string(n))
+	}
+	if strings.ContainsAny(string(n), "{}") {
+		return errors.Errorf("namespace %q contains braces", string(n))
+	}
+	if strings.Contains(string(n), Separator) {
+		return errors.Errorf("namespace %q contains %q", string(n), Separator)
+	}
+	if strings.ContainsAny(string(n), `*?[]\`) {
+		return errors.Errorf("namespace %q contains a glob pattern character", string(n))
+	}
+	return nil
+}
+
+// Key returns key in the namespace.
+func (n Namespace) Key(key string) string {
+	if n == "" {
+		return key
+	}
+	return string(n) + Separator + key
+}
+
+// Trim returns key without the namespace, and whether key is in the
+// namespace.
+func (n Namespace) Trim(key string) (string, bool) {
+	if n == "" {
+		return key, true
+	}
+	prefix := string(n) + Separator
+	if !strings.HasPrefix(key, prefix) {
+		return key, false
+	}
+	return strings.TrimPrefix(key, prefix), true
+}
+
+// Pattern returns the Redis glob pattern that matches every key in the
+// namespace.
+func (n Namespace) Pattern() string {
+	if n == "" {
+		return "*"
+	}
+	var b strings.Builder
+	for _, r := range string(n) + Separator {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('*')
+	return b.String()
+}
+
+// Kind is the kind of state that is kept under a key.
+type Kind string
+
+// Kinds of keys.
+const (
+	// KindResult is a report of an analyzed document.
+	KindResult Kind = "result"
+	// KindJob is the state of the job producing a report.
+	KindJob Kind = "job"
+	// KindCounter is a counter, such as the number of finished shards of a
+	// document.
+	KindCounter Kind = "counter"
+	// KindQueue is a queue of messages for workers.
+	KindQueue Kind = "queue"
+	// KindOther is any other state, such as collections, signatures and
+	// stored documents.
+	KindOther Kind = "other"
+)
+
+const (
+	jobPrefix   = "job:"
+	shardPrefix = "shard:"
+	// runInfix separates the result key of a shard key from the run of the
+	// split that the shard belongs to.
+	runInfix = ":run:"
+)
+
+// Result returns the key that the report of a document is stored under.
+// Documents that are analyzed with other than the default options have a
+// variant identifying the options.
+func Result(id, variant string) string {
+	if variant == "" {
+		return id
+	}
+	return id + ":" + variant
+}
+
+// Job returns the key that the state of the job producing the report stored
+// under resultKey is stored under.
+func Job(resultKey string) string {
+	return jobPrefix + resultKey
+}
+
+// shardBase returns the prefix of the keys of the shards of one split of the
+// document whose report is stored under resultKey. Each split has a run of
+// its own, so that splitting the same document again does not change the
+// state of a split whose shards are still being counted.
+func shardBase(resultKey, run string) string {
+	return shardPrefix + resultKey + runInfix + run
+}
+
+// ShardCounter returns the key of a counter of the shards of a run of the
+// document whose report is stored under resultKey.
+func ShardCounter(resultKey, run, name string) string {
+	return shardBase(resultKey, run) + ":" + name
+}
+
+// ShardPartial returns the key that the counts of a shard of a run of the
+// document whose report is stored under resultKey are stored under.
+func ShardPartial(resultKey, run string, index int) string {
+	return shardBase(resultKey, run) + ":partial:" + strconv.Itoa(index)
+}
+
+// Tag returns a key built by this package with a Redis Cluster hash tag, so
+// that the report of a document and the state of its job and shards are
+// stored in the same slot: reports are stored under "{<result key>}", jobs
+// under "job:{<result key>}" and shards of every run under
+// "shard:{<result key>}:...". Other keys are returned as they are.
+func Tag(key string) string {
+	switch KindOf(key) {
+	case KindJob:
+		return jobPrefix + "{" + strings.TrimPrefix(key, jobPrefix) + "}"
+	case KindResult:
+		return "{" + key + "}"
+	}
+	if !strings.HasPrefix(key, shardPrefix) {
+		return key
+	}
+	rest := strings.TrimPrefix(key, shardPrefix)
+	end := strings.LastIndex(rest, runInfix)
+	if end < 0 {
+		return key
+	}
+	return shardPrefix + "{" + rest[:end] + "}" + rest[end:]
+}
+
+// Untag returns a key returned by Tag without its hash tag. Keys without a
+// hash tag are returned as they are.
+func Untag(key string) string {
+	for _, prefix := range []string{jobPrefix, shardPrefix, ""} {
+		if !strings.HasPrefix(key, prefix+"{") {
+			continue
+		}
+		rest := key[len(prefix)+1:]
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return key
+		}
+		return prefix + rest[:end] + rest[end+1:]
+	}
+	return key
+}
+
+// Slots is the number of hash slots of Redis Cluster.
+const Slots = 16384
+
+// Slot returns the Redis Cluster hash slot of a key. Keys with a hash tag,
+// the first non-empty part of the key between braces, are in the slot of
+// their hash tag.
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % Slots
+}
+
+// crc16 returns the CRC-16/XMODEM checksum of s, which Redis Cluster hashes
+// keys with.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// KindOf returns the kind of a key built by this package, without its
+// namespace. Queues other than WorkerQueue are stored under their name, so
+// they can't be told apart from reports by their key alone.
+func KindOf(key string) Kind {
+	switch {
+	case strings.HasPrefix(key, jobPrefix):
+		return KindJob
+	case strings.HasPrefix(key, shardPrefix):
+		if strings.Contains(strings.TrimPrefix(key, shardPrefix), ":partial:") {
+			return KindOther
+		}
+		return KindCounter
+	case key == WorkerQueue:
+		return KindQueue
+	}
+	for _, prefix := range otherPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return KindOther
+		}
+	}
+	return KindResult
+}
@@ -0,0 +1,143 @@
+package keyspace_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+)
+
+func TestNamespace(t *testing.T) {
+	t.Parallel()
+
+	ns := keyspace.Namespace("staging")
+	assert.Equal(t, "staging:job:abc", ns.Key("job:abc"))
+	key, ok := ns.Trim("staging:job:abc")
+	assert.True(t, ok, "Keys in the namespace should be trimmed.")
+	assert.Equal(t, "job:abc", key)
+	_, ok = ns.Trim("production:job:abc")
+	assert.False(t, ok, "Keys of other namespaces should not be trimmed.")
+	assert.Equal(t, "staging:*", ns.Pattern())
+	assert.Equal(t, `a\*b\?:*`, keyspace.Namespace("a*b?").Pattern(), "Wildcards should be escaped.")
+
+	var empty keyspace.Namespace
+	assert.Equal(t, "abc", empty.Key("abc"), "The empty namespace should not change keys.")
+	assert.Equal(t, "*", empty.Pattern())
+
+	assert.NoError(t, ns.Validate())
+	assert.Error(t, keyspace.Namespace("a b").Validate(), "Namespaces should not contain whitespace.")
+	assert.Error(t, keyspace.Namespace("{a}").Validate(), "Namespaces should not contain hash tags.")
+	assert.Error(t, keyspace.Namespace("prod:eu").Validate(), "Namespaces should not contain the separator.")
+	for _, name := range []string{"a*", "a?", "a[b]", `a\b`} {
+		assert.Error(t, keyspace.Namespace(name).Validate(), "Namespace %q should not contain glob characters.", name)
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	t.Parallel()
+
+	result := keyspace.Result("v2:abc", "opts")
+	assert.Equal(t, "v2:abc:opts", result)
+	assert.Equal(t, "v2:abc", keyspace.Result("v2:abc", ""), "Default options should have no variant.")
+
+	tests := []struct {
+		key  string
+		kind keyspace.Kind
+	}{
+		{result, keyspace.KindResult},
+		{keyspace.Job(result), keyspace.KindJob},
+		{keyspace.ShardCounter(result, "0a1b", "done"), keyspace.KindCounter},
+		{keyspace.ShardPartial(result, "0a1b", 2), keyspace.KindOther},
+		{keyspace.WorkerQueue, keyspace.KindQueue},
+		{keyspace.Blob(result), keyspace.KindOther},
+		{keyspace.BlobChunk("0a1b", 2), keyspace.KindOther},
+		{keyspace.Terms(result), keyspace.KindOther},
+		{keyspace.Signature(result), keyspace.KindOther},
+		{keyspace.NearDuplicateBucket("0:abc"), keyspace.KindOther},
+		{keyspace.Results(result), keyspace.KindOther},
+		{keyspace.ShardRuns(result), keyspace.KindOther},
+		{keyspace.Collection("books"), keyspace.KindOther},
+		{keyspace.CollectionMembers("books"), keyspace.KindOther},
+		{keyspace.CollectionDocument("books", result), keyspace.KindOther},
+		{keyspace.Document(result), keyspace.KindOther},
+		{keyspace.DocumentDays(), keyspace.KindOther},
+		{keyspace.DocumentDay("2019-01-02"), keyspace.KindOther},
+		{keyspace.SearchStats(), keyspace.KindOther},
+		{keyspace.SearchTerm("abc", 2), keyspace.KindOther},
+		{keyspace.SearchPositions("abc", result, 2), keyspace.KindOther},
+		{keyspace.SearchDocuments(2), keyspace.KindOther},
+		{keyspace.SearchDocument(result), keyspace.KindOther},
+		{keyspace.SearchWords(result, 2), keyspace.KindOther},
+		{keyspace.SearchFiles(result), keyspace.KindOther},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.kind, keyspace.KindOf(test.key), "Key %q should have the expected kind.", test.key)
+	}
+}
+
+func TestUncached(t *testing.T) {
+	t.Parallel()
+
+	const upload = "0123456789abcdef0123456789abcdef"
+	for _, key := range []string{
+		keyspace.BlobChunk(upload, 0),
+		keyspace.BlobChunk(upload, 12),
+		keyspace.SearchStats(),
+		keyspace.SearchTerm("abc", 1),
+		keyspace.SearchDocument("v1:sha256:abc"),
+	} {
+		assert.True(t, keyspace.Uncached(key), "%s should not be cached.", key)
+	}
+	for _, key := range []string{
+		keyspace.Blob("v1:sha256:abc"),
+		keyspace.Blob(keyspace.Document("v1:sha256:abc")),
+		keyspace.Blob(upload + ":x"),
+		keyspace.Document("v1:sha256:abc"),
+		keyspace.Result("v1:sha256:abc", ""),
+	} {
+		assert.False(t, keyspace.Uncached(key), "%s should be cached.", key)
+	}
+}
+
+func TestSlot(t *testing.T) {
+	t.Parallel()
+
+	// The slots that CLUSTER KEYSLOT returns.
+	assert.Equal(t, 12182, keyspace.Slot("foo"))
+	assert.Equal(t, 5061, keyspace.Slot("bar"))
+	assert.Equal(t, 12739, keyspace.Slot("123456789"))
+	assert.Equal(t, keyspace.Slot("user1000"), keyspace.Slot("{user1000}.following"),
+		"Keys should be in the slot of their hash tag.")
+	assert.Equal(t, keyspace.Slot("{bar"), keyspace.Slot("foo{{bar}}zap"),
+		"The hash tag should end at the first closing brace.")
+	assert.NotEqual(t, keyspace.Slot("bar"), keyspace.Slot("foo{}{bar}"),
+		"Empty hash tags should be ignored.")
+}
+
+func TestTag(t *testing.T) {
+	t.Parallel()
+
+	ns := keyspace.Namespace("staging")
+	result := keyspace.Result("v1:sha256:abc", "opts")
+	keys := []string{
+		result,
+		keyspace.Job(result),
+		keyspace.ShardCounter(result, "0a1b", "done"),
+		keyspace.ShardPartial(result, "0a1b", 2),
+	}
+	slot := keyspace.Slot(ns.Key(keyspace.Tag(result)))
+	for _, key := range keys {
+		tagged := keyspace.Tag(key)
+		assert.Equal(t, slot, keyspace.Slot(ns.Key(tagged)), "Key %q should be in the slot of its report.", key)
+		assert.Equal(t, key, keyspace.Untag(tagged), "Key %q should be untagged.", key)
+		assert.Equal(t, keyspace.KindOf(key), keyspace.KindOf(tagged), "Tagged key %q should keep its kind.", key)
+	}
+	assert.Equal(t, "{"+result+"}", keyspace.Tag(result))
+	assert.Equal(t, "shard:{"+result+"}:run:0a1b:partial:2", keyspace.Tag(keyspace.ShardPartial(result, "0a1b", 2)))
+
+	for _, key := range []string{keyspace.WorkerQueue, "collection:books"} {
+		assert.Equal(t, key, keyspace.Tag(key), "Key %q should not be tagged.", key)
+		assert.Equal(t, key, keyspace.Untag(key), "Untagged key %q should be kept.", key)
+	}
+}
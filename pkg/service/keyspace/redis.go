@@ -0,0 +1,132 @@
+package keyspace
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// scanCount is how many keys are asked for by each SCAN.
+const scanCount = 1000
+
+// Entry is a key in a namespace.
+type Entry struct {
+	// Key is the key without the namespace and hash tag.
+	Key  string
+	Kind Kind
+	// RedisKey is the key as it is stored in Redis.
+	RedisKey string
+}
+
+// List calls fn with every key in the namespace ns of a Redis database, until
+// fn returns an error.
+//
+// Keys are listed with SCAN, so Redis is not blocked, but keys that are
+// written while the namespace is listed may be missed. Listing the empty
+// namespace lists every key, including the keys of other namespaces. The keys
+// of every master of a cluster are listed, and fn is never called
+// concurrently.
+func List(ctx context.Context, c redis.UniversalClient, ns Namespace, fn func(Entry) error) error {
+	return scan(ctx, c, ns, func(client *redis.Client, keys []string) error {
+		types := make([]*redis.StatusCmd, len(keys))
+		_, err := client.Pipelined(func(p redis.Pipeliner) error {
+			for i, key := range keys {
+				types[i] = p.Type(key)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "unable to get types of keys in namespace %q", string(ns))
+		}
+		for i, key := range keys {
+			trimmed, _ := ns.Trim(key)
+			trimmed = Untag(trimmed)
+			kind := KindOf(trimmed)
+			switch types[i].Val() {
+			case "none":
+				// The key was deleted after it was scanned.
+				continue
+			case "list":
+				kind = KindQueue
+			}
+			if err := fn(Entry{Key: trimmed, Kind: kind, RedisKey: key}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Clean deletes every key in the namespace ns of a Redis database, and
+// returns the number of deleted keys.
+//
+// The empty namespace can't be cleaned, since it holds the keys of every
+// namespace.
+func Clean(ctx context.Context, c redis.UniversalClient, ns Namespace) (int64, error) {
+	if ns == "" {
+		return 0, errors.New("refusing to clean the empty namespace")
+	}
+	var deleted int64
+	err := scan(ctx, c, ns, func(client *redis.Client, keys []string) error {
+		// Keys are deleted one by one, since the keys of a batch may be
+		// in different slots of a cluster.
+		dels := make([]*redis.IntCmd, len(keys))
+		_, err := client.Pipelined(func(p redis.Pipeliner) error {
+			for i, key := range keys {
+				dels[i] = p.Del(key)
+			}
+			return nil
+		})
+		for _, del := range dels {
+			deleted += del.Val()
+		}
+		return errors.Wrapf(err, "unable to delete keys in namespace %q", string(ns))
+	})
+	return deleted, err
+}
+
+// scan calls fn with each batch of the keys in the namespace ns that SCAN
+// returns, and the client of the node that they are kept on.
+//
+// SCAN only returns the keys of one node, so every master of a cluster is
+// scanned. fn is never called concurrently.
+func scan(ctx context.Context, c redis.UniversalClient, ns Namespace, fn func(client *redis.Client, keys []string) error) error {
+	switch c := c.(type) {
+	case *redis.Client:
+		return scanNode(c.WithContext(ctx), ns, fn)
+	case *redis.ClusterClient:
+		var mu sync.Mutex
+		return c.WithContext(ctx).ForEachMaster(func(client *redis.Client) error {
+			return scanNode(client.WithContext(ctx), ns, func(client *redis.Client, keys []string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				return fn(client, keys)
+			})
+		})
+	default:
+		return errors.Errorf("unable to scan keys with a %T", c)
+	}
+}
+
+// scanNode calls fn with each batch of the keys in the namespace ns that SCAN
+// returns from a single node.
+func scanNode(client *redis.Client, ns Namespace, fn func(client *redis.Client, keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(cursor, ns.Pattern(), scanCount).Result()
+		if err != nil {
+			return errors.Wrapf(err, "unable to scan namespace %q", string(ns))
+		}
+		if len(keys) > 0 {
+			if err := fn(client, keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,103 @@
+package keyspace_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redistest"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+)
+
+func TestRedisNamespaces(t *testing.T) {
+	t.Parallel()
+	c := redistest.Connect(t)
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	staging := keyspace.Namespace(t.Name() + "-staging-" + suffix)
+	production := keyspace.Namespace(t.Name() + "-production-" + suffix)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stagingKV := keyvalue.NewRedisAdapterWithConfig(c, keyvalue.RedisConfig{Namespace: staging})
+	productionKV := keyvalue.NewRedisAdapterWithConfig(c, keyvalue.RedisConfig{Namespace: production})
+	result := keyspace.Result("abc", "")
+	require.NoError(t, stagingKV.Store(ctx, result, []byte("staging"), time.Minute))
+	require.NoError(t, productionKV.Store(ctx, result, []byte("production"), time.Minute))
+	require.NoError(t, stagingKV.Store(ctx, keyspace.Job(result), []byte("{}"), time.Minute))
+	require.NoError(t, stagingKV.IncrementCounter(ctx, keyspace.ShardCounter(result, "0a1b", "done")))
+	got, err := stagingKV.Retrieve(ctx, result)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("staging"), got, "Namespaces should not share keys.")
+
+	stagingQueue := queue.NewRedisAdapterWithConfig(c, queue.RedisConfig{Namespace: staging})
+	productionQueue := queue.NewRedisAdapterWithConfig(c, queue.RedisConfig{Namespace: production})
+	require.NoError(t, stagingQueue.Push(ctx, keyspace.WorkerQueue, [][]byte{[]byte("staging")}))
+	require.NoError(t, productionQueue.Push(ctx, keyspace.WorkerQueue, [][]byte{[]byte("production")}))
+	message, err := stagingQueue.Pull(ctx, keyspace.WorkerQueue)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("staging"), message, "Namespaces should not share queues.")
+	require.NoError(t, stagingQueue.Push(ctx, keyspace.WorkerQueue, [][]byte{[]byte("left")}))
+
+	kinds := make(map[string]keyspace.Kind)
+	err = keyspace.List(ctx, c, staging, func(e keyspace.Entry) error {
+		kinds[e.Key] = e.Kind
+		return nil
+	})
+	require.NoError(t, err, "Listing a namespace should succeed.")
+	assert.Equal(t, map[string]keyspace.Kind{
+		result:               keyspace.KindResult,
+		keyspace.Job(result): keyspace.KindJob,
+		keyspace.ShardCounter(result, "0a1b", "done"): keyspace.KindCounter,
+		keyspace.WorkerQueue:                          keyspace.KindQueue,
+	}, kinds, "Only the keys of the namespace should be listed, by kind.")
+
+	deleted, err := keyspace.Clean(ctx, c, staging)
+	require.NoError(t, err, "Cleaning a namespace should succeed.")
+	assert.Equal(t, int64(4), deleted)
+	got, _ = stagingKV.Retrieve(ctx, result)
+	assert.Nil(t, got, "Keys of the cleaned namespace should be deleted.")
+	got, _ = productionKV.Retrieve(ctx, result)
+	assert.Equal(t, []byte("production"), got, "Keys of other namespaces should be kept.")
+
+	_, err = keyspace.Clean(ctx, c, "")
+	assert.Error(t, err, "The empty namespace should not be cleaned.")
+}
+
+func TestRedisClusterNamespaces(t *testing.T) {
+	t.Parallel()
+	c := redistest.ConnectCluster(t)
+	defer c.Close()
+	ns := keyspace.Namespace(t.Name() + "-" + strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kv := keyvalue.NewRedisAdapterWithConfig(c, keyvalue.RedisConfig{Namespace: ns, HashTags: true})
+	result := keyspace.Result("abc", "")
+	require.NoError(t, kv.Store(ctx, result, []byte("report"), time.Minute))
+	require.NoError(t, kv.Store(ctx, keyspace.Job(result), []byte("{}"), time.Minute))
+	require.NoError(t, kv.Store(ctx, "collection:books", []byte("[]"), time.Minute))
+
+	entries := make(map[string]keyspace.Entry)
+	err := keyspace.List(ctx, c, ns, func(e keyspace.Entry) error {
+		entries[e.Key] = e
+		return nil
+	})
+	require.NoError(t, err, "Listing a namespace of a cluster should succeed.")
+	assert.Equal(t, map[string]keyspace.Entry{
+		result:               {Key: result, Kind: keyspace.KindResult, RedisKey: ns.Key("{abc}")},
+		keyspace.Job(result): {Key: keyspace.Job(result), Kind: keyspace.KindJob, RedisKey: ns.Key("job:{abc}")},
+		"collection:books":   {Key: "collection:books", Kind: keyspace.KindOther, RedisKey: ns.Key("collection:books")},
+	}, entries, "Keys should be listed without their hash tags.")
+
+	deleted, err := keyspace.Clean(ctx, c, ns)
+	require.NoError(t, err, "Keys of different slots should be cleaned.")
+	assert.Equal(t, int64(3), deleted)
+}
@@ -2,19 +2,43 @@ package keyvalue
 
 import (
 	"context"
-	"encoding/base64"
 	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/go-redis/redis"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/codec"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redisclient"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
 )
 
 // NewRedisAdapter creates a Redis client that supports storing and retrieving
 // key value pairs.
-func NewRedisAdapter(c *redis.Client) *RedisAdapter {
-	return &RedisAdapter{c: c}
+func NewRedisAdapter(c redis.UniversalClient) *RedisAdapter {
+	return NewRedisAdapterWithConfig(c, RedisConfig{})
+}
+
+// RedisConfig contains the configuration for a RedisAdapter.
+type RedisConfig struct {
+	// Namespace is the namespace that every key is kept in.
+	Namespace keyspace.Namespace
+	// Codec encodes the stored values. codec.Default is used if it is nil.
+	Codec *codec.Codec
+	// HashTags stores keys with the hash tags of keyspace.Tag, so that the
+	// keys of a report are kept in the same slot of a Redis Cluster. Keys are
+	// stored under other names with and without hash tags.
+	HashTags bool
+}
+
+// NewRedisAdapterWithConfig creates a Redis client that supports storing and
+// retrieving key value pairs, configured by conf.
+func NewRedisAdapterWithConfig(c redis.UniversalClient, conf RedisConfig) *RedisAdapter {
+	if conf.Codec == nil {
+		conf.Codec = codec.Default
+	}
+	return &RedisAdapter{c: c, ns: conf.Namespace, codec: conf.Codec, hashTags: conf.HashTags}
 }
 
 // Ensure RedisAdapter implements the KeyValue interface.
@@ -22,7 +46,18 @@ var _ KeyValue = (*RedisAdapter)(nil)
 
 // RedisAdapter adapts a Redis client to support the KeyValue interface.
 type RedisAdapter struct {
-	c *redis.Client
+	c        redis.UniversalClient
+	ns       keyspace.Namespace
+	codec    *codec.Codec
+	hashTags bool
+}
+
+// key returns the Redis key that key is stored under.
+func (r *RedisAdapter) key(key string) string {
+	if r.hashTags {
+		key = keyspace.Tag(key)
+	}
+	return r.ns.Key(key)
 }
 
 // Store stores a key value pair in Redis.
@@ -33,9 +68,8 @@ func (r *RedisAdapter) Store(ctx context.Context, key string, data []byte, expir
 	if len(key) == 0 {
 		return errors.New("invalid key")
 	}
-	client := r.c.WithContext(ctx)
-	value := base64.StdEncoding.EncodeToString(data)
-	err := client.Set(key, value, expiration).Err()
+	client := redisclient.WithContext(ctx, r.c)
+	err := client.Set(r.key(key), r.codec.Encode(data), expiration).Err()
 	return errors.Wrap(err, "error storing key value pair in Redis")
 }
 
@@ -45,30 +79,222 @@ func (r *RedisAdapter) Retrieve(ctx context.Context, key string) ([]byte, error)
 	if len(key) == 0 {
 		return nil, errors.New("invalid key")
 	}
-	client := r.c.WithContext(ctx)
-	v, err := client.Get(key).Result()
+	client := redisclient.WithContext(ctx, r.c)
+	v, err := client.Get(r.key(key)).Bytes()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil
 		}
 		return nil, errors.Wrapf(err, "unable to retrieve value for key %q from Redis", key)
 	}
-	data, err := base64.StdEncoding.DecodeString(v)
+	data, err := r.codec.Decode(v)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to decode value for key %q as base64", key)
+		return nil, errors.Wrapf(err, "unable to decode value for key %q", key)
 	}
 	return data, nil
 }
 
+// Delete deletes the value or counter for a given key from Redis.
+//
+// Deleting a key that does not exist is not an error.
+func (r *RedisAdapter) Delete(ctx context.Context, key string) error {
+	// TODO: Handle message trace from ctx.
+	if len(key) == 0 {
+		return errors.New("invalid key")
+	}
+	client := redisclient.WithContext(ctx, r.c)
+	err := client.Del(r.key(key)).Err()
+	return errors.Wrapf(err, "unable to delete key %q from Redis", key)
+}
+
+// RetrieveMany retrieves the values for several keys from Redis with a single
+// MGET, or in a cluster with an MGET for each slot in a single pipeline.
+func (r *RedisAdapter) RetrieveMany(ctx context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	// batches holds the indexes of the keys of each MGET by their slot, which
+	// is only computed for a cluster.
+	batches := make(map[int][]int)
+	cluster := redisclient.IsCluster(r.c)
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = errors.New("invalid key")
+			continue
+		}
+		slot := 0
+		if cluster {
+			slot = keyspace.Slot(r.key(key))
+		}
+		batches[slot] = append(batches[slot], i)
+	}
+	if len(batches) == 0 {
+		return values, batchError(errs)
+	}
+	client := redisclient.WithContext(ctx, r.c)
+	cmds := make(map[int]*redis.SliceCmd, len(batches))
+	_, err := client.Pipelined(func(p redis.Pipeliner) error {
+		for slot, batch := range batches {
+			redisKeys := make([]string, len(batch))
+			for j, i := range batch {
+				redisKeys[j] = r.key(keys[i])
+			}
+			cmds[slot] = p.MGet(redisKeys...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve values from Redis")
+	}
+	for slot, batch := range batches {
+		replies := cmds[slot].Val()
+		for j, i := range batch {
+			v, ok := replies[j].(string)
+			if !ok {
+				// Missing keys and keys that do not hold strings are nil.
+				continue
+			}
+			data, err := r.codec.Decode([]byte(v))
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "unable to decode value for key %q", keys[i])
+				continue
+			}
+			values[i] = data
+		}
+	}
+	return values, batchError(errs)
+}
+
+// StoreMany stores several key value pairs in Redis in a single pipeline.
+func (r *RedisAdapter) StoreMany(ctx context.Context, entries []Entry) error {
+	errs := make([]error, len(entries))
+	cmds := make([]*redis.StatusCmd, len(entries))
+	client := redisclient.WithContext(ctx, r.c)
+	_, err := client.Pipelined(func(p redis.Pipeliner) error {
+		for i, e := range entries {
+			if len(e.Key) == 0 {
+				errs[i] = errors.New("invalid key")
+				continue
+			}
+			cmds[i] = p.Set(r.key(e.Key), r.codec.Encode(e.Data), e.Expiration)
+		}
+		return nil
+	})
+	if err != nil && !isCommandError(cmds, err) {
+		return errors.Wrap(err, "unable to store key value pairs in Redis")
+	}
+	for i, cmd := range cmds {
+		if cmd != nil && cmd.Err() != nil {
+			errs[i] = errors.Wrapf(cmd.Err(), "error storing key %q in Redis", entries[i].Key)
+		}
+	}
+	return batchError(errs)
+}
+
+// isCommandError reports if err is the error of one of cmds rather than of
+// the whole pipeline.
+func isCommandError(cmds []*redis.StatusCmd, err error) bool {
+	for _, cmd := range cmds {
+		if cmd != nil && cmd.Err() == err {
+			return true
+		}
+	}
+	return false
+}
+
+// RetrieveVersion retrieves a value and its version for a given key from
+// Redis.
+func (r *RedisAdapter) RetrieveVersion(ctx context.Context, key string) ([]byte, Version, error) {
+	data, err := r.Retrieve(ctx, key)
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	return data, versionOf(data), nil
+}
+
+// StoreIfAbsent stores a key value pair in Redis if the key does not exist.
+func (r *RedisAdapter) StoreIfAbsent(ctx context.Context, key string, data []byte, expiration time.Duration) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("invalid key")
+	}
+	client := redisclient.WithContext(ctx, r.c)
+	ok, err := client.SetNX(r.key(key), r.codec.Encode(data), expiration).Result()
+	return ok, errors.Wrapf(err, "unable to store value for key %q in Redis", key)
+}
+
+// CompareAndSwap stores a key value pair in Redis if the value of the key has
+// the given version.
+//
+// The key is watched while its value is compared, so the value is only
+// written if the key is not changed in between.
+func (r *RedisAdapter) CompareAndSwap(ctx context.Context, key string, version Version, data []byte, expiration time.Duration) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("invalid key")
+	}
+	value := r.codec.Encode(data)
+	return r.compareAnd(ctx, key, version, func(p redis.Pipeliner) {
+		p.Set(r.key(key), value, expiration)
+	})
+}
+
+// CompareAndDelete deletes a key from Redis if its value has the given
+// version.
+func (r *RedisAdapter) CompareAndDelete(ctx context.Context, key string, version Version) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("invalid key")
+	}
+	if version == NoVersion {
+		return false, nil
+	}
+	return r.compareAnd(ctx, key, version, func(p redis.Pipeliner) {
+		p.Del(r.key(key))
+	})
+}
+
+// compareAnd runs write in a transaction if the value of key has the given
+// version, and reports whether it was run.
+func (r *RedisAdapter) compareAnd(ctx context.Context, key string, version Version, write func(redis.Pipeliner)) (bool, error) {
+	client := redisclient.WithContext(ctx, r.c)
+	swapped := false
+	err := client.Watch(func(tx *redis.Tx) error {
+		v, err := tx.Get(r.key(key)).Bytes()
+		var data []byte
+		switch {
+		case err == redis.Nil:
+		case err != nil:
+			return errors.Wrapf(err, "unable to retrieve value for key %q from Redis", key)
+		default:
+			if data, err = r.codec.Decode(v); err != nil {
+				return errors.Wrapf(err, "unable to decode value for key %q", key)
+			}
+		}
+		if versionOf(data) != version {
+			return nil
+		}
+		_, err = tx.Pipelined(func(p redis.Pipeliner) error {
+			write(p)
+			return nil
+		})
+		if err == redis.TxFailedErr {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to write key %q to Redis", key)
+		}
+		swapped = true
+		return nil
+	}, r.key(key))
+	return swapped, err
+}
+
 // SetCounter sets the counter with the given key to value.
 func (r *RedisAdapter) SetCounter(ctx context.Context, key string, value int64) error {
 	// TODO: Create child span for trace.
 	if len(key) == 0 {
 		return errors.New("invalid key")
 	}
-	client := r.c.WithContext(ctx)
+	client := redisclient.WithContext(ctx, r.c)
 	valString := strconv.FormatInt(value, 10)
-	err := client.Set(key, valString, 0).Err()
+	err := client.Set(r.key(key), valString, 0).Err()
 	return errors.Wrapf(err, "failed to set number for key: %q", key)
 }
 
@@ -78,8 +304,8 @@ func (r *RedisAdapter) GetCounter(ctx context.Context, key string) (int64, error
 	if len(key) == 0 {
 		return 0, errors.New("invalid key")
 	}
-	client := r.c.WithContext(ctx)
-	current, err := client.Get(key).Result()
+	client := redisclient.WithContext(ctx, r.c)
+	current, err := client.Get(r.key(key)).Result()
 	if err != nil {
 		return 0, errors.Wrapf(err, "failed to get number for key: %q", key)
 	}
@@ -98,7 +324,7 @@ func (r *RedisAdapter) IncrementCounter(ctx context.Context, key string) error {
 	if len(key) == 0 {
 		return errors.New("invalid key")
 	}
-	client := r.c.WithContext(ctx)
-	err := client.Incr(key).Err()
+	client := redisclient.WithContext(ctx, r.c)
+	err := client.Incr(r.key(key)).Err()
 	return errors.Wrapf(err, "failed to increment value for key %q", key)
 }
@@ -1,12 +1,9 @@
-//+build integration
-
 package keyvalue_test
 
 import (
 	"context"
-	"math/rand"
+	"encoding/base64"
 	"strconv"
-	"sync"
 	"testing"
 	"time"
 
@@ -14,65 +11,199 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/rwool/saas-interview-challenge1/pkg/service/codec"
 	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redistest"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
 	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue/keyvaluetest"
 )
 
-var seedOnce sync.Once
+func TestConformance(t *testing.T) {
+	t.Parallel()
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		return keyvalue.NewRedisAdapter(redistest.Connect(t)), func() {}
+	})
+}
 
-func randString() string {
-	seedOnce.Do(func() { rand.Seed(time.Now().UnixNano()) })
-	i := rand.Int()
-	return strconv.Itoa(i)
+func TestNamespacedConformance(t *testing.T) {
+	t.Parallel()
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		return keyvalue.NewRedisAdapterWithConfig(redistest.Connect(t), keyvalue.RedisConfig{Namespace: "conformance"}), func() {}
+	})
 }
 
-func TestStoreRetrieve(t *testing.T) {
+func TestGzipConformance(t *testing.T) {
 	t.Parallel()
-	c := redistest.Connect(t)
-	rc := keyvalue.NewRedisAdapter(c)
+	c, err := codec.New(codec.Config{Encoding: codec.Gzip, Threshold: 1})
+	require.NoError(t, err)
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		return keyvalue.NewRedisAdapterWithConfig(redistest.Connect(t), keyvalue.RedisConfig{Codec: c}), func() {}
+	})
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func TestClusterConformance(t *testing.T) {
+	t.Parallel()
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		c := redistest.ConnectCluster(t)
+		conf := keyvalue.RedisConfig{Namespace: "conformance", HashTags: true}
+		return keyvalue.NewRedisAdapterWithConfig(c, conf), func() { _ = c.Close() }
+	})
+}
+
+func TestRedisClusterHashTags(t *testing.T) {
+	t.Parallel()
+	c := redistest.ConnectCluster(t)
+	defer c.Close()
+	ns := keyspace.Namespace(t.Name() + strconv.FormatInt(time.Now().UnixNano(), 10))
+	kv := keyvalue.NewRedisAdapterWithConfig(c, keyvalue.RedisConfig{Namespace: ns, HashTags: true})
+	ctx := context.Background()
+
+	result := keyspace.Result("abc", "")
+	require.NoError(t, kv.Store(ctx, result, []byte("report"), time.Minute))
+	require.NoError(t, kv.Store(ctx, keyspace.Job(result), []byte("job"), time.Minute))
+	require.NoError(t, kv.Store(ctx, "other", []byte("other"), time.Minute))
+	values, err := c.MGet(ns.Key("{abc}"), ns.Key("job:{abc}")).Result()
+	require.NoError(t, err, "The report and its job should be stored in one slot.")
+	assert.Len(t, values, 2)
+
+	got, err := kv.RetrieveMany(ctx, []string{"other", result, keyspace.Job(result), "missing"})
+	require.NoError(t, err, "Keys of different slots should be retrieved together.")
+	assert.Equal(t, [][]byte{[]byte("other"), []byte("report"), []byte("job"), nil}, got)
+}
+
+func TestRedisLegacyValues(t *testing.T) {
+	t.Parallel()
+	c := redistest.Connect(t)
+	kv := keyvalue.NewRedisAdapter(c)
+	key := t.Name() + strconv.FormatInt(time.Now().UnixNano(), 10)
+	ctx := context.Background()
 
-	key := t.Name() + randString()
-	value := []byte("123")
-	err := rc.Store(ctx, key, value, 5*time.Second)
-	require.NoError(t, err, "Should store value without error.")
+	// Earlier versions stored values as base64.
+	legacy := base64.StdEncoding.EncodeToString([]byte("legacy value"))
+	require.NoError(t, c.Set(key, legacy, time.Minute).Err())
+	got, err := kv.Retrieve(ctx, key)
+	require.NoError(t, err, "Base64 values should be read.")
+	assert.Equal(t, []byte("legacy value"), got)
+	values, err := kv.RetrieveMany(ctx, []string{key})
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("legacy value")}, values, "Base64 values should be read in batches.")
+	ok, err := kv.CompareAndSwap(ctx, key, keyvalue.VersionOf([]byte("legacy value")), []byte("new value"), time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok, "Base64 values should have the version of their contents.")
 
-	retValue, err := rc.Retrieve(ctx, key)
-	require.NoError(t, err, "Should retrieve value without error.")
-	assert.Equal(t, value, retValue, "Stored and retrieved values should match.")
+	raw, err := c.Get(key).Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{byte(codec.Raw)}, "new value"...), raw, "New values should be stored raw.")
 }
 
-func TestIncrementAndGet(t *testing.T) {
+func TestRedisInvalidator(t *testing.T) {
 	t.Parallel()
 	c := redistest.Connect(t)
-	rc := keyvalue.NewRedisAdapter(c)
+	channel := t.Name() + strconv.FormatInt(time.Now().UnixNano(), 10)
+	publisher, err := keyvalue.NewRedisInvalidator(c, channel)
+	require.NoError(t, err, "Should create publisher without error.")
+	subscriber, err := keyvalue.NewRedisInvalidator(c, channel)
+	require.NoError(t, err, "Should create subscriber without error.")
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	key := t.Name() + randString()
-	var current int64
-	incr := func() {
-		err := rc.IncrementCounter(ctx, key)
-		require.NoError(t, err, "Should increment counter with no error.")
-		current++
+	keys := make(chan string, 2)
+	go func() {
+		_ = subscriber.Subscribe(ctx, func(key string) { keys <- key })
+	}()
+	// Publish until the subscription is ready.
+	for {
+		require.NoError(t, publisher.Publish(ctx, []string{"a key"}), "Should publish without error.")
+		select {
+		case key := <-keys:
+			assert.Equal(t, "a key", key, "Published key should be received.")
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("Published key was not received.")
+		}
 	}
-	get := func() {
-		val, err := rc.GetCounter(ctx, key)
-		require.NoError(t, err, "Should get counter with no error.")
-		require.Equal(t, current, val, "Incremented value should equal retrieved value.")
+}
+
+// benchmarkKeys is the number of keys read by each iteration of the batch
+// benchmarks.
+const benchmarkKeys = 100
+
+// storeBenchmarkKeys stores the keys read by the batch benchmarks.
+func storeBenchmarkKeys(b *testing.B, kv keyvalue.KeyValue) []string {
+	keys := make([]string, benchmarkKeys)
+	entries := make([]keyvalue.Entry, benchmarkKeys)
+	for i := range keys {
+		keys[i] = b.Name() + ":" + strconv.Itoa(i)
+		entries[i] = keyvalue.Entry{Key: keys[i], Data: []byte("value"), Expiration: time.Minute}
 	}
+	require.NoError(b, kv.StoreMany(context.Background(), entries))
+	return keys
+}
+
+// BenchmarkRedisRetrieve reads keys with one round trip each, for comparison
+// with BenchmarkRedisRetrieveMany.
+func BenchmarkRedisRetrieve(b *testing.B) {
+	kv := keyvalue.NewRedisAdapter(redistest.Connect(b))
+	keys := storeBenchmarkKeys(b, kv)
+	ctx := context.Background()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, key := range keys {
+			if _, err := kv.Retrieve(ctx, key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
 
-	incr()
-	get()
-	incr()
-	incr()
-	get()
-	get()
-	incr()
-	get()
+// BenchmarkRedisRetrieveMany reads the same keys as BenchmarkRedisRetrieve in
+// a single round trip.
+func BenchmarkRedisRetrieveMany(b *testing.B) {
+	kv := keyvalue.NewRedisAdapter(redistest.Connect(b))
+	keys := storeBenchmarkKeys(b, kv)
+	ctx := context.Background()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := kv.RetrieveMany(ctx, keys); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRedisStore writes keys with one round trip each, for comparison
+// with BenchmarkRedisStoreMany.
+func BenchmarkRedisStore(b *testing.B) {
+	kv := keyvalue.NewRedisAdapter(redistest.Connect(b))
+	keys := storeBenchmarkKeys(b, kv)
+	ctx := context.Background()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, key := range keys {
+			if err := kv.Store(ctx, key, []byte("value"), time.Minute); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkRedisStoreMany writes the same keys as BenchmarkRedisStore in a
+// single pipeline.
+func BenchmarkRedisStoreMany(b *testing.B) {
+	kv := keyvalue.NewRedisAdapter(redistest.Connect(b))
+	keys := storeBenchmarkKeys(b, kv)
+	entries := make([]keyvalue.Entry, len(keys))
+	for i, key := range keys {
+		entries[i] = keyvalue.Entry{Key: key, Data: []byte("value"), Expiration: time.Minute}
+	}
+	ctx := context.Background()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := kv.StoreMany(ctx, entries); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
 
-// TODO: Add more tests to check for race conditions, higher load, larger
-//  values, larger keys, counter set, etc.
+// TODO: Add tests for higher load and larger keys.
@@ -0,0 +1,422 @@
+package keyvalue
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket that keys are stored in.
+var boltBucket = []byte("keyvalue")
+
+// Kinds of the entries stored by a BoltAdapter.
+const (
+	boltValue byte = iota + 1
+	boltCounter
+)
+
+// boltHeaderSize is the size of the kind and the deadline that start each
+// entry.
+const boltHeaderSize = 1 + 8
+
+// Ensure BoltAdapter implements the KeyValue interface.
+var _ KeyValue = (*BoltAdapter)(nil)
+
+// BoltAdapter stores key value pairs in a bbolt database file, for running
+// without Redis.
+//
+// Each entry is stored with its deadline, so keys expire across restarts.
+// Expired keys are not returned and are deleted by Sweep. Counters behave like
+// Redis counters, see MemoryAdapter.
+//
+// Changes are durable once they return if the database syncs its commits,
+// which bbolt does unless NoSync is set.
+type BoltAdapter struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+// NewBoltAdapter creates a BoltAdapter that stores keys in db.
+func NewBoltAdapter(db *bolt.DB) (*BoltAdapter, error) {
+	if db == nil {
+		panic("nil key value database")
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create key value bucket")
+	}
+	return &BoltAdapter{db: db, now: time.Now}, nil
+}
+
+// boltEntry is a decoded entry.
+type boltEntry struct {
+	kind     byte
+	deadline int64
+	data     []byte
+}
+
+func (e boltEntry) encode() []byte {
+	b := make([]byte, boltHeaderSize+len(e.data))
+	b[0] = e.kind
+	binary.BigEndian.PutUint64(b[1:], uint64(e.deadline))
+	copy(b[boltHeaderSize:], e.data)
+	return b
+}
+
+func (e boltEntry) expired(now time.Time) bool {
+	return e.deadline != 0 && now.UnixNano() >= e.deadline
+}
+
+// get returns the live entry for key. The data of the entry is only valid
+// during the transaction.
+func (b *BoltAdapter) get(tx *bolt.Tx, key string) (boltEntry, bool, error) {
+	v := tx.Bucket(boltBucket).Get([]byte(key))
+	if v == nil {
+		return boltEntry{}, false, nil
+	}
+	if len(v) < boltHeaderSize {
+		return boltEntry{}, false, errors.Errorf("invalid entry for key %q", key)
+	}
+	e := boltEntry{
+		kind:     v[0],
+		deadline: int64(binary.BigEndian.Uint64(v[1:])),
+		data:     v[boltHeaderSize:],
+	}
+	if e.expired(b.now()) {
+		return boltEntry{}, false, nil
+	}
+	return e, true, nil
+}
+
+func (b *BoltAdapter) put(tx *bolt.Tx, key string, e boltEntry) error {
+	return tx.Bucket(boltBucket).Put([]byte(key), e.encode())
+}
+
+// Store stores a key value pair in the database.
+//
+// If expiration is set to 0, then the key will never expire.
+func (b *BoltAdapter) Store(_ context.Context, key string, data []byte, expiration time.Duration) error {
+	if len(key) == 0 {
+		return errors.New("invalid key")
+	}
+	e := boltEntry{kind: boltValue, data: data}
+	if expiration > 0 {
+		e.deadline = b.now().Add(expiration).UnixNano()
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return b.put(tx, key, e)
+	})
+	return errors.Wrapf(err, "unable to store key %q", key)
+}
+
+// Retrieve retrieves the value for a given key. Missing and expired keys have
+// no value.
+func (b *BoltAdapter) Retrieve(_ context.Context, key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("invalid key")
+	}
+	var data []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		data, err = b.retrieve(tx, key)
+		return err
+	})
+	return data, errors.WithStack(err)
+}
+
+// retrieve retrieves the value for a given key in a transaction.
+func (b *BoltAdapter) retrieve(tx *bolt.Tx, key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("invalid key")
+	}
+	e, ok, err := b.get(tx, key)
+	switch {
+	case err != nil || !ok:
+		return nil, err
+	case e.kind != boltValue:
+		return nil, errors.Errorf("key %q holds a counter, not a value", key)
+	}
+	return append([]byte{}, e.data...), nil
+}
+
+// Delete deletes the value or counter for a given key.
+//
+// Deleting a key that does not exist is not an error.
+func (b *BoltAdapter) Delete(_ context.Context, key string) error {
+	if len(key) == 0 {
+		return errors.New("invalid key")
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+	return errors.Wrapf(err, "unable to delete key %q", key)
+}
+
+// RetrieveMany retrieves the values for several keys in one transaction.
+func (b *BoltAdapter) RetrieveMany(_ context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	err := b.db.View(func(tx *bolt.Tx) error {
+		for i, key := range keys {
+			values[i], errs[i] = b.retrieve(tx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve keys")
+	}
+	return values, batchError(errs)
+}
+
+// StoreMany stores several key value pairs in one transaction.
+func (b *BoltAdapter) StoreMany(_ context.Context, entries []Entry) error {
+	errs := make([]error, len(entries))
+	now := b.now()
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for i, entry := range entries {
+			if len(entry.Key) == 0 {
+				errs[i] = errors.New("invalid key")
+				continue
+			}
+			e := boltEntry{kind: boltValue, data: entry.Data}
+			if entry.Expiration > 0 {
+				e.deadline = now.Add(entry.Expiration).UnixNano()
+			}
+			if err := b.put(tx, entry.Key, e); err != nil {
+				return errors.Wrapf(err, "unable to store key %q", entry.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return batchError(errs)
+}
+
+// RetrieveVersion retrieves the value and version for a given key.
+func (b *BoltAdapter) RetrieveVersion(ctx context.Context, key string) ([]byte, Version, error) {
+	data, err := b.Retrieve(ctx, key)
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	return data, versionOf(data), nil
+}
+
+// StoreIfAbsent stores a key value pair if the key is missing or expired.
+func (b *BoltAdapter) StoreIfAbsent(ctx context.Context, key string, data []byte, expiration time.Duration) (bool, error) {
+	return b.CompareAndSwap(ctx, key, NoVersion, data, expiration)
+}
+
+// CompareAndSwap stores a key value pair if the value of the key has the
+// given version.
+func (b *BoltAdapter) CompareAndSwap(_ context.Context, key string, version Version, data []byte, expiration time.Duration) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("invalid key")
+	}
+	e := boltEntry{kind: boltValue, data: data}
+	if expiration > 0 {
+		e.deadline = b.now().Add(expiration).UnixNano()
+	}
+	swapped := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		ok, err := b.matches(tx, key, version)
+		if !ok || err != nil {
+			return err
+		}
+		swapped = true
+		return b.put(tx, key, e)
+	})
+	return swapped, errors.Wrapf(err, "unable to store key %q", key)
+}
+
+// CompareAndDelete deletes a key if its value has the given version.
+func (b *BoltAdapter) CompareAndDelete(_ context.Context, key string, version Version) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("invalid key")
+	}
+	if version == NoVersion {
+		return false, nil
+	}
+	deleted := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		ok, err := b.matches(tx, key, version)
+		if !ok || err != nil {
+			return err
+		}
+		deleted = true
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+	return deleted, errors.Wrapf(err, "unable to delete key %q", key)
+}
+
+// matches reports whether the value of key has the given version.
+func (b *BoltAdapter) matches(tx *bolt.Tx, key string, version Version) (bool, error) {
+	e, ok, err := b.get(tx, key)
+	switch {
+	case err != nil:
+		return false, err
+	case !ok:
+		return version == NoVersion, nil
+	case e.kind != boltValue:
+		return false, errors.Errorf("key %q holds a counter, not a value", key)
+	}
+	return VersionOf(e.data) == version, nil
+}
+
+func counterData(value int64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(value))
+	return data
+}
+
+// SetCounter sets the counter with the given key to value. The counter never
+// expires.
+func (b *BoltAdapter) SetCounter(_ context.Context, key string, value int64) error {
+	if len(key) == 0 {
+		return errors.New("invalid key")
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return b.put(tx, key, boltEntry{kind: boltCounter, data: counterData(value)})
+	})
+	return errors.Wrapf(err, "failed to set number for key: %q", key)
+}
+
+// GetCounter gets the current value of a counter.
+func (b *BoltAdapter) GetCounter(_ context.Context, key string) (int64, error) {
+	if len(key) == 0 {
+		return 0, errors.New("invalid key")
+	}
+	var value int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		e, ok, err := b.get(tx, key)
+		switch {
+		case err != nil:
+			return err
+		case !ok:
+			return errors.Errorf("failed to get number for key: %q", key)
+		case e.kind != boltCounter || len(e.data) != 8:
+			return errors.Errorf("unexpected format or not a number for key %q", key)
+		}
+		value = int64(binary.BigEndian.Uint64(e.data))
+		return nil
+	})
+	return value, errors.WithStack(err)
+}
+
+// IncrementCounter increments the value with the given key.
+//
+// If the key does not exist, it will be initialized to 0 and incremented.
+func (b *BoltAdapter) IncrementCounter(_ context.Context, key string) error {
+	if len(key) == 0 {
+		return errors.New("invalid key")
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		e, ok, err := b.get(tx, key)
+		switch {
+		case err != nil:
+			return err
+		case !ok:
+			e = boltEntry{kind: boltCounter, data: counterData(0)}
+		case e.kind != boltCounter || len(e.data) != 8:
+			return errors.Errorf("not a number for key %q", key)
+		}
+		// The expiration of the counter is kept.
+		e.data = counterData(int64(binary.BigEndian.Uint64(e.data)) + 1)
+		return b.put(tx, key, e)
+	})
+	return errors.Wrapf(err, "failed to increment value for key %q", key)
+}
+
+// Sweep deletes the expired keys and returns the number of deleted keys.
+//
+// The expired keys are found without blocking writes, and are deleted if they
+// are still expired, since they may have been stored again in the meantime.
+func (b *BoltAdapter) Sweep(ctx context.Context) (int, error) {
+	var expired [][]byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		now := b.now()
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if len(v) >= boltHeaderSize && (boltEntry{deadline: int64(binary.BigEndian.Uint64(v[1:]))}).expired(now) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		})
+	})
+	if err != nil || len(expired) == 0 {
+		return 0, errors.Wrap(err, "unable to find expired keys")
+	}
+
+	deleted := 0
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, k := range expired {
+			if _, ok, err := b.get(tx, string(k)); ok || err != nil || bucket.Get(k) == nil {
+				continue
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to delete expired keys")
+	}
+	return deleted, nil
+}
+
+// Keys returns the keys of the values that have not expired, with how long
+// each is kept for, which is 0 for keys that never expire. Counters are left
+// out.
+func (b *BoltAdapter) Keys(ctx context.Context) (map[string]time.Duration, error) {
+	keys := make(map[string]time.Duration)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		now := b.now()
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if len(v) < boltHeaderSize || v[0] != boltValue {
+				return nil
+			}
+			e := boltEntry{deadline: int64(binary.BigEndian.Uint64(v[1:]))}
+			switch {
+			case e.expired(now):
+			case e.deadline == 0:
+				keys[string(k)] = 0
+			default:
+				keys[string(k)] = time.Duration(e.deadline - now.UnixNano())
+			}
+			return nil
+		})
+	})
+	return keys, errors.Wrap(err, "unable to list keys")
+}
+
+// Run sweeps expired keys every interval until the context is done. Errors
+// are passed to onError, and the sweep is retried on the next interval.
+func (b *BoltAdapter) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := b.Sweep(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
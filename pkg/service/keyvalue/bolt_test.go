@@ -0,0 +1,87 @@
+package keyvalue_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue/keyvaluetest"
+)
+
+// openBolt opens a database in a temporary directory, returning the path of
+// the database and a function to remove the directory.
+func openBolt(t *testing.T) (*bolt.DB, string, func()) {
+	dir, err := ioutil.TempDir("", "keyvalue")
+	require.NoError(t, err, "Creating temporary directory should succeed.")
+	path := filepath.Join(dir, "keyvalue.db")
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err, "Opening database should succeed.")
+	return db, path, func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestBoltConformance(t *testing.T) {
+	t.Parallel()
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		db, _, cleanup := openBolt(t)
+		b, err := keyvalue.NewBoltAdapter(db)
+		require.NoError(t, err, "Creating adapter should succeed.")
+		return b, cleanup
+	})
+}
+
+func TestBoltExpiration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db, path, cleanup := openBolt(t)
+	defer cleanup()
+	b, err := keyvalue.NewBoltAdapter(db)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Store(ctx, "short", []byte("a"), 20*time.Millisecond))
+	require.NoError(t, b.Store(ctx, "swept", []byte("b"), 20*time.Millisecond))
+	require.NoError(t, b.Store(ctx, "forever", []byte("c"), 0))
+	require.NoError(t, b.IncrementCounter(ctx, "counter"))
+	keys, err := b.Keys(ctx)
+	require.NoError(t, err, "Listing keys should succeed.")
+	assert.Len(t, keys, 3, "Counters should not be listed.")
+	assert.Zero(t, keys["forever"], "Keys that never expire should have no expiration.")
+	assert.True(t, keys["short"] > 0 && keys["short"] <= 20*time.Millisecond, "Keys should have their remaining expiration.")
+
+	time.Sleep(30 * time.Millisecond)
+	v, err := b.Retrieve(ctx, "short")
+	require.NoError(t, err)
+	assert.Nil(t, v, "Expired key should have no value.")
+	deleted, err := b.Sweep(ctx)
+	require.NoError(t, err, "Sweeping should succeed.")
+	assert.Equal(t, 2, deleted, "Only the expired keys should be deleted.")
+
+	// Keys and their deadlines survive a restart.
+	require.NoError(t, b.Store(ctx, "later", []byte("d"), 20*time.Millisecond))
+	require.NoError(t, db.Close())
+	db, err = bolt.Open(path, 0600, nil)
+	require.NoError(t, err, "Reopening database should succeed.")
+	defer func() {
+		_ = db.Close()
+	}()
+	b, err = keyvalue.NewBoltAdapter(db)
+	require.NoError(t, err)
+	v, _ = b.Retrieve(ctx, "forever")
+	assert.Equal(t, []byte("c"), v, "Keys should survive a restart.")
+	n, err := b.GetCounter(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "Counters should survive a restart.")
+	time.Sleep(30 * time.Millisecond)
+	v, _ = b.Retrieve(ctx, "later")
+	assert.Nil(t, v, "Keys should expire after a restart.")
+}
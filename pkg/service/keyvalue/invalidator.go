@@ -0,0 +1,85 @@
+package keyvalue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redisclient"
+)
+
+// Ensure RedisInvalidator implements the Invalidator interface.
+var _ Invalidator = (*RedisInvalidator)(nil)
+
+// RedisInvalidator publishes changed keys on a Redis pub/sub channel.
+//
+// Each message is the ID of the publishing process and a key, separated by a
+// space, so that processes ignore their own invalidations. The messages for
+// several keys are sent in one pipeline.
+type RedisInvalidator struct {
+	c       redis.UniversalClient
+	channel string
+	origin  string
+}
+
+// NewRedisInvalidator creates a RedisInvalidator that publishes on channel.
+func NewRedisInvalidator(c redis.UniversalClient, channel string) (*RedisInvalidator, error) {
+	if c == nil {
+		panic("nil invalidator client")
+	}
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, errors.Wrap(err, "unable to create invalidator ID")
+	}
+	return &RedisInvalidator{
+		c:       c,
+		channel: channel,
+		origin:  hex.EncodeToString(origin),
+	}, nil
+}
+
+// Publish publishes that keys changed.
+func (r *RedisInvalidator) Publish(ctx context.Context, keys []string) error {
+	_, err := redisclient.WithContext(ctx, r.c).Pipelined(func(p redis.Pipeliner) error {
+		for _, key := range keys {
+			p.Publish(r.channel, r.origin+" "+key)
+		}
+		return nil
+	})
+	return errors.Wrap(err, "unable to publish to Redis")
+}
+
+// Subscribe calls invalidate with the keys published by other processes until
+// the context is done.
+//
+// Keys that are published while the subscription is reconnecting are missed,
+// so values must also expire from the caches that are invalidated.
+func (r *RedisInvalidator) Subscribe(ctx context.Context, invalidate func(key string)) error {
+	ps := r.c.Subscribe(r.channel)
+	defer func() {
+		_ = ps.Close()
+	}()
+	// Wait for the subscription so that no later invalidations are missed.
+	if _, err := ps.Receive(); err != nil {
+		return errors.Wrap(err, "unable to subscribe to Redis")
+	}
+	messages := ps.Channel()
+	for {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				return errors.New("Redis subscription closed")
+			}
+			parts := strings.SplitN(m.Payload, " ", 2)
+			if len(parts) == 2 && parts[0] != r.origin {
+				invalidate(parts[1])
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -4,6 +4,9 @@ package keyvalue
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
 	"time"
 )
 
@@ -12,8 +15,93 @@ import (
 type KeyValue interface {
 	Store(ctx context.Context, key string, data []byte, expiration time.Duration) error
 	Retrieve(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+
+	// RetrieveVersion retrieves the value for a key and its version. Missing
+	// keys have no value and NoVersion.
+	RetrieveVersion(ctx context.Context, key string) ([]byte, Version, error)
+	// StoreIfAbsent stores a key value pair if the key has no value, and
+	// reports whether it was stored.
+	StoreIfAbsent(ctx context.Context, key string, data []byte, expiration time.Duration) (bool, error)
+	// CompareAndSwap stores a key value pair if the value of the key still
+	// has the given version, and reports whether it was stored. NoVersion
+	// only matches a missing key.
+	CompareAndSwap(ctx context.Context, key string, version Version, data []byte, expiration time.Duration) (bool, error)
+	// CompareAndDelete deletes a key if its value still has the given
+	// version, and reports whether it was deleted.
+	CompareAndDelete(ctx context.Context, key string, version Version) (bool, error)
+
+	// RetrieveMany retrieves the values for several keys, in the order of
+	// the keys, in as few round trips as the store allows. Missing keys have
+	// no value. Errors of single keys are returned as a BatchError.
+	RetrieveMany(ctx context.Context, keys []string) ([][]byte, error)
+	// StoreMany stores several key value pairs in as few round trips as the
+	// store allows. The pairs are not stored atomically, and errors of single
+	// pairs are returned as a BatchError.
+	StoreMany(ctx context.Context, entries []Entry) error
 
 	SetCounter(ctx context.Context, key string, value int64) error
 	GetCounter(ctx context.Context, key string) (int64, error)
 	IncrementCounter(ctx context.Context, key string) error
 }
+
+// Entry is a key value pair stored by StoreMany.
+type Entry struct {
+	Key  string
+	Data []byte
+	// Expiration is how long the pair is kept for. It is never deleted if
+	// Expiration is 0.
+	Expiration time.Duration
+}
+
+// BatchError holds the errors of a batch operation by the index of their key.
+// Keys that succeeded have a nil error.
+type BatchError []error
+
+func (e BatchError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d keys failed, first: %v", failed, len(e), first)
+}
+
+// batchError returns errs as a BatchError if any of them is not nil.
+func batchError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return BatchError(errs)
+		}
+	}
+	return nil
+}
+
+// Version identifies a value for compare-and-swap.
+//
+// Versions are derived from the contents of values, so they are the same in
+// every store, and a value that is changed back to what it was has its
+// earlier version again.
+type Version string
+
+// NoVersion is the version of a missing key.
+const NoVersion Version = ""
+
+// VersionOf returns the version of a value.
+func VersionOf(data []byte) Version {
+	sum := sha256.Sum256(data)
+	return Version(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
+
+// versionOf returns the version of a value that may be missing.
+func versionOf(data []byte) Version {
+	if data == nil {
+		return NoVersion
+	}
+	return VersionOf(data)
+}
@@ -0,0 +1,428 @@
+// Package keyvaluetest implements a conformance test suite for
+// implementations of keyvalue.KeyValue.
+//
+// Every implementation should pass it to behave like the Redis adapter that
+// the services were written against:
+//
+//	func TestConformance(t *testing.T) {
+//		keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+//			return NewAdapter(), func() {}
+//		})
+//	}
+package keyvaluetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// Factory returns a KeyValue to test and a function that releases it.
+//
+// Tests may share a store, such as a Redis database, since every test uses
+// keys of its own.
+type Factory func(t *testing.T) (keyvalue.KeyValue, func())
+
+// Timeout is how long each test may take.
+const Timeout = 10 * time.Second
+
+// LargeValueSize is the size of the large value that must be stored.
+const LargeValueSize = 4 << 20
+
+var (
+	seedOnce sync.Once
+	randMu   sync.Mutex
+)
+
+// prefix returns a prefix for the keys of a test.
+func prefix(t *testing.T) string {
+	seedOnce.Do(func() { rand.Seed(time.Now().UnixNano()) })
+	randMu.Lock()
+	defer randMu.Unlock()
+	return t.Name() + ":" + strconv.Itoa(rand.Int()) + ":"
+}
+
+// Run runs the conformance tests as subtests of t.
+func Run(t *testing.T, newKeyValue Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string)
+	}{
+		{"StoreRetrieve", testStoreRetrieve},
+		{"Replace", testReplace},
+		{"Missing", testMissing},
+		{"Empty", testEmpty},
+		{"InvalidKey", testInvalidKey},
+		{"Delete", testDelete},
+		{"Expiration", testExpiration},
+		{"Counters", testCounters},
+		{"CounterTypes", testCounterTypes},
+		{"ConcurrentCounters", testConcurrentCounters},
+		{"ConcurrentValues", testConcurrentValues},
+		{"LargeValue", testLargeValue},
+		{"StoreIfAbsent", testStoreIfAbsent},
+		{"CompareAndSwap", testCompareAndSwap},
+		{"CompareAndDelete", testCompareAndDelete},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"RetrieveMany", testRetrieveMany},
+		{"StoreMany", testStoreMany},
+		{"BatchErrors", testBatchErrors},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			kv, cleanup := newKeyValue(t)
+			defer cleanup()
+			ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+			defer cancel()
+			test.test(t, ctx, kv, prefix(t))
+		})
+	}
+}
+
+func testStoreRetrieve(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	value := []byte("123")
+	require.NoError(t, kv.Store(ctx, key, value, time.Minute), "Should store value without error.")
+	for i := 0; i < 2; i++ {
+		got, err := kv.Retrieve(ctx, key)
+		require.NoError(t, err, "Should retrieve value without error.")
+		assert.Equal(t, value, got, "Stored and retrieved values should match, and retrieving should not consume the value.")
+	}
+}
+
+func testReplace(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	require.NoError(t, kv.Store(ctx, key, []byte("first"), 0))
+	require.NoError(t, kv.Store(ctx, key, []byte("second"), 0))
+	got, err := kv.Retrieve(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), got, "Storing should replace the value.")
+	require.NoError(t, kv.Delete(ctx, key))
+}
+
+func testMissing(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	start := time.Now()
+	got, err := kv.Retrieve(ctx, key)
+	require.NoError(t, err, "Retrieving a missing key should not error.")
+	assert.Nil(t, got, "Missing key should have no value.")
+	assert.True(t, time.Since(start) < time.Second, "Retrieving a missing key should not block.")
+}
+
+func testEmpty(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	require.NoError(t, kv.Store(ctx, key, []byte{}, time.Minute))
+	got, err := kv.Retrieve(ctx, key)
+	require.NoError(t, err)
+	assert.NotNil(t, got, "Empty value should be told apart from a missing key.")
+	assert.Empty(t, got, "Empty value should be empty.")
+}
+
+func testInvalidKey(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, _ string) {
+	assert.Error(t, kv.Store(ctx, "", []byte("value"), 0), "Empty key should not be stored.")
+	_, err := kv.Retrieve(ctx, "")
+	assert.Error(t, err, "Empty key should not be retrieved.")
+	assert.Error(t, kv.IncrementCounter(ctx, ""), "Empty key should not be incremented.")
+}
+
+func testDelete(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	require.NoError(t, kv.Store(ctx, key, []byte("123"), time.Minute))
+	require.NoError(t, kv.Delete(ctx, key), "Should delete value without error.")
+	got, err := kv.Retrieve(ctx, key)
+	require.NoError(t, err, "Should retrieve deleted value without error.")
+	assert.Nil(t, got, "Deleted value should not be retrieved.")
+	assert.NoError(t, kv.Delete(ctx, key), "Deleting missing key should not error.")
+
+	require.NoError(t, kv.SetCounter(ctx, key, 1))
+	require.NoError(t, kv.Delete(ctx, key), "Should delete counter without error.")
+	_, err = kv.GetCounter(ctx, key)
+	assert.Error(t, err, "Deleted counter should be missing.")
+}
+
+func testExpiration(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	require.NoError(t, kv.Store(ctx, key+"short", []byte("a"), 100*time.Millisecond))
+	require.NoError(t, kv.Store(ctx, key+"long", []byte("b"), time.Minute))
+	require.NoError(t, kv.Store(ctx, key+"forever", []byte("c"), 0))
+	got, err := kv.Retrieve(ctx, key+"short")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), got, "Key should be retrieved before it expires.")
+
+	time.Sleep(200 * time.Millisecond)
+	got, err = kv.Retrieve(ctx, key+"short")
+	require.NoError(t, err)
+	assert.Nil(t, got, "Expired key should have no value.")
+	got, _ = kv.Retrieve(ctx, key+"long")
+	assert.Equal(t, []byte("b"), got, "Key should not expire early.")
+	got, _ = kv.Retrieve(ctx, key+"forever")
+	assert.Equal(t, []byte("c"), got, "Key without expiration should be kept.")
+
+	// Storing again restarts the expiration.
+	require.NoError(t, kv.Store(ctx, key+"long", []byte("b"), 100*time.Millisecond))
+	require.NoError(t, kv.Store(ctx, key+"long", []byte("b"), 0))
+	time.Sleep(200 * time.Millisecond)
+	got, _ = kv.Retrieve(ctx, key+"long")
+	assert.Equal(t, []byte("b"), got, "Storing without expiration should remove the expiration.")
+
+	for _, k := range []string{"long", "forever"} {
+		require.NoError(t, kv.Delete(ctx, key+k))
+	}
+}
+
+func testCounters(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	_, err := kv.GetCounter(ctx, key)
+	assert.Error(t, err, "Getting a missing counter should error.")
+
+	require.NoError(t, kv.IncrementCounter(ctx, key), "Should increment missing counter without error.")
+	n, err := kv.GetCounter(ctx, key)
+	require.NoError(t, err, "Should get counter with no error.")
+	assert.Equal(t, int64(1), n, "Incrementing a missing counter should set it to 1.")
+
+	require.NoError(t, kv.SetCounter(ctx, key, -5))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, kv.IncrementCounter(ctx, key))
+	}
+	n, err = kv.GetCounter(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-2), n, "Incremented value should equal retrieved value.")
+	require.NoError(t, kv.Delete(ctx, key))
+}
+
+func testCounterTypes(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	require.NoError(t, kv.Store(ctx, key, []byte("not a number"), time.Minute))
+	assert.Error(t, kv.IncrementCounter(ctx, key), "Values should not be incremented.")
+	_, err := kv.GetCounter(ctx, key)
+	assert.Error(t, err, "Values should not be read as counters.")
+
+	// Storing a value replaces a counter.
+	require.NoError(t, kv.SetCounter(ctx, key, 1))
+	require.NoError(t, kv.Store(ctx, key, []byte("value"), time.Minute))
+	got, err := kv.Retrieve(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), got, "Storing should replace a counter.")
+}
+
+func testConcurrentCounters(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	const workers, increments = 10, 20
+	require.NoError(t, kv.SetCounter(ctx, key, 0))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				assert.NoError(t, kv.IncrementCounter(ctx, key))
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := kv.GetCounter(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*increments), n, "Concurrent increments should not be lost.")
+	require.NoError(t, kv.Delete(ctx, key))
+}
+
+func testConcurrentValues(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	const workers = 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			k := fmt.Sprintf("%s%d", key, w)
+			for i := 0; i < 10; i++ {
+				value := []byte(strconv.Itoa(i))
+				if !assert.NoError(t, kv.Store(ctx, k, value, time.Minute)) {
+					return
+				}
+				got, err := kv.Retrieve(ctx, k)
+				assert.NoError(t, err)
+				assert.Equal(t, value, got, "Concurrent writers to other keys should not interfere.")
+			}
+			assert.NoError(t, kv.Delete(ctx, k))
+		}(w)
+	}
+	wg.Wait()
+}
+
+func testLargeValue(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	value := bytes.Repeat([]byte{0, 1, 2, 253, 254, 255}, LargeValueSize/6)
+	require.NoError(t, kv.Store(ctx, key, value, time.Minute), "Should store large value without error.")
+	got, err := kv.Retrieve(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(value, got), "Large binary value should be retrieved intact.")
+	require.NoError(t, kv.Delete(ctx, key))
+}
+
+func testStoreIfAbsent(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	ok, err := kv.StoreIfAbsent(ctx, key, []byte("a"), 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, ok, "Missing key should be stored.")
+	ok, err = kv.StoreIfAbsent(ctx, key, []byte("b"), 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "Existing key should not be stored.")
+	got, _ := kv.Retrieve(ctx, key)
+	assert.Equal(t, []byte("a"), got, "Existing value should be kept.")
+
+	time.Sleep(200 * time.Millisecond)
+	ok, err = kv.StoreIfAbsent(ctx, key, []byte("c"), 0)
+	require.NoError(t, err)
+	assert.True(t, ok, "Expired key should be stored.")
+	got, _ = kv.Retrieve(ctx, key)
+	assert.Equal(t, []byte("c"), got)
+	require.NoError(t, kv.Delete(ctx, key))
+}
+
+func testCompareAndSwap(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	data, version, err := kv.RetrieveVersion(ctx, key)
+	require.NoError(t, err)
+	assert.Nil(t, data, "Missing key should have no value.")
+	assert.Equal(t, keyvalue.NoVersion, version, "Missing key should have no version.")
+
+	ok, err := kv.CompareAndSwap(ctx, key, keyvalue.VersionOf([]byte("a")), []byte("b"), 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "Missing key should not match a version.")
+	ok, err = kv.CompareAndSwap(ctx, key, keyvalue.NoVersion, []byte("a"), 0)
+	require.NoError(t, err)
+	assert.True(t, ok, "Missing key should match no version.")
+
+	data, version, err = kv.RetrieveVersion(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), data)
+	assert.Equal(t, keyvalue.VersionOf([]byte("a")), version, "Version should be derived from the value.")
+
+	ok, err = kv.CompareAndSwap(ctx, key, version, []byte("b"), 0)
+	require.NoError(t, err)
+	assert.True(t, ok, "Current version should match.")
+	ok, err = kv.CompareAndSwap(ctx, key, version, []byte("c"), 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "Stale version should not match.")
+	ok, err = kv.CompareAndSwap(ctx, key, keyvalue.NoVersion, []byte("c"), 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "Existing key should not match no version.")
+	got, _ := kv.Retrieve(ctx, key)
+	assert.Equal(t, []byte("b"), got, "Failed swaps should not change the value.")
+
+	_, version, _ = kv.RetrieveVersion(ctx, key)
+	ok, err = kv.CompareAndSwap(ctx, key, version, []byte("c"), 100*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok)
+	time.Sleep(200 * time.Millisecond)
+	got, _ = kv.Retrieve(ctx, key)
+	assert.Nil(t, got, "Swapped value should expire.")
+}
+
+func testCompareAndDelete(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	ok, err := kv.CompareAndDelete(ctx, key, keyvalue.NoVersion)
+	require.NoError(t, err)
+	assert.False(t, ok, "Missing key should not be deleted.")
+
+	require.NoError(t, kv.Store(ctx, key, []byte("a"), 0))
+	ok, err = kv.CompareAndDelete(ctx, key, keyvalue.VersionOf([]byte("b")))
+	require.NoError(t, err)
+	assert.False(t, ok, "Stale version should not match.")
+	got, _ := kv.Retrieve(ctx, key)
+	assert.Equal(t, []byte("a"), got, "Key should be kept.")
+
+	ok, err = kv.CompareAndDelete(ctx, key, keyvalue.VersionOf([]byte("a")))
+	require.NoError(t, err)
+	assert.True(t, ok, "Current version should match.")
+	got, _ = kv.Retrieve(ctx, key)
+	assert.Nil(t, got, "Key should be deleted.")
+}
+
+func testConcurrentUpdates(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	const workers, increments = 5, 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				err := keyvalue.Update(ctx, kv, key, 0, func(data []byte) ([]byte, error) {
+					n, _ := strconv.Atoi(string(data))
+					return []byte(strconv.Itoa(n + 1)), nil
+				})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := kv.Retrieve(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(workers*increments), string(got), "Concurrent updates should not be lost.")
+
+	err = keyvalue.Update(ctx, kv, key, 0, func([]byte) ([]byte, error) {
+		return nil, keyvalue.ErrUnchanged
+	})
+	require.NoError(t, err)
+	got, _ = kv.Retrieve(ctx, key)
+	assert.Equal(t, strconv.Itoa(workers*increments), string(got), "Unchanged values should be kept.")
+
+	err = keyvalue.Update(ctx, kv, key, 0, func([]byte) ([]byte, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	got, _ = kv.Retrieve(ctx, key)
+	assert.Nil(t, got, "Updating to nil should delete the key.")
+}
+
+func testRetrieveMany(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	require.NoError(t, kv.Store(ctx, key+"a", []byte("a"), 0))
+	require.NoError(t, kv.Store(ctx, key+"c", []byte("c"), 0))
+	got, err := kv.RetrieveMany(ctx, []string{key + "c", key + "b", key + "a", key + "c"})
+	require.NoError(t, err, "Should retrieve values without error.")
+	assert.Equal(t, [][]byte{[]byte("c"), nil, []byte("a"), []byte("c")}, got,
+		"Values should be in the order of the keys, with nil for missing keys.")
+
+	got, err = kv.RetrieveMany(ctx, nil)
+	require.NoError(t, err, "Should retrieve no keys without error.")
+	assert.Empty(t, got)
+}
+
+func testStoreMany(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	require.NoError(t, kv.Store(ctx, key+"b", []byte("old"), 0))
+	err := kv.StoreMany(ctx, []keyvalue.Entry{
+		{Key: key + "a", Data: []byte("a")},
+		{Key: key + "b", Data: []byte("b"), Expiration: time.Minute},
+		{Key: key + "short", Data: []byte("short"), Expiration: 100 * time.Millisecond},
+	})
+	require.NoError(t, err, "Should store values without error.")
+	got, err := kv.RetrieveMany(ctx, []string{key + "a", key + "b", key + "short"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("short")}, got, "Stored values should be retrieved.")
+
+	time.Sleep(200 * time.Millisecond)
+	got, err = kv.RetrieveMany(ctx, []string{key + "a", key + "b", key + "short"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), nil}, got, "Only the expired value should be missing.")
+
+	assert.NoError(t, kv.StoreMany(ctx, nil), "Should store no values without error.")
+}
+
+func testBatchErrors(t *testing.T, ctx context.Context, kv keyvalue.KeyValue, key string) {
+	err := kv.StoreMany(ctx, []keyvalue.Entry{
+		{Key: key, Data: []byte("a")},
+		{Key: "", Data: []byte("b")},
+	})
+	require.IsType(t, keyvalue.BatchError{}, err, "Invalid key should fail alone.")
+	errs := err.(keyvalue.BatchError)
+	require.Len(t, errs, 2, "Errors should be returned by the index of their key.")
+	assert.NoError(t, errs[0], "Valid key should be stored.")
+	assert.Error(t, errs[1], "Empty key should not be stored.")
+
+	got, err := kv.RetrieveMany(ctx, []string{"", key})
+	require.IsType(t, keyvalue.BatchError{}, err, "Invalid key should fail alone.")
+	errs = err.(keyvalue.BatchError)
+	require.Len(t, errs, 2, "Errors should be returned by the index of their key.")
+	assert.Error(t, errs[0], "Empty key should not be retrieved.")
+	assert.NoError(t, errs[1], "Valid key should be retrieved.")
+	require.Len(t, got, 2)
+	assert.Equal(t, []byte("a"), got[1], "Values of valid keys should be returned.")
+}
@@ -0,0 +1,450 @@
+package keyvalue
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// counterSize is the number of bytes that a counter is accounted for, in
+// addition to its key.
+const counterSize = 8
+
+// ErrFull is returned by writes to a MemoryAdapter that would exceed its
+// MaxBytes with keys that can't be evicted.
+var ErrFull = errors.New("memory limit reached")
+
+// Ensure MemoryAdapter implements the KeyValue interface.
+var _ KeyValue = (*MemoryAdapter)(nil)
+
+// MemoryConfig contains the configuration for a MemoryAdapter.
+type MemoryConfig struct {
+	// MaxBytes is the number of bytes of keys and values that are kept before
+	// the least recently used values are evicted. If it is 0, nothing is
+	// evicted.
+	MaxBytes int64
+	// Durable is set when the adapter is the primary store rather than a
+	// cache. Values that never expire are then not evicted, like counters.
+	Durable bool
+	// CounterExpiration is how long a counter is kept after it is set, so
+	// that counters that are never deleted do not fill the memory. Counters
+	// never expire if it is 0.
+	CounterExpiration time.Duration
+}
+
+// memoryEntry is a value or a counter stored in a MemoryAdapter.
+type memoryEntry struct {
+	key     string
+	value   []byte
+	counter int64
+	// isCounter is set for counters, which are never evicted since they
+	// coordinate jobs between workers.
+	isCounter bool
+	// deadline is when the entry expires. It is zero if the entry never
+	// expires.
+	deadline time.Time
+}
+
+func (e *memoryEntry) size() int64 {
+	if e.isCounter {
+		return int64(len(e.key)) + counterSize
+	}
+	return int64(len(e.key) + len(e.value))
+}
+
+// evictable returns whether the entry can be evicted from an adapter.
+func (e *memoryEntry) evictable(durable bool) bool {
+	return !e.isCounter && !(durable && e.deadline.IsZero())
+}
+
+// MemoryAdapter stores key value pairs in memory, for running without Redis
+// or as a cache in front of it.
+//
+// Keys expire like they do in Redis, and counters behave like Redis counters:
+// incrementing a missing counter sets it to 1, incrementing keeps the
+// expiration of a counter, and values cannot be used as counters or counters
+// as values. Expired keys are removed when they are read or by Sweep.
+//
+// Writes fail with ErrFull if the keys that can't be evicted leave no room
+// for them.
+type MemoryAdapter struct {
+	maxBytes   int64
+	durable    bool
+	counterExp time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries with the most recently used at the front.
+	lru   *list.List
+	bytes int64
+	// pinned is the number of bytes of the entries that can't be evicted.
+	pinned int64
+}
+
+// NewMemoryAdapter creates an empty MemoryAdapter.
+func NewMemoryAdapter(conf MemoryConfig) *MemoryAdapter {
+	return &MemoryAdapter{
+		maxBytes:   conf.MaxBytes,
+		durable:    conf.Durable,
+		counterExp: conf.CounterExpiration,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// get returns the entry for key, removing it if it has expired.
+//
+// The caller must hold m.mu.
+func (m *MemoryAdapter) get(key string) *memoryEntry {
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*memoryEntry)
+	if !e.deadline.IsZero() && !m.now().Before(e.deadline) {
+		m.remove(elem)
+		return nil
+	}
+	m.lru.MoveToFront(elem)
+	return e
+}
+
+// set replaces the entry for its key. The entry is not set, and nothing is
+// evicted, if the entries that can't be evicted leave no room for it.
+//
+// The caller must hold m.mu.
+func (m *MemoryAdapter) set(e *memoryEntry) error {
+	elem, replaced := m.entries[e.key]
+	if m.maxBytes > 0 {
+		pinned := m.pinned
+		if replaced {
+			if old := elem.Value.(*memoryEntry); !old.evictable(m.durable) {
+				pinned -= old.size()
+			}
+		}
+		if pinned+e.size() > m.maxBytes {
+			return errors.Wrapf(ErrFull, "unable to store key %q", e.key)
+		}
+	}
+	if replaced {
+		m.remove(elem)
+	}
+	m.entries[e.key] = m.lru.PushFront(e)
+	m.bytes += e.size()
+	if !e.evictable(m.durable) {
+		m.pinned += e.size()
+	}
+	m.evict()
+	return nil
+}
+
+// remove removes an entry.
+//
+// The caller must hold m.mu.
+func (m *MemoryAdapter) remove(elem *list.Element) {
+	e := m.lru.Remove(elem).(*memoryEntry)
+	delete(m.entries, e.key)
+	m.bytes -= e.size()
+	if !e.evictable(m.durable) {
+		m.pinned -= e.size()
+	}
+}
+
+// evict removes the least recently used values that can be evicted until the
+// entries fit in MaxBytes.
+//
+// The caller must hold m.mu.
+func (m *MemoryAdapter) evict() {
+	if m.maxBytes <= 0 {
+		return
+	}
+	for elem := m.lru.Back(); elem != nil && m.bytes > m.maxBytes; {
+		prev := elem.Prev()
+		if elem.Value.(*memoryEntry).evictable(m.durable) {
+			m.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+// deadline returns the deadline for an expiration, which is zero for keys
+// that never expire.
+func (m *MemoryAdapter) deadline(expiration time.Duration) time.Time {
+	if expiration <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(expiration)
+}
+
+// Store stores a key value pair in memory.
+//
+// If expiration is set to 0, then the key will never expire.
+func (m *MemoryAdapter) Store(_ context.Context, key string, data []byte, expiration time.Duration) error {
+	e, err := m.newEntry(key, data, expiration)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.set(e)
+}
+
+// newEntry returns the entry for a key value pair, if it can be stored.
+func (m *MemoryAdapter) newEntry(key string, data []byte, expiration time.Duration) (*memoryEntry, error) {
+	if len(key) == 0 {
+		return nil, errors.New("invalid key")
+	}
+	e := &memoryEntry{
+		key:      key,
+		value:    append([]byte{}, data...),
+		deadline: m.deadline(expiration),
+	}
+	if m.maxBytes > 0 && e.size() > m.maxBytes {
+		return nil, errors.Errorf("value for key %q is larger than the memory limit of %d bytes", key, m.maxBytes)
+	}
+	return e, nil
+}
+
+// Retrieve retrieves the value for a given key. Missing and expired keys have
+// no value.
+func (m *MemoryAdapter) Retrieve(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.retrieve(key)
+}
+
+// retrieve retrieves the value for a given key.
+//
+// The caller must hold m.mu.
+func (m *MemoryAdapter) retrieve(key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("invalid key")
+	}
+	e := m.get(key)
+	if e == nil {
+		return nil, nil
+	}
+	if e.isCounter {
+		return nil, errors.Errorf("key %q holds a counter, not a value", key)
+	}
+	return append([]byte{}, e.value...), nil
+}
+
+// Delete deletes the value or counter for a given key.
+//
+// Deleting a key that does not exist is not an error.
+func (m *MemoryAdapter) Delete(_ context.Context, key string) error {
+	if len(key) == 0 {
+		return errors.New("invalid key")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	return nil
+}
+
+// RetrieveMany retrieves the values for several keys.
+func (m *MemoryAdapter) RetrieveMany(_ context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, key := range keys {
+		values[i], errs[i] = m.retrieve(key)
+	}
+	return values, batchError(errs)
+}
+
+// StoreMany stores several key value pairs.
+func (m *MemoryAdapter) StoreMany(_ context.Context, entries []Entry) error {
+	errs := make([]error, len(entries))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range entries {
+		entry, err := m.newEntry(e.Key, e.Data, e.Expiration)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = m.set(entry)
+	}
+	return batchError(errs)
+}
+
+// RetrieveVersion retrieves the value and version for a given key.
+func (m *MemoryAdapter) RetrieveVersion(ctx context.Context, key string) ([]byte, Version, error) {
+	data, err := m.Retrieve(ctx, key)
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	return data, versionOf(data), nil
+}
+
+// StoreIfAbsent stores a key value pair if the key is missing or expired.
+func (m *MemoryAdapter) StoreIfAbsent(_ context.Context, key string, data []byte, expiration time.Duration) (bool, error) {
+	return m.compareAndSet(key, NoVersion, data, expiration)
+}
+
+// CompareAndSwap stores a key value pair if the value of the key has the
+// given version.
+func (m *MemoryAdapter) CompareAndSwap(_ context.Context, key string, version Version, data []byte, expiration time.Duration) (bool, error) {
+	return m.compareAndSet(key, version, data, expiration)
+}
+
+// compareAndSet stores a key value pair if the value of the key has the given
+// version.
+func (m *MemoryAdapter) compareAndSet(key string, version Version, data []byte, expiration time.Duration) (bool, error) {
+	e, err := m.newEntry(key, data, expiration)
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok, err := m.matches(key, version)
+	if !ok || err != nil {
+		return false, err
+	}
+	if err := m.set(e); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete deletes a key if its value has the given version.
+func (m *MemoryAdapter) CompareAndDelete(_ context.Context, key string, version Version) (bool, error) {
+	if len(key) == 0 {
+		return false, errors.New("invalid key")
+	}
+	if version == NoVersion {
+		return false, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok, err := m.matches(key, version)
+	if !ok || err != nil {
+		return false, err
+	}
+	m.remove(m.entries[key])
+	return true, nil
+}
+
+// matches reports whether the value of key has the given version.
+//
+// The caller must hold m.mu.
+func (m *MemoryAdapter) matches(key string, version Version) (bool, error) {
+	e := m.get(key)
+	switch {
+	case e == nil:
+		return version == NoVersion, nil
+	case e.isCounter:
+		return false, errors.Errorf("key %q holds a counter, not a value", key)
+	}
+	return VersionOf(e.value) == version, nil
+}
+
+// SetCounter sets the counter with the given key to value. The counter
+// expires after CounterExpiration, if it is set.
+func (m *MemoryAdapter) SetCounter(_ context.Context, key string, value int64) error {
+	if len(key) == 0 {
+		return errors.New("invalid key")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.set(m.newCounter(key, value))
+}
+
+// newCounter returns the entry for a counter that is set to value.
+func (m *MemoryAdapter) newCounter(key string, value int64) *memoryEntry {
+	return &memoryEntry{key: key, counter: value, isCounter: true, deadline: m.deadline(m.counterExp)}
+}
+
+// GetCounter gets the current value of a counter.
+func (m *MemoryAdapter) GetCounter(_ context.Context, key string) (int64, error) {
+	if len(key) == 0 {
+		return 0, errors.New("invalid key")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	switch {
+	case e == nil:
+		return 0, errors.Errorf("failed to get number for key: %q", key)
+	case !e.isCounter:
+		return 0, errors.Errorf("unexpected format or not a number for key %q", key)
+	}
+	return e.counter, nil
+}
+
+// IncrementCounter increments the value with the given key.
+//
+// If the key does not exist, it will be initialized to 0 and incremented.
+func (m *MemoryAdapter) IncrementCounter(_ context.Context, key string) error {
+	if len(key) == 0 {
+		return errors.New("invalid key")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key)
+	switch {
+	case e == nil:
+		return m.set(m.newCounter(key, 1))
+	case !e.isCounter:
+		return errors.Errorf("failed to increment value for key %q: not a number", key)
+	}
+	e.counter++
+	return nil
+}
+
+// Len returns the number of keys, including expired keys that have not been
+// removed yet.
+func (m *MemoryAdapter) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Bytes returns the number of bytes of the keys and values.
+func (m *MemoryAdapter) Bytes() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bytes
+}
+
+// Sweep removes the expired keys and returns the number of removed keys.
+func (m *MemoryAdapter) Sweep() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	removed := 0
+	for elem := m.lru.Front(); elem != nil; {
+		next := elem.Next()
+		e := elem.Value.(*memoryEntry)
+		if !e.deadline.IsZero() && !now.Before(e.deadline) {
+			m.remove(elem)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+// Run sweeps expired keys every interval until the context is done.
+func (m *MemoryAdapter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
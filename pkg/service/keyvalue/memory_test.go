@@ -0,0 +1,198 @@
+package keyvalue_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue/keyvaluetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	t.Parallel()
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		return keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}), func() {}
+	})
+}
+
+func TestMemoryStoreRetrieve(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	m := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+
+	v, err := m.Retrieve(ctx, "missing")
+	require.NoError(t, err, "Retrieving a missing key should succeed.")
+	assert.Nil(t, v, "Missing key should have no value.")
+
+	data := []byte("value")
+	require.NoError(t, m.Store(ctx, "key", data, 0))
+	data[0] = 'X'
+	v, err = m.Retrieve(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), v, "Stored value should not be changed by the caller.")
+	v[0] = 'X'
+	v, _ = m.Retrieve(ctx, "key")
+	assert.Equal(t, []byte("value"), v, "Retrieving should not consume or share the value.")
+
+	require.NoError(t, m.Store(ctx, "key", []byte("other"), 0))
+	v, _ = m.Retrieve(ctx, "key")
+	assert.Equal(t, []byte("other"), v, "Storing should replace the value.")
+
+	require.NoError(t, m.Delete(ctx, "key"))
+	require.NoError(t, m.Delete(ctx, "key"), "Deleting a missing key should succeed.")
+	v, _ = m.Retrieve(ctx, "key")
+	assert.Nil(t, v, "Deleted key should have no value.")
+
+	assert.Error(t, m.Store(ctx, "", data, 0), "Empty keys should be rejected.")
+}
+
+func TestMemoryExpiration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	m := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+
+	require.NoError(t, m.Store(ctx, "short", []byte("a"), 20*time.Millisecond))
+	require.NoError(t, m.Store(ctx, "swept", []byte("b"), 20*time.Millisecond))
+	require.NoError(t, m.Store(ctx, "forever", []byte("c"), 0))
+	v, _ := m.Retrieve(ctx, "short")
+	assert.Equal(t, []byte("a"), v, "Key should be readable before it expires.")
+
+	time.Sleep(30 * time.Millisecond)
+	v, _ = m.Retrieve(ctx, "short")
+	assert.Nil(t, v, "Expired key should have no value.")
+	assert.Equal(t, 1, m.Sweep(), "Only the remaining expired key should be swept.")
+	assert.Equal(t, 1, m.Len(), "Only the key without expiration should be kept.")
+	assert.Equal(t, int64(len("forever")+len("c")), m.Bytes(), "Removed keys should not be accounted for.")
+}
+
+func TestMemoryEviction(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	// Each key and value is 4 bytes, so 3 values fit.
+	m := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{MaxBytes: 12})
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		require.NoError(t, m.Store(ctx, key, []byte("vv"), 0))
+	}
+	// Reading k1 makes k2 the least recently used.
+	_, _ = m.Retrieve(ctx, "k1")
+	require.NoError(t, m.Store(ctx, "k4", []byte("vv"), 0))
+
+	for key, kept := range map[string]bool{"k1": true, "k2": false, "k3": true, "k4": true} {
+		v, _ := m.Retrieve(ctx, key)
+		assert.Equal(t, kept, v != nil, "Only the least recently used key should be evicted, not %s.", key)
+	}
+	assert.Equal(t, int64(12), m.Bytes(), "Evicted keys should not be accounted for.")
+
+	// Counters are never evicted.
+	require.NoError(t, m.SetCounter(ctx, "c", 1))
+	n, err := m.GetCounter(ctx, "c")
+	require.NoError(t, err, "Counter should not be evicted.")
+	assert.Equal(t, int64(1), n)
+
+	assert.Error(t, m.Store(ctx, "large", make([]byte, 12), 0), "Values larger than the limit should be rejected.")
+}
+
+func TestMemoryDurable(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	m := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{MaxBytes: 12, Durable: true})
+
+	require.NoError(t, m.Store(ctx, "k1", []byte("vv"), 0))
+	require.NoError(t, m.Store(ctx, "k2", []byte("vv"), time.Minute))
+	require.NoError(t, m.Store(ctx, "k3", []byte("vv"), 0))
+	require.NoError(t, m.Store(ctx, "k4", []byte("vv"), 0))
+	v, _ := m.Retrieve(ctx, "k2")
+	assert.Nil(t, v, "Expiring keys should be evicted.")
+
+	err := m.Store(ctx, "k5", []byte("vv"), 0)
+	assert.Equal(t, keyvalue.ErrFull, errors.Cause(err), "Writes should fail when keys that never expire fill the limit.")
+	err = m.Store(ctx, "k1", []byte("longer"), 0)
+	assert.Equal(t, keyvalue.ErrFull, errors.Cause(err), "Replacing a value should fail if the new value does not fit.")
+	for _, key := range []string{"k1", "k3", "k4"} {
+		v, _ := m.Retrieve(ctx, key)
+		assert.Equal(t, []byte("vv"), v, "Keys that never expire should be kept, not %s.", key)
+	}
+	assert.Equal(t, int64(12), m.Bytes(), "Failed writes should not be accounted for.")
+}
+
+func TestMemoryFull(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	m := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{MaxBytes: 12, Durable: true})
+
+	require.NoError(t, m.Store(ctx, "k1", []byte("vv"), 0))
+	require.NoError(t, m.Store(ctx, "k2", []byte("vv"), time.Minute))
+	require.NoError(t, m.Store(ctx, "k3", []byte("vv"), 0))
+
+	err := m.Store(ctx, "k4", []byte("vvvvvv"), 0)
+	assert.Equal(t, keyvalue.ErrFull, errors.Cause(err), "Writes should fail when keys that never expire fill the limit.")
+	v, _ := m.Retrieve(ctx, "k2")
+	assert.Equal(t, []byte("vv"), v, "Failed writes should not evict other keys.")
+
+	require.NoError(t, m.Store(ctx, "k4", []byte("vv"), 0), "Writes that fit should evict expiring keys.")
+	v, _ = m.Retrieve(ctx, "k2")
+	assert.Nil(t, v, "Expiring key should be evicted.")
+}
+
+func TestMemoryCounterExpiration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	m := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{CounterExpiration: 20 * time.Millisecond})
+
+	require.NoError(t, m.SetCounter(ctx, "set", 1))
+	require.NoError(t, m.IncrementCounter(ctx, "incremented"))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, m.IncrementCounter(ctx, "set"))
+	n, err := m.GetCounter(ctx, "set")
+	require.NoError(t, err, "Counter should be kept before it expires.")
+	assert.Equal(t, int64(2), n)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 2, m.Sweep(), "Counters should be swept once they expire.")
+	assert.Zero(t, m.Len(), "Incrementing should keep the expiration of counters.")
+}
+
+func TestMemoryCounters(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	m := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+
+	_, err := m.GetCounter(ctx, "missing")
+	assert.Error(t, err, "Getting a missing counter should fail like it does in Redis.")
+
+	require.NoError(t, m.IncrementCounter(ctx, "new"))
+	n, err := m.GetCounter(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "Incrementing a missing counter should set it to 1.")
+
+	require.NoError(t, m.SetCounter(ctx, "c", 10))
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, m.IncrementCounter(ctx, "c"))
+		}()
+	}
+	wg.Wait()
+	n, err = m.GetCounter(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, int64(110), n, "Concurrent increments should not be lost.")
+
+	require.NoError(t, m.Store(ctx, "value", []byte(strconv.Itoa(5)), 0))
+	assert.Error(t, m.IncrementCounter(ctx, "value"), "Values should not be incremented.")
+	_, err = m.Retrieve(ctx, "c")
+	assert.Error(t, err, "Counters should not be read as values.")
+
+	require.NoError(t, m.Delete(ctx, "c"))
+	_, err = m.GetCounter(ctx, "c")
+	assert.Error(t, err, "Deleted counter should be missing.")
+}
@@ -0,0 +1,372 @@
+package keyvalue
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+)
+
+const (
+	// DefaultL1Expiration is how long values are cached in the first tier by
+	// default.
+	DefaultL1Expiration = 10 * time.Second
+	// DefaultNegativeExpiration is how long missing keys are cached in the
+	// first tier by default.
+	DefaultNegativeExpiration = time.Second
+)
+
+// Markers that start the values cached in the first tier, so that missing
+// keys can be cached too.
+const (
+	tierMissing byte = iota
+	tierPresent
+)
+
+// Ensure TieredKeyValue implements the KeyValue interface.
+var _ KeyValue = (*TieredKeyValue)(nil)
+
+// Invalidator tells other processes that keys have changed.
+type Invalidator interface {
+	// Publish tells the subscribers in other processes that keys changed.
+	Publish(ctx context.Context, keys []string) error
+	// Subscribe calls invalidate with the keys published by other processes
+	// until the context is done.
+	Subscribe(ctx context.Context, invalidate func(key string)) error
+}
+
+// TieredConfig contains the configuration for a TieredKeyValue.
+type TieredConfig struct {
+	// L1 is the fast, usually local, store that caches values of L2. It is
+	// owned by the TieredKeyValue and must not be shared.
+	L1 KeyValue
+	// L2 is the store that holds the values.
+	L2 KeyValue
+	// L1Expiration is the longest that a value is cached in L1, which bounds
+	// how stale it can be if an invalidation is lost. DefaultL1Expiration is
+	// used if it is 0.
+	L1Expiration time.Duration
+	// NegativeExpiration is how long a missing key is cached in L1.
+	// DefaultNegativeExpiration is used if it is 0, and missing keys are not
+	// cached if it is negative.
+	NegativeExpiration time.Duration
+	// Invalidator tells other processes about changed keys, so that they
+	// evict them from their L1. Keys are only evicted by expiration if it is
+	// nil.
+	Invalidator Invalidator
+	// Uncached reports the keys that are only kept in L2, so they are neither
+	// cached in L1 nor published when they change. keyspace.Uncached is used
+	// if it is nil.
+	Uncached func(key string) bool
+	// OnPublishError is called with the errors of publishing invalidations,
+	// if it is not nil. Writes still succeed, since the other processes evict
+	// the keys from their L1 when they expire there.
+	OnPublishError func(error)
+}
+
+// TieredKeyValue caches the values of a KeyValue in another, faster
+// KeyValue.
+//
+// Reads are served from L1 and fall back to L2, caching the result in L1.
+// Writes and deletes go to L2 and then L1, and are published to the other
+// processes so that they evict the key from their L1. Counters are only kept
+// in L2 since they are changed by other processes without an invalidation,
+// and so are the uncached keys.
+type TieredKeyValue struct {
+	l1           KeyValue
+	l2           KeyValue
+	l1Exp        time.Duration
+	negativeExp  time.Duration
+	invalidator  Invalidator
+	uncached     func(key string) bool
+	onPublishErr func(error)
+
+	// generation is increased by every invalidation, so that a value read
+	// from L2 before an invalidation is not cached after it.
+	generation int64
+}
+
+// NewTieredKeyValue creates a TieredKeyValue.
+func NewTieredKeyValue(conf TieredConfig) *TieredKeyValue {
+	if conf.L1 == nil || conf.L2 == nil {
+		panic("nil tier")
+	}
+	t := &TieredKeyValue{
+		l1:           conf.L1,
+		l2:           conf.L2,
+		l1Exp:        conf.L1Expiration,
+		negativeExp:  conf.NegativeExpiration,
+		invalidator:  conf.Invalidator,
+		uncached:     conf.Uncached,
+		onPublishErr: conf.OnPublishError,
+	}
+	if t.uncached == nil {
+		t.uncached = keyspace.Uncached
+	}
+	if t.l1Exp <= 0 {
+		t.l1Exp = DefaultL1Expiration
+	}
+	if t.negativeExp == 0 {
+		t.negativeExp = DefaultNegativeExpiration
+	}
+	return t
+}
+
+// l1Expiration returns how long a value stored with expiration is cached in
+// L1.
+func (t *TieredKeyValue) l1Expiration(expiration time.Duration) time.Duration {
+	if expiration > 0 && expiration < t.l1Exp {
+		return expiration
+	}
+	return t.l1Exp
+}
+
+// cache stores a value in L1, or marks the key as missing if data is nil.
+//
+// Errors are not returned since L2 still has the value.
+func (t *TieredKeyValue) cache(ctx context.Context, key string, data []byte, expiration time.Duration) {
+	if data == nil {
+		if t.negativeExp > 0 {
+			_ = t.l1.Store(ctx, key, []byte{tierMissing}, t.negativeExp)
+		}
+		return
+	}
+	v := make([]byte, 1+len(data))
+	v[0] = tierPresent
+	copy(v[1:], data)
+	_ = t.l1.Store(ctx, key, v, t.l1Expiration(expiration))
+}
+
+// Store stores a key value pair in L2 and caches it in L1.
+//
+// If expiration is set to 0, then the key will never expire.
+func (t *TieredKeyValue) Store(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	if t.uncached(key) {
+		return t.l2.Store(ctx, key, data, expiration)
+	}
+	generation := atomic.LoadInt64(&t.generation)
+	if err := t.l2.Store(ctx, key, data, expiration); err != nil {
+		return err
+	}
+	// The value is not cached if another invalidation happened since it was
+	// stored, as it may be stale already.
+	if t.invalidate(key) == generation+1 {
+		if data == nil {
+			data = []byte{}
+		}
+		t.cache(ctx, key, data, expiration)
+	}
+	t.publish(ctx, key)
+	return nil
+}
+
+// Retrieve retrieves the value for a key from L1, or from L2 if it is not
+// cached.
+func (t *TieredKeyValue) Retrieve(ctx context.Context, key string) ([]byte, error) {
+	if t.uncached(key) {
+		return t.l2.Retrieve(ctx, key)
+	}
+	v, err := t.l1.Retrieve(ctx, key)
+	if err == nil && len(v) > 0 {
+		if v[0] == tierMissing {
+			return nil, nil
+		}
+		return v[1:], nil
+	}
+
+	generation := atomic.LoadInt64(&t.generation)
+	data, err := t.l2.Retrieve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if atomic.LoadInt64(&t.generation) == generation {
+		t.cache(ctx, key, data, 0)
+	}
+	return data, nil
+}
+
+// RetrieveMany retrieves the values for several keys from L1, and the values
+// that are not cached from L2 in one batch.
+func (t *TieredKeyValue) RetrieveMany(ctx context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	// Errors from L1 are treated as misses.
+	cached, _ := t.l1.RetrieveMany(ctx, keys)
+	var misses []int
+	for i := range keys {
+		if i >= len(cached) || len(cached[i]) == 0 {
+			misses = append(misses, i)
+			continue
+		}
+		if cached[i][0] == tierPresent {
+			values[i] = cached[i][1:]
+		}
+	}
+	if len(misses) == 0 {
+		return values, nil
+	}
+
+	missed := make([]string, len(misses))
+	for j, i := range misses {
+		missed[j] = keys[i]
+	}
+	generation := atomic.LoadInt64(&t.generation)
+	data, err := t.l2.RetrieveMany(ctx, missed)
+	l2Errs, ok := err.(BatchError)
+	if err != nil && !ok {
+		return nil, err
+	}
+	current := atomic.LoadInt64(&t.generation) == generation
+	for j, i := range misses {
+		if l2Errs != nil && l2Errs[j] != nil {
+			errs[i] = l2Errs[j]
+			continue
+		}
+		values[i] = data[j]
+		if current && !t.uncached(keys[i]) {
+			t.cache(ctx, keys[i], data[j], 0)
+		}
+	}
+	return values, batchError(errs)
+}
+
+// StoreMany stores several key value pairs in L2 in one batch and caches them
+// in L1.
+func (t *TieredKeyValue) StoreMany(ctx context.Context, entries []Entry) error {
+	generation := atomic.LoadInt64(&t.generation)
+	err := t.l2.StoreMany(ctx, entries)
+	errs, ok := err.(BatchError)
+	if err != nil && !ok {
+		return err
+	}
+	stored := make([]string, 0, len(entries))
+	cached := make([]Entry, 0, len(entries))
+	for i, entry := range entries {
+		if (errs != nil && errs[i] != nil) || t.uncached(entry.Key) {
+			continue
+		}
+		t.invalidate(entry.Key)
+		stored = append(stored, entry.Key)
+		cached = append(cached, entry)
+	}
+	// The values are not cached if another invalidation happened since they
+	// were stored, as they may be stale already.
+	if atomic.LoadInt64(&t.generation) == generation+int64(len(cached)) {
+		for _, entry := range cached {
+			data := entry.Data
+			if data == nil {
+				data = []byte{}
+			}
+			t.cache(ctx, entry.Key, data, entry.Expiration)
+		}
+	}
+	t.publish(ctx, stored...)
+	return err
+}
+
+// Delete deletes the value or counter for a key from both tiers.
+func (t *TieredKeyValue) Delete(ctx context.Context, key string) error {
+	if err := t.l2.Delete(ctx, key); err != nil {
+		return err
+	}
+	t.changed(ctx, key)
+	return nil
+}
+
+// RetrieveVersion retrieves the value and version for a key from L2, since
+// values cached in L1 may be stale.
+func (t *TieredKeyValue) RetrieveVersion(ctx context.Context, key string) ([]byte, Version, error) {
+	return t.l2.RetrieveVersion(ctx, key)
+}
+
+// StoreIfAbsent stores a key value pair in L2 if the key is missing there.
+func (t *TieredKeyValue) StoreIfAbsent(ctx context.Context, key string, data []byte, expiration time.Duration) (bool, error) {
+	ok, err := t.l2.StoreIfAbsent(ctx, key, data, expiration)
+	if !ok || err != nil {
+		return false, err
+	}
+	t.changed(ctx, key)
+	return true, nil
+}
+
+// CompareAndSwap stores a key value pair in L2 if the value of the key has
+// the given version there.
+func (t *TieredKeyValue) CompareAndSwap(ctx context.Context, key string, version Version, data []byte, expiration time.Duration) (bool, error) {
+	ok, err := t.l2.CompareAndSwap(ctx, key, version, data, expiration)
+	if !ok || err != nil {
+		return false, err
+	}
+	t.changed(ctx, key)
+	return true, nil
+}
+
+// CompareAndDelete deletes a key from both tiers if its value has the given
+// version in L2.
+func (t *TieredKeyValue) CompareAndDelete(ctx context.Context, key string, version Version) (bool, error) {
+	ok, err := t.l2.CompareAndDelete(ctx, key, version)
+	if !ok || err != nil {
+		return false, err
+	}
+	t.changed(ctx, key)
+	return true, nil
+}
+
+// changed evicts a key that was changed in L2 from L1, here and in the other
+// processes.
+func (t *TieredKeyValue) changed(ctx context.Context, key string) {
+	if t.uncached(key) {
+		return
+	}
+	t.invalidate(key)
+	t.publish(ctx, key)
+}
+
+// SetCounter sets the counter with the given key to value in L2.
+func (t *TieredKeyValue) SetCounter(ctx context.Context, key string, value int64) error {
+	return t.l2.SetCounter(ctx, key, value)
+}
+
+// GetCounter gets the current value of a counter from L2.
+func (t *TieredKeyValue) GetCounter(ctx context.Context, key string) (int64, error) {
+	return t.l2.GetCounter(ctx, key)
+}
+
+// IncrementCounter increments the value with the given key in L2.
+func (t *TieredKeyValue) IncrementCounter(ctx context.Context, key string) error {
+	return t.l2.IncrementCounter(ctx, key)
+}
+
+// invalidate evicts a key from L1 and returns the new generation.
+func (t *TieredKeyValue) invalidate(key string) int64 {
+	generation := atomic.AddInt64(&t.generation, 1)
+	// A key that cannot be deleted still expires.
+	_ = t.l1.Delete(context.Background(), key)
+	return generation
+}
+
+// publish tells the other processes that keys changed, in one batch.
+//
+// Errors are not returned since the keys were changed in L2, and are passed to
+// onPublishErr instead.
+func (t *TieredKeyValue) publish(ctx context.Context, keys ...string) {
+	if t.invalidator == nil || len(keys) == 0 {
+		return
+	}
+	err := t.invalidator.Publish(ctx, keys)
+	if err != nil && t.onPublishErr != nil {
+		t.onPublishErr(errors.Wrapf(err, "unable to publish invalidation of %d keys", len(keys)))
+	}
+}
+
+// Run evicts the keys that other processes changed from L1 until the context
+// is done.
+func (t *TieredKeyValue) Run(ctx context.Context) error {
+	if t.invalidator == nil {
+		<-ctx.Done()
+		return nil
+	}
+	return t.invalidator.Subscribe(ctx, func(key string) { t.invalidate(key) })
+}
@@ -0,0 +1,286 @@
+package keyvalue_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue/keyvaluetest"
+)
+
+// countingKeyValue counts the values retrieved from a KeyValue.
+type countingKeyValue struct {
+	keyvalue.KeyValue
+	mu        sync.Mutex
+	retrieved int
+}
+
+func (c *countingKeyValue) Retrieve(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	c.retrieved++
+	c.mu.Unlock()
+	return c.KeyValue.Retrieve(ctx, key)
+}
+
+func (c *countingKeyValue) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retrieved
+}
+
+// localInvalidators delivers invalidations between the processes of a test
+// like Redis pub/sub does.
+type localInvalidators struct {
+	mu          sync.Mutex
+	subscribers map[*localInvalidator]func(string)
+}
+
+type localInvalidator struct {
+	hub *localInvalidators
+	// published counts the calls to Publish.
+	published int
+}
+
+func (l *localInvalidators) new() *localInvalidator {
+	return &localInvalidator{hub: l}
+}
+
+func (l *localInvalidator) Publish(_ context.Context, keys []string) error {
+	l.hub.mu.Lock()
+	defer l.hub.mu.Unlock()
+	l.published++
+	for s, invalidate := range l.hub.subscribers {
+		if s != l {
+			for _, key := range keys {
+				invalidate(key)
+			}
+		}
+	}
+	return nil
+}
+
+func (l *localInvalidator) Subscribe(ctx context.Context, invalidate func(string)) error {
+	l.hub.mu.Lock()
+	l.hub.subscribers[l] = invalidate
+	l.hub.mu.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+func TestTieredConformance(t *testing.T) {
+	t.Parallel()
+	keyvaluetest.Run(t, func(t *testing.T) (keyvalue.KeyValue, func()) {
+		return keyvalue.NewTieredKeyValue(keyvalue.TieredConfig{
+			L1: keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+			L2: keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+		}), func() {}
+	})
+}
+
+func TestTieredKeyValue(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	l2 := &countingKeyValue{KeyValue: keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})}
+	hub := &localInvalidators{subscribers: make(map[*localInvalidator]func(string))}
+	newReplica := func() *keyvalue.TieredKeyValue {
+		tkv := keyvalue.NewTieredKeyValue(keyvalue.TieredConfig{
+			L1:                 keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+			L2:                 l2,
+			NegativeExpiration: time.Hour,
+			Invalidator:        hub.new(),
+		})
+		go func() {
+			_ = tkv.Run(ctx)
+		}()
+		return tkv
+	}
+	a, b := newReplica(), newReplica()
+	for subscribed := 0; subscribed < 2; {
+		require.NoError(t, ctx.Err(), "Replicas should subscribe.")
+		time.Sleep(time.Millisecond)
+		hub.mu.Lock()
+		subscribed = len(hub.subscribers)
+		hub.mu.Unlock()
+	}
+
+	// Reads are served from L1 after the first read.
+	require.NoError(t, l2.Store(ctx, "key", []byte("value"), 0))
+	for i := 0; i < 3; i++ {
+		v, err := a.Retrieve(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), v, "Value should be read through.")
+	}
+	assert.Equal(t, 1, l2.count(), "Value should be cached in L1.")
+
+	// Missing keys are cached too.
+	for i := 0; i < 3; i++ {
+		v, err := b.Retrieve(ctx, "new")
+		require.NoError(t, err)
+		assert.Nil(t, v, "Missing key should have no value.")
+	}
+	assert.Equal(t, 2, l2.count(), "Missing key should be cached in L1.")
+
+	// Writes go through to L2 and evict the key from other replicas.
+	require.NoError(t, a.Store(ctx, "new", []byte("stored"), time.Minute))
+	v, _ := l2.Retrieve(ctx, "new")
+	assert.Equal(t, []byte("stored"), v, "Value should be written through.")
+	v, err := b.Retrieve(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("stored"), v, "Other replicas should not serve the cached missing key.")
+
+	// Deletes evict the key from every replica.
+	require.NoError(t, b.Delete(ctx, "key"))
+	v, err = a.Retrieve(ctx, "key")
+	require.NoError(t, err)
+	assert.Nil(t, v, "Deleted key should not be served from L1.")
+
+	// Counters are not cached.
+	require.NoError(t, a.IncrementCounter(ctx, "counter"))
+	require.NoError(t, b.IncrementCounter(ctx, "counter"))
+	n, err := a.GetCounter(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n, "Counters should be shared.")
+}
+
+func TestTieredExpiration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	l2 := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	tkv := keyvalue.NewTieredKeyValue(keyvalue.TieredConfig{
+		L1:           keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+		L2:           l2,
+		L1Expiration: 20 * time.Millisecond,
+	})
+
+	require.NoError(t, tkv.Store(ctx, "key", []byte("value"), 0))
+	// Without an invalidator, changes made directly to L2 are seen once the
+	// cached value expires.
+	require.NoError(t, l2.Store(ctx, "key", []byte("changed"), 0))
+	v, _ := tkv.Retrieve(ctx, "key")
+	assert.Equal(t, []byte("value"), v, "Cached value should be served.")
+	time.Sleep(30 * time.Millisecond)
+	v, _ = tkv.Retrieve(ctx, "key")
+	assert.Equal(t, []byte("changed"), v, "Expired value should be read again.")
+
+	require.NoError(t, tkv.Store(ctx, "empty", nil, 0))
+	v, err := tkv.Retrieve(ctx, "empty")
+	require.NoError(t, err)
+	assert.NotNil(t, v, "Empty values should not be cached as missing.")
+	assert.Empty(t, v)
+}
+
+// failingInvalidator fails to publish.
+type failingInvalidator struct{}
+
+func (failingInvalidator) Publish(context.Context, []string) error {
+	return errors.New("unavailable")
+}
+
+func (failingInvalidator) Subscribe(ctx context.Context, _ func(string)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestTieredPublish(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	hub := &localInvalidators{subscribers: make(map[*localInvalidator]func(string))}
+	invalidator := hub.new()
+	tkv := keyvalue.NewTieredKeyValue(keyvalue.TieredConfig{
+		L1:          keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+		L2:          keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+		Invalidator: invalidator,
+	})
+	require.NoError(t, tkv.StoreMany(ctx, []keyvalue.Entry{{Key: "a"}, {Key: "b"}, {Key: "c"}}))
+	assert.Equal(t, 1, invalidator.published, "Keys stored in a batch should be published once.")
+
+	var errs []error
+	tkv = keyvalue.NewTieredKeyValue(keyvalue.TieredConfig{
+		L1:             keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+		L2:             keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+		Invalidator:    failingInvalidator{},
+		OnPublishError: func(err error) { errs = append(errs, err) },
+	})
+	require.NoError(t, tkv.Store(ctx, "key", []byte("value"), 0), "Writes should succeed when publishing fails.")
+	require.NoError(t, tkv.StoreMany(ctx, []keyvalue.Entry{{Key: "a"}, {Key: "b"}}))
+	require.NoError(t, tkv.Delete(ctx, "key"))
+	assert.Len(t, errs, 3, "Publishing errors should be reported.")
+}
+
+// racingKeyValue calls race once after storing a value, like a write of
+// another process that lands right after it.
+type racingKeyValue struct {
+	keyvalue.KeyValue
+	race func()
+}
+
+func (r *racingKeyValue) Store(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	if err := r.KeyValue.Store(ctx, key, data, expiration); err != nil {
+		return err
+	}
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+	return nil
+}
+
+func TestTieredStoreRace(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	l2 := &racingKeyValue{KeyValue: keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})}
+	tkv := keyvalue.NewTieredKeyValue(keyvalue.TieredConfig{
+		L1: keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+		L2: l2,
+	})
+	// Another process stores a newer value and its invalidation arrives
+	// before the first value is cached.
+	l2.race = func() {
+		require.NoError(t, l2.KeyValue.Store(ctx, "key", []byte("newer"), 0))
+		require.NoError(t, tkv.Delete(ctx, "other"))
+	}
+	require.NoError(t, tkv.Store(ctx, "key", []byte("older"), 0))
+	v, err := tkv.Retrieve(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("newer"), v, "Value stored before an invalidation should not be cached.")
+}
+
+func TestTieredUncached(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	hub := &localInvalidators{subscribers: make(map[*localInvalidator]func(string))}
+	invalidator := hub.new()
+	l2 := keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{})
+	tkv := keyvalue.NewTieredKeyValue(keyvalue.TieredConfig{
+		L1:          keyvalue.NewMemoryAdapter(keyvalue.MemoryConfig{}),
+		L2:          l2,
+		Invalidator: invalidator,
+	})
+
+	chunk := keyspace.BlobChunk("0123456789abcdef0123456789abcdef", 0)
+	require.NoError(t, tkv.Store(ctx, chunk, []byte("chunk"), 0))
+	require.NoError(t, tkv.StoreMany(ctx, []keyvalue.Entry{{Key: keyspace.SearchStats(), Data: []byte("{}")}}))
+	require.NoError(t, tkv.Delete(ctx, keyspace.SearchDocument("a")))
+	assert.Zero(t, invalidator.published, "Uncached keys should not be published.")
+
+	require.NoError(t, l2.Store(ctx, chunk, []byte("changed"), 0))
+	v, err := tkv.Retrieve(ctx, chunk)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("changed"), v, "Uncached keys should be read from L2.")
+	require.NoError(t, l2.Store(ctx, keyspace.SearchStats(), []byte("[]"), 0))
+	values, err := tkv.RetrieveMany(ctx, []string{keyspace.SearchStats()})
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("[]")}, values, "Uncached keys should be read from L2 in batches.")
+}
@@ -0,0 +1,63 @@
+package keyvalue
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxUpdateAttempts is the number of times that Update tries to write a value
+// before giving up.
+const maxUpdateAttempts = 100
+
+// ErrConflict is returned by Update when the value kept changing while it was
+// being updated.
+var ErrConflict = errors.New("value was changed concurrently")
+
+// ErrUnchanged can be returned by the function passed to Update to leave the
+// value as it is.
+var ErrUnchanged = errors.New("value is unchanged")
+
+// Update atomically replaces the value of a key with the value returned by
+// update, which is called with the current value, or nil if the key is
+// missing.
+//
+// If the value is changed by someone else before it is written, update is
+// called again with the new value, so it must not have side effects. If
+// update returns nil, the key is deleted, and if it returns ErrUnchanged,
+// nothing is written. Other errors are returned as they are.
+func Update(ctx context.Context, kv KeyValue, key string, expiration time.Duration, update func(data []byte) ([]byte, error)) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		data, version, err := kv.RetrieveVersion(ctx, key)
+		if err != nil {
+			return err
+		}
+		updated, err := update(data)
+		if err == ErrUnchanged {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var ok bool
+		switch {
+		case updated == nil && version == NoVersion:
+			return nil
+		case updated == nil:
+			ok, err = kv.CompareAndDelete(ctx, key, version)
+		default:
+			ok, err = kv.CompareAndSwap(ctx, key, version, updated, expiration)
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrConflict, "unable to update key %q", key)
+}
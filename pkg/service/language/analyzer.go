@@ -0,0 +1,159 @@
+package language
+
+import (
+	"bufio"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/stem"
+)
+
+// Analyzer splits the text of a document into words and decides which words
+// are counted.
+type Analyzer struct {
+	// Language is the language that the analyzer was picked for.
+	Language  string
+	stopWords map[string]bool
+	// bigrams is set for languages that are written without spaces between
+	// words.
+	bigrams bool
+	stemmer stem.Stemmer
+}
+
+// NewAnalyzer returns the default analyzer for a detected language. Texts
+// whose language was detected with less than MinConfidence are split on
+// whitespace without removing any words.
+func NewAnalyzer(d Detection) Analyzer {
+	if d.Confidence < MinConfidence {
+		return Analyzer{Language: Unknown}
+	}
+	return Analyzer{
+		Language:  d.Language,
+		stopWords: stopWords[d.Language],
+		bigrams:   d.Language == "zh" || d.Language == "ja",
+	}
+}
+
+// WithStemmer returns an analyzer that counts words under their stems.
+func (a Analyzer) WithStemmer(s stem.Stemmer) Analyzer {
+	a.stemmer = s
+	return a
+}
+
+// Term returns the term that word is counted as, which is word itself unless
+// words are stemmed. Words are lower cased and stripped of the punctuation
+// around them before they are stemmed, and form is the word as it was
+// stemmed.
+func (a Analyzer) Term(word string) (term, form string) {
+	if a.stemmer == nil {
+		return word, word
+	}
+	form = trim(word)
+	if form == "" {
+		return word, word
+	}
+	return a.stemmer(strings.ToLower(form)), form
+}
+
+func trim(word string) string {
+	return strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+}
+
+// StopWord reports if word should not be counted. Case and punctuation around
+// the word are ignored.
+func (a Analyzer) StopWord(word string) bool {
+	if len(a.stopWords) == 0 {
+		return false
+	}
+	return a.stopWords[strings.ToLower(trim(word))]
+}
+
+// Split returns the function that splits the text into words for a
+// bufio.Scanner. A new function must be used for each text.
+//
+// Chinese and Japanese are segmented without a dictionary: every pair of
+// consecutive Han or kana characters is a word, and characters that stand
+// alone are words of their own. Words in other scripts are separated by
+// whitespace.
+func (a Analyzer) Split() bufio.SplitFunc {
+	if !a.bigrams {
+		return bufio.ScanWords
+	}
+	// covered is set when the previous bigram included the next character,
+	// so that it is not counted alone at the end of a run.
+	covered := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		// Characters are skipped within the function since the scanner stops
+		// at the end of the text when no word is returned.
+		start := 0
+		for {
+			for start < len(data) {
+				if !atEOF && !utf8.FullRune(data[start:]) {
+					return start, nil, nil
+				}
+				r, width := utf8.DecodeRune(data[start:])
+				if !separator(r) {
+					break
+				}
+				covered = false
+				start += width
+			}
+			if start == len(data) {
+				return start, nil, nil
+			}
+
+			r, width := utf8.DecodeRune(data[start:])
+			if !cjk(r) {
+				break
+			}
+			next := start + width
+			if !atEOF && (next == len(data) || !utf8.FullRune(data[next:])) {
+				return start, nil, nil
+			}
+			if next < len(data) {
+				r2, width2 := utf8.DecodeRune(data[next:])
+				if cjk(r2) {
+					covered = true
+					return next, data[start : next+width2], nil
+				}
+			}
+			if !covered {
+				return next, data[start:next], nil
+			}
+			covered = false
+			start = next
+		}
+
+		covered = false
+		_, width := utf8.DecodeRune(data[start:])
+		for end := start + width; end < len(data); {
+			if !atEOF && !utf8.FullRune(data[end:]) {
+				break
+			}
+			r, width := utf8.DecodeRune(data[end:])
+			if separator(r) || cjk(r) {
+				return end, data[start:end], nil
+			}
+			end += width
+		}
+		if atEOF {
+			return len(data), data[start:], nil
+		}
+		return start, nil, nil
+	}
+}
+
+// cjk reports if r is a Han or kana character, or the prolonged sound mark
+// that is written with kana.
+func cjk(r rune) bool {
+	return r == 'ー' || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// separator reports if r separates words, which includes the punctuation of
+// Chinese and Japanese since it is not surrounded by spaces.
+func separator(r rune) bool {
+	return unicode.IsSpace(r) || r >= 0x3000 && unicode.IsPunct(r)
+}
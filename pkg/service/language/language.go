@@ -0,0 +1,227 @@
+// Package language identifies the language of a text and provides the
+// default analyzer for each language.
+//
+// Languages written in a script of their own are identified by the script.
+// Languages written in the Latin script are identified by comparing the
+// character trigrams of the text with trigram profiles that are built from
+// sample texts compiled into the binary, so no data files are needed.
+package language
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Unknown is the language reported for texts whose language cannot be
+// identified, such as texts without any letters.
+const Unknown = "und"
+
+// MinConfidence is the confidence below which a detected language is not used
+// to pick an analyzer.
+const MinConfidence = 0.1
+
+// SampleSize is the number of bytes from the start of a document that are
+// enough to identify its language.
+const SampleSize = 4096
+
+// profileSize is the number of the most frequent trigrams of a sample text
+// that are kept in a profile.
+const profileSize = 300
+
+// Detection is the identified language of a text.
+type Detection struct {
+	// Language is the ISO 639-1 code of the language, or Unknown.
+	Language string
+	// Confidence is between 0 and 1, with higher values being more certain.
+	Confidence float64
+}
+
+// scriptLanguages are the languages that are identified by their script.
+// Han characters are identified as Chinese unless kana are present as well.
+var scriptLanguages = []struct {
+	language string
+	script   *unicode.RangeTable
+}{
+	{"zh", unicode.Han},
+	{"ja", unicode.Hiragana},
+	{"ja", unicode.Katakana},
+	{"ko", unicode.Hangul},
+	{"ru", unicode.Cyrillic},
+	{"el", unicode.Greek},
+	{"ar", unicode.Arabic},
+	{"he", unicode.Hebrew},
+	{"hi", unicode.Devanagari},
+	{"th", unicode.Thai},
+}
+
+// profile is a trigram frequency vector of unit length.
+type profile map[string]float64
+
+var profiles = func() map[string]profile {
+	p := make(map[string]profile, len(samples))
+	for lang, text := range samples {
+		p[lang] = newProfile(text, profileSize)
+	}
+	return p
+}()
+
+// Supported reports if lang is the code of a language that can be detected.
+func Supported(lang string) bool {
+	if _, ok := profiles[lang]; ok {
+		return true
+	}
+	for _, sl := range scriptLanguages {
+		if sl.language == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect identifies the language of text. Only the first SampleSize bytes are
+// needed for a reliable result.
+func Detect(text string) Detection {
+	if len(text) > SampleSize {
+		text = text[:SampleSize]
+	}
+	var latin, letters int
+	counts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, sl := range scriptLanguages {
+			if unicode.Is(sl.script, r) {
+				counts[sl.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return Detection{Language: Unknown}
+	}
+	// Japanese mixes kana with Han characters.
+	if counts["ja"] > 0 {
+		counts["ja"] += counts["zh"]
+		delete(counts, "zh")
+	}
+
+	best, bestCount := "", 0
+	for lang, n := range counts {
+		if n > bestCount || n == bestCount && lang < best {
+			best, bestCount = lang, n
+		}
+	}
+	if bestCount > latin {
+		return Detection{Language: best, Confidence: float64(bestCount) / float64(letters)}
+	}
+
+	d := detectLatin(text)
+	d.Confidence *= float64(latin) / float64(letters)
+	return d
+}
+
+// detectLatin identifies the language of a text in the Latin script by the
+// cosine similarity of its trigrams to each profile and the share of its
+// words that are stop words of each language. The confidence is the
+// relative margin of the most similar profile over the next one, so that
+// closely related languages are reported with a low confidence.
+func detectLatin(text string) Detection {
+	p := newProfile(text, 0)
+	if len(p) == 0 {
+		return Detection{Language: Unknown}
+	}
+	words := strings.Fields(text)
+	// The trigrams are summed in order so that the result does not depend on
+	// the order of iterating over the maps.
+	trigrams := make([]string, 0, len(p))
+	for t := range p {
+		trigrams = append(trigrams, t)
+	}
+	sort.Strings(trigrams)
+	type score struct {
+		lang       string
+		similarity float64
+	}
+	scores := make([]score, 0, len(profiles))
+	for lang, lp := range profiles {
+		var dot float64
+		for _, t := range trigrams {
+			dot += p[t] * lp[t]
+		}
+		// Short texts have few trigrams, but most of their words are usually
+		// stop words.
+		a := Analyzer{stopWords: stopWords[lang]}
+		var stop int
+		for _, w := range words {
+			if a.StopWord(w) {
+				stop++
+			}
+		}
+		scores = append(scores, score{lang, dot + float64(stop)/float64(len(words))})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].similarity != scores[j].similarity {
+			return scores[i].similarity > scores[j].similarity
+		}
+		return scores[i].lang < scores[j].lang
+	})
+	if scores[0].similarity == 0 {
+		return Detection{Language: Unknown}
+	}
+	return Detection{
+		Language:   scores[0].lang,
+		Confidence: (scores[0].similarity - scores[1].similarity) / scores[0].similarity,
+	}
+}
+
+// newProfile builds the trigram profile of text. Words are lower cased and
+// padded with spaces so that trigrams at the start and end of words are told
+// apart. Only the size most frequent trigrams are kept unless size is 0.
+func newProfile(text string, size int) profile {
+	counts := make(map[string]float64)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		r := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			counts[string(r[i:i+3])]++
+		}
+	}
+
+	trigrams := make([]string, 0, len(counts))
+	for t := range counts {
+		trigrams = append(trigrams, t)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		if counts[trigrams[i]] != counts[trigrams[j]] {
+			return counts[trigrams[i]] > counts[trigrams[j]]
+		}
+		return trigrams[i] < trigrams[j]
+	})
+	if size > 0 && len(trigrams) > size {
+		for _, t := range trigrams[size:] {
+			delete(counts, t)
+		}
+		trigrams = trigrams[:size]
+	}
+
+	var norm float64
+	for _, t := range trigrams {
+		norm += counts[t] * counts[t]
+	}
+	norm = math.Sqrt(norm)
+	p := make(profile, len(counts))
+	for t, v := range counts {
+		p[t] = v / norm
+	}
+	return p
+}
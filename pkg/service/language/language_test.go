@@ -0,0 +1,98 @@
+package language_test
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/language"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		text     string
+		language string
+	}{
+		{"The quick brown fox jumps over the lazy dog and runs into the forest.", "en"},
+		{"Der schnelle braune Fuchs springt über den faulen Hund und läuft in den Wald.", "de"},
+		{"Le renard brun rapide saute par-dessus le chien paresseux et court dans la forêt.", "fr"},
+		{"El rápido zorro marrón salta sobre el perro perezoso y corre hacia el bosque.", "es"},
+		{"La volpe marrone veloce salta sopra il cane pigro e corre nella foresta.", "it"},
+		{"A rápida raposa marrom pula sobre o cão preguiçoso e corre para a floresta, onde não há caçadores.", "pt"},
+		{"De snelle bruine vos springt over de luie hond en rent het bos in.", "nl"},
+		{"Den snabba bruna räven hoppar över den lata hunden och springer in i skogen.", "sv"},
+		{"Быстрая коричневая лиса прыгает через ленивую собаку.", "ru"},
+		{"敏捷的棕色狐狸跳过了懒狗。", "zh"},
+		{"素早い茶色の狐がのろまな犬を飛び越える。", "ja"},
+		{"빠른 갈색 여우가 게으른 개를 뛰어넘는다.", "ko"},
+		{"Η γρήγορη καφέ αλεπού πηδάει πάνω από τον τεμπέλη σκύλο.", "el"},
+	}
+	for _, test := range tests {
+		d := language.Detect(test.text)
+		assert.Equal(t, test.language, d.Language, test.text)
+		assert.True(t, d.Confidence >= language.MinConfidence, "%s: confidence %f", test.text, d.Confidence)
+		assert.True(t, language.Supported(test.language), test.language)
+	}
+
+	d := language.Detect("123 456 !!!")
+	assert.Equal(t, language.Unknown, d.Language, "Texts without letters have no language.")
+	assert.Zero(t, d.Confidence)
+	assert.False(t, language.Supported("xx"))
+}
+
+func split(a language.Analyzer, text string) []string {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Split(a.Split())
+	var words []string
+	for scanner.Scan() {
+		words = append(words, scanner.Text())
+	}
+	return words
+}
+
+func TestAnalyzer(t *testing.T) {
+	en := language.NewAnalyzer(language.Detection{Language: "en", Confidence: 1})
+	assert.True(t, en.StopWord("The"), "Stop words should ignore case.")
+	assert.True(t, en.StopWord("and,"), "Stop words should ignore punctuation.")
+	assert.False(t, en.StopWord("fox"))
+	assert.Equal(t, []string{"a", "quick", "東京都"}, split(en, " a quick\t東京都 "))
+
+	unsure := language.NewAnalyzer(language.Detection{Language: "en", Confidence: language.MinConfidence / 2})
+	assert.Equal(t, language.Unknown, unsure.Language)
+	assert.False(t, unsure.StopWord("the"), "Uncertain languages should keep all words.")
+
+	zh := language.NewAnalyzer(language.Detection{Language: "zh", Confidence: 1})
+	assert.Equal(t, []string{"东京", "京都", "是", "abc", "日本"}, split(zh, "东京都，是abc日本。"))
+	assert.Equal(t, []string{"一"}, split(zh, "一"))
+	assert.Equal(t, []string{"カタ", "タカ", "カナ", "ナー", "and", "more"}, split(zh, "カタカナー and more"))
+
+	// Splitting must not depend on how the text is buffered.
+	text := strings.Repeat("东京都 word 日本語。", 1000)
+	scanner := bufio.NewScanner(&oneByteReader{strings.NewReader(text)})
+	scanner.Split(zh.Split())
+	var words []string
+	for scanner.Scan() {
+		words = append(words, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	expected := split(zh, text)
+	require.Len(t, expected, 5000)
+	require.Len(t, words, len(expected))
+	for i := range words {
+		require.Equal(t, expected[i], words[i], "word %d", i)
+	}
+}
+
+type oneByteReader struct {
+	r *strings.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
@@ -0,0 +1,111 @@
+package language
+
+// samples are the texts that the trigram profiles of the languages written in
+// the Latin script are built from. They are the first articles of the
+// Universal Declaration of Human Rights followed by a few everyday sentences,
+// so that the profiles hold both formal and common words.
+var samples = map[string]string{
+	"de": `Alle Menschen sind frei und gleich an Würde und Rechten geboren. Sie
+sind mit Vernunft und Gewissen begabt und sollen einander im Geist der
+Brüderlichkeit begegnen. Jeder hat Anspruch auf alle in dieser Erklärung
+verkündeten Rechte und Freiheiten ohne irgendeinen Unterschied, etwa nach
+Rasse, Hautfarbe, Geschlecht, Sprache, Religion, politischer oder sonstiger
+Überzeugung, nationaler oder sozialer Herkunft, Vermögen, Geburt oder
+sonstigem Stand. Jeder hat das Recht auf Leben, Freiheit und Sicherheit der
+Person. Niemand darf in Sklaverei oder Leibeigenschaft gehalten werden. Das
+Wetter war an diesem Morgen kalt, deshalb sind wir zu Hause geblieben und
+haben die Zeitung gelesen, während die Kinder mit ihren Freunden im Garten
+spielten. Es gibt nichts, was er nicht für seine Familie tun würde, und sie
+wissen, dass sie sich immer auf ihn verlassen können, wenn es schwierig wird.`,
+
+	"en": `All human beings are born free and equal in dignity and rights. They
+are endowed with reason and conscience and should act towards one another in
+a spirit of brotherhood. Everyone is entitled to all the rights and freedoms
+set forth in this Declaration, without distinction of any kind, such as race,
+colour, sex, language, religion, political or other opinion, national or
+social origin, property, birth or other status. Everyone has the right to
+life, liberty and security of person. No one shall be held in slavery or
+servitude. The weather was cold that morning, so we stayed at home and read
+the newspaper while the children played with their friends in the garden.
+There is nothing that he would not do for his family, and they know that they
+can always count on him when things are difficult.`,
+
+	"es": `Todos los seres humanos nacen libres e iguales en dignidad y derechos
+y, dotados como están de razón y conciencia, deben comportarse fraternalmente
+los unos con los otros. Toda persona tiene todos los derechos y libertades
+proclamados en esta Declaración, sin distinción alguna de raza, color, sexo,
+idioma, religión, opinión política o de cualquier otra índole, origen
+nacional o social, posición económica, nacimiento o cualquier otra condición.
+Todo individuo tiene derecho a la vida, a la libertad y a la seguridad de su
+persona. Nadie estará sometido a esclavitud ni a servidumbre. Hacía frío esa
+mañana, así que nos quedamos en casa y leímos el periódico mientras los niños
+jugaban con sus amigos en el jardín. No hay nada que él no haría por su
+familia, y ellos saben que siempre pueden contar con él cuando las cosas son
+difíciles.`,
+
+	"fr": `Tous les êtres humains naissent libres et égaux en dignité et en
+droits. Ils sont doués de raison et de conscience et doivent agir les uns
+envers les autres dans un esprit de fraternité. Chacun peut se prévaloir de
+tous les droits et de toutes les libertés proclamés dans la présente
+Déclaration, sans distinction aucune, notamment de race, de couleur, de sexe,
+de langue, de religion, d'opinion politique ou de toute autre opinion,
+d'origine nationale ou sociale, de fortune, de naissance ou de toute autre
+situation. Tout individu a droit à la vie, à la liberté et à la sûreté de sa
+personne. Nul ne sera tenu en esclavage ni en servitude. Il faisait froid ce
+matin-là, alors nous sommes restés à la maison et nous avons lu le journal
+pendant que les enfants jouaient avec leurs amis dans le jardin. Il n'y a
+rien qu'il ne ferait pas pour sa famille, et ils savent qu'ils peuvent
+toujours compter sur lui quand les choses sont difficiles.`,
+
+	"it": `Tutti gli esseri umani nascono liberi ed eguali in dignità e diritti.
+Essi sono dotati di ragione e di coscienza e devono agire gli uni verso gli
+altri in spirito di fratellanza. Ad ogni individuo spettano tutti i diritti e
+tutte le libertà enunciate nella presente Dichiarazione, senza distinzione
+alcuna, per ragioni di razza, di colore, di sesso, di lingua, di religione,
+di opinione politica o di altro genere, di origine nazionale o sociale, di
+ricchezza, di nascita o di altra condizione. Ogni individuo ha diritto alla
+vita, alla libertà ed alla sicurezza della propria persona. Nessun individuo
+potrà essere tenuto in stato di schiavitù o di servitù. Quella mattina faceva
+freddo, quindi siamo rimasti a casa e abbiamo letto il giornale mentre i
+bambini giocavano con i loro amici nel giardino. Non c'è niente che lui non
+farebbe per la sua famiglia, e loro sanno che possono sempre contare su di
+lui quando le cose sono difficili.`,
+
+	"nl": `Alle mensen worden vrij en gelijk in waardigheid en rechten geboren.
+Zij zijn begiftigd met verstand en geweten, en behoren zich jegens elkander in
+een geest van broederschap te gedragen. Een ieder heeft aanspraak op alle
+rechten en vrijheden, in deze Verklaring opgesomd, zonder enig onderscheid van
+welke aard ook, zoals ras, kleur, geslacht, taal, godsdienst, politieke of
+andere overtuiging, nationale of maatschappelijke afkomst, eigendom, geboorte
+of andere status. Een ieder heeft het recht op leven, vrijheid en
+onschendbaarheid van zijn persoon. Niemand zal in slavernij of horigheid
+gehouden worden. Het was koud die ochtend, dus bleven we thuis en lazen we de
+krant terwijl de kinderen met hun vrienden in de tuin speelden. Er is niets
+dat hij niet voor zijn familie zou doen, en zij weten dat ze altijd op hem
+kunnen rekenen wanneer het moeilijk wordt.`,
+
+	"pt": `Todos os seres humanos nascem livres e iguais em dignidade e em
+direitos. Dotados de razão e de consciência, devem agir uns para com os
+outros em espírito de fraternidade. Todos os seres humanos podem invocar os
+direitos e as liberdades proclamados na presente Declaração, sem distinção
+alguma, nomeadamente de raça, de cor, de sexo, de língua, de religião, de
+opinião política ou outra, de origem nacional ou social, de fortuna, de
+nascimento ou de qualquer outra situação. Todo o indivíduo tem direito à
+vida, à liberdade e à segurança pessoal. Ninguém será mantido em escravatura
+ou em servidão. Estava frio naquela manhã, então ficamos em casa e lemos o
+jornal enquanto as crianças brincavam com os seus amigos no jardim. Não há
+nada que ele não faria pela sua família, e eles sabem que podem sempre contar
+com ele quando as coisas são difíceis.`,
+
+	"sv": `Alla människor är födda fria och lika i värde och rättigheter. De har
+utrustats med förnuft och samvete och bör handla gentemot varandra i en anda
+av broderskap. Var och en är berättigad till alla de fri- och rättigheter som
+uttalas i denna förklaring utan åtskillnad av något slag, såsom ras, hudfärg,
+kön, språk, religion, politisk eller annan uppfattning, nationellt eller
+socialt ursprung, egendom, börd eller ställning i övrigt. Var och en har rätt
+till liv, frihet och personlig säkerhet. Ingen får hållas i slaveri eller
+träldom. Det var kallt den morgonen, så vi stannade hemma och läste tidningen
+medan barnen lekte med sina vänner i trädgården. Det finns ingenting som han
+inte skulle göra för sin familj, och de vet att de alltid kan lita på honom
+när saker är svåra.`,
+}
@@ -0,0 +1,59 @@
+package language
+
+import "strings"
+
+// stopWords are the most common function words of each language, which say
+// little about what a document is about.
+var stopWords = map[string]map[string]bool{
+	"de": wordSet(`aber alle als also am an auch auf aus bei bin bis bist da
+		damit dann das dass dem den der des die dies diese dieser dir doch du
+		durch ein eine einem einen einer eines er es für hat hatte ich ihm ihn
+		ihr im in ist ja kein mich mir mit nach nicht noch nun nur ob oder ohne
+		sehr sich sie sind so um und uns vom von vor war waren was weil wenn
+		wer wie wir wird zu zum zur über`),
+	"en": wordSet(`a about after all also am an and any are as at be because
+		been before being but by can could did do does doing for from had has
+		have having he her here hers him his how i if in into is it its just
+		me my no nor not of off on or our out over own she should so some such
+		than that the their them then there these they this those through to
+		too under until up very was we were what when where which while who
+		whom why will with would you your`),
+	"es": wordSet(`a al algo como con de del desde donde el ella ellas ellos
+		en entre era es esa ese eso esta este esto estos fue ha hay la las le
+		les lo los me mi mucho muy más ni no nos o para pero por que se sea
+		ser si sin sobre son su sus también te todo tu un una uno unos y ya
+		él`),
+	"fr": wordSet(`à au aussi aux avec ce ces cette comme dans de des du elle
+		elles en est et eux il ils je la le les leur lui ma mais me même mes
+		moi mon ne nos notre nous on ou où par pas pour qu que qui sa se ses
+		son sont sur ta te tes toi ton tu un une vos votre vous y été être`),
+	"it": wordSet(`a ad al alla alle anche che chi ci come con da dal dalla
+		dei del della delle di e ed era gli ha hanno ho i il in io la le lei
+		lo loro lui ma mi ne nei nel nella noi non o per più quando quella
+		quello questa questo se si sono su sua suo tra tu un una uno è`),
+	"nl": wordSet(`aan al als bij dan dat de der deze die dit doch door een
+		en er had heb heeft het hij hoe hun ik in is je kan maar me met mij
+		na naar niet nog nu of om omdat ons ook op over te tot u uit van veel
+		voor was wat we wel werd wie wij worden zal ze zich zij zijn zo zou`),
+	"pt": wordSet(`a ao aos as com como da das de do dos e ela elas ele eles
+		em entre era essa esse esta este eu foi há isso já lhe mais mas me
+		mesmo muito na nas nem no nos o os ou para pela pelo por que se sem
+		ser seu sobre sua são também te um uma você à é`),
+	"ru": wordSet(`а без бы был была были было в вам вас весь во вот все всё
+		вы где да для до его ее её если есть еще ещё же за и из или им их к
+		как ко когда кто ли мне мы на над не нет ни но ну о об однако он она
+		они оно от по под при с со так также то только тот ты у уже что чтобы
+		эта это этот я`),
+	"sv": wordSet(`alla allt att av blev bli den denna det detta de dem din
+		du där efter eller en ett från för ha hade han hans har henne hon hur
+		i icke inte jag kan man med men mig min mot mycket nu när och om oss
+		på sig sin sina som så till under upp ut var vara vi vid än är över`),
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
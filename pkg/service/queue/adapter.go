@@ -2,57 +2,85 @@ package queue
 
 import (
 	"context"
-	"encoding/base64"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/codec"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redisclient"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
 )
 
-// Ensure RedisAdapter implements Queue.
-var _ Queue = (*RedisAdapter)(nil)
+// pullTimeout is how long a pull blocks in Redis before checking whether its
+// context is done.
+const pullTimeout = time.Second
+
+// Ensure RedisAdapter implements Queue and Depther.
+var (
+	_ Queue   = (*RedisAdapter)(nil)
+	_ Depther = (*RedisAdapter)(nil)
+)
 
 // NewRedisAdapter creates a new RedisAdapter.
-func NewRedisAdapter(c *redis.Client) *RedisAdapter {
+func NewRedisAdapter(c redis.UniversalClient) *RedisAdapter {
+	return NewRedisAdapterWithConfig(c, RedisConfig{})
+}
+
+// RedisConfig contains the configuration for a RedisAdapter.
+type RedisConfig struct {
+	// Namespace is the namespace that every queue is kept in.
+	Namespace keyspace.Namespace
+	// Codec encodes the pushed messages. codec.Default is used if it is nil.
+	Codec *codec.Codec
+}
+
+// NewRedisAdapterWithConfig creates a new RedisAdapter configured by conf.
+func NewRedisAdapterWithConfig(c redis.UniversalClient, conf RedisConfig) *RedisAdapter {
 	if c == nil {
 		panic("nil queue client")
 	}
+	if conf.Codec == nil {
+		conf.Codec = codec.Default
+	}
 	return &RedisAdapter{
 		c:      c,
+		ns:     conf.Namespace,
+		codec:  conf.Codec,
 		unread: make(map[string][][]byte),
 	}
 }
 
 // RedisAdapter for a Redis client to implement the Queue interface.
 type RedisAdapter struct {
-	c      *redis.Client
-	unread map[string][][]byte
-}
+	c     redis.UniversalClient
+	ns    keyspace.Namespace
+	codec *codec.Codec
 
-func bytesToString(b []byte) string {
-	return base64.StdEncoding.EncodeToString(b)
+	mu     sync.Mutex
+	unread map[string][][]byte
 }
 
-func stringToBytes(s string) ([]byte, error) {
-	//fmt.Println("STRING S IS...", s)
-	b, err := base64.StdEncoding.DecodeString(s)
-	//fmt.Printf("STRING S DECODED IS...%s -> %s\n", s, b)
+func (r *RedisAdapter) stringToBytes(s string) ([]byte, error) {
+	b, err := r.codec.Decode([]byte(s))
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to decode string into bytes")
 	}
 	return b, nil
 }
 
-// stringsToMultiBytes attempts to base64 decode all the strings in s.
+// stringsToMultiBytes attempts to decode all the strings in s.
 //
 // Invalid strings will be dropped.
 //
 // Only the first error encountered will be returned, if there is one.
-func stringsToMultiBytes(s []string) ([][]byte, error) {
+func (r *RedisAdapter) stringsToMultiBytes(s []string) ([][]byte, error) {
 	// TODO: Handle invalid strings somehow instead of dropping them.
 	out := make([][]byte, 0, len(s))
 	var err error
 	for _, v := range s {
-		b, e := stringToBytes(v)
+		b, e := r.stringToBytes(v)
 		if e != nil {
 			if err == nil {
 				err = errors.WithStack(e)
@@ -63,10 +91,10 @@ func stringsToMultiBytes(s []string) ([][]byte, error) {
 	return out, err
 }
 
-func bytesToInterfaces(b [][]byte) []interface{} {
+func (r *RedisAdapter) bytesToInterfaces(b [][]byte) []interface{} {
 	out := make([]interface{}, len(b))
 	for i, v := range b {
-		out[i] = bytesToString(v)
+		out[i] = r.codec.Encode(v)
 	}
 	return out
 }
@@ -79,13 +107,14 @@ func (r *RedisAdapter) Push(ctx context.Context, channel string, data [][]byte)
 	if len(data) == 0 {
 		return nil
 	}
-	client := r.c.WithContext(ctx)
-	messages := bytesToInterfaces(data)
-	err := client.RPush(channel, messages...).Err()
+	client := redisclient.WithContext(ctx, r.c)
+	messages := r.bytesToInterfaces(data)
+	err := client.RPush(r.ns.Key(channel), messages...).Err()
 	return errors.Wrapf(err, "error pushing to Redis list \"%s\"", channel)
 }
 
-// Pull pulls values from the queue in Redis.
+// Pull pulls values from the queue in Redis, waiting until there is one or the
+// context is done.
 //
 // If there is an error, nil will be returned for the bytes. Subsequent calls
 // will return the retrieved values, if there were any.
@@ -94,6 +123,8 @@ func (r *RedisAdapter) Push(ctx context.Context, channel string, data [][]byte)
 func (r *RedisAdapter) Pull(ctx context.Context, channel string) ([]byte, error) {
 	// TODO: Handle message trace from ctx.
 	getUnread := func() []byte {
+		r.mu.Lock()
+		defer r.mu.Unlock()
 		unread := r.unread[channel]
 		if len(unread) > 0 {
 			out := unread[0]
@@ -106,11 +137,22 @@ func (r *RedisAdapter) Pull(ctx context.Context, channel string) ([]byte, error)
 		return tmp, nil
 	}
 
-	client := r.c.WithContext(ctx)
-	// Block forever since context cancellation can be used.
-	values, err := client.BLPop(0, channel).Result()
-	if err != nil {
-		return nil, errors.Wrapf(err, "error reading from Redis list \"%s\"", channel)
+	client := redisclient.WithContext(ctx, r.c)
+	var values []string
+	for {
+		// Redis does not notice when the context is done, so block for a
+		// limited time and check it in between.
+		var err error
+		values, err = client.BLPop(pullTimeout, r.ns.Key(channel)).Result()
+		if err == nil {
+			break
+		}
+		if err != redis.Nil {
+			return nil, errors.Wrapf(err, "error reading from Redis list \"%s\"", channel)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrapf(err, "error reading from Redis list \"%s\"", channel)
+		}
 	}
 
 	// Ignore the channel name.
@@ -118,11 +160,27 @@ func (r *RedisAdapter) Pull(ctx context.Context, channel string) ([]byte, error)
 		values = values[1:]
 	}
 
-	b, err := stringsToMultiBytes(values)
+	b, err := r.stringsToMultiBytes(values)
+	r.mu.Lock()
 	r.unread[channel] = append(r.unread[channel], b...)
+	r.mu.Unlock()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	return getUnread(), nil
 }
+
+// Depth returns the number of messages waiting on a channel, including the
+// messages that were read from Redis but not pulled yet.
+func (r *RedisAdapter) Depth(ctx context.Context, channel string) (int64, error) {
+	client := redisclient.WithContext(ctx, r.c)
+	n, err := client.LLen(r.ns.Key(channel)).Result()
+	if err != nil {
+		return 0, errors.Wrapf(err, "error getting length of Redis list \"%s\"", channel)
+	}
+	r.mu.Lock()
+	n += int64(len(r.unread[channel]))
+	r.mu.Unlock()
+	return n, nil
+}
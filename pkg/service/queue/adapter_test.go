@@ -1,34 +1,21 @@
-//+build integration
-
 package queue_test
 
 import (
 	"context"
-	"math/rand"
+	"encoding/base64"
 	"strconv"
-	"sync"
 	"testing"
 	"time"
 
-	"github.com/stretchr/testify/require"
-
 	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redistest"
 
 	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue/queuetest"
 
 	"github.com/stretchr/testify/assert"
-
-	"golang.org/x/sync/errgroup"
+	"github.com/stretchr/testify/require"
 )
 
-var seedOnce sync.Once
-
-func randString() string {
-	seedOnce.Do(func() { rand.Seed(time.Now().UnixNano()) })
-	i := rand.Int()
-	return strconv.Itoa(i)
-}
-
 func TestRedisConnection(t *testing.T) {
 	t.Parallel()
 	client := redistest.Connect(t)
@@ -37,63 +24,34 @@ func TestRedisConnection(t *testing.T) {
 
 func TestQueue(t *testing.T) {
 	//t.Parallel()
-	client := redistest.Connect(t)
-	adapter := queue.NewRedisAdapter(client)
-
-	id := randString()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	group, ctx := errgroup.WithContext(ctx)
-
-	// Wait on a channel to "burst" all requests as fast as possible.
-	var ready sync.WaitGroup
-	ready.Add(11)
-	start := make(chan struct{})
-
-	found := make(map[string]struct{})
-	var foundMu sync.Mutex
-
-	group.Go(func() error {
-		ready.Done()
-		<-start
-		for i := 0; i < 10; i++ {
-			err := adapter.Push(ctx, id, [][]byte{
-				[]byte(strconv.Itoa(i)),
-			})
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+	queuetest.Run(t, func(t *testing.T) (queue.Queue, func()) {
+		return queue.NewRedisAdapter(redistest.Connect(t)), func() {}
 	})
+}
 
-	for i := 0; i < 10; i++ {
-		group.Go(func() error {
-			ready.Done()
-			<-start
-			data, err := adapter.Pull(ctx, id)
-			if err != nil {
-				return err
-			}
-			foundMu.Lock()
-			found[string(data)] = struct{}{}
-			foundMu.Unlock()
-			return nil
-		})
-	}
+func TestClusterQueue(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T) (queue.Queue, func()) {
+		c := redistest.ConnectCluster(t)
+		return queue.NewRedisAdapter(c), func() { _ = c.Close() }
+	})
+}
 
-	ready.Wait()
-	close(start)
-	err := group.Wait()
-	require.NoError(t, err, "Sending and receiving messages should not error.")
+func TestRedisLegacyMessages(t *testing.T) {
+	t.Parallel()
+	c := redistest.Connect(t)
+	q := queue.NewRedisAdapter(c)
+	channel := t.Name() + strconv.FormatInt(time.Now().UnixNano(), 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	for i := 0; i < 10; i++ {
-		iStr := strconv.Itoa(i)
-		_, ok := found[iStr]
-		require.True(t, ok, "Missing value %s in found set", iStr)
+	// Earlier versions pushed messages as base64.
+	require.NoError(t, c.RPush(channel, base64.StdEncoding.EncodeToString([]byte("legacy"))).Err())
+	require.NoError(t, q.Push(ctx, channel, [][]byte{[]byte("new")}))
+	for _, want := range []string{"legacy", "new"} {
+		got, err := q.Pull(ctx, channel)
+		require.NoError(t, err, "Messages should be pulled.")
+		assert.Equal(t, []byte(want), got, "Messages of both versions should be read.")
 	}
 }
 
-// TODO: Add tests for multi-send, tests with mocks for error handling tests,
-//  large payloads, empty payloads, etc.
+// TODO: Add tests with mocks for error handling tests.
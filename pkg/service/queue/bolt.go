@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket that holds a bucket of messages for each channel.
+var boltBucket = []byte("queue")
+
+// Ensure BoltAdapter implements Queue and Depther.
+var (
+	_ Queue   = (*BoltAdapter)(nil)
+	_ Depther = (*BoltAdapter)(nil)
+)
+
+// BoltAdapter keeps queues in a bbolt database file, for running without
+// Redis.
+//
+// Messages are kept in the order they were pushed, and are removed in the
+// same transaction that pulls them. They survive restarts once they are
+// pushed if the database syncs its commits, which bbolt does unless NoSync is
+// set. Like with Redis, a message that was pulled by a worker that crashes
+// before it finishes is lost.
+//
+// bbolt locks its file, so only the processes that share the database can
+// pull the messages, and waiting pulls are woken by the pushes of this
+// adapter.
+type BoltAdapter struct {
+	db *bolt.DB
+
+	mu sync.Mutex
+	// pushed is closed and replaced whenever messages are pushed.
+	pushed chan struct{}
+}
+
+// NewBoltAdapter creates a BoltAdapter that keeps queues in db.
+func NewBoltAdapter(db *bolt.DB) (*BoltAdapter, error) {
+	if db == nil {
+		panic("nil queue database")
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create queue bucket")
+	}
+	return &BoltAdapter{
+		db:     db,
+		pushed: make(chan struct{}),
+	}, nil
+}
+
+// Push pushes a number of messages to a queue.
+//
+// The messages are added to the queue together.
+func (b *BoltAdapter) Push(_ context.Context, channel string, data [][]byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		messages, err := tx.Bucket(boltBucket).CreateBucketIfNotExists([]byte(channel))
+		if err != nil {
+			return err
+		}
+		for _, d := range data {
+			// Sequences only increase, so messages are kept in order.
+			seq, err := messages.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := messages.Put(key, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error pushing to queue \"%s\"", channel)
+	}
+
+	b.mu.Lock()
+	close(b.pushed)
+	b.pushed = make(chan struct{})
+	b.mu.Unlock()
+	return nil
+}
+
+// pop removes and returns the first message of a queue, or nil if the queue
+// is empty.
+func (b *BoltAdapter) pop(channel string) ([]byte, error) {
+	var message []byte
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(boltBucket).Bucket([]byte(channel))
+		if messages == nil {
+			return nil
+		}
+		c := messages.Cursor()
+		k, v := c.First()
+		if k == nil {
+			return nil
+		}
+		message = append([]byte{}, v...)
+		return c.Delete()
+	})
+	return message, errors.Wrapf(err, "error reading from queue \"%s\"", channel)
+}
+
+// Pull pulls a message from a queue, waiting until there is one or the
+// context is done.
+//
+// This function is thread-safe.
+func (b *BoltAdapter) Pull(ctx context.Context, channel string) ([]byte, error) {
+	for {
+		// Get the channel before checking the queue so that a push in
+		// between is not missed.
+		b.mu.Lock()
+		pushed := b.pushed
+		b.mu.Unlock()
+
+		message, err := b.pop(channel)
+		if err != nil || message != nil {
+			return message, err
+		}
+		select {
+		case <-pushed:
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "error reading from queue \"%s\"", channel)
+		}
+	}
+}
+
+// Depth returns the number of messages waiting on a channel.
+func (b *BoltAdapter) Depth(_ context.Context, channel string) (int64, error) {
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(boltBucket).Bucket([]byte(channel))
+		if messages != nil {
+			n = int64(messages.Stats().KeyN)
+		}
+		return nil
+	})
+	return n, errors.Wrapf(err, "error counting queue \"%s\"", channel)
+}
@@ -0,0 +1,88 @@
+package queue_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue/queuetest"
+)
+
+// openBolt opens a database in a temporary directory, returning its path and
+// a function that closes and removes it.
+func openBolt(t *testing.T) (*bolt.DB, string, func()) {
+	dir, err := ioutil.TempDir("", "queue")
+	require.NoError(t, err, "Creating temporary directory should succeed.")
+	path := filepath.Join(dir, "queue.db")
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err, "Opening database should succeed.")
+	return db, path, func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestBoltConformance(t *testing.T) {
+	queuetest.Run(t, func(t *testing.T) (queue.Queue, func()) {
+		db, _, cleanup := openBolt(t)
+		b, err := queue.NewBoltAdapter(db)
+		require.NoError(t, err, "Creating adapter should succeed.")
+		return b, cleanup
+	})
+}
+
+func TestBoltQueueRestart(t *testing.T) {
+	db, path, cleanup := openBolt(t)
+	defer cleanup()
+	b, err := queue.NewBoltAdapter(db)
+	require.NoError(t, err, "Creating adapter should succeed.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Messages are kept in order across restarts.
+	require.NoError(t, b.Push(ctx, "ordered", [][]byte{[]byte("1"), []byte("2")}))
+	require.NoError(t, b.Push(ctx, "ordered", [][]byte{[]byte("3")}))
+	require.NoError(t, db.Close())
+	db, err = bolt.Open(path, 0600, nil)
+	require.NoError(t, err, "Reopening database should succeed.")
+	defer func() {
+		_ = db.Close()
+	}()
+	b, err = queue.NewBoltAdapter(db)
+	require.NoError(t, err)
+	n, err := b.Depth(ctx, "ordered")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n, "Messages should be counted.")
+	for _, want := range []string{"1", "2", "3"} {
+		got, err := b.Pull(ctx, "ordered")
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got), "Messages should be pulled in order.")
+	}
+}
+
+func TestBoltDepth(t *testing.T) {
+	db, _, cleanup := openBolt(t)
+	defer cleanup()
+	b, err := queue.NewBoltAdapter(db)
+	require.NoError(t, err, "Creating adapter should succeed.")
+	ctx := context.Background()
+
+	n, err := b.Depth(ctx, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n, "Missing channel should be empty.")
+	require.NoError(t, b.Push(ctx, "channel", [][]byte{[]byte("1"), []byte("2")}))
+	_, err = b.Pull(ctx, "channel")
+	require.NoError(t, err)
+	n, err = b.Depth(ctx, "channel")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "Pulled messages should not be counted.")
+}
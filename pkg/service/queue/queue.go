@@ -18,3 +18,9 @@ type Queue interface {
 	Push(ctx context.Context, channel string, data [][]byte) error
 	Pull(ctx context.Context, channel string) ([]byte, error)
 }
+
+// Depther is implemented by queues that report how many messages are waiting
+// on a channel.
+type Depther interface {
+	Depth(ctx context.Context, channel string) (int64, error)
+}
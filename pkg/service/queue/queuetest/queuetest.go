@@ -0,0 +1,206 @@
+// Package queuetest implements a conformance test suite for implementations
+// of queue.Queue.
+//
+// Every implementation should pass it to behave like the Redis adapter that
+// the services were written against:
+//
+//	func TestConformance(t *testing.T) {
+//		queuetest.Run(t, func(t *testing.T) (queue.Queue, func()) {
+//			return NewAdapter(), func() {}
+//		})
+//	}
+package queuetest
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+)
+
+// Factory returns a Queue to test and a function that releases it.
+//
+// Tests may share a store, such as a Redis database, since every test uses
+// channels of its own.
+type Factory func(t *testing.T) (queue.Queue, func())
+
+// Timeout is how long each test may take.
+const Timeout = 10 * time.Second
+
+// LargeMessageSize is the size of the large message that must be queued.
+const LargeMessageSize = 1 << 20
+
+var (
+	seedOnce sync.Once
+	randMu   sync.Mutex
+)
+
+// channelName returns a channel name for a test.
+func channelName(t *testing.T) string {
+	seedOnce.Do(func() { rand.Seed(time.Now().UnixNano()) })
+	randMu.Lock()
+	defer randMu.Unlock()
+	return t.Name() + ":" + strconv.Itoa(rand.Int())
+}
+
+// Run runs the conformance tests as subtests of t.
+func Run(t *testing.T, newQueue Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, ctx context.Context, q queue.Queue, channel string)
+	}{
+		{"Order", testOrder},
+		{"Channels", testChannels},
+		{"EmptyPush", testEmptyPush},
+		{"EmptyMessage", testEmptyMessage},
+		{"BlockingPull", testBlockingPull},
+		{"PullCancellation", testPullCancellation},
+		{"Concurrency", testConcurrency},
+		{"LargeMessage", testLargeMessage},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			q, cleanup := newQueue(t)
+			defer cleanup()
+			ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+			defer cancel()
+			test.test(t, ctx, q, channelName(t))
+		})
+	}
+}
+
+// pullAll pulls n messages.
+func pullAll(t *testing.T, ctx context.Context, q queue.Queue, channel string, n int) []string {
+	var messages []string
+	for i := 0; i < n; i++ {
+		data, err := q.Pull(ctx, channel)
+		require.NoError(t, err, "Should pull message without error.")
+		messages = append(messages, string(data))
+	}
+	return messages
+}
+
+func testOrder(t *testing.T, ctx context.Context, q queue.Queue, channel string) {
+	require.NoError(t, q.Push(ctx, channel, [][]byte{[]byte("1"), []byte("2")}), "Should push messages without error.")
+	require.NoError(t, q.Push(ctx, channel, [][]byte{[]byte("3")}))
+	assert.Equal(t, []string{"1", "2", "3"}, pullAll(t, ctx, q, channel, 3), "Messages should be pulled in the order they were pushed.")
+
+	require.NoError(t, q.Push(ctx, channel, [][]byte{[]byte("4")}))
+	assert.Equal(t, []string{"4"}, pullAll(t, ctx, q, channel, 1), "Messages pushed after pulling should be pulled.")
+}
+
+func testChannels(t *testing.T, ctx context.Context, q queue.Queue, channel string) {
+	require.NoError(t, q.Push(ctx, channel+"a", [][]byte{[]byte("a")}))
+	require.NoError(t, q.Push(ctx, channel+"b", [][]byte{[]byte("b")}))
+	assert.Equal(t, []string{"b"}, pullAll(t, ctx, q, channel+"b", 1), "Channels should be separate.")
+	assert.Equal(t, []string{"a"}, pullAll(t, ctx, q, channel+"a", 1), "Channels should be separate.")
+}
+
+func testEmptyPush(t *testing.T, ctx context.Context, q queue.Queue, channel string) {
+	assert.NoError(t, q.Push(ctx, channel, nil), "Pushing no messages should not error.")
+}
+
+func testEmptyMessage(t *testing.T, ctx context.Context, q queue.Queue, channel string) {
+	require.NoError(t, q.Push(ctx, channel, [][]byte{{}}))
+	data, err := q.Pull(ctx, channel)
+	require.NoError(t, err)
+	assert.Empty(t, data, "Empty message should be pulled.")
+}
+
+func testBlockingPull(t *testing.T, ctx context.Context, q queue.Queue, channel string) {
+	pulled := make(chan []byte, 1)
+	go func() {
+		data, err := q.Pull(ctx, channel)
+		assert.NoError(t, err)
+		pulled <- data
+	}()
+	select {
+	case <-pulled:
+		t.Fatal("Pulling from an empty queue should wait for a message.")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, q.Push(ctx, channel, [][]byte{[]byte("late")}))
+	select {
+	case data := <-pulled:
+		assert.Equal(t, []byte("late"), data, "Waiting pull should receive the pushed message.")
+	case <-ctx.Done():
+		t.Fatal("Waiting pull should be woken by a push.")
+	}
+}
+
+func testPullCancellation(t *testing.T, ctx context.Context, q queue.Queue, channel string) {
+	pullCtx, cancel := context.WithCancel(ctx)
+	pulled := make(chan error, 1)
+	go func() {
+		_, err := q.Pull(pullCtx, channel)
+		pulled <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-pulled:
+		assert.Equal(t, context.Canceled, errors.Cause(err), "Canceled pull should return the context error.")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pull should return when its context is canceled.")
+	}
+
+	// No message is lost to the canceled pull.
+	require.NoError(t, q.Push(ctx, channel, [][]byte{[]byte("kept")}))
+	assert.Equal(t, []string{"kept"}, pullAll(t, ctx, q, channel, 1), "Message should not be lost to a canceled pull.")
+}
+
+func testConcurrency(t *testing.T, ctx context.Context, q queue.Queue, channel string) {
+	const pushers, perPusher, pullers = 5, 20, 10
+	group, ctx := errgroup.WithContext(ctx)
+	for p := 0; p < pushers; p++ {
+		p := p
+		group.Go(func() error {
+			for i := 0; i < perPusher; i++ {
+				err := q.Push(ctx, channel, [][]byte{[]byte(strconv.Itoa(p*perPusher + i))})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	var mu sync.Mutex
+	found := make(map[string]int)
+	for p := 0; p < pullers; p++ {
+		group.Go(func() error {
+			for i := 0; i < pushers*perPusher/pullers; i++ {
+				data, err := q.Pull(ctx, channel)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				found[string(data)]++
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	require.NoError(t, group.Wait(), "Sending and receiving messages should not error.")
+	for i := 0; i < pushers*perPusher; i++ {
+		assert.Equal(t, 1, found[strconv.Itoa(i)], "Message %d should be pulled exactly once.", i)
+	}
+}
+
+func testLargeMessage(t *testing.T, ctx context.Context, q queue.Queue, channel string) {
+	message := bytes.Repeat([]byte{0, 1, 2, 253, 254, 255}, LargeMessageSize/6)
+	require.NoError(t, q.Push(ctx, channel, [][]byte{message}), "Should push large message without error.")
+	data, err := q.Pull(ctx, channel)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(message, data), "Large binary message should be pulled intact.")
+}
@@ -0,0 +1,394 @@
+package queue
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ringPoints is the number of points that each shard has on the hash
+	// ring, so that messages are spread evenly between the shards.
+	ringPoints = 100
+
+	// requeueTimeout is how long a closing Sharded queue may take to push
+	// back a message that it pulled but did not hand out.
+	requeueTimeout = 5 * time.Second
+
+	// shardRetryInterval is how long a shard that failed to be pulled from
+	// waits before it is pulled from again.
+	shardRetryInterval = time.Second
+)
+
+// ErrClosed is returned when pulling from a closed Sharded queue.
+var ErrClosed = errors.New("queue is closed")
+
+// ShardStrategy chooses the shard that each pushed message is stored on.
+type ShardStrategy int
+
+const (
+	// RoundRobin stores the pushed messages on each shard in turn.
+	RoundRobin ShardStrategy = iota
+	// ConsistentHash stores a message on the shard that its content hashes to
+	// on a ring, so that adding a shard only moves a share of the messages
+	// to it.
+	ConsistentHash
+)
+
+// Shard is one of the queues of a Sharded queue.
+type Shard struct {
+	// Name identifies the shard, e.g. by the address of its Redis node. With
+	// ConsistentHash, the shard of a message depends on the names.
+	Name  string
+	Queue Queue
+}
+
+// ShardedConfig contains the configuration for a Sharded queue.
+type ShardedConfig struct {
+	Shards   []Shard
+	Strategy ShardStrategy
+}
+
+// ShardDepth is the number of messages waiting on a channel of a shard.
+type ShardDepth struct {
+	Name  string
+	Depth int64
+}
+
+// Ensure Sharded implements Queue and Depther.
+var (
+	_ Queue   = (*Sharded)(nil)
+	_ Depther = (*Sharded)(nil)
+)
+
+// Sharded is a queue whose messages are spread over several queues, such as
+// Redis adapters of different Redis nodes.
+//
+// Messages are only pulled in the order they were pushed within a shard.
+// Pulling from a channel starts a goroutine per shard that blocks on it, and
+// the goroutines take turns handing out their messages, so every shard is
+// pulled from fairly. Each goroutine holds at most one message until it is
+// pulled, which Close pushes back to its shard.
+type Sharded struct {
+	// next is the number of messages pushed with RoundRobin. It is first so
+	// that it is aligned for atomic access.
+	next uint64
+
+	strategy ShardStrategy
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu       sync.RWMutex
+	shards   []Shard
+	ring     []ringPoint
+	channels map[string]*fanIn
+}
+
+// ringPoint is a point on the hash ring, owned by a shard.
+type ringPoint struct {
+	hash  uint32
+	shard int
+}
+
+// fanIn hands out the messages of a channel pulled from every shard.
+type fanIn struct {
+	pulled  chan pulled
+	pullers []*puller
+}
+
+// pulled is a message pulled from a shard, or the error pulling from it.
+type pulled struct {
+	data []byte
+	err  error
+	from *puller
+}
+
+// puller pulls the messages of a channel from a shard.
+type puller struct {
+	shard Shard
+	// held is 1 while the puller holds a message that was not handed out.
+	held int64
+}
+
+// NewSharded creates a new Sharded queue over the shards of conf.
+func NewSharded(conf ShardedConfig) (*Sharded, error) {
+	if len(conf.Shards) == 0 {
+		return nil, errors.New("no shards")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sharded{
+		strategy: conf.Strategy,
+		ctx:      ctx,
+		cancel:   cancel,
+		channels: make(map[string]*fanIn),
+	}
+	for _, shard := range conf.Shards {
+		if err := s.AddShard(shard); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// AddShard adds a shard that messages are pushed to from now on.
+//
+// No messages are moved, and the channels that are being pulled from are
+// pulled from the new shard too, so adding a shard loses no messages.
+func (s *Sharded) AddShard(shard Shard) error {
+	if shard.Queue == nil {
+		return errors.Errorf("shard %q has no queue", shard.Name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return ErrClosed
+	}
+	for _, existing := range s.shards {
+		if existing.Name == shard.Name {
+			return errors.Errorf("duplicate shard %q", shard.Name)
+		}
+	}
+	s.shards = append(s.shards, shard)
+	s.buildRing()
+	for channel, f := range s.channels {
+		s.startPuller(channel, f, shard)
+	}
+	return nil
+}
+
+// buildRing places the points of every shard on the hash ring.
+//
+// s.mu must be locked.
+func (s *Sharded) buildRing() {
+	s.ring = s.ring[:0]
+	for i, shard := range s.shards {
+		for p := 0; p < ringPoints; p++ {
+			s.ring = append(s.ring, ringPoint{hash: hash32([]byte(shard.Name + "#" + strconv.Itoa(p))), shard: i})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+}
+
+// hash32 hashes b for the hash ring. FNV-1a is mixed with the finalizer of
+// MurmurHash3, since the names of shards differ in few bits.
+func hash32(b []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(b)
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// shardOf returns the index of the shard that a message is pushed to.
+//
+// s.mu must be read locked.
+func (s *Sharded) shardOf(message []byte) int {
+	if s.strategy != ConsistentHash {
+		return int((atomic.AddUint64(&s.next, 1) - 1) % uint64(len(s.shards)))
+	}
+	h := hash32(message)
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// Push pushes messages to the shards chosen by the strategy. The messages
+// pushed to each shard keep their order.
+func (s *Sharded) Push(ctx context.Context, channel string, data [][]byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	s.mu.RLock()
+	shards := s.shards
+	batches := make([][][]byte, len(shards))
+	for _, message := range data {
+		i := s.shardOf(message)
+		batches[i] = append(batches[i], message)
+	}
+	s.mu.RUnlock()
+
+	for i, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		if err := shards[i].Queue.Push(ctx, channel, batch); err != nil {
+			return errors.Wrapf(err, "error pushing to shard %q", shards[i].Name)
+		}
+	}
+	return nil
+}
+
+// Pull pulls a message from any of the shards, waiting until there is one or
+// the context is done.
+//
+// This function is thread-safe.
+func (s *Sharded) Pull(ctx context.Context, channel string) ([]byte, error) {
+	f, err := s.fanIn(channel)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case p := <-f.pulled:
+		// The message is no longer held once it is received, so it is not
+		// counted twice by Depths.
+		atomic.StoreInt64(&p.from.held, 0)
+		return p.data, p.err
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "error pulling from channel \"%s\"", channel)
+	case <-s.ctx.Done():
+		return nil, ErrClosed
+	}
+}
+
+// fanIn returns the fan in of a channel, starting to pull from every shard the
+// first time.
+func (s *Sharded) fanIn(channel string) (*fanIn, error) {
+	s.mu.RLock()
+	f := s.channels[channel]
+	s.mu.RUnlock()
+	if f != nil {
+		return f, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	if f = s.channels[channel]; f != nil {
+		return f, nil
+	}
+	// The channel is unbuffered so that a message is only taken from a
+	// shard's puller when it is pulled, and blocked pullers are handed out
+	// in the order they became ready.
+	f = &fanIn{pulled: make(chan pulled)}
+	for _, shard := range s.shards {
+		s.startPuller(channel, f, shard)
+	}
+	s.channels[channel] = f
+	return f, nil
+}
+
+// startPuller starts pulling a channel from a shard.
+//
+// s.mu must be locked.
+func (s *Sharded) startPuller(channel string, f *fanIn, shard Shard) {
+	p := &puller{shard: shard}
+	f.pullers = append(f.pullers, p)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.pull(channel, f, p)
+	}()
+}
+
+// pull hands out the messages of a channel of a shard until the queue is
+// closed.
+func (s *Sharded) pull(channel string, f *fanIn, p *puller) {
+	for {
+		data, err := p.shard.Queue.Pull(s.ctx, channel)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			err = errors.Wrapf(err, "error pulling from shard %q", p.shard.Name)
+		} else {
+			atomic.StoreInt64(&p.held, 1)
+		}
+
+		select {
+		case f.pulled <- pulled{data: data, err: err, from: p}:
+		case <-s.ctx.Done():
+			if err == nil {
+				s.requeue(channel, p, data)
+			}
+			return
+		}
+
+		if err != nil {
+			// Don't spin on a shard that is unavailable.
+			select {
+			case <-time.After(shardRetryInterval):
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// requeue pushes a message that was pulled but not handed out back to its
+// shard, behind the messages that are waiting on it.
+func (s *Sharded) requeue(channel string, p *puller, data []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), requeueTimeout)
+	defer cancel()
+	// The message is lost if it can't be pushed, like any message that is
+	// pulled from a queue by a process that stops.
+	_ = p.shard.Queue.Push(ctx, channel, [][]byte{data})
+	atomic.StoreInt64(&p.held, 0)
+}
+
+// Depths returns the number of messages waiting on a channel of each shard,
+// including the messages pulled from it that were not handed out yet. Every
+// shard's queue must implement Depther.
+func (s *Sharded) Depths(ctx context.Context, channel string) ([]ShardDepth, error) {
+	s.mu.RLock()
+	shards := s.shards
+	held := make(map[string]int64)
+	if f := s.channels[channel]; f != nil {
+		for _, p := range f.pullers {
+			held[p.shard.Name] += atomic.LoadInt64(&p.held)
+		}
+	}
+	s.mu.RUnlock()
+
+	depths := make([]ShardDepth, 0, len(shards))
+	for _, shard := range shards {
+		d, ok := shard.Queue.(Depther)
+		if !ok {
+			return nil, errors.Errorf("shard %q does not report its depth", shard.Name)
+		}
+		n, err := d.Depth(ctx, channel)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting depth of shard %q", shard.Name)
+		}
+		depths = append(depths, ShardDepth{Name: shard.Name, Depth: n + held[shard.Name]})
+	}
+	return depths, nil
+}
+
+// Depth returns the number of messages waiting on a channel of every shard.
+func (s *Sharded) Depth(ctx context.Context, channel string) (int64, error) {
+	depths, err := s.Depths(ctx, channel)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, d := range depths {
+		n += d.Depth
+	}
+	return n, nil
+}
+
+// Close stops pulling from the shards and pushes the messages that were
+// pulled but not handed out back to them. The shards' queues are not closed.
+func (s *Sharded) Close() error {
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,220 @@
+package queue_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/internal/redistest"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/queue/queuetest"
+)
+
+// newShards returns Redis adapters that stand in for different Redis nodes by
+// keeping their queues in namespaces of their own.
+func newShards(t *testing.T, names ...string) []queue.Shard {
+	c := redistest.Connect(t)
+	prefix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	shards := make([]queue.Shard, len(names))
+	for i, name := range names {
+		shards[i] = queue.Shard{
+			Name: name,
+			Queue: queue.NewRedisAdapterWithConfig(c, queue.RedisConfig{
+				Namespace: keyspace.Namespace(prefix + name),
+			}),
+		}
+	}
+	return shards
+}
+
+// newSharded returns a Sharded queue over shards that is closed when the test
+// ends.
+func newSharded(t *testing.T, strategy queue.ShardStrategy, shards []queue.Shard) *queue.Sharded {
+	s, err := queue.NewSharded(queue.ShardedConfig{Shards: shards, Strategy: strategy})
+	require.NoError(t, err, "Creating sharded queue should succeed.")
+	return s
+}
+
+func TestShardedConformance(t *testing.T) {
+	// Messages are only pulled in order within a shard.
+	queuetest.Run(t, func(t *testing.T) (queue.Queue, func()) {
+		s := newSharded(t, queue.RoundRobin, newShards(t, "a"))
+		return s, func() { _ = s.Close() }
+	})
+}
+
+func TestShardedConfig(t *testing.T) {
+	t.Parallel()
+	_, err := queue.NewSharded(queue.ShardedConfig{})
+	assert.Error(t, err, "Sharded queue should need a shard.")
+	shards := newShards(t, "a")
+	_, err = queue.NewSharded(queue.ShardedConfig{Shards: append(shards, shards[0])})
+	assert.Error(t, err, "Shard names should be unique.")
+}
+
+// pullSet pulls n messages and returns how many times each was pulled.
+func pullSet(t *testing.T, ctx context.Context, q queue.Queue, channel string, n int) map[string]int {
+	found := make(map[string]int)
+	for i := 0; i < n; i++ {
+		data, err := q.Pull(ctx, channel)
+		require.NoError(t, err, "Should pull message without error.")
+		found[string(data)]++
+	}
+	return found
+}
+
+func messages(from, to int) ([][]byte, map[string]int) {
+	var data [][]byte
+	want := make(map[string]int)
+	for i := from; i < to; i++ {
+		data = append(data, []byte(strconv.Itoa(i)))
+		want[strconv.Itoa(i)] = 1
+	}
+	return data, want
+}
+
+func TestShardedRoundRobin(t *testing.T) {
+	t.Parallel()
+	s := newSharded(t, queue.RoundRobin, newShards(t, "a", "b", "c"))
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	channel := t.Name()
+
+	data, want := messages(0, 9)
+	require.NoError(t, s.Push(ctx, channel, data[:5]))
+	require.NoError(t, s.Push(ctx, channel, data[5:]))
+	depths, err := s.Depths(ctx, channel)
+	require.NoError(t, err)
+	assert.Equal(t, []queue.ShardDepth{{"a", 3}, {"b", 3}, {"c", 3}}, depths,
+		"Messages should be spread evenly over the shards.")
+
+	assert.Equal(t, want, pullSet(t, ctx, s, channel, 9), "Every message should be pulled once.")
+	n, err := s.Depth(ctx, channel)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestShardedConsistentHash(t *testing.T) {
+	t.Parallel()
+	shards := newShards(t, "a", "b", "c", "d")
+	s := newSharded(t, queue.ConsistentHash, shards[:3])
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	channel := t.Name()
+
+	// A message is always pushed to the same shard.
+	require.NoError(t, s.Push(ctx, channel, [][]byte{[]byte("same"), []byte("same")}))
+	depths, err := s.Depths(ctx, channel)
+	require.NoError(t, err)
+	var used int
+	for _, d := range depths {
+		if d.Depth > 0 {
+			used++
+			assert.Equal(t, int64(2), d.Depth, "Equal messages should be pushed to the same shard.")
+		}
+	}
+	assert.Equal(t, 1, used)
+
+	data, want := messages(0, 300)
+	require.NoError(t, s.Push(ctx, channel, data))
+	depths, err = s.Depths(ctx, channel)
+	require.NoError(t, err)
+	before := make(map[string]int64)
+	for _, d := range depths {
+		assert.True(t, d.Depth > 30, "Shard %q should get a share of the messages.", d.Name)
+		before[d.Name] = d.Depth
+	}
+
+	// Adding a shard only moves messages pushed after it to the new shard.
+	require.NoError(t, s.AddShard(shards[3]))
+	require.NoError(t, s.Push(ctx, channel, data))
+	depths, err = s.Depths(ctx, channel)
+	require.NoError(t, err)
+	require.Len(t, depths, 4)
+	for _, d := range depths[:3] {
+		added := d.Depth - before[d.Name]
+		assert.True(t, added <= before[d.Name], "Shard %q should not get more messages than before.", d.Name)
+	}
+	assert.True(t, depths[3].Depth > 0, "New shard should get a share of the messages.")
+
+	found := pullSet(t, ctx, s, channel, 602)
+	assert.Equal(t, 2, found["same"])
+	for message := range want {
+		assert.Equal(t, 2, found[message], "Message %s should be pulled from its shards.", message)
+	}
+}
+
+func TestShardedAddShard(t *testing.T) {
+	t.Parallel()
+	shards := newShards(t, "a", "b")
+	s := newSharded(t, queue.RoundRobin, shards[:1])
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	channel := t.Name()
+
+	// The channel is being pulled from when the shard is added.
+	data, want := messages(0, 4)
+	require.NoError(t, s.Push(ctx, channel, data[:1]))
+	assert.Equal(t, map[string]int{"0": 1}, pullSet(t, ctx, s, channel, 1))
+	require.NoError(t, s.AddShard(shards[1]))
+	assert.Error(t, s.AddShard(shards[1]), "Shard should not be added twice.")
+	require.NoError(t, s.Push(ctx, channel, data[1:]))
+
+	// Messages pushed to the new shard are pulled.
+	delete(want, "0")
+	assert.Equal(t, want, pullSet(t, ctx, s, channel, 3))
+}
+
+func TestShardedFairness(t *testing.T) {
+	t.Parallel()
+	shards := newShards(t, "a", "b")
+	s := newSharded(t, queue.RoundRobin, shards)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	channel := t.Name()
+
+	for _, shard := range shards {
+		var data [][]byte
+		for i := 0; i < 10; i++ {
+			data = append(data, []byte(shard.Name))
+		}
+		require.NoError(t, shard.Queue.Push(ctx, channel, data))
+	}
+	found := pullSet(t, ctx, s, channel, 10)
+	assert.True(t, found["a"] >= 3, "Shard a should not be starved: %v", found)
+	assert.True(t, found["b"] >= 3, "Shard b should not be starved: %v", found)
+}
+
+func TestShardedClose(t *testing.T) {
+	t.Parallel()
+	shards := newShards(t, "a", "b")
+	s := newSharded(t, queue.RoundRobin, shards)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	channel := t.Name()
+
+	require.NoError(t, s.Push(ctx, channel, [][]byte{[]byte("1"), []byte("2")}))
+	_ = pullSet(t, ctx, s, channel, 1)
+	// Give the other shard's puller time to hold its message.
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, s.Close())
+
+	_, err := s.Pull(ctx, channel)
+	assert.Equal(t, queue.ErrClosed, err, "Closed queue should not be pulled from.")
+	var n int64
+	for _, shard := range shards {
+		d, err := shard.Queue.(queue.Depther).Depth(ctx, channel)
+		require.NoError(t, err)
+		n += d
+	}
+	assert.Equal(t, int64(1), n, "Held message should be pushed back to its shard.")
+}
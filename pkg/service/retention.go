@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// DefaultRetention is how long reports are kept for when no retention is
+// configured or requested.
+var DefaultRetention = Retention{Duration: 30 * time.Second}
+
+// RetentionForever is the retention of reports that never expire.
+const RetentionForever = "forever"
+
+// retentionSliding prefixes retentions that are refreshed on cache hits.
+const retentionSliding = "sliding:"
+
+// Retention is how long the report for a document is kept for.
+//
+// Its text form is a duration such as "1h30m", "forever", or a duration
+// prefixed with "sliding:" for an expiration that is restarted whenever the
+// report is served from the cache.
+type Retention struct {
+	Duration time.Duration
+	Forever  bool
+	Sliding  bool
+}
+
+// ParseRetention parses the text form of a retention.
+func ParseRetention(s string) (Retention, error) {
+	if s == RetentionForever {
+		return Retention{Forever: true}, nil
+	}
+	var r Retention
+	if strings.HasPrefix(s, retentionSliding) {
+		r.Sliding = true
+		s = strings.TrimPrefix(s, retentionSliding)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return r, errors.Wrapf(ErrInvalidRequest, "invalid retention %q", s)
+	}
+	if d <= 0 {
+		return r, errors.Wrapf(ErrInvalidRequest, "retention %q must be positive", s)
+	}
+	r.Duration = d
+	return r, nil
+}
+
+// ParseTenantRetention parses a comma separated list of tenant=retention
+// pairs, such as "acme=forever,beta=sliding:1h".
+func ParseTenantRetention(s string) (map[string]Retention, error) {
+	tenants := make(map[string]Retention)
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Wrapf(ErrInvalidRequest, "invalid tenant retention %q", pair)
+		}
+		r, err := ParseRetention(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", parts[0])
+		}
+		tenants[parts[0]] = r
+	}
+	return tenants, nil
+}
+
+// String returns the text form of the retention.
+func (r Retention) String() string {
+	if r.Forever {
+		return RetentionForever
+	}
+	if r.Sliding {
+		return retentionSliding + r.Duration.String()
+	}
+	return r.Duration.String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r Retention) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (r *Retention) UnmarshalText(text []byte) error {
+	parsed, err := ParseRetention(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// expiration returns the expiration to store values with, where 0 means that
+// they never expire.
+func (r Retention) expiration() time.Duration {
+	if r.Forever {
+		return 0
+	}
+	return r.Duration
+}
+
+// atLeast returns the expiration of the retention, or d if the retention is
+// shorter than d.
+func (r Retention) atLeast(d time.Duration) time.Duration {
+	if r.Forever || r.Duration > d {
+		return r.expiration()
+	}
+	return d
+}
+
+// longerThan reports if values are kept for longer with r than with o.
+func (r Retention) longerThan(o Retention) bool {
+	switch {
+	case o.Forever:
+		return false
+	case r.Forever:
+		return true
+	}
+	return r.Duration > o.Duration
+}
+
+// RetentionOptions select how long the report for a document is kept for.
+type RetentionOptions struct {
+	// RetainFor is the text form of a retention that overrides the retention
+	// of the tenant. It can't be longer than the maximum retention.
+	RetainFor string `json:"retain_for,omitempty"`
+}
+
+// tenantContextKey is the key of the tenant of a request in its context.
+type tenantContextKey struct{}
+
+// WithTenant returns a context for the requests of an authenticated tenant,
+// whose reports are kept for the retention configured for the tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFrom returns the tenant set by WithTenant, which is empty if the
+// request was not authenticated.
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// retention returns the retention for a request. The requested retention is
+// used if there is one, then the retention of the tenant, then the global
+// retention.
+//
+// Requested retentions can't be longer than the maximum retention, or than
+// the retention of the tenant if there is no maximum. Sliding retentions can
+// only be requested if the maximum is sliding or forever.
+func (a *apiService) retention(ctx context.Context, o RetentionOptions) (Retention, error) {
+	r := a.defaultRetention
+	if tenant := TenantFrom(ctx); tenant != "" {
+		if tr, ok := a.tenantRetention[tenant]; ok {
+			r = tr
+		}
+	}
+	if o.RetainFor == "" {
+		return r, nil
+	}
+	requested, err := ParseRetention(o.RetainFor)
+	if err != nil {
+		return requested, err
+	}
+	max := a.maxRetention
+	if max == (Retention{}) {
+		max = r
+	}
+	if requested.longerThan(max) || (requested.Sliding && !max.Sliding && !max.Forever) {
+		return requested, errors.Wrapf(ErrInvalidRequest, "retention %s exceeds the maximum of %s", requested, max)
+	}
+	return requested, nil
+}
+
+// refreshReport keeps a cached report for longer when it has a sliding
+// retention or when the request asks for a longer retention than it was
+// stored with.
+func (a *apiService) refreshReport(ctx context.Context, key string, report DocumentFrequencyReport, requested Retention) error {
+	stored := DefaultRetention
+	if report.Retention != nil {
+		stored = *report.Retention
+	}
+	r := stored
+	if requested.longerThan(stored) {
+		r = requested
+	}
+	if !r.Sliding && r == stored {
+		return nil
+	}
+	report.Retention = &r
+	data, err := json.Marshal(report)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := a.kv.Store(ctx, key, data, r.expiration()); err != nil {
+		return errors.Wrapf(err, "unable to refresh report for document %s", report.DocumentID)
+	}
+	// The counts, the signature and the list of results are kept for at
+	// least as long as the report, like when they were stored.
+	analysis := r.atLeast(analysisExpiration)
+	for _, k := range []string{termsKey(key), signatureKey(key), resultsKey(report.DocumentID)} {
+		if err := a.refreshKey(ctx, k, analysis); err != nil {
+			return errors.Wrapf(err, "unable to refresh %s", k)
+		}
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Refreshed report for document %s with retention %s", report.DocumentID, r))
+	return nil
+}
+
+// refreshKey stores the value of key again with expiration. Missing keys are
+// left missing, so keys deleted meanwhile are not stored again.
+func (a *apiService) refreshKey(ctx context.Context, key string, expiration time.Duration) error {
+	return keyvalue.Update(ctx, a.kv, key, expiration, func(data []byte) ([]byte, error) {
+		return data, nil
+	})
+}
@@ -0,0 +1,191 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+func TestParseRetention(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text      string
+		retention service.Retention
+	}{
+		{"30s", service.Retention{Duration: 30 * time.Second}},
+		{"forever", service.Retention{Forever: true}},
+		{"sliding:1h", service.Retention{Duration: time.Hour, Sliding: true}},
+	}
+	for _, test := range tests {
+		r, err := service.ParseRetention(test.text)
+		require.NoError(t, err, "Parsing %q should succeed.", test.text)
+		assert.Equal(t, test.retention, r, "%q should be parsed.", test.text)
+		parsed, err := service.ParseRetention(r.String())
+		require.NoError(t, err, "Parsing the text form should succeed.")
+		assert.Equal(t, r, parsed, "Text form should round trip.")
+	}
+	for _, text := range []string{"", "soon", "-1s", "sliding:forever"} {
+		_, err := service.ParseRetention(text)
+		assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "%q should be rejected.", text)
+	}
+
+	tenants, err := service.ParseTenantRetention("acme=forever,beta=sliding:1h")
+	require.NoError(t, err, "Parsing tenant retention should succeed.")
+	assert.Equal(t, map[string]service.Retention{
+		"acme": {Forever: true},
+		"beta": {Duration: time.Hour, Sliding: true},
+	}, tenants)
+	_, err = service.ParseTenantRetention("acme")
+	assert.Error(t, err, "Tenants without a retention should be rejected.")
+}
+
+// expirationKeyValue records the expiration that each key was last stored
+// with.
+type expirationKeyValue struct {
+	*keyvaluemock.KeyValueMock
+	mu          sync.Mutex
+	expirations map[string]time.Duration
+}
+
+func (e *expirationKeyValue) Store(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	e.mu.Lock()
+	e.expirations[key] = expiration
+	e.mu.Unlock()
+	return e.KeyValueMock.Store(ctx, key, data, expiration)
+}
+
+func (e *expirationKeyValue) CompareAndSwap(ctx context.Context, key string, version keyvalue.Version, data []byte, expiration time.Duration) (bool, error) {
+	swapped, err := e.KeyValueMock.CompareAndSwap(ctx, key, version, data, expiration)
+	if swapped {
+		e.mu.Lock()
+		e.expirations[key] = expiration
+		e.mu.Unlock()
+	}
+	return swapped, err
+}
+
+func (e *expirationKeyValue) expiration(key string) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.expirations[key]
+}
+
+func TestRetention(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := &expirationKeyValue{
+		KeyValueMock: keyvaluemock.New(),
+		expirations:  make(map[string]time.Duration),
+	}
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:           q,
+		KeyVal:          kv,
+		Log:             l,
+		Channel:         channel,
+		Retention:       service.Retention{Duration: time.Minute},
+		TenantRetention: map[string]service.Retention{"acme": {Duration: time.Hour, Sliding: true}},
+		MaxRetention:    service.Retention{Forever: true},
+	})
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go runWorker(ctx, q, channel, worker)
+
+	process := func(ctx context.Context, document string, opts service.RetentionOptions) string {
+		dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+			Document:         document,
+			RetentionOptions: opts,
+		})
+		require.NoError(t, err, "Processing document should succeed.")
+		return dfr.DocumentID
+	}
+
+	id := process(ctx, "kept for the global retention", service.RetentionOptions{})
+	assert.Equal(t, time.Minute, kv.expiration(id), "Global retention should be used.")
+	assert.Equal(t, 24*time.Hour, kv.expiration("terms:"+id), "Counts should be kept for at least a day.")
+
+	id = process(service.WithTenant(ctx, "acme"), "kept for the tenant retention", service.RetentionOptions{})
+	assert.Equal(t, time.Hour, kv.expiration(id), "Tenant retention should be used.")
+	data, err := kv.Retrieve(ctx, id)
+	require.NoError(t, err, "Report should be stored.")
+	var report service.DocumentFrequencyReport
+	require.NoError(t, json.Unmarshal(data, &report), "Report should unmarshal.")
+	require.NotNil(t, report.Retention, "Report should record its retention.")
+	assert.True(t, report.Retention.Sliding, "Tenant retention should be sliding.")
+
+	// Sliding retentions are refreshed on cache hits.
+	kv.mu.Lock()
+	delete(kv.expirations, id)
+	delete(kv.expirations, "terms:"+id)
+	kv.mu.Unlock()
+	process(ctx, "kept for the tenant retention", service.RetentionOptions{})
+	assert.Equal(t, time.Hour, kv.expiration(id), "Sliding retention should be refreshed.")
+	assert.Equal(t, 24*time.Hour, kv.expiration("terms:"+id), "Counts should be refreshed with the report.")
+
+	// Longer retentions extend cached reports, and shorter ones do not.
+	id = process(ctx, "kept forever", service.RetentionOptions{RetainFor: "10m"})
+	assert.Equal(t, 10*time.Minute, kv.expiration(id), "Requested retention should be used.")
+	process(ctx, "kept forever", service.RetentionOptions{RetainFor: "forever"})
+	assert.Zero(t, kv.expiration(id), "Longer retention should extend the report.")
+	assert.Zero(t, kv.expiration("terms:"+id), "Longer retention should extend the counts.")
+	process(ctx, "kept forever", service.RetentionOptions{RetainFor: "1s"})
+	assert.Zero(t, kv.expiration(id), "Shorter retention should not shorten the report.")
+
+	_, err = apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:         "invalid",
+		RetentionOptions: service.RetentionOptions{RetainFor: "eventually"},
+	})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "Invalid retention should be rejected.")
+}
+
+func TestMaxRetention(t *testing.T) {
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:           queuemock.New(),
+		KeyVal:          keyvaluemock.New(),
+		Log:             log.NewNopLogger(),
+		Channel:         "worker",
+		Retention:       service.Retention{Duration: time.Minute},
+		TenantRetention: map[string]service.Retention{"acme": {Duration: time.Hour}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, tc := range []struct {
+		ctx       context.Context
+		retainFor string
+	}{
+		{ctx, "forever"},
+		{ctx, "10m"},
+		{ctx, "sliding:1s"},
+		{service.WithTenant(ctx, "acme"), "2h"},
+		{service.WithTenant(ctx, "other"), "1h"},
+	} {
+		_, err := apiService.ProcessDocument(tc.ctx, service.DocumentRequest{
+			Document:         "kept for too long",
+			RetentionOptions: service.RetentionOptions{RetainFor: tc.retainFor},
+		})
+		assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err),
+			"Retention %s should be rejected for tenant %q.", tc.retainFor, service.TenantFrom(tc.ctx))
+	}
+}
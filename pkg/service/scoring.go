@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Scoring modes.
+const (
+	// ModeCounts ranks words by the number of times they occur in a document.
+	ModeCounts = "counts"
+	// ModeTFIDF ranks words by TF-IDF against a reference collection.
+	ModeTFIDF = "tfidf"
+	// ModeBM25 ranks words by their BM25 weight against a reference
+	// collection.
+	ModeBM25 = "bm25"
+)
+
+// IDF smoothing variants for ModeTFIDF, where N is the number of documents in
+// the collection and df is the number of documents that contain a word.
+const (
+	// SmoothingSmooth is ln((1 + N) / (1 + df)) + 1.
+	SmoothingSmooth = "smooth"
+	// SmoothingStandard is ln(N / df), where words that are not in the
+	// collection count as being in one document.
+	SmoothingStandard = "standard"
+	// SmoothingProbabilistic is max(0, ln((N - df) / df)), where words that
+	// are not in the collection count as being in one document.
+	SmoothingProbabilistic = "probabilistic"
+)
+
+// Default BM25 parameters.
+const (
+	DefaultBM25K1 = 1.2
+	DefaultBM25B  = 0.75
+)
+
+// DefaultTopN is the number of words returned in a report.
+const DefaultTopN = 10
+
+// ScoringOptions contains the options that change how the words of a document
+// are ranked.
+//
+// The scores depend on the reference collection at the time of the request,
+// so they are computed by the API from the cached word counts of the document
+// instead of by the workers.
+type ScoringOptions struct {
+	// Mode is one of ModeCounts, ModeTFIDF or ModeBM25. ModeCounts is used if
+	// it is not set.
+	Mode string `json:"mode,omitempty"`
+	// Collection is the reference collection for ModeTFIDF and ModeBM25.
+	Collection string `json:"collection,omitempty"`
+	// Smoothing is the IDF variant for ModeTFIDF. SmoothingSmooth is used if
+	// it is not set.
+	Smoothing string `json:"smoothing,omitempty"`
+	// K1 and B are the BM25 parameters. DefaultBM25K1 and DefaultBM25B are
+	// used if they are not set.
+	K1 *float64 `json:"k1,omitempty"`
+	B  *float64 `json:"b,omitempty"`
+	// N is the number of words to return for ModeTFIDF and ModeBM25.
+	// DefaultTopN is used if it is 0.
+	N int `json:"n,omitempty"`
+}
+
+// scored reports if the words are ranked against a collection.
+func (o ScoringOptions) scored() bool {
+	return o.Mode != "" && o.Mode != ModeCounts
+}
+
+func (o ScoringOptions) validate() error {
+	switch o.Mode {
+	case "", ModeCounts:
+		return nil
+	case ModeTFIDF:
+		switch o.Smoothing {
+		case "", SmoothingSmooth, SmoothingStandard, SmoothingProbabilistic:
+		default:
+			return errors.Wrapf(ErrInvalidRequest, "unknown smoothing %q", o.Smoothing)
+		}
+	case ModeBM25:
+		if o.K1 != nil && *o.K1 < 0 {
+			return errors.Wrap(ErrInvalidRequest, "k1 must not be negative")
+		}
+		if o.B != nil && (*o.B < 0 || *o.B > 1) {
+			return errors.Wrap(ErrInvalidRequest, "b must be between 0 and 1")
+		}
+	default:
+		return errors.Wrapf(ErrInvalidRequest, "unknown mode %q", o.Mode)
+	}
+	if o.N < 0 {
+		return errors.Wrap(ErrInvalidRequest, "n must not be negative")
+	}
+	return validateCollection(o.Collection)
+}
+
+// idf returns the inverse document frequency of a word that is in df of the n
+// documents of a collection.
+func idf(smoothing string, n, df int) float64 {
+	switch smoothing {
+	case SmoothingStandard:
+		return math.Log(float64(n) / math.Max(float64(df), 1))
+	case SmoothingProbabilistic:
+		d := math.Max(float64(df), 1)
+		return math.Max(0, math.Log((float64(n)-d)/d))
+	default:
+		return math.Log(float64(1+n)/float64(1+df)) + 1
+	}
+}
+
+// bm25 returns the BM25 weight of a word that occurs tf times in a document of
+// length dl and in df of the n documents of a collection with an average
+// document length of avgdl.
+func bm25(k1, b float64, tf, dl, n, df int, avgdl float64) float64 {
+	w := math.Log(1 + (float64(n)-float64(df)+0.5)/(float64(df)+0.5))
+	norm := 1 - b
+	if avgdl > 0 {
+		norm += b * float64(dl) / avgdl
+	}
+	return w * float64(tf) * (k1 + 1) / (float64(tf) + k1*norm)
+}
+
+// scoreTerms ranks the words of a document against the statistics of a
+// collection.
+func scoreTerms(opts ScoringOptions, words map[string]int, stats collectionStats) []Frequency {
+	k1, b := DefaultBM25K1, DefaultBM25B
+	if opts.K1 != nil {
+		k1 = *opts.K1
+	}
+	if opts.B != nil {
+		b = *opts.B
+	}
+	var dl int
+	for _, count := range words {
+		dl += count
+	}
+	avgdl := float64(stats.TotalTerms) / float64(stats.Documents)
+
+	out := make([]Frequency, 0, len(words))
+	for word, count := range words {
+		df := stats.DocumentFrequency[word]
+		f := Frequency{Word: word, Frequency: count}
+		if opts.Mode == ModeBM25 {
+			f.Score = bm25(k1, b, count, dl, stats.Documents, df, avgdl)
+		} else {
+			f.Score = float64(count) * idf(opts.Smoothing, stats.Documents, df)
+		}
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Word < out[j].Word
+	})
+
+	n := opts.N
+	if n == 0 {
+		n = DefaultTopN
+	}
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// score returns a report for a document with the words ranked against a
+// reference collection.
+func (a *apiService) score(ctx context.Context, workerRequest DocumentID, opts ScoringOptions) (DocumentFrequenciesResponse, error) {
+	var dfr DocumentFrequenciesResponse
+	stats, err := a.loadCollection(ctx, opts.Collection)
+	if err != nil {
+		return dfr, err
+	}
+	if stats.Documents == 0 {
+		return dfr, errors.Wrapf(ErrNotFound, "collection %q has no documents", opts.Collection)
+	}
+	words, err := a.documentTerms(ctx, workerRequest)
+	if err != nil {
+		return dfr, err
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Scoring document %s with %s against collection %q", workerRequest.ID, opts.Mode, opts.Collection))
+	return DocumentFrequenciesResponse{
+		DocumentID:  workerRequest.ID,
+		Frequencies: scoreTerms(opts, words, stats),
+	}, nil
+}
@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+func TestScoring(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	apiService := newCollectionService(ctx)
+
+	for _, document := range []string{
+		"pet cat sat",
+		"pet dog sat",
+		"pet bird flew",
+	} {
+		_, err := apiService.AddToCollection(ctx, service.CollectionDocumentRequest{
+			Collection:      "pets",
+			DocumentRequest: service.DocumentRequest{Document: document},
+		})
+		require.NoError(t, err, "Adding document should succeed.")
+	}
+
+	score := func(scoring service.ScoringOptions) []service.Frequency {
+		dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+			Document:       "pet pet cat cat fish",
+			ScoringOptions: scoring,
+		})
+		require.NoError(t, err, "Scoring document should succeed.")
+		return dfr.Frequencies
+	}
+
+	// N = 3; df(pet) = 3, df(cat) = 1, df(fish) = 0.
+	tfidf := score(service.ScoringOptions{Mode: service.ModeTFIDF, Collection: "pets"})
+	require.Len(t, tfidf, 3, "Every word should be scored.")
+	assert.Equal(t, []string{"cat", "fish", "pet"}, words(tfidf), "Distinctive words should rank first.")
+	assert.InDelta(t, 2*(math.Log(4.0/2)+1), tfidf[0].Score, 1e-9, "Smooth IDF should be used by default.")
+	assert.Equal(t, 2, tfidf[0].Frequency, "Counts should be kept.")
+
+	standard := score(service.ScoringOptions{Mode: service.ModeTFIDF, Collection: "pets", Smoothing: service.SmoothingStandard, N: 1})
+	require.Len(t, standard, 1, "N words should be returned.")
+	assert.Equal(t, service.Frequency{Word: "cat", Frequency: 2, Score: 2 * math.Log(3)}, standard[0])
+
+	bm25 := score(service.ScoringOptions{Mode: service.ModeBM25, Collection: "pets"})
+	// Term frequency saturates, so the rarest word ranks first.
+	assert.Equal(t, []string{"fish", "cat", "pet"}, words(bm25), "Distinctive words should rank first.")
+	// avgdl = 3, dl = 5, tf(cat) = 2, df(cat) = 1.
+	idf := math.Log(1 + 2.5/1.5)
+	norm := 1 - 0.75 + 0.75*5.0/3
+	assert.InDelta(t, idf*2*2.2/(2+1.2*norm), bm25[1].Score, 1e-9, "BM25 weight should match.")
+
+	_, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:       "text",
+		ScoringOptions: service.ScoringOptions{Mode: service.ModeTFIDF, Collection: "missing"},
+	})
+	assert.Equal(t, service.ErrNotFound, errors.Cause(err), "Missing collections should be reported.")
+
+	_, err = apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:       "text",
+		ScoringOptions: service.ScoringOptions{Mode: "unknown"},
+	})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "Unknown modes should be rejected.")
+}
+
+func words(frequencies []service.Frequency) []string {
+	var out []string
+	for _, f := range frequencies {
+		out = append(out, f.Word)
+	}
+	return out
+}
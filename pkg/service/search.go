@@ -0,0 +1,123 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/language"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/search"
+)
+
+// SearchRequest is a request to search the analyzed documents.
+type SearchRequest struct {
+	// Query consists of words, phrases in double quotes and the operators
+	// AND, OR and NOT.
+	Query string
+	// Ranking is ModeBM25 or ModeTFIDF. ModeBM25 is used if it is empty.
+	Ranking string
+	Offset  int
+	Limit   int
+}
+
+// SearchResponse holds a page of the documents that match a query.
+type SearchResponse struct {
+	Query string
+	// Total is the number of matching documents.
+	Total  int
+	Offset int
+	Hits   []search.Hit
+}
+
+// indexDocument adds a document to the search index once it is counted, if
+// it is kept in the document store. Failures are only logged, as the document
+// is counted already.
+//
+// The files of archives are indexed as documents of their own. Large
+// documents are indexed as a whole when they are split, so their shards are
+// not indexed.
+func (w *workerService) indexDocument(ctx context.Context, doc DocumentID) {
+	if w.index == nil || !doc.Searchable || (doc.Shard != nil && doc.Shard.Name == "") {
+		return
+	}
+	if err := w.addToIndex(ctx, doc); err != nil {
+		_ = w.log.Log("LEVEL", "WARN", "MESSAGE", fmt.Sprintf("Unable to index document %s: %s", doc.ID, err))
+	}
+}
+
+// addToIndex reads a document again and adds its words to the search index in
+// parts of the largest number of words that the index keeps for each part, so
+// that only one part is held in memory.
+func (w *workerService) addToIndex(ctx context.Context, doc DocumentID) error {
+	raw, _, err := w.openDocument(ctx, doc)
+	if err != nil {
+		return err
+	}
+	reader, err := extract.Reader(raw, doc.ContentType, doc.extractOptions())
+	if err != nil {
+		return errors.Wrapf(err, "unable to read document %s", doc.ID)
+	}
+	defer reader.Close()
+	text := bufio.NewReaderSize(reader, language.SampleSize)
+	scanner := w.scanner(text, doc.analyzer(detectLanguage(doc, text)))
+
+	part := search.Document{ID: doc.ID}
+	if doc.Shard != nil {
+		part.Parent = doc.Shard.Parent
+		part.Name = doc.Shard.Name
+	}
+	maxWords := w.index.MaxWords()
+	words := make([]string, 0, maxWords)
+	for scanner.Scan() {
+		words = append(words, scanner.Text())
+		if len(words) < maxWords {
+			continue
+		}
+		part.Words = words
+		if err := w.index.Add(ctx, part); err != nil {
+			return err
+		}
+		part.Part++
+		words = words[:0]
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "error while scanning document")
+	}
+	// Empty documents are indexed as well, so that they are known to the
+	// index.
+	if len(words) == 0 && part.Part > 0 {
+		return nil
+	}
+	part.Words = words
+	return w.index.Add(ctx, part)
+}
+
+// Search searches the documents that were analyzed by the workers.
+func (a *apiService) Search(ctx context.Context, request SearchRequest) (SearchResponse, error) {
+	var sr SearchResponse
+	if a.index == nil {
+		return sr, errors.New("search is not supported")
+	}
+	results, err := a.index.Search(ctx, request.Query, search.Options{
+		Ranking: request.Ranking,
+		Offset:  request.Offset,
+		Limit:   request.Limit,
+	})
+	switch errors.Cause(err) {
+	case nil:
+	case search.ErrInvalidQuery, search.ErrInvalidOptions:
+		return sr, errors.Wrap(ErrInvalidRequest, err.Error())
+	default:
+		return sr, errors.Wrapf(err, "unable to search for %q", request.Query)
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Search for %q matched %d documents", request.Query, results.Total))
+	return SearchResponse{
+		Query:  request.Query,
+		Total:  results.Total,
+		Offset: request.Offset,
+		Hits:   results.Hits,
+	}, nil
+}
@@ -0,0 +1,1123 @@
+// Package search implements an inverted index for full-text search over
+// analyzed documents.
+//
+// The index maps each term to the documents that contain it and the positions
+// of the term in them, which makes phrase queries possible. Terms are words
+// in lower case without surrounding punctuation. The words of each document
+// are kept as well to build snippets of the matches.
+//
+// The index is kept in a key value store, so every process sharing the store
+// searches and changes the same index. The documents containing a term are
+// spread over several keys by document ID, and the positions of a term in a
+// part of a document have a key of their own, so adding a document only
+// changes small values and processes can index documents concurrently.
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+// Ranking functions.
+const (
+	RankBM25  = "bm25"
+	RankTFIDF = "tfidf"
+)
+
+const (
+	// DefaultLimit is the number of hits returned when no limit is set.
+	DefaultLimit = 10
+	// MaxLimit is the largest number of hits returned at once.
+	MaxLimit = 100
+	// DefaultMaxWords is the largest number of words indexed for each part of
+	// a document when no limit is configured.
+	DefaultMaxWords = 64 * 1024
+
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	// snippetRadius is the number of words before and after a match in a
+	// snippet.
+	snippetRadius = 8
+
+	// updateConcurrency is the largest number of terms of a document that are
+	// updated at once.
+	updateConcurrency = 16
+
+	// buckets is the number of keys that the documents of a term, and the IDs
+	// of every document, are spread over.
+	buckets = 32
+)
+
+// ErrInvalidOptions is returned when the options of a search are invalid.
+var ErrInvalidOptions = errors.New("invalid search options")
+
+// statsKey is the key of the number of indexed documents and their total
+// length.
+var statsKey = keyspace.SearchStats()
+
+// bucket returns the bucket of a document.
+func bucket(id string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return int(h.Sum32() % buckets)
+}
+
+// termHash returns the hash of a term that its keys are named after, so that
+// the words of documents are not part of key names.
+func termHash(term string) string {
+	sum := sha256.Sum256([]byte(term))
+	return hex.EncodeToString(sum[:16])
+}
+
+// termKey returns the key of the documents in a bucket that contain a term.
+func termKey(term string, bucket int) string {
+	return keyspace.SearchTerm(termHash(term), bucket)
+}
+
+// positionsKey returns the key of the positions of a term in a part of a
+// document.
+func positionsKey(term, id string, part int) string {
+	return keyspace.SearchPositions(termHash(term), id, part)
+}
+
+// documentsKey returns the key of the IDs of the indexed documents in a
+// bucket.
+func documentsKey(bucket int) string {
+	return keyspace.SearchDocuments(bucket)
+}
+
+func documentKey(id string) string {
+	return keyspace.SearchDocument(id)
+}
+
+func wordsKey(id string, part int) string {
+	return keyspace.SearchWords(id, part)
+}
+
+// filesKey returns the key of the IDs of the indexed files of an archive.
+func filesKey(parent string) string {
+	return keyspace.SearchFiles(parent)
+}
+
+// Normalize returns the term that a word is indexed under. An empty string is
+// returned for words that consist only of punctuation.
+func Normalize(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}))
+}
+
+// Document is a part of a document to index.
+type Document struct {
+	ID string
+	// Parent is the ID of the archive that the document is a file of.
+	Parent string
+	// Name is the name of the document in its archive.
+	Name string
+	// Part is the index of the part of a document that is too large to be
+	// analyzed at once. Phrases are not matched across parts.
+	Part int
+	// Words are the words of the part in order.
+	Words []string
+}
+
+// document is an indexed document. The words of each part are stored under
+// keys of their own.
+type document struct {
+	Parent string `json:",omitempty"`
+	Name   string `json:",omitempty"`
+	// Parts holds the number of terms in each part of the document.
+	Parts map[int]int
+}
+
+// length returns the number of terms in the document.
+func (d document) length() int {
+	var n int
+	for _, terms := range d.Parts {
+		n += terms
+	}
+	return n
+}
+
+// stats are the number of indexed documents and their total length, which
+// BM25 ranks matches with.
+type stats struct {
+	Documents int
+	Length    int64
+}
+
+// termDocuments holds the number of occurrences of a term in each part of
+// the documents that contain it, by document ID.
+type termDocuments map[string]map[int]int
+
+// frequency returns the number of occurrences of the term in a document.
+func (docs termDocuments) frequency(id string) int {
+	var n int
+	for _, count := range docs[id] {
+		n += count
+	}
+	return n
+}
+
+// position is the position of a term in a document.
+type position struct {
+	part   int
+	offset int
+}
+
+// Index is an inverted index. It is safe for concurrent use, also by several
+// processes sharing its key value store.
+type Index struct {
+	kv       keyvalue.KeyValue
+	maxWords int
+}
+
+// Config contains the configuration for an Index.
+type Config struct {
+	// MaxWords is the largest number of words indexed for each part of a
+	// document. DefaultMaxWords is used if it is 0.
+	MaxWords int
+}
+
+// NewIndex returns an Index that is kept in kv, which must return nil for
+// keys without a value.
+func NewIndex(kv keyvalue.KeyValue, conf Config) *Index {
+	ix := &Index{
+		kv:       kv,
+		maxWords: conf.MaxWords,
+	}
+	if ix.maxWords <= 0 {
+		ix.maxWords = DefaultMaxWords
+	}
+	return ix
+}
+
+// MaxWords returns the largest number of words indexed for each part of a
+// document.
+func (ix *Index) MaxWords() int {
+	return ix.maxWords
+}
+
+// Add adds a part of a document to the index, replacing the part if it was
+// already indexed.
+//
+// A document is only found once its first part is added completely. Adding a
+// part again after a failure finishes adding it.
+func (ix *Index) Add(ctx context.Context, doc Document) error {
+	words := doc.Words
+	if len(words) > ix.maxWords {
+		words = words[:ix.maxWords]
+	}
+	old, err := ix.words(ctx, doc.ID, doc.Part)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(words)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ix.kv.Store(ctx, wordsKey(doc.ID, doc.Part), data, 0); err != nil {
+		return errors.Wrapf(err, "unable to store words of document %s", doc.ID)
+	}
+
+	positions := termPositions(words)
+	entries := make([]keyvalue.Entry, 0, len(positions))
+	var length int
+	for term, offsets := range positions {
+		data, err := json.Marshal(offsets)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		entries = append(entries, keyvalue.Entry{Key: positionsKey(term, doc.ID, doc.Part), Data: data})
+		length += len(offsets)
+	}
+	if len(entries) > 0 {
+		if err := ix.kv.StoreMany(ctx, entries); err != nil {
+			return errors.Wrapf(err, "unable to store positions of document %s", doc.ID)
+		}
+	}
+
+	// The terms of the replaced part that are not in the new part are
+	// removed.
+	terms := make(map[string]bool)
+	for term := range positions {
+		terms[term] = true
+	}
+	var removed []string
+	for term := range termPositions(old) {
+		if !terms[term] {
+			terms[term] = true
+			removed = append(removed, term)
+		}
+	}
+	err = ix.updateTerms(ctx, doc.ID, terms, func(term string, docs termDocuments) bool {
+		return setPart(docs, doc.ID, doc.Part, len(positions[term]))
+	})
+	if err != nil {
+		return err
+	}
+	for _, term := range removed {
+		if err := ix.kv.Delete(ctx, positionsKey(term, doc.ID, doc.Part)); err != nil {
+			return errors.Wrapf(err, "unable to delete positions of document %s", doc.ID)
+		}
+	}
+
+	var added bool
+	var delta int
+	err = ix.updateDocument(ctx, doc.ID, func(stored *document) bool {
+		added = stored.Parts == nil
+		if added {
+			stored.Parts = make(map[int]int)
+		}
+		delta = length - stored.Parts[doc.Part]
+		stored.Parent = doc.Parent
+		stored.Name = doc.Name
+		stored.Parts[doc.Part] = length
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if doc.Parent != "" {
+		err := updateList(ctx, ix.kv, filesKey(doc.Parent), func(files []string) ([]string, bool) {
+			return addID(files, doc.ID)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "unable to add document %s to the files of %s", doc.ID, doc.Parent)
+		}
+	}
+	err = updateList(ctx, ix.kv, documentsKey(bucket(doc.ID)), func(ids []string) ([]string, bool) {
+		return addID(ids, doc.ID)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to add indexed document %s", doc.ID)
+	}
+	if !added && delta == 0 {
+		return nil
+	}
+	return ix.updateStats(ctx, func(s *stats) {
+		if added {
+			s.Documents++
+		}
+		s.Length += int64(delta)
+	})
+}
+
+// Remove removes a document from the index, along with the files of the
+// document if it is an archive. Removing it again after a failure finishes
+// removing it.
+func (ix *Index) Remove(ctx context.Context, id string) error {
+	files, err := retrieveList(ctx, ix.kv, filesKey(id))
+	if err != nil {
+		return err
+	}
+	for _, docID := range append(files, id) {
+		if err := ix.removeDocument(ctx, docID); err != nil {
+			return err
+		}
+	}
+	return errors.Wrapf(ix.kv.Delete(ctx, filesKey(id)), "unable to remove files of document %s", id)
+}
+
+// removeDocument removes the terms, positions, words and length of a
+// document.
+func (ix *Index) removeDocument(ctx context.Context, id string) error {
+	data, err := ix.kv.Retrieve(ctx, documentKey(id))
+	if err != nil {
+		return errors.Wrapf(err, "unable to retrieve indexed document %s", id)
+	}
+	if data != nil {
+		var d document
+		if err := json.Unmarshal(data, &d); err != nil {
+			return errors.Wrapf(err, "invalid indexed document %s", id)
+		}
+		// The terms are removed first, so that the words are still known if
+		// removing them fails.
+		for part := range d.Parts {
+			words, err := ix.words(ctx, id, part)
+			if err != nil {
+				return err
+			}
+			terms := make(map[string]bool)
+			for term := range termPositions(words) {
+				terms[term] = true
+			}
+			err = ix.updateTerms(ctx, id, terms, func(term string, docs termDocuments) bool {
+				_, ok := docs[id]
+				delete(docs, id)
+				return ok
+			})
+			if err != nil {
+				return err
+			}
+			for term := range terms {
+				if err := ix.kv.Delete(ctx, positionsKey(term, id, part)); err != nil {
+					return errors.Wrapf(err, "unable to delete positions of document %s", id)
+				}
+			}
+		}
+		for part := range d.Parts {
+			if err := ix.kv.Delete(ctx, wordsKey(id, part)); err != nil {
+				return errors.Wrapf(err, "unable to delete words of document %s", id)
+			}
+		}
+
+		var removed bool
+		err = keyvalue.Update(ctx, ix.kv, documentKey(id), 0, func(data []byte) ([]byte, error) {
+			removed = data != nil
+			if !removed {
+				return nil, keyvalue.ErrUnchanged
+			}
+			if err := json.Unmarshal(data, &d); err != nil {
+				return nil, errors.Wrapf(err, "invalid indexed document %s", id)
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "unable to delete indexed document %s", id)
+		}
+		if removed {
+			err := ix.updateStats(ctx, func(s *stats) {
+				s.Documents--
+				s.Length -= int64(d.length())
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = updateList(ctx, ix.kv, documentsKey(bucket(id)), func(ids []string) ([]string, bool) {
+		for i, docID := range ids {
+			if docID == id {
+				return append(ids[:i], ids[i+1:]...), true
+			}
+		}
+		return ids, false
+	})
+	return errors.Wrapf(err, "unable to remove indexed document %s", id)
+}
+
+// Len returns the number of documents in the index.
+func (ix *Index) Len(ctx context.Context) (int, error) {
+	s, err := ix.stats(ctx)
+	return s.Documents, err
+}
+
+// termPositions returns the offsets of each term in the words of a part.
+func termPositions(words []string) map[string][]int {
+	positions := make(map[string][]int)
+	for offset, word := range words {
+		term := Normalize(word)
+		if term == "" {
+			continue
+		}
+		positions[term] = append(positions[term], offset)
+	}
+	return positions
+}
+
+// setPart sets the number of occurrences of a term in a part of a document
+// and reports if it changed.
+func setPart(docs termDocuments, id string, part, count int) bool {
+	parts := docs[id]
+	if parts[part] == count {
+		return false
+	}
+	if count == 0 {
+		delete(parts, part)
+		if len(parts) == 0 {
+			delete(docs, id)
+		}
+		return true
+	}
+	if parts == nil {
+		parts = make(map[int]int)
+		docs[id] = parts
+	}
+	parts[part] = count
+	return true
+}
+
+// addID adds an ID to a list of IDs and reports if it was not in it yet.
+func addID(ids []string, id string) ([]string, bool) {
+	for _, other := range ids {
+		if other == id {
+			return ids, false
+		}
+	}
+	return append(ids, id), true
+}
+
+// updateTerms changes the documents of several terms in the bucket of a
+// document with update, which reports if it changed them and may be called
+// more than once for a term.
+func (ix *Index) updateTerms(ctx context.Context, id string, terms map[string]bool, update func(term string, docs termDocuments) bool) error {
+	group, ctx := errgroup.WithContext(ctx)
+	next := make(chan string)
+	for i := 0; i < updateConcurrency && i < len(terms); i++ {
+		group.Go(func() error {
+			for term := range next {
+				if err := ix.updateTerm(ctx, term, bucket(id), update); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	group.Go(func() error {
+		defer close(next)
+		for term := range terms {
+			select {
+			case next <- term:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+	return group.Wait()
+}
+
+// updateTerm changes the documents of a term in a bucket with update. Buckets
+// without documents are deleted.
+func (ix *Index) updateTerm(ctx context.Context, term string, bucket int, update func(term string, docs termDocuments) bool) error {
+	err := keyvalue.Update(ctx, ix.kv, termKey(term, bucket), 0, func(data []byte) ([]byte, error) {
+		docs := make(termDocuments)
+		if data != nil {
+			if err := json.Unmarshal(data, &docs); err != nil {
+				return nil, errors.Wrapf(err, "invalid documents of term %q", term)
+			}
+		}
+		switch {
+		case !update(term, docs):
+			return nil, keyvalue.ErrUnchanged
+		case len(docs) == 0:
+			return nil, nil
+		}
+		data, err := json.Marshal(docs)
+		return data, errors.WithStack(err)
+	})
+	return errors.Wrapf(err, "unable to update documents of term %q", term)
+}
+
+// updateDocument changes an indexed document with update, which reports if it
+// changed the document and may be called more than once.
+func (ix *Index) updateDocument(ctx context.Context, id string, update func(d *document) bool) error {
+	err := keyvalue.Update(ctx, ix.kv, documentKey(id), 0, func(data []byte) ([]byte, error) {
+		var d document
+		if data != nil {
+			if err := json.Unmarshal(data, &d); err != nil {
+				return nil, errors.Wrapf(err, "invalid indexed document %s", id)
+			}
+		}
+		if !update(&d) {
+			return nil, keyvalue.ErrUnchanged
+		}
+		data, err := json.Marshal(d)
+		return data, errors.WithStack(err)
+	})
+	return errors.Wrapf(err, "unable to update indexed document %s", id)
+}
+
+// stats returns the number of indexed documents and their total length.
+func (ix *Index) stats(ctx context.Context) (stats, error) {
+	var s stats
+	data, err := ix.kv.Retrieve(ctx, statsKey)
+	if err != nil || data == nil {
+		return s, errors.Wrap(err, "unable to retrieve index statistics")
+	}
+	err = json.Unmarshal(data, &s)
+	return s, errors.Wrap(err, "invalid index statistics")
+}
+
+// updateStats changes the number of indexed documents and their total length
+// with update, which may be called more than once. The statistics are only
+// used to rank matches, so they are not restored if a document is added or
+// removed again after a failure.
+func (ix *Index) updateStats(ctx context.Context, update func(s *stats)) error {
+	err := keyvalue.Update(ctx, ix.kv, statsKey, 0, func(data []byte) ([]byte, error) {
+		var s stats
+		if data != nil {
+			if err := json.Unmarshal(data, &s); err != nil {
+				return nil, errors.Wrap(err, "invalid index statistics")
+			}
+		}
+		update(&s)
+		if s.Documents <= 0 {
+			return nil, nil
+		}
+		data, err := json.Marshal(s)
+		return data, errors.WithStack(err)
+	})
+	return errors.Wrap(err, "unable to update index statistics")
+}
+
+// words returns the indexed words of a part of a document.
+func (ix *Index) words(ctx context.Context, id string, part int) ([]string, error) {
+	words, err := retrieveList(ctx, ix.kv, wordsKey(id, part))
+	return words, errors.Wrapf(err, "unable to retrieve words of document %s", id)
+}
+
+// retrieveList retrieves a list of strings, which is empty if there is no
+// value for key.
+func retrieveList(ctx context.Context, kv keyvalue.KeyValue, key string) ([]string, error) {
+	data, err := kv.Retrieve(ctx, key)
+	if err != nil || data == nil {
+		return nil, errors.WithStack(err)
+	}
+	var list []string
+	err = json.Unmarshal(data, &list)
+	return list, errors.Wrapf(err, "invalid list %s", key)
+}
+
+// updateList replaces a list of strings with the list returned by update,
+// which reports if it changed the list and may be called more than once.
+// Empty lists are deleted.
+func updateList(ctx context.Context, kv keyvalue.KeyValue, key string, update func(list []string) ([]string, bool)) error {
+	return keyvalue.Update(ctx, kv, key, 0, func(data []byte) ([]byte, error) {
+		var list []string
+		if data != nil {
+			if err := json.Unmarshal(data, &list); err != nil {
+				return nil, errors.Wrapf(err, "invalid list %s", key)
+			}
+		}
+		list, changed := update(list)
+		switch {
+		case !changed:
+			return nil, keyvalue.ErrUnchanged
+		case len(list) == 0:
+			return nil, nil
+		}
+		data, err := json.Marshal(list)
+		return data, errors.WithStack(err)
+	})
+}
+
+// Options contains the options for a search.
+type Options struct {
+	// Ranking is RankBM25 or RankTFIDF. RankBM25 is used if it is empty.
+	Ranking string
+	Offset  int
+	// Limit is the number of hits to return. DefaultLimit is used if it is 0.
+	Limit int
+}
+
+// Results are the results of a search.
+type Results struct {
+	// Total is the number of matching documents.
+	Total int
+	Hits  []Hit
+}
+
+// Hit is a document that matches a query.
+type Hit struct {
+	ID      string
+	Parent  string `json:",omitempty"`
+	Name    string `json:",omitempty"`
+	Score   float64
+	Snippet string
+}
+
+// view holds the part of the index that a query is evaluated against: the
+// statistics, the documents of the terms of the query and the documents that
+// contain its phrases. The IDs of every document are only read for queries
+// that exclude documents.
+type view struct {
+	stats    stats
+	postings map[string]termDocuments
+	phrases  map[string]map[string]bool
+	all      map[string]bool
+}
+
+// Search finds the documents that match a query.
+//
+// Queries consist of words, which must all be in matching documents, phrases
+// in double quotes, and the operators AND, OR and NOT (or -) with
+// parentheses for grouping. Invalid queries return ErrInvalidQuery and
+// invalid options ErrInvalidOptions.
+func (ix *Index) Search(ctx context.Context, query string, opts Options) (Results, error) {
+	var results Results
+	q, err := parseQuery(query)
+	if err != nil {
+		return results, err
+	}
+	switch opts.Ranking {
+	case "", RankBM25, RankTFIDF:
+	default:
+		return results, errors.Wrapf(ErrInvalidOptions, "unknown ranking %q", opts.Ranking)
+	}
+	if opts.Offset < 0 {
+		return results, errors.Wrap(ErrInvalidOptions, "offset must not be negative")
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	v, err := ix.view(ctx, q)
+	if err != nil {
+		return results, err
+	}
+	matches := v.eval(q)
+	// Documents that are being added or removed are not matched.
+	docs, err := ix.documents(ctx, matches)
+	if err != nil {
+		return results, err
+	}
+	terms := uniqueTerms(q.terms())
+	hits := make([]Hit, 0, len(docs))
+	for id, d := range docs {
+		hits = append(hits, Hit{
+			ID:     id,
+			Parent: d.Parent,
+			Name:   d.Name,
+			Score:  v.score(id, d.length(), terms, opts.Ranking),
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	results.Total = len(hits)
+	if opts.Offset >= len(hits) {
+		results.Hits = []Hit{}
+		return results, nil
+	}
+	hits = hits[opts.Offset:]
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	if err := ix.describe(ctx, v, hits, terms); err != nil {
+		return results, err
+	}
+	results.Hits = hits
+	return results, nil
+}
+
+// view reads the statistics, the documents of the terms of a query and the
+// IDs of every document, if needed, in one batch, and then the positions of
+// the phrases of the query in another.
+func (ix *Index) view(ctx context.Context, q node) (*view, error) {
+	terms := uniqueTerms(allTerms(q))
+	keys := []string{statsKey}
+	for _, term := range terms {
+		for b := 0; b < buckets; b++ {
+			keys = append(keys, termKey(term, b))
+		}
+	}
+	excludes := hasNot(q)
+	if excludes {
+		for b := 0; b < buckets; b++ {
+			keys = append(keys, documentsKey(b))
+		}
+	}
+	values, err := ix.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve indexed terms")
+	}
+
+	v := &view{
+		postings: make(map[string]termDocuments, len(terms)),
+		phrases:  make(map[string]map[string]bool),
+	}
+	if values[0] != nil {
+		if err := json.Unmarshal(values[0], &v.stats); err != nil {
+			return nil, errors.Wrap(err, "invalid index statistics")
+		}
+	}
+	values = values[1:]
+	for _, term := range terms {
+		docs := make(termDocuments)
+		for _, data := range values[:buckets] {
+			if data == nil {
+				continue
+			}
+			if err := json.Unmarshal(data, &docs); err != nil {
+				return nil, errors.Wrapf(err, "invalid documents of term %q", term)
+			}
+		}
+		v.postings[term] = docs
+		values = values[buckets:]
+	}
+	if excludes {
+		v.all = make(map[string]bool)
+		for i, data := range values {
+			if data == nil {
+				continue
+			}
+			var ids []string
+			if err := json.Unmarshal(data, &ids); err != nil {
+				return nil, errors.Wrapf(err, "invalid list %s", documentsKey(i))
+			}
+			for _, id := range ids {
+				v.all[id] = true
+			}
+		}
+	}
+	return v, ix.matchPhrases(ctx, v, phrases(q))
+}
+
+// matchPhrases finds the documents that contain each phrase, reading the
+// positions of the words of the phrases in the parts that contain all of
+// them in one batch.
+func (ix *Index) matchPhrases(ctx context.Context, v *view, phrases [][]string) error {
+	type candidate struct {
+		words []string
+		id    string
+		part  int
+		key   int
+	}
+	var candidates []candidate
+	var keys []string
+	for _, words := range phrases {
+		v.phrases[strings.Join(words, " ")] = make(map[string]bool)
+		for id, parts := range v.postings[words[0]] {
+			for part := range parts {
+				if !v.inPart(id, part, words) {
+					continue
+				}
+				candidates = append(candidates, candidate{words: words, id: id, part: part, key: len(keys)})
+				for _, word := range words {
+					keys = append(keys, positionsKey(word, id, part))
+				}
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	values, err := ix.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return errors.Wrap(err, "unable to retrieve positions of indexed terms")
+	}
+	for _, c := range candidates {
+		positions := make([][]int, len(c.words))
+		for i := range c.words {
+			if data := values[c.key+i]; data != nil {
+				if err := json.Unmarshal(data, &positions[i]); err != nil {
+					return errors.Wrapf(err, "invalid positions in document %s", c.id)
+				}
+			}
+		}
+		if hasPhrase(positions) {
+			v.phrases[strings.Join(c.words, " ")][c.id] = true
+		}
+	}
+	return nil
+}
+
+// inPart reports if all words occur in a part of a document.
+func (v *view) inPart(id string, part int, words []string) bool {
+	for _, word := range words {
+		if v.postings[word][id][part] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPhrase reports if words occur one after another, given the offsets of
+// each word in order.
+func hasPhrase(positions [][]int) bool {
+	for _, start := range positions[0] {
+		found := true
+		for i, offsets := range positions[1:] {
+			if !containsOffset(offsets, start+i+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func containsOffset(offsets []int, offset int) bool {
+	i := sort.SearchInts(offsets, offset)
+	return i < len(offsets) && offsets[i] == offset
+}
+
+// documents reads the indexed documents with the given IDs in one batch,
+// leaving out the documents that are not indexed completely.
+func (ix *Index) documents(ctx context.Context, ids map[string]bool) (map[string]document, error) {
+	docs := make(map[string]document, len(ids))
+	if len(ids) == 0 {
+		return docs, nil
+	}
+	keys := make([]string, 0, len(ids))
+	order := make([]string, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, documentKey(id))
+		order = append(order, id)
+	}
+	values, err := ix.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve indexed documents")
+	}
+	for i, id := range order {
+		if values[i] == nil {
+			continue
+		}
+		var d document
+		if err := json.Unmarshal(values[i], &d); err != nil {
+			return nil, errors.Wrapf(err, "invalid indexed document %s", id)
+		}
+		docs[id] = d
+	}
+	return docs, nil
+}
+
+// describe sets the snippet of each hit, reading the positions of the terms
+// in the first part with a match and the words around the first match in one
+// batch each.
+func (ix *Index) describe(ctx context.Context, v *view, hits []Hit, terms []string) error {
+	parts := make([]int, len(hits))
+	var keys []string
+	for i, hit := range hits {
+		parts[i] = v.firstPart(hit.ID, terms)
+		for _, term := range terms {
+			keys = append(keys, positionsKey(term, hit.ID, parts[i]))
+		}
+	}
+	var values [][]byte
+	if len(keys) > 0 {
+		var err error
+		values, err = ix.kv.RetrieveMany(ctx, keys)
+		if err != nil {
+			return errors.Wrap(err, "unable to retrieve positions of indexed terms")
+		}
+	}
+	firsts := make([]*position, len(hits))
+	keys = make([]string, len(hits))
+	for i, hit := range hits {
+		for j := range terms {
+			data := values[i*len(terms)+j]
+			if data == nil {
+				continue
+			}
+			var offsets []int
+			if err := json.Unmarshal(data, &offsets); err != nil {
+				return errors.Wrapf(err, "invalid positions in document %s", hit.ID)
+			}
+			if len(offsets) > 0 && (firsts[i] == nil || offsets[0] < firsts[i].offset) {
+				firsts[i] = &position{part: parts[i], offset: offsets[0]}
+			}
+		}
+		keys[i] = wordsKey(hit.ID, parts[i])
+	}
+
+	values, err := ix.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return errors.Wrap(err, "unable to retrieve words of indexed documents")
+	}
+	for i, hit := range hits {
+		var words []string
+		if values[i] != nil {
+			if err := json.Unmarshal(values[i], &words); err != nil {
+				return errors.Wrapf(err, "invalid words of document %s", hit.ID)
+			}
+		}
+		hits[i].Snippet = snippet(words, firsts[i])
+	}
+	return nil
+}
+
+// phrases returns the words of the phrases of a query.
+func phrases(n node) [][]string {
+	switch n := n.(type) {
+	case phraseNode:
+		return [][]string{n.words}
+	case andNode:
+		var out [][]string
+		for _, c := range n.children {
+			out = append(out, phrases(c)...)
+		}
+		return out
+	case orNode:
+		return phrases(andNode{children: n.children})
+	case notNode:
+		return phrases(n.child)
+	}
+	return nil
+}
+
+// hasNot reports if a query excludes documents.
+func hasNot(n node) bool {
+	switch n := n.(type) {
+	case andNode:
+		for _, c := range n.children {
+			if hasNot(c) {
+				return true
+			}
+		}
+	case orNode:
+		return hasNot(andNode{children: n.children})
+	case notNode:
+		return true
+	}
+	return false
+}
+
+// allTerms returns the terms of a query, including the terms of documents that
+// must not match.
+func allTerms(n node) []string {
+	switch n := n.(type) {
+	case andNode:
+		var terms []string
+		for _, c := range n.children {
+			terms = append(terms, allTerms(c)...)
+		}
+		return terms
+	case orNode:
+		var terms []string
+		for _, c := range n.children {
+			terms = append(terms, allTerms(c)...)
+		}
+		return terms
+	case notNode:
+		return allTerms(n.child)
+	}
+	return n.terms()
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// eval returns the IDs of the documents that match a query.
+func (v *view) eval(n node) map[string]bool {
+	out := make(map[string]bool)
+	switch n := n.(type) {
+	case termNode:
+		for id := range v.postings[n.term] {
+			out[id] = true
+		}
+	case phraseNode:
+		for id := range v.phrases[strings.Join(n.words, " ")] {
+			out[id] = true
+		}
+	case andNode:
+		out = v.eval(n.children[0])
+		for _, c := range n.children[1:] {
+			next := v.eval(c)
+			for id := range out {
+				if !next[id] {
+					delete(out, id)
+				}
+			}
+		}
+	case orNode:
+		for _, c := range n.children {
+			for id := range v.eval(c) {
+				out[id] = true
+			}
+		}
+	case notNode:
+		excluded := v.eval(n.child)
+		for id := range v.all {
+			if !excluded[id] {
+				out[id] = true
+			}
+		}
+	}
+	return out
+}
+
+// score returns the relevance of a document of length dl to the terms of a
+// query.
+func (v *view) score(id string, dl int, terms []string, ranking string) float64 {
+	// The statistics may be behind while documents are added.
+	n := math.Max(float64(v.stats.Documents), 1)
+	avgdl := float64(v.stats.Length) / n
+	if avgdl <= 0 {
+		avgdl = math.Max(float64(dl), 1)
+	}
+	var score float64
+	for _, term := range terms {
+		tf := float64(v.postings[term].frequency(id))
+		if tf == 0 {
+			continue
+		}
+		df := float64(len(v.postings[term]))
+		if ranking == RankTFIDF {
+			score += tf * (math.Log((1+n)/(1+df)) + 1)
+			continue
+		}
+		idf := math.Log(1 + math.Max(n-df+0.5, 0)/(df+0.5))
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(dl)/avgdl))
+	}
+	return score
+}
+
+// firstPart returns the first part of a document that contains any of the
+// terms, or 0 if none of them occur in it.
+func (v *view) firstPart(id string, terms []string) int {
+	first := -1
+	for _, term := range terms {
+		for part := range v.postings[term][id] {
+			if first < 0 || part < first {
+				first = part
+			}
+		}
+	}
+	if first < 0 {
+		return 0
+	}
+	return first
+}
+
+// snippet returns the words of a part around the first match, or the first
+// words of the part if there is no match.
+func snippet(words []string, first *position) string {
+	start, end := 0, snippetRadius*2
+	if first != nil {
+		start, end = first.offset-snippetRadius, first.offset+snippetRadius+1
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(words) {
+		end = len(words)
+	}
+	if start > end {
+		start = end
+	}
+	snippet := strings.Join(words[start:end], " ")
+	if start > 0 {
+		snippet = "… " + snippet
+	}
+	if end < len(words) {
+		snippet += " …"
+	}
+	return snippet
+}
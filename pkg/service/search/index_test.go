@@ -0,0 +1,203 @@
+package search_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/search"
+)
+
+func newIndex(t *testing.T, docs map[string]string) *search.Index {
+	ix := search.NewIndex(keyvaluemock.New(), search.Config{})
+	for id, text := range docs {
+		add(t, ix, search.Document{ID: id, Words: strings.Fields(text)})
+	}
+	return ix
+}
+
+func add(t *testing.T, ix *search.Index, doc search.Document) {
+	require.NoError(t, ix.Add(context.Background(), doc), "Adding document %s should succeed.", doc.ID)
+}
+
+func find(t *testing.T, ix *search.Index, query string, opts search.Options) search.Results {
+	results, err := ix.Search(context.Background(), query, opts)
+	require.NoError(t, err, "Searching for %s should succeed.", query)
+	return results
+}
+
+func ids(results search.Results) []string {
+	var out []string
+	for _, hit := range results.Hits {
+		out = append(out, hit.ID)
+	}
+	return out
+}
+
+var docs = map[string]string{
+	"fox":   "The quick brown fox jumps over the lazy dog.",
+	"dog":   "A lazy dog sleeps all day, the dog is brown.",
+	"cat":   "The cat watches the quick bird.",
+	"stale": "Nothing here matches.",
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+	ix := newIndex(t, docs)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"dog", []string{"dog", "fox"}},
+		{"DOG.", []string{"dog", "fox"}},
+		{"quick brown", []string{"fox"}},
+		{"quick AND brown", []string{"fox"}},
+		{"cat OR fox", []string{"cat", "fox"}},
+		{"quick NOT fox", []string{"cat"}},
+		{"quick -fox", []string{"cat"}},
+		{`"lazy dog"`, []string{"dog", "fox"}},
+		{`"dog lazy"`, nil},
+		{`"brown fox" OR (cat bird)`, []string{"cat", "fox"}},
+		{"NOT (dog OR quick) OR cat", []string{"cat", "stale"}},
+	}
+	for _, test := range tests {
+		results := find(t, ix, test.query, search.Options{})
+		found := ids(results)
+		assert.ElementsMatch(t, test.expected, found, "Query %s should match.", test.query)
+		assert.Equal(t, len(test.expected), results.Total, "Query %s should count every match.", test.query)
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	t.Parallel()
+	ix := newIndex(t, docs)
+
+	for _, ranking := range []string{search.RankBM25, search.RankTFIDF} {
+		results := find(t, ix, "dog", search.Options{Ranking: ranking})
+		assert.Equal(t, []string{"dog", "fox"}, ids(results), "More frequent matches should rank first with %s.", ranking)
+		assert.True(t, results.Hits[0].Score > results.Hits[1].Score, "Scores should be ordered with %s.", ranking)
+	}
+
+	_, err := ix.Search(context.Background(), "dog", search.Options{Ranking: "unknown"})
+	assert.Equal(t, search.ErrInvalidOptions, errors.Cause(err), "Unknown rankings should be rejected.")
+}
+
+func TestSearchPagination(t *testing.T) {
+	t.Parallel()
+	ix := newIndex(t, nil)
+	for i := 0; i < 25; i++ {
+		add(t, ix, search.Document{ID: strings.Repeat("d", i+1), Words: []string{"word"}})
+	}
+
+	page := find(t, ix, "word", search.Options{Offset: 20, Limit: 10})
+	assert.Equal(t, 25, page.Total, "Total should count every match.")
+	assert.Len(t, page.Hits, 5, "Last page should hold the rest of the hits.")
+
+	page = find(t, ix, "word", search.Options{Offset: 30})
+	assert.Empty(t, page.Hits, "No hits should be returned past the end.")
+}
+
+func TestSnippet(t *testing.T) {
+	t.Parallel()
+	words := strings.Fields(strings.Repeat("filler ", 20) + "the needle is here " + strings.Repeat("filler ", 20))
+	ix := newIndex(t, nil)
+	add(t, ix, search.Document{ID: "doc", Name: "doc.txt", Parent: "archive", Words: words})
+
+	results := find(t, ix, "needle", search.Options{})
+	require.Len(t, results.Hits, 1, "Document should match.")
+	hit := results.Hits[0]
+	assert.Equal(t, "… filler filler filler filler filler filler filler the needle is here filler filler filler filler filler filler …", hit.Snippet)
+	assert.Equal(t, "doc.txt", hit.Name, "Name should be returned.")
+	assert.Equal(t, "archive", hit.Parent, "Parent should be returned.")
+}
+
+func TestAddParts(t *testing.T) {
+	t.Parallel()
+	ix := newIndex(t, nil)
+	add(t, ix, search.Document{ID: "doc", Part: 0, Words: []string{"first", "part"}})
+	add(t, ix, search.Document{ID: "doc", Part: 1, Words: []string{"second", "part"}})
+
+	results := find(t, ix, `"part second"`, search.Options{})
+	assert.Zero(t, results.Total, "Phrases should not match across parts.")
+
+	add(t, ix, search.Document{ID: "doc", Part: 1, Words: []string{"replaced"}})
+	results = find(t, ix, "second", search.Options{})
+	assert.Zero(t, results.Total, "Replaced parts should no longer match.")
+
+	require.NoError(t, ix.Remove(context.Background(), "doc"), "Removing document should succeed.")
+	n, err := ix.Len(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n, "Removed documents should be gone.")
+}
+
+func TestRemoveArchive(t *testing.T) {
+	t.Parallel()
+	ix := newIndex(t, map[string]string{"other": "a file elsewhere"})
+	add(t, ix, search.Document{ID: "a", Parent: "archive", Name: "a.txt", Words: []string{"a", "file"}})
+	add(t, ix, search.Document{ID: "b", Parent: "archive", Name: "b.txt", Words: []string{"another", "file"}})
+
+	require.NoError(t, ix.Remove(context.Background(), "archive"), "Removing archive should succeed.")
+	results := find(t, ix, "file", search.Options{})
+	assert.Equal(t, []string{"other"}, ids(results), "Files of removed archives should be gone.")
+}
+
+func TestInvalidQueries(t *testing.T) {
+	t.Parallel()
+	ix := newIndex(t, docs)
+	for _, query := range []string{"", `"unterminated`, "(dog", "dog)", "NOT dog", "dog OR", "AND", "..."} {
+		_, err := ix.Search(context.Background(), query, search.Options{})
+		assert.Equal(t, search.ErrInvalidQuery, errors.Cause(err), "Query %q should be rejected.", query)
+	}
+}
+
+func TestSharedIndex(t *testing.T) {
+	t.Parallel()
+	kv := keyvaluemock.New()
+	api := search.NewIndex(kv, search.Config{})
+	worker := search.NewIndex(kv, search.Config{})
+	for id, text := range docs {
+		add(t, worker, search.Document{ID: id, Words: strings.Fields(text)})
+	}
+
+	results := find(t, api, `"lazy dog" OR cat`, search.Options{})
+	assert.ElementsMatch(t, []string{"cat", "dog", "fox"}, ids(results), "Documents indexed by another process should be found.")
+
+	require.NoError(t, api.Remove(context.Background(), "dog"), "Removing document should succeed.")
+	results = find(t, worker, "dog", search.Options{})
+	assert.Equal(t, []string{"fox"}, ids(results), "Documents removed by another process should be gone.")
+
+	// Removing every document leaves nothing behind.
+	for id := range docs {
+		require.NoError(t, worker.Remove(context.Background(), id), "Removing document should succeed.")
+	}
+	for _, key := range []string{"search:stats", "search:documents:0", "search:document:fox", "search:words:fox:0"} {
+		data, err := kv.Retrieve(context.Background(), key)
+		require.NoError(t, err)
+		assert.Nil(t, data, "Key %s should be deleted.", key)
+	}
+}
+
+func TestConcurrentParts(t *testing.T) {
+	t.Parallel()
+	ix := newIndex(t, nil)
+	errs := make(chan error)
+	for part := 0; part < 8; part++ {
+		go func(part int) {
+			errs <- ix.Add(context.Background(), search.Document{ID: "doc", Part: part, Words: []string{"shared", "word"}})
+		}(part)
+	}
+	for part := 0; part < 8; part++ {
+		require.NoError(t, <-errs, "Adding part should succeed.")
+	}
+	results := find(t, ix, `"shared word"`, search.Options{})
+	require.Equal(t, 1, results.Total, "Document should match.")
+	// Every part is counted, like in a document with the words of every part.
+	single := newIndex(t, map[string]string{"doc": strings.Repeat("shared word ", 8)})
+	assert.Equal(t, find(t, single, "shared", search.Options{}).Hits[0].Score, find(t, ix, "shared", search.Options{}).Hits[0].Score)
+}
@@ -0,0 +1,265 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidQuery is returned when a query cannot be parsed.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// node is a node of a parsed query.
+type node interface {
+	// terms returns the terms that count towards the score of matches.
+	terms() []string
+}
+
+type termNode struct {
+	term string
+}
+
+type phraseNode struct {
+	words []string
+}
+
+type andNode struct {
+	children []node
+}
+
+type orNode struct {
+	children []node
+}
+
+type notNode struct {
+	child node
+}
+
+func (n termNode) terms() []string   { return []string{n.term} }
+func (n phraseNode) terms() []string { return n.words }
+func (n notNode) terms() []string    { return nil }
+
+func (n andNode) terms() []string {
+	var out []string
+	for _, c := range n.children {
+		out = append(out, c.terms()...)
+	}
+	return out
+}
+
+func (n orNode) terms() []string {
+	return andNode{children: n.children}.terms()
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenPhrase
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// lex splits a query into tokens. Operators must be upper case so that the
+// words "and", "or" and "not" can be searched for.
+func lex(q string) ([]token, error) {
+	var tokens []token
+	r := []rune(q)
+	for i := 0; i < len(r); {
+		switch c := r[i]; {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(r) && r[end] != '"' {
+				end++
+			}
+			if end == len(r) {
+				return nil, errors.Wrap(ErrInvalidQuery, "unterminated phrase")
+			}
+			tokens = append(tokens, token{kind: tokenPhrase, value: string(r[i+1 : end])})
+			i = end + 1
+		case c == '-' && (i == 0 || unicode.IsSpace(r[i-1]) || r[i-1] == '(') && i+1 < len(r) && !unicode.IsSpace(r[i+1]):
+			tokens = append(tokens, token{kind: tokenNot})
+			i++
+		default:
+			end := i
+			for end < len(r) && !unicode.IsSpace(r[end]) && r[end] != '(' && r[end] != ')' && r[end] != '"' {
+				end++
+			}
+			word := string(r[i:end])
+			switch word {
+			case "AND":
+				tokens = append(tokens, token{kind: tokenAnd})
+			case "OR":
+				tokens = append(tokens, token{kind: tokenOr})
+			case "NOT":
+				tokens = append(tokens, token{kind: tokenNot})
+			default:
+				tokens = append(tokens, token{kind: tokenWord, value: word})
+			}
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// parseQuery parses a query with the grammar
+//
+//	or      = and { "OR" and }
+//	and     = unary { [ "AND" ] unary }
+//	unary   = ( "NOT" | "-" ) unary | primary
+//	primary = "(" or ")" | '"' words '"' | word
+func parseQuery(q string) (node, error) {
+	tokens, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.Wrap(ErrInvalidQuery, "empty query")
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, errors.Wrap(ErrInvalidQuery, "unexpected closing parenthesis")
+	}
+	if len(n.terms()) == 0 {
+		return nil, errors.Wrap(ErrInvalidQuery, "query has no terms to match")
+	}
+	return n, nil
+}
+
+func (p *parser) or() (node, error) {
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	children := []node{n}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokenOr {
+			break
+		}
+		p.pos++
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return orNode{children: children}, nil
+}
+
+func (p *parser) and() (node, error) {
+	n, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	children := []node{n}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind == tokenOr || t.kind == tokenClose {
+			break
+		}
+		if t.kind == tokenAnd {
+			p.pos++
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return andNode{children: children}, nil
+}
+
+func (p *parser) unary() (node, error) {
+	t, ok := p.peek()
+	if ok && t.kind == tokenNot {
+		p.pos++
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child: n}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, errors.Wrap(ErrInvalidQuery, "unexpected end of query")
+	}
+	p.pos++
+	switch t.kind {
+	case tokenOpen:
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.kind != tokenClose {
+			return nil, errors.Wrap(ErrInvalidQuery, "missing closing parenthesis")
+		}
+		p.pos++
+		return n, nil
+	case tokenPhrase:
+		var terms []string
+		for _, word := range strings.Fields(t.value) {
+			if term := Normalize(word); term != "" {
+				terms = append(terms, term)
+			}
+		}
+		switch len(terms) {
+		case 0:
+			return nil, errors.Wrap(ErrInvalidQuery, "empty phrase")
+		case 1:
+			return termNode{term: terms[0]}, nil
+		}
+		return phraseNode{words: terms}, nil
+	case tokenWord:
+		term := Normalize(t.value)
+		if term == "" {
+			return nil, errors.Wrapf(ErrInvalidQuery, "%q is not a searchable word", t.value)
+		}
+		return termNode{term: term}, nil
+	default:
+		return nil, errors.Wrap(ErrInvalidQuery, "misplaced operator")
+	}
+}
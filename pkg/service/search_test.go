@@ -0,0 +1,130 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/docstore"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/search"
+)
+
+// searchFor searches until the expected number of documents match, as
+// documents are indexed after their reports are stored.
+func searchFor(ctx context.Context, t *testing.T, apiService service.APIService, request service.SearchRequest, total int) service.SearchResponse {
+	for {
+		sr, err := apiService.Search(ctx, request)
+		require.NoError(t, err, "Searching should succeed.")
+		if sr.Total == total || ctx.Err() != nil {
+			return sr
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	// The API and the workers share the index through the key value store.
+	index := search.NewIndex(kv, search.Config{})
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       l,
+		Channel:   channel,
+		Index:     index,
+		Documents: docstore.NewStore(kv, docstore.Config{}),
+	})
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       l,
+		Channel:   channel,
+		ShardSize: 16,
+		// Documents are indexed in parts of four words.
+		Index: search.NewIndex(kv, search.Config{MaxWords: 4}),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go runWorker(ctx, q, channel, worker)
+
+	small, err := apiService.ProcessDocument(ctx, service.DocumentRequest{Document: "A lazy dog sleeps."})
+	require.NoError(t, err, "Processing document should succeed.")
+	// The document is sharded, and indexed as a whole.
+	large, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document: "The quick brown fox jumps over the lazy dog while the cat sleeps.",
+	})
+	require.NoError(t, err, "Processing document should succeed.")
+
+	sr := searchFor(ctx, t, apiService, service.SearchRequest{Query: "sleeps", Limit: 1, Offset: 1}, 2)
+	assert.Equal(t, 2, sr.Total, "Both documents should match.")
+	require.Len(t, sr.Hits, 1, "One document should be on the page.")
+
+	sr = searchFor(ctx, t, apiService, service.SearchRequest{Query: `"lazy dog" -fox`}, 1)
+	require.Equal(t, 1, sr.Total, "One document should match.")
+	assert.Equal(t, small.DocumentID, sr.Hits[0].ID)
+	assert.Equal(t, "A lazy dog sleeps.", sr.Hits[0].Snippet, "Snippet should hold the match.")
+
+	sr = searchFor(ctx, t, apiService, service.SearchRequest{Query: "fox"}, 1)
+	require.Equal(t, 1, sr.Total, "Large documents should be indexed.")
+	assert.Equal(t, large.DocumentID, sr.Hits[0].ID, "Large documents should be indexed under their ID.")
+	sr = searchFor(ctx, t, apiService, service.SearchRequest{Query: `"while the cat"`}, 1)
+	assert.Equal(t, 1, sr.Total, "Every part of large documents should be indexed.")
+
+	_, err = apiService.Search(ctx, service.SearchRequest{Query: "(unbalanced"})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "Invalid queries should be rejected.")
+}
+
+func TestSearchUnkeptDocuments(t *testing.T) {
+	const channel = "worker"
+	l := log.NewNopLogger()
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	index := search.NewIndex(kv, search.Config{})
+	apiService := service.NewAPIServiceWithConfig(service.APIServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+		Index:   index,
+	})
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   q,
+		KeyVal:  kv,
+		Log:     l,
+		Channel: channel,
+		Index:   index,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		_, _ = apiService.ProcessDocument(ctx, service.DocumentRequest{Document: "A lazy dog sleeps."})
+	}()
+
+	// The document is counted here so that it is indexed, if at all, once
+	// counting returns.
+	d, err := q.Pull(ctx, channel)
+	require.NoError(t, err)
+	var doc service.DocumentID
+	require.NoError(t, json.Unmarshal(d, &doc))
+	_, err = worker.ParseDocument(ctx, doc)
+	require.NoError(t, err, "Counting document should succeed.")
+
+	n, err := index.Len(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "Documents that are not kept should not be indexed.")
+}
@@ -0,0 +1,347 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/language"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/similarity"
+)
+
+// shardExpiration is how long partial results of a sharded document are kept
+// while waiting for the remaining shards.
+const shardExpiration = 10 * time.Minute
+
+// Shard identifies a part of a larger document that is counted separately
+// from the rest of the document.
+//
+// The files of an archive are shards of the archive as well. They are
+// documents of their own, so their reports are included in the report for the
+// archive.
+type Shard struct {
+	// Parent is the ID of the whole document.
+	Parent string
+	// Key is the key that the report for the whole document is stored under.
+	Key string
+	// Run identifies the split that the shard is part of, so that the state
+	// of concurrent splits of the same document is kept apart.
+	Run   string
+	Index int
+	// Name is the name of the file if the shard is a file of an archive.
+	Name string `json:",omitempty"`
+	// Language is the language detected from the start of the whole document,
+	// so that every shard is analyzed the same way. The files of an archive
+	// are detected on their own.
+	Language *language.Detection `json:",omitempty"`
+	// Retention is how long the report for the whole document is kept for.
+	// DefaultRetention is used if it is nil.
+	Retention *Retention `json:",omitempty"`
+}
+
+// shardCounts are the partial counts for a shard.
+type shardCounts struct {
+	ID       string `json:",omitempty"`
+	Name     string `json:",omitempty"`
+	Words    map[string]int
+	Forms    map[string]map[string]int `json:",omitempty"`
+	MinHash  similarity.MinHash        `json:",omitempty"`
+	Language language.Detection
+}
+
+func shardPartialKey(key, run string, index int) string {
+	return keyspace.ShardPartial(key, run, index)
+}
+
+func shardTotalKey(key, run string) string {
+	return keyspace.ShardCounter(key, run, "total")
+}
+
+func shardDoneKey(key, run string) string {
+	return keyspace.ShardCounter(key, run, "done")
+}
+
+// shardMergedKey is the key that the worker merging the shards of a run
+// claims, so that the shards are only merged once.
+func shardMergedKey(key, run string) string {
+	return keyspace.ShardCounter(key, run, "merged")
+}
+
+// newShardRun returns a new run to split a document in.
+func newShardRun() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate shard run")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// splitDocument cuts the document read from r into chunks of at least size
+// bytes and calls emit with each of them.
+//
+// Chunks end after whitespace so that no word is split between two chunks,
+// unless no whitespace follows within maxWord bytes, which is longer than any
+// word that can be counted. The bytes of the document are passed through
+// unchanged.
+func splitDocument(r io.Reader, size, maxWord int, emit func(chunk string) error) error {
+	br := bufio.NewReader(r)
+	var buf bytes.Buffer
+	for {
+		c, n, err := br.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read document")
+		}
+		if c == utf8.RuneError && n == 1 {
+			// Keep invalid bytes as they are instead of replacing them.
+			_ = br.UnreadRune()
+			b, _ := br.ReadByte()
+			buf.WriteByte(b)
+		} else {
+			buf.WriteRune(c)
+		}
+		if buf.Len() >= size && unicode.IsSpace(c) || buf.Len() >= size+maxWord {
+			if err := emit(buf.String()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+	}
+	if buf.Len() > 0 {
+		return emit(buf.String())
+	}
+	return nil
+}
+
+// splitAndEnqueue splits the text of a large document into shards and pushes
+// a sub-job for each shard onto the worker queue.
+//
+// Once every shard has been counted, the partial counts are merged into the
+// report for the whole document by whichever worker finishes last.
+func (w *workerService) splitAndEnqueue(ctx context.Context, doc DocumentID, text io.Reader, detection language.Detection) error {
+	key := resultKey(doc.ID, doc.AnalysisOptions)
+	run, err := newShardRun()
+	if err != nil {
+		return err
+	}
+	if err := trackShards(ctx, w.kv, doc.ID, key, run); err != nil {
+		return err
+	}
+
+	// Mark the total as unknown until all shards have been enqueued so that
+	// early finishers do not merge too soon.
+	if err := w.kv.SetCounter(ctx, shardTotalKey(key, run), 0); err != nil {
+		return errors.Wrap(err, "unable to initialize shard total")
+	}
+	if err := w.kv.SetCounter(ctx, shardDoneKey(key, run), 0); err != nil {
+		return errors.Wrap(err, "unable to initialize shard count")
+	}
+
+	var total int
+	err = splitDocument(text, w.shardSize, w.maxTokenSize, func(chunk string) error {
+		job, err := json.Marshal(DocumentID{
+			DocumentRequest: DocumentRequest{
+				Document: chunk,
+				// The text has already been extracted.
+				AnalysisOptions: AnalysisOptions{
+					ContentType:  extract.TypePlain,
+					Stemmer:      doc.Stemmer,
+					SurfaceForms: doc.SurfaceForms,
+				},
+			},
+			ID: doc.ID,
+			Shard: &Shard{
+				Parent:    doc.ID,
+				Key:       key,
+				Run:       run,
+				Index:     total,
+				Language:  &detection,
+				Retention: doc.Retention,
+			},
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := w.q.Push(ctx, w.channel, [][]byte{job}); err != nil {
+			return errors.Wrap(err, "unable to enqueue shard")
+		}
+		total++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.kv.SetCounter(ctx, shardTotalKey(key, run), int64(total)); err != nil {
+		return errors.Wrap(err, "unable to set shard total")
+	}
+	_ = w.log.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Split document %s into %d shards", doc.ID, total))
+
+	// All shards may have already finished before the total was known.
+	return w.reduceIfComplete(ctx, doc.ID, key, run, doc.retention())
+}
+
+// countShard stores the partial counts for a shard and merges all of the
+// partial counts if it is the last shard to finish.
+func (w *workerService) countShard(ctx context.Context, shard Shard, id string, counts shardCounts) error {
+	if shard.Name != "" {
+		counts.ID = id
+		counts.Name = shard.Name
+	}
+	data, err := json.Marshal(counts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := w.kv.Store(ctx, shardPartialKey(shard.Key, shard.Run, shard.Index), data, shardExpiration); err != nil {
+		return errors.Wrap(err, "unable to store shard counts")
+	}
+	if err := w.kv.IncrementCounter(ctx, shardDoneKey(shard.Key, shard.Run)); err != nil {
+		return errors.Wrap(err, "unable to record shard completion")
+	}
+	retention := DefaultRetention
+	if shard.Retention != nil {
+		retention = *shard.Retention
+	}
+	return w.reduceIfComplete(ctx, shard.Parent, shard.Key, shard.Run, retention)
+}
+
+// reduceIfComplete merges the partial counts of a sharded document into its
+// report once all of the shards have been counted, keeping the report for the
+// given retention.
+//
+// More than one worker may observe completion, so only the worker that claims
+// the run merges it. The counters and partial counts of the run are deleted
+// once they are merged.
+func (w *workerService) reduceIfComplete(ctx context.Context, parent, key, run string, retention Retention) error {
+	total, err := w.kv.GetCounter(ctx, shardTotalKey(key, run))
+	if err != nil {
+		return w.mergedOr(ctx, key, run, errors.Wrap(err, "unable to get shard total"))
+	}
+	done, err := w.kv.GetCounter(ctx, shardDoneKey(key, run))
+	if err != nil {
+		return w.mergedOr(ctx, key, run, errors.Wrap(err, "unable to get shard count"))
+	}
+	if total == 0 || done < total {
+		return nil
+	}
+	claimed, err := w.kv.StoreIfAbsent(ctx, shardMergedKey(key, run), []byte(parent), shardExpiration)
+	if err != nil {
+		return errors.Wrapf(err, "unable to claim shards of document %s", parent)
+	}
+	if !claimed {
+		return nil
+	}
+
+	words := make(map[string]int)
+	var forms map[string]map[string]int
+	// Shingles that span two shards are lost, which barely changes the
+	// signature of a large document.
+	var minHash similarity.MinHash
+	var files []FileFrequencies
+	// The report has a language if all of the shards agree on it.
+	var detection *language.Detection
+	mixed := false
+	keys := make([]string, total)
+	for i := range keys {
+		keys[i] = shardPartialKey(key, run, i)
+	}
+	partials, err := w.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return errors.Wrapf(err, "unable to retrieve counts for shards of document %s", parent)
+	}
+	for i, data := range partials {
+		if data == nil {
+			return errors.Errorf("missing counts for shard %d of document %s", i, parent)
+		}
+		var partial shardCounts
+		if err := json.Unmarshal(data, &partial); err != nil {
+			return errors.Wrapf(err, "invalid counts for shard %d", i)
+		}
+		for word, count := range partial.Words {
+			words[word] += count
+		}
+		if partial.Forms != nil && forms == nil {
+			forms = make(map[string]map[string]int)
+		}
+		for word, counts := range partial.Forms {
+			if forms[word] == nil {
+				forms[word] = make(map[string]int)
+			}
+			for form, count := range counts {
+				forms[word][form] += count
+			}
+		}
+		minHash = minHash.Merge(partial.MinHash)
+		switch {
+		case detection == nil:
+			detection = &partial.Language
+		case detection.Language != partial.Language.Language:
+			mixed = true
+		case partial.Language.Confidence < detection.Confidence:
+			detection.Confidence = partial.Language.Confidence
+		}
+		if partial.Name != "" {
+			frequencies := topN(partial.Words, DefaultTopN)
+			addForms(frequencies, partial.Forms)
+			files = append(files, FileFrequencies{
+				Name:               partial.Name,
+				DocumentID:         partial.ID,
+				Language:           partial.Language.Language,
+				LanguageConfidence: partial.Language.Confidence,
+				Frequencies:        frequencies,
+			})
+		}
+	}
+	_ = w.log.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Merged %d shards of document %s", total, parent))
+	dfr := newReport(parent, words)
+	addForms(dfr.Frequencies, forms)
+	if detection != nil && !mixed {
+		dfr.Language = detection.Language
+		dfr.LanguageConfidence = detection.Confidence
+	}
+	dfr.Files = files
+	dfr.Retention = &retention
+	if err := w.storeResult(ctx, key, dfr, words, minHash); err != nil {
+		return err
+	}
+	w.finishJob(ctx, key, nil)
+	w.deleteShards(ctx, key, run, keys)
+	return nil
+}
+
+// mergedOr returns nil if the shards of the run were already merged, since
+// their counters are deleted once they are merged, or err otherwise.
+func (w *workerService) mergedOr(ctx context.Context, key, run string, err error) error {
+	merged, rerr := w.kv.Retrieve(ctx, shardMergedKey(key, run))
+	if rerr == nil && merged != nil {
+		return nil
+	}
+	return err
+}
+
+// deleteShards deletes the counters and the partial counts of a merged run.
+// The claim is kept until it expires, so that workers that observe completion
+// late do not merge the run again.
+func (w *workerService) deleteShards(ctx context.Context, key, run string, partials []string) {
+	keys := append([]string{shardTotalKey(key, run), shardDoneKey(key, run)}, partials...)
+	for _, k := range keys {
+		if err := w.kv.Delete(ctx, k); err != nil {
+			// Partial counts expire on their own.
+			_ = w.log.Log("LEVEL", "WARN", "MESSAGE", fmt.Sprintf("Unable to delete shard key %s: %v", k, err))
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/keyvaluemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/internal/queuemock"
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+)
+
+func randomDocument(words int) string {
+	vocabulary := []string{"alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta", "iota", "kappa", "lambda", "mu"}
+	separators := []string{" ", "  ", "\n", "\t", "　"}
+	r := rand.New(rand.NewSource(1))
+	var sb strings.Builder
+	for i := 0; i < words; i++ {
+		// Skew the distribution so that the top words are well defined.
+		sb.WriteString(vocabulary[r.Intn(1+r.Intn(len(vocabulary)))])
+		sb.WriteString(separators[r.Intn(len(separators))])
+	}
+	return sb.String()
+}
+
+func TestShardedMatchesSingleWorker(t *testing.T) {
+	const channel = "worker_parse_document"
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	document := randomDocument(2000)
+
+	single := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   queuemock.New(),
+		KeyVal:  keyvaluemock.New(),
+		Log:     log.NewNopLogger(),
+		Channel: channel,
+	})
+	expected, err := single.ParseDocument(ctx, service.DocumentID{
+		DocumentRequest: service.DocumentRequest{Document: document},
+		ID:              "1",
+	})
+	require.NoError(t, err, "Single worker parsing should succeed.")
+
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	sharded := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       log.NewNopLogger(),
+		Channel:   channel,
+		ShardSize: 512,
+	})
+	parent, err := sharded.ParseDocument(ctx, service.DocumentID{
+		DocumentRequest: service.DocumentRequest{Document: document},
+		ID:              "1",
+	})
+	require.NoError(t, err, "Splitting document should succeed.")
+	assert.Empty(t, parent.Frequencies, "Sharded document should not be counted by the splitting worker.")
+
+	// Process the shards as a worker would, until the merged report is stored.
+	shards := make(map[int]string)
+	var run string
+	var data []byte
+	for data == nil {
+		pulled, err := q.Pull(ctx, channel)
+		require.NoError(t, err, "Pull from queue should succeed.")
+		var doc service.DocumentID
+		require.NoError(t, json.Unmarshal(pulled, &doc), "Shard should unmarshal successfully.")
+		require.NotNil(t, doc.Shard, "Sub-job should be a shard.")
+		shards[doc.Shard.Index] = doc.Document
+		run = doc.Shard.Run
+		_, err = sharded.ParseDocument(ctx, doc)
+		require.NoError(t, err, "Shard parsing should succeed.")
+
+		data, err = kv.Retrieve(ctx, "1")
+		require.NoError(t, err, "Retrieving merged report should succeed.")
+	}
+	var actual service.DocumentFrequencyReport
+	require.NoError(t, json.Unmarshal(data, &actual), "Merged report should unmarshal successfully.")
+	assert.Equal(t, expected, actual, "Sharded and single worker reports should match.")
+
+	assert.True(t, len(shards) > 1, "Document should be split into several shards.")
+	var joined strings.Builder
+	for i := 0; i < len(shards); i++ {
+		joined.WriteString(shards[i])
+	}
+	assert.Equal(t, document, joined.String(), "Shards should cover the document exactly.")
+	assertShardsDeleted(t, kv, "1", run, len(shards))
+}
+
+// assertShardsDeleted asserts that the counters and the partial counts of a
+// merged run are deleted.
+func assertShardsDeleted(t *testing.T, kv keyvalue.KeyValue, key, run string, shards int) {
+	ctx := context.Background()
+	require.NotEmpty(t, run, "Shards should have a run.")
+	for _, name := range []string{"total", "done"} {
+		_, err := kv.GetCounter(ctx, keyspace.ShardCounter(key, run, name))
+		assert.Error(t, err, "Counter %q should be deleted after the merge.", name)
+	}
+	for i := 0; i < shards; i++ {
+		data, err := kv.Retrieve(ctx, keyspace.ShardPartial(key, run, i))
+		require.NoError(t, err)
+		assert.Nil(t, data, "Partial counts %d should be deleted after the merge.", i)
+	}
+}
+
+func TestShardedSplitTwice(t *testing.T) {
+	const channel = "worker_parse_document"
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	document := randomDocument(2000)
+	expected, err := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   queuemock.New(),
+		KeyVal:  keyvaluemock.New(),
+		Log:     log.NewNopLogger(),
+		Channel: channel,
+	}).ParseDocument(ctx, service.DocumentID{
+		DocumentRequest: service.DocumentRequest{Document: document},
+		ID:              "1",
+	})
+	require.NoError(t, err, "Single worker parsing should succeed.")
+
+	q := queuemock.New()
+	kv := keyvaluemock.New()
+	sharded := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:     q,
+		KeyVal:    kv,
+		Log:       log.NewNopLogger(),
+		Channel:   channel,
+		ShardSize: 512,
+	})
+	// The document is split again while the shards of the first split are
+	// still queued, as when a lost job is retried.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, kv.Delete(ctx, keyspace.Job("1")))
+		_, err := sharded.ParseDocument(ctx, service.DocumentID{
+			DocumentRequest: service.DocumentRequest{Document: document},
+			ID:              "1",
+		})
+		require.NoError(t, err, "Splitting document should succeed.")
+	}
+
+	shards := make(map[string]int)
+	for {
+		pullCtx, pullCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		pulled, err := q.Pull(pullCtx, channel)
+		pullCancel()
+		if err != nil {
+			break
+		}
+		var doc service.DocumentID
+		require.NoError(t, json.Unmarshal(pulled, &doc), "Shard should unmarshal successfully.")
+		require.NotNil(t, doc.Shard, "Sub-job should be a shard.")
+		shards[doc.Shard.Run]++
+		_, err = sharded.ParseDocument(ctx, doc)
+		require.NoError(t, err, "Shard parsing should succeed.")
+	}
+	require.Len(t, shards, 2, "Each split should have a run of its own.")
+
+	data, err := kv.Retrieve(ctx, "1")
+	require.NoError(t, err)
+	var actual service.DocumentFrequencyReport
+	require.NoError(t, json.Unmarshal(data, &actual), "Merged report should unmarshal successfully.")
+	assert.Equal(t, expected, actual, "Both splits should merge to the single worker report.")
+	for run, n := range shards {
+		assertShardsDeleted(t, kv, "1", run, n)
+	}
+}
+
+func TestShardedWithoutWhitespace(t *testing.T) {
+	const channel = "worker_parse_document"
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	q := queuemock.New()
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:        q,
+		KeyVal:       keyvaluemock.New(),
+		Log:          log.NewNopLogger(),
+		Channel:      channel,
+		ShardSize:    16,
+		MaxTokenSize: 32,
+	})
+	document := strings.Repeat("x", 200)
+	_, err := worker.ParseDocument(ctx, service.DocumentID{
+		DocumentRequest: service.DocumentRequest{Document: document},
+		ID:              "1",
+	})
+	require.NoError(t, err, "Splitting document should succeed.")
+
+	var joined strings.Builder
+	for joined.Len() < len(document) {
+		pulled, err := q.Pull(ctx, channel)
+		require.NoError(t, err, "Pull from queue should succeed.")
+		var doc service.DocumentID
+		require.NoError(t, json.Unmarshal(pulled, &doc), "Shard should unmarshal successfully.")
+		assert.True(t, len(doc.Document) <= 16+32, "Shards should end within the longest word after the shard size.")
+		joined.WriteString(doc.Document)
+	}
+	assert.Equal(t, document, joined.String(), "Shards should cover the document exactly.")
+}
+
+func TestShardWithoutRun(t *testing.T) {
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   queuemock.New(),
+		KeyVal:  keyvaluemock.New(),
+		Log:     log.NewNopLogger(),
+		Channel: "worker_parse_document",
+	})
+	_, err := worker.ParseDocument(context.Background(), service.DocumentID{
+		DocumentRequest: service.DocumentRequest{Document: "one two"},
+		ID:              "1",
+		Shard:           &service.Shard{Parent: "1", Key: "1"},
+	})
+	assert.Error(t, err, "Shards without a run should be rejected.")
+}
@@ -0,0 +1,459 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/similarity"
+)
+
+// DefaultNearDuplicateThreshold is the smallest estimated Jaccard similarity
+// of the shingles of two documents for them to be near-duplicates when no
+// threshold is requested.
+const DefaultNearDuplicateThreshold = 0.8
+
+// maxBucketSize is the largest number of documents kept in a bucket of the
+// near-duplicate index. The oldest documents are dropped first.
+const maxBucketSize = 1000
+
+// SimilarityRequest is a request for the similarity of two documents, or of a
+// document and each document of a collection.
+type SimilarityRequest struct {
+	// Documents are the IDs of the documents to compare. There must be two
+	// documents, or one if Collection is set.
+	Documents []string `json:"documents"`
+	// Collection is the collection to compare the document with.
+	Collection string `json:"collection,omitempty"`
+	// N is the number of the most similar documents of the collection to
+	// return. DefaultTopN is used if it is 0.
+	N int `json:"n,omitempty"`
+}
+
+// NearDuplicatesRequest is a request for the near-duplicates of a document.
+type NearDuplicatesRequest struct {
+	DocumentID string
+	// Threshold is the smallest estimated Jaccard similarity of near-duplicates.
+	// DefaultNearDuplicateThreshold is used if it is 0.
+	Threshold float64
+}
+
+// SimilarityResponse holds the similarity of a document to other documents.
+type SimilarityResponse struct {
+	DocumentID   string
+	Collection   string `json:",omitempty"`
+	Similarities []Similarity
+}
+
+// Similarity describes how similar a document is to another document.
+type Similarity struct {
+	DocumentID string
+	// Cosine is the cosine similarity of the word counts of the documents.
+	Cosine float64
+	// Jaccard is the estimated Jaccard similarity of the shingles of the
+	// documents. It is missing if a document has no stored signature.
+	Jaccard *float64 `json:",omitempty"`
+	// SimHashDistance is the Hamming distance of the SimHash fingerprints of
+	// the documents. It is missing if a document has no stored signature.
+	SimHashDistance *int `json:",omitempty"`
+}
+
+// signature summarizes the text of a document for finding similar documents
+// without reading the document again.
+type signature struct {
+	MinHash similarity.MinHash
+	SimHash uint64
+}
+
+func signatureKey(key string) string {
+	return keyspace.Signature(key)
+}
+
+func nearDuplicateBucketKey(band string) string {
+	return keyspace.NearDuplicateBucket(band)
+}
+
+// documentIDOfKey returns the ID of the document that the result stored under
+// key belongs to.
+//
+// Keys of documents with IDs from before IDs were versioned have no prefix.
+func documentIDOfKey(key string) string {
+	if strings.HasPrefix(key, documentIDPrefix) {
+		rest := strings.TrimPrefix(key, documentIDPrefix)
+		return documentIDPrefix + strings.SplitN(rest, ":", 2)[0]
+	}
+	return strings.SplitN(key, ":", 2)[0]
+}
+
+// storeSignature stores the signature of the document whose report is stored
+// under key and adds the document to the near-duplicate index, keeping both
+// for at least expiration.
+func (w *workerService) storeSignature(ctx context.Context, key string, sig signature, expiration time.Duration) error {
+	data, err := json.Marshal(sig)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := w.kv.Store(ctx, signatureKey(key), data, expiration); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, band := range sig.MinHash.Bands() {
+		err := updateStrings(ctx, w.kv, nearDuplicateBucketKey(band), expiration, func(bucket []string) ([]string, bool) {
+			if containsString(bucket, key) {
+				return bucket, false
+			}
+			bucket = append(bucket, key)
+			if len(bucket) > maxBucketSize {
+				bucket = bucket[len(bucket)-maxBucketSize:]
+			}
+			return bucket, true
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to update near-duplicate index")
+		}
+	}
+	return nil
+}
+
+// retrieveStrings retrieves a list of strings, which is empty if there is no
+// value for key.
+func retrieveStrings(ctx context.Context, kv keyvalue.KeyValue, key string) ([]string, error) {
+	data, err := kv.Retrieve(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return decodeStrings(key, data)
+}
+
+// decodeStrings decodes the list of strings stored under key, which is empty
+// if there is no value.
+func decodeStrings(key string, data []byte) ([]string, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	return list, errors.Wrapf(err, "invalid list %s", key)
+}
+
+// updateStrings atomically replaces a list of strings with the list returned
+// by update, which is called with the current list and may be called more
+// than once. The list is left as it is if update reports no change, and is
+// deleted if it becomes empty.
+func updateStrings(ctx context.Context, kv keyvalue.KeyValue, key string, expiration time.Duration, update func(list []string) ([]string, bool)) error {
+	return keyvalue.Update(ctx, kv, key, expiration, func(data []byte) ([]byte, error) {
+		var list []string
+		if data != nil {
+			if err := json.Unmarshal(data, &list); err != nil {
+				return nil, errors.Wrapf(err, "invalid list %s", key)
+			}
+		}
+		list, changed := update(list)
+		switch {
+		case !changed:
+			return nil, keyvalue.ErrUnchanged
+		case len(list) == 0:
+			return nil, nil
+		}
+		data, err := json.Marshal(list)
+		return data, errors.WithStack(err)
+	})
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSignature retrieves the signature stored for the document with the
+// given key. A nil signature is returned if there is none.
+func (a *apiService) loadSignature(ctx context.Context, key string) (*signature, error) {
+	data, err := a.kv.Retrieve(ctx, signatureKey(key))
+	if err != nil || data == nil {
+		return nil, errors.WithStack(err)
+	}
+	var sig signature
+	err = json.Unmarshal(data, &sig)
+	return &sig, errors.Wrapf(err, "invalid signature for document %s", key)
+}
+
+// loadTerms retrieves the stored counts of every word of an analyzed document.
+func (a *apiService) loadTerms(ctx context.Context, id string) (map[string]int, error) {
+	data, err := a.kv.Retrieve(ctx, termsKey(id))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if data == nil {
+		return nil, errors.Wrapf(ErrNotFound, "document %s has not been analyzed recently", id)
+	}
+	var words map[string]int
+	err = json.Unmarshal(data, &words)
+	return words, errors.Wrapf(err, "invalid word counts for document %s", id)
+}
+
+// compare compares a document with another document.
+func compare(id string, words, otherWords map[string]int, sig, otherSig *signature) Similarity {
+	s := Similarity{
+		DocumentID: id,
+		Cosine:     similarity.Cosine(words, otherWords),
+	}
+	if sig != nil && otherSig != nil {
+		jaccard := sig.MinHash.Jaccard(otherSig.MinHash)
+		distance := similarity.HammingDistance(sig.SimHash, otherSig.SimHash)
+		s.Jaccard = &jaccard
+		s.SimHashDistance = &distance
+	}
+	return s
+}
+
+// Similarity compares a document with another document, or with each
+// document of a collection.
+//
+// Documents are compared by the word counts and signatures that are stored
+// when they are analyzed, so documents must have been analyzed recently.
+// Documents in a collection are compared by the word counts stored in the
+// collection.
+func (a *apiService) Similarity(ctx context.Context, request SimilarityRequest) (SimilarityResponse, error) {
+	var sr SimilarityResponse
+	switch {
+	case request.Collection != "" && len(request.Documents) == 1:
+		if err := validateCollection(request.Collection); err != nil {
+			return sr, err
+		}
+	case request.Collection == "" && len(request.Documents) == 2:
+	default:
+		return sr, errors.Wrap(ErrInvalidRequest, "either two documents or a document and a collection are required")
+	}
+	if request.N < 0 {
+		return sr, errors.Wrap(ErrInvalidRequest, "n must not be negative")
+	}
+
+	id := request.Documents[0]
+	words, err := a.loadTerms(ctx, id)
+	if err != nil {
+		return sr, err
+	}
+	sig, err := a.loadSignature(ctx, id)
+	if err != nil {
+		return sr, err
+	}
+	sr = SimilarityResponse{
+		DocumentID: id,
+		Collection: request.Collection,
+	}
+
+	if request.Collection == "" {
+		other := request.Documents[1]
+		otherWords, err := a.loadTerms(ctx, other)
+		if err != nil {
+			return sr, err
+		}
+		otherSig, err := a.loadSignature(ctx, other)
+		if err != nil {
+			return sr, err
+		}
+		sr.Similarities = []Similarity{compare(other, words, otherWords, sig, otherSig)}
+		return sr, nil
+	}
+
+	stats, err := a.loadCollection(ctx, request.Collection)
+	if err != nil {
+		return sr, err
+	}
+	if stats.Documents == 0 {
+		return sr, errors.Wrapf(ErrNotFound, "collection %q has no documents", request.Collection)
+	}
+	memberIDs, err := a.collectionMembers(ctx, request.Collection)
+	if err != nil {
+		return sr, err
+	}
+	// The counts and signatures of every member are read in one batch, since
+	// collections may have many members.
+	members := len(memberIDs)
+	keys := make([]string, 2*members)
+	for i, member := range memberIDs {
+		keys[i] = collectionDocumentKey(request.Collection, member)
+		keys[members+i] = signatureKey(member)
+	}
+	values, err := a.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return sr, errors.Wrapf(err, "unable to retrieve members of collection %q", request.Collection)
+	}
+	sr.Similarities = make([]Similarity, 0, members)
+	for i, member := range memberIDs {
+		if values[i] == nil {
+			continue
+		}
+		var memberWords map[string]int
+		if err := json.Unmarshal(values[i], &memberWords); err != nil {
+			return sr, errors.Wrapf(err, "invalid counts for document %s", member)
+		}
+		var memberSig *signature
+		if data := values[members+i]; data != nil {
+			memberSig = new(signature)
+			if err := json.Unmarshal(data, memberSig); err != nil {
+				return sr, errors.Wrapf(err, "invalid signature for document %s", member)
+			}
+		}
+		sr.Similarities = append(sr.Similarities, compare(member, words, memberWords, sig, memberSig))
+	}
+	sort.Slice(sr.Similarities, func(i, j int) bool {
+		if sr.Similarities[i].Cosine != sr.Similarities[j].Cosine {
+			return sr.Similarities[i].Cosine > sr.Similarities[j].Cosine
+		}
+		return sr.Similarities[i].DocumentID < sr.Similarities[j].DocumentID
+	})
+	n := request.N
+	if n == 0 {
+		n = DefaultTopN
+	}
+	if len(sr.Similarities) > n {
+		sr.Similarities = sr.Similarities[:n]
+	}
+	return sr, nil
+}
+
+// nearDuplicate is the report of a near-duplicate that is the most similar to
+// a document.
+type nearDuplicate struct {
+	key     string
+	sig     *signature
+	jaccard float64
+}
+
+// nearDuplicateMatches reads the signatures of the candidates in one batch
+// and returns the candidates whose estimated Jaccard similarity with sig is
+// at least threshold, by document ID. Of the reports of a document, the most
+// similar one is returned.
+func (a *apiService) nearDuplicateMatches(ctx context.Context, sig *signature, candidates []string, threshold float64) (map[string]nearDuplicate, error) {
+	matches := make(map[string]nearDuplicate)
+	if len(candidates) == 0 {
+		return matches, nil
+	}
+	keys := make([]string, len(candidates))
+	for i, key := range candidates {
+		keys[i] = signatureKey(key)
+	}
+	values, err := a.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve signatures of near-duplicates")
+	}
+	for i, key := range candidates {
+		if values[i] == nil {
+			continue
+		}
+		candidate := new(signature)
+		if err := json.Unmarshal(values[i], candidate); err != nil {
+			return nil, errors.Wrapf(err, "invalid signature for document %s", key)
+		}
+		jaccard := sig.MinHash.Jaccard(candidate.MinHash)
+		id := documentIDOfKey(key)
+		if best, ok := matches[id]; jaccard < threshold || ok && best.jaccard >= jaccard {
+			continue
+		}
+		matches[id] = nearDuplicate{key: key, sig: candidate, jaccard: jaccard}
+	}
+	return matches, nil
+}
+
+// NearDuplicates finds the recently analyzed documents that are
+// near-duplicates of a document.
+//
+// Candidates are looked up in the near-duplicate index by the bands of the
+// MinHash signature of the document, so no documents are read again.
+func (a *apiService) NearDuplicates(ctx context.Context, request NearDuplicatesRequest) (SimilarityResponse, error) {
+	var sr SimilarityResponse
+	threshold := request.Threshold
+	if threshold == 0 {
+		threshold = DefaultNearDuplicateThreshold
+	}
+	if threshold < 0 || threshold > 1 {
+		return sr, errors.Wrap(ErrInvalidRequest, "threshold must be between 0 and 1")
+	}
+
+	sig, err := a.loadSignature(ctx, request.DocumentID)
+	if err != nil {
+		return sr, err
+	}
+	if sig == nil {
+		return sr, errors.Wrapf(ErrNotFound, "document %s has not been analyzed recently", request.DocumentID)
+	}
+	words, err := a.loadTerms(ctx, request.DocumentID)
+	if err != nil {
+		return sr, err
+	}
+
+	// The buckets of every band are read in one batch.
+	bands := sig.MinHash.Bands()
+	keys := make([]string, len(bands))
+	for i, band := range bands {
+		keys[i] = nearDuplicateBucketKey(band)
+	}
+	buckets, err := a.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return sr, errors.Wrap(err, "unable to retrieve near-duplicate index")
+	}
+
+	// A document analyzed with several options is in the index under the key
+	// of each report.
+	seen := make(map[string]bool)
+	var candidates []string
+	for i := range bands {
+		bucket, err := decodeStrings(keys[i], buckets[i])
+		if err != nil {
+			return sr, err
+		}
+		for _, key := range bucket {
+			if seen[key] || documentIDOfKey(key) == request.DocumentID {
+				continue
+			}
+			seen[key] = true
+			candidates = append(candidates, key)
+		}
+	}
+	matches, err := a.nearDuplicateMatches(ctx, sig, candidates, threshold)
+	if err != nil {
+		return sr, err
+	}
+
+	// The counts of the near-duplicates are read in one batch.
+	ids := make([]string, 0, len(matches))
+	keys = make([]string, 0, len(matches))
+	for id, match := range matches {
+		ids = append(ids, id)
+		keys = append(keys, termsKey(match.key))
+	}
+	values, err := a.kv.RetrieveMany(ctx, keys)
+	if err != nil {
+		return sr, errors.Wrap(err, "unable to retrieve word counts of near-duplicates")
+	}
+	sr = SimilarityResponse{DocumentID: request.DocumentID, Similarities: make([]Similarity, 0, len(ids))}
+	for i, id := range ids {
+		var candidateWords map[string]int
+		if values[i] != nil {
+			if err := json.Unmarshal(values[i], &candidateWords); err != nil {
+				return sr, errors.Wrapf(err, "invalid word counts for document %s", matches[id].key)
+			}
+		}
+		sr.Similarities = append(sr.Similarities, compare(id, words, candidateWords, sig, matches[id].sig))
+	}
+	_ = a.l.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Found %d near-duplicates of document %s", len(sr.Similarities), request.DocumentID))
+	sort.Slice(sr.Similarities, func(i, j int) bool {
+		if *sr.Similarities[i].Jaccard != *sr.Similarities[j].Jaccard {
+			return *sr.Similarities[i].Jaccard > *sr.Similarities[j].Jaccard
+		}
+		return sr.Similarities[i].DocumentID < sr.Similarities[j].DocumentID
+	})
+	return sr, nil
+}
@@ -0,0 +1,220 @@
+// Package similarity implements measures of the similarity of documents.
+//
+// Term vectors are compared with the cosine similarity. Documents are also
+// summarized by MinHash signatures of their shingles, the sequences of
+// consecutive words in them, which estimate the Jaccard similarity of the
+// shingle sets, and by SimHash fingerprints of their terms. Both summaries are
+// small and fixed in size, so they can be stored when a document is analyzed
+// and compared later without reading the document again.
+package similarity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+const (
+	// DefaultShingleSize is the number of words in a shingle.
+	DefaultShingleSize = 3
+	// NumHashes is the number of hash functions in a MinHash signature.
+	NumHashes = 64
+	// NumBands is the number of bands that signatures are split into for
+	// locality sensitive hashing. Documents that share a band are candidates
+	// for being near-duplicates.
+	NumBands = 16
+
+	rowsPerBand = NumHashes / NumBands
+)
+
+// seeds are the seeds of the hash functions of MinHash signatures.
+var seeds = func() [NumHashes]uint64 {
+	var s [NumHashes]uint64
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range s {
+		x = mix(x + uint64(i))
+		s[i] = x
+	}
+	return s
+}()
+
+// mix is the finalizer of SplitMix64.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// MinHash is a MinHash signature. The signature of a document without any
+// words is empty.
+type MinHash []uint64
+
+// add adds a hashed element to the signature.
+func (m MinHash) add(h uint64) {
+	for i := range m {
+		if v := mix(h ^ seeds[i]); v < m[i] {
+			m[i] = v
+		}
+	}
+}
+
+// Merge returns the signature of the union of the sets summarized by m and o.
+func (m MinHash) Merge(o MinHash) MinHash {
+	if len(m) == 0 {
+		return append(MinHash(nil), o...)
+	}
+	if len(o) == 0 {
+		return m
+	}
+	out := make(MinHash, len(m))
+	for i := range m {
+		out[i] = m[i]
+		if i < len(o) && o[i] < out[i] {
+			out[i] = o[i]
+		}
+	}
+	return out
+}
+
+// Jaccard returns an estimate of the Jaccard similarity of the sets
+// summarized by m and o.
+func (m MinHash) Jaccard(o MinHash) float64 {
+	if len(m) == 0 || len(m) != len(o) {
+		return 0
+	}
+	var equal int
+	for i := range m {
+		if m[i] == o[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(m))
+}
+
+// Bands returns the locality sensitive hashing buckets of the signature.
+// Documents with a Jaccard similarity of s share at least one bucket with a
+// probability of 1 - (1 - s^4)^16, e.g. 0.98 for s = 0.8 and 0.05 for s = 0.3.
+func (m MinHash) Bands() []string {
+	if len(m) != NumHashes {
+		return nil
+	}
+	bands := make([]string, 0, NumBands)
+	buf := make([]byte, 8*rowsPerBand)
+	for b := 0; b < NumBands; b++ {
+		for r := 0; r < rowsPerBand; r++ {
+			binary.BigEndian.PutUint64(buf[8*r:], m[b*rowsPerBand+r])
+		}
+		h := fnv.New64a()
+		_, _ = h.Write(buf)
+		bands = append(bands, fmt.Sprintf("%d:%016x", b, h.Sum64()))
+	}
+	return bands
+}
+
+// Shingler builds the MinHash signature of the shingles of a sequence of
+// words.
+type Shingler struct {
+	size   int
+	window []string
+	seen   int
+	sig    MinHash
+}
+
+// NewShingler returns a Shingler for shingles of size words.
+// DefaultShingleSize is used if size is 0.
+func NewShingler(size int) *Shingler {
+	if size <= 0 {
+		size = DefaultShingleSize
+	}
+	return &Shingler{size: size}
+}
+
+// Add adds the next word of the sequence.
+func (s *Shingler) Add(word string) {
+	if s.sig == nil {
+		s.sig = make(MinHash, NumHashes)
+		for i := range s.sig {
+			s.sig[i] = math.MaxUint64
+		}
+	}
+	if len(s.window) == s.size {
+		copy(s.window, s.window[1:])
+		s.window = s.window[:s.size-1]
+	}
+	s.window = append(s.window, word)
+	s.seen++
+	if len(s.window) == s.size {
+		s.sig.add(hashString(strings.Join(s.window, " ")))
+	}
+}
+
+// Signature returns the signature of the words added so far. Sequences that
+// are shorter than a shingle are treated as a single shingle.
+func (s *Shingler) Signature() MinHash {
+	if s.seen == 0 || s.seen >= s.size {
+		return s.sig
+	}
+	sig := append(MinHash(nil), s.sig...)
+	sig.add(hashString(strings.Join(s.window, " ")))
+	return sig
+}
+
+// SimHash returns the SimHash fingerprint of a term vector. Documents with
+// similar terms have fingerprints with a small Hamming distance.
+func SimHash(words map[string]int) uint64 {
+	var v [64]int
+	for word, count := range words {
+		h := hashString(word)
+		for i := range v {
+			if h&(1<<uint(i)) != 0 {
+				v[i] += count
+			} else {
+				v[i] -= count
+			}
+		}
+	}
+	var fingerprint uint64
+	for i := range v {
+		if v[i] > 0 {
+			fingerprint |= 1 << uint(i)
+		}
+	}
+	return fingerprint
+}
+
+// HammingDistance returns the number of bits that differ between two
+// fingerprints.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Cosine returns the cosine similarity of two term vectors.
+func Cosine(a, b map[string]int) float64 {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	var dot, normA, normB float64
+	for word, x := range a {
+		dot += float64(x) * float64(b[word])
+		normA += float64(x) * float64(x)
+	}
+	for _, y := range b {
+		normB += float64(y) * float64(y)
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
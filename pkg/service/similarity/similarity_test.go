@@ -0,0 +1,85 @@
+package similarity_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/similarity"
+)
+
+func signature(text string) similarity.MinHash {
+	s := similarity.NewShingler(0)
+	for _, word := range strings.Fields(text) {
+		s.Add(word)
+	}
+	return s.Signature()
+}
+
+func counts(text string) map[string]int {
+	words := make(map[string]int)
+	for _, word := range strings.Fields(text) {
+		words[word]++
+	}
+	return words
+}
+
+const text = "the quick brown fox jumps over the lazy dog while the cat sleeps " +
+	"in the warm afternoon sun and the birds sing in the old oak tree"
+
+func TestMinHash(t *testing.T) {
+	t.Parallel()
+
+	a := signature(text)
+	require.Len(t, a, similarity.NumHashes, "Signature should have one value per hash.")
+	assert.Equal(t, 1.0, a.Jaccard(signature(text)), "Identical documents should match.")
+
+	nearDuplicate := signature(strings.Replace(text, "old oak", "old elm", 1))
+	assert.True(t, a.Jaccard(nearDuplicate) > 0.7, "Near-duplicates should be similar.")
+	assert.True(t, a.Jaccard(signature("completely different words appear in this other text")) < 0.2,
+		"Different documents should not be similar.")
+
+	shared := 0
+	bands := nearDuplicate.Bands()
+	for i, band := range a.Bands() {
+		if band == bands[i] {
+			shared++
+		}
+	}
+	assert.NotZero(t, shared, "Near-duplicates should share a band.")
+
+	assert.Empty(t, signature(""), "Empty documents should have no signature.")
+	assert.Len(t, signature("two words"), similarity.NumHashes, "Short documents should have a signature.")
+}
+
+func TestMinHashMerge(t *testing.T) {
+	t.Parallel()
+
+	first, second := "the quick brown fox jumps", "over the lazy dog today"
+	merged := signature(first).Merge(signature(second))
+	whole := signature(first + " " + second)
+	// Only the shingles that span both halves are missing from the merge.
+	assert.True(t, merged.Jaccard(whole) > 0.5, "Merged signature should approximate the whole.")
+	assert.Equal(t, signature(first), signature(first).Merge(nil), "Merging nothing should have no effect.")
+}
+
+func TestSimHash(t *testing.T) {
+	t.Parallel()
+
+	a := similarity.SimHash(counts(text))
+	b := similarity.SimHash(counts(strings.Replace(text, "oak", "elm", 1)))
+	c := similarity.SimHash(counts("completely different words appear in this other text"))
+	assert.True(t, similarity.HammingDistance(a, b) < similarity.HammingDistance(a, c),
+		"Similar documents should have closer fingerprints.")
+}
+
+func TestCosine(t *testing.T) {
+	t.Parallel()
+
+	assert.InDelta(t, 1.0, similarity.Cosine(counts("a b b"), counts("a a b b b b")), 1e-9, "Parallel vectors should match.")
+	assert.Equal(t, 0.0, similarity.Cosine(counts("a b"), counts("c d")), "Disjoint vectors should not match.")
+	assert.InDelta(t, 0.5, similarity.Cosine(counts("a b"), counts("b c")), 1e-9)
+	assert.Equal(t, 0.0, similarity.Cosine(nil, counts("a")), "Empty vectors should not match.")
+}
@@ -0,0 +1,75 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service"
+)
+
+const similarityText = "the quick brown fox jumps over the lazy dog while the cat sleeps " +
+	"in the warm afternoon sun and the birds sing in the old oak tree"
+
+func TestSimilarity(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	apiService := newCollectionService(ctx)
+
+	process := func(document string) string {
+		dfr, err := apiService.ProcessDocument(ctx, service.DocumentRequest{Document: document})
+		require.NoError(t, err, "Processing document should succeed.")
+		return dfr.DocumentID
+	}
+	original := process(similarityText)
+	nearDuplicate := process(strings.Replace(similarityText, "old oak", "old elm", 1))
+	different := process("completely different words appear in this other text")
+	// The near-duplicate is in the near-duplicate index once for each report.
+	_, err := apiService.ProcessDocument(ctx, service.DocumentRequest{
+		Document:        strings.Replace(similarityText, "old oak", "old elm", 1),
+		AnalysisOptions: service.AnalysisOptions{Stemmer: "en"},
+	})
+	require.NoError(t, err, "Processing document with other options should succeed.")
+
+	sr, err := apiService.Similarity(ctx, service.SimilarityRequest{Documents: []string{original, nearDuplicate}})
+	require.NoError(t, err, "Comparing documents should succeed.")
+	require.Len(t, sr.Similarities, 1, "Documents should be compared once.")
+	s := sr.Similarities[0]
+	assert.Equal(t, nearDuplicate, s.DocumentID)
+	assert.True(t, s.Cosine > 0.9, "Cosine similarity should be high.")
+	require.NotNil(t, s.Jaccard, "Jaccard similarity should be estimated.")
+	assert.True(t, *s.Jaccard > 0.7, "Jaccard similarity should be high.")
+	require.NotNil(t, s.SimHashDistance, "SimHash distance should be computed.")
+
+	ndr, err := apiService.NearDuplicates(ctx, service.NearDuplicatesRequest{DocumentID: original, Threshold: 0.7})
+	require.NoError(t, err, "Finding near-duplicates should succeed.")
+	var found []string
+	for _, s := range ndr.Similarities {
+		found = append(found, s.DocumentID)
+	}
+	assert.Equal(t, []string{nearDuplicate}, found, "Only the near-duplicate should be found, once.")
+
+	for _, document := range []string{similarityText, "completely different words appear in this other text"} {
+		_, err := apiService.AddToCollection(ctx, service.CollectionDocumentRequest{
+			Collection:      "texts",
+			DocumentRequest: service.DocumentRequest{Document: document},
+		})
+		require.NoError(t, err, "Adding document should succeed.")
+	}
+	sr, err = apiService.Similarity(ctx, service.SimilarityRequest{Documents: []string{nearDuplicate}, Collection: "texts"})
+	require.NoError(t, err, "Comparing with a collection should succeed.")
+	require.Len(t, sr.Similarities, 2, "Every document of the collection should be compared.")
+	assert.Equal(t, original, sr.Similarities[0].DocumentID, "Most similar document should be first.")
+	assert.Equal(t, different, sr.Similarities[1].DocumentID)
+
+	_, err = apiService.NearDuplicates(ctx, service.NearDuplicatesRequest{DocumentID: "unknown"})
+	assert.Equal(t, service.ErrNotFound, errors.Cause(err), "Unknown documents should be reported.")
+	_, err = apiService.Similarity(ctx, service.SimilarityRequest{Documents: []string{original}})
+	assert.Equal(t, service.ErrInvalidRequest, errors.Cause(err), "A second document should be required.")
+}
@@ -0,0 +1,144 @@
+package stem
+
+import "strings"
+
+func dutchVowel(r rune) bool {
+	return contains("aeiouyè", r)
+}
+
+var dutchAccents = strings.NewReplacer(
+	"ä", "a", "ë", "e", "ï", "i", "ö", "o", "ü", "u",
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u",
+)
+
+// Dutch stems a Dutch word with the Snowball Dutch algorithm.
+func Dutch(s string) string {
+	w := &word{r: []rune(dutchAccents.Replace(s))}
+	for i, c := range w.r {
+		switch {
+		case c == 'y' && (i == 0 || dutchVowel(w.r[i-1])):
+			w.r[i] = 'Y'
+		case c == 'i' && i > 0 && i < len(w.r)-1 && dutchVowel(w.r[i-1]) && dutchVowel(w.r[i+1]):
+			w.r[i] = 'I'
+		}
+	}
+	w.setRegions(dutchVowel)
+	// At least three letters must come before R1.
+	if w.r1 < 3 {
+		w.r1 = 3
+		if w.r1 > len(w.r) {
+			w.r1 = len(w.r)
+		}
+	}
+
+	dutchStep1(w)
+	removedE := dutchStep2(w)
+	dutchStep3a(w)
+	dutchStep3b(w, removedE)
+	dutchStep4(w)
+
+	return strings.NewReplacer("I", "i", "Y", "y").Replace(w.String())
+}
+
+// dutchUndouble removes the last letter of a word that ends with kk, dd or
+// tt.
+func dutchUndouble(w *word) {
+	if w.longest("kk", "dd", "tt") != "" {
+		w.replace(string(w.r[len(w.r)-1]), "")
+	}
+}
+
+// dutchEnding deletes en or ene if it is in R1 and is preceded by a valid
+// en-ending, which is a non-vowel that is not part of gem.
+func dutchEnding(w *word, suffix string) {
+	if !w.inR1(suffix) {
+		return
+	}
+	c := w.before(suffix, 1)
+	if c == 0 || dutchVowel(c) || w.precededBy(suffix, "gem") {
+		return
+	}
+	w.replace(suffix, "")
+	dutchUndouble(w)
+}
+
+func dutchStep1(w *word) {
+	switch suffix := w.longest("heden", "en", "ene", "s", "se"); suffix {
+	case "heden":
+		if w.inR1(suffix) {
+			w.replace(suffix, "heid")
+		}
+	case "en", "ene":
+		dutchEnding(w, suffix)
+	case "s", "se":
+		c := w.before(suffix, 1)
+		if w.inR1(suffix) && c != 0 && !dutchVowel(c) && c != 'j' {
+			w.replace(suffix, "")
+		}
+	}
+}
+
+func dutchStep2(w *word) bool {
+	if !w.hasSuffix("e") || !w.inR1("e") {
+		return false
+	}
+	c := w.before("e", 1)
+	if c == 0 || dutchVowel(c) {
+		return false
+	}
+	w.replace("e", "")
+	dutchUndouble(w)
+	return true
+}
+
+func dutchStep3a(w *word) {
+	if !w.hasSuffix("heid") || !w.inR2("heid") || w.before("heid", 1) == 'c' {
+		return
+	}
+	w.replace("heid", "")
+	if w.hasSuffix("en") {
+		dutchEnding(w, "en")
+	}
+}
+
+func dutchStep3b(w *word, removedE bool) {
+	suffix := w.longest("end", "ing", "ig", "lijk", "baar", "bar")
+	if suffix == "" || !w.inR2(suffix) {
+		return
+	}
+	switch suffix {
+	case "end", "ing":
+		w.replace(suffix, "")
+		if w.hasSuffix("ig") && w.inR2("ig") && w.before("ig", 1) != 'e' {
+			w.replace("ig", "")
+		} else {
+			dutchUndouble(w)
+		}
+	case "ig":
+		if w.before(suffix, 1) != 'e' {
+			w.replace(suffix, "")
+		}
+	case "lijk":
+		w.replace(suffix, "")
+		dutchStep2(w)
+	case "baar":
+		w.replace(suffix, "")
+	case "bar":
+		if removedE {
+			w.replace(suffix, "")
+		}
+	}
+}
+
+// dutchStep4 removes one of the vowels of a word that ends with a non-vowel,
+// a double a, e, o or u, and a non-vowel other than I.
+func dutchStep4(w *word) {
+	n := len(w.r)
+	if n < 4 {
+		return
+	}
+	c, v1, v2, d := w.r[n-4], w.r[n-3], w.r[n-2], w.r[n-1]
+	if !dutchVowel(c) && v1 == v2 && contains("aeou", v1) && !dutchVowel(d) && d != 'I' {
+		w.r = append(w.r[:n-2], d)
+	}
+}
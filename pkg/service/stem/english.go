@@ -0,0 +1,218 @@
+package stem
+
+import "strings"
+
+func englishVowel(r rune) bool {
+	return contains("aeiouy", r)
+}
+
+// englishExceptions are stemmed irregularly, or are left as they are.
+var englishExceptions = map[string]string{
+	"skis": "ski", "skies": "sky", "dying": "die", "lying": "lie",
+	"tying": "tie", "idly": "idl", "gently": "gentl", "ugly": "ugli",
+	"early": "earli", "only": "onli", "singly": "singl",
+	"sky": "sky", "news": "news", "howe": "howe", "atlas": "atlas",
+	"cosmos": "cosmos", "bias": "bias", "andes": "andes",
+}
+
+// englishStep1aExceptions are left as they are after step 1a.
+var englishStep1aExceptions = map[string]bool{
+	"inning": true, "outing": true, "canning": true, "herring": true,
+	"earring": true, "proceed": true, "exceed": true, "succeed": true,
+}
+
+// English stems an English word with the Porter2 algorithm.
+func English(s string) string {
+	if len([]rune(s)) <= 2 {
+		return s
+	}
+	s = strings.Replace(s, "’", "'", -1)
+	s = strings.TrimPrefix(s, "'")
+	if e, ok := englishExceptions[s]; ok {
+		return e
+	}
+
+	w := &word{r: []rune(s)}
+	for i, c := range w.r {
+		if c == 'y' && (i == 0 || englishVowel(w.r[i-1])) {
+			w.r[i] = 'Y'
+		}
+	}
+	w.setRegions(englishVowel)
+	for _, prefix := range []string{"gener", "commun", "arsen"} {
+		if strings.HasPrefix(s, prefix) {
+			w.r1 = len(prefix)
+			w.r2 = regions(w.r, w.r1, englishVowel)
+		}
+	}
+
+	englishStep0(w)
+	englishStep1a(w)
+	if englishStep1aExceptions[w.String()] {
+		return w.String()
+	}
+	englishStep1b(w)
+	englishStep1c(w)
+	englishStep2(w)
+	englishStep3(w)
+	englishStep4(w)
+	englishStep5(w)
+	return strings.Replace(w.String(), "Y", "y", -1)
+}
+
+// shortSyllable reports if the word has a short syllable ending at i.
+func englishShortSyllable(r []rune, i int) bool {
+	if i == 1 {
+		return englishVowel(r[0]) && !englishVowel(r[1])
+	}
+	return i >= 2 && !englishVowel(r[i-2]) && englishVowel(r[i-1]) &&
+		!englishVowel(r[i]) && !contains("wxY", r[i])
+}
+
+func englishShort(w *word) bool {
+	return w.r1 >= len(w.r) && englishShortSyllable(w.r, len(w.r)-1)
+}
+
+// hasVowel reports if the part of the word before suffix has a vowel,
+// ignoring the last n runes.
+func englishHasVowel(w *word, suffix string, n int) bool {
+	for _, c := range w.r[:w.start(suffix)-n] {
+		if englishVowel(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func englishStep0(w *word) {
+	if suffix := w.longest("'", "'s", "'s'"); suffix != "" {
+		w.replace(suffix, "")
+	}
+}
+
+func englishStep1a(w *word) {
+	switch suffix := w.longest("sses", "ied", "ies", "s", "us", "ss"); suffix {
+	case "sses":
+		w.replace(suffix, "ss")
+	case "ied", "ies":
+		if w.start(suffix) > 1 {
+			w.replace(suffix, "i")
+		} else {
+			w.replace(suffix, "ie")
+		}
+	case "s":
+		if w.start(suffix) >= 2 && englishHasVowel(w, suffix, 1) {
+			w.replace(suffix, "")
+		}
+	}
+}
+
+func englishStep1b(w *word) {
+	switch suffix := w.longest("eed", "eedly", "ed", "edly", "ing", "ingly"); suffix {
+	case "eed", "eedly":
+		if w.inR1(suffix) {
+			w.replace(suffix, "ee")
+		}
+	case "ed", "edly", "ing", "ingly":
+		if !englishHasVowel(w, suffix, 0) {
+			return
+		}
+		w.replace(suffix, "")
+		switch {
+		case w.hasSuffix("at"), w.hasSuffix("bl"), w.hasSuffix("iz"):
+			w.r = append(w.r, 'e')
+		case w.longest("bb", "dd", "ff", "gg", "mm", "nn", "pp", "rr", "tt") != "":
+			w.r = w.r[:len(w.r)-1]
+		case englishShort(w):
+			w.r = append(w.r, 'e')
+		}
+	}
+}
+
+func englishStep1c(w *word) {
+	n := len(w.r)
+	if n > 2 && (w.r[n-1] == 'y' || w.r[n-1] == 'Y') && !englishVowel(w.r[n-2]) {
+		w.r[n-1] = 'i'
+	}
+}
+
+var englishStep2Suffixes = map[string]string{
+	"tional": "tion", "enci": "ence", "anci": "ance", "abli": "able",
+	"entli": "ent", "izer": "ize", "ization": "ize", "ational": "ate",
+	"ation": "ate", "ator": "ate", "alism": "al", "aliti": "al", "alli": "al",
+	"fulness": "ful", "ousli": "ous", "ousness": "ous", "iveness": "ive",
+	"iviti": "ive", "biliti": "ble", "bli": "ble", "ogi": "og", "fulli": "ful",
+	"lessli": "less", "li": "",
+}
+
+func englishStep2(w *word) {
+	suffix := longestOf(w, englishStep2Suffixes)
+	if suffix == "" || !w.inR1(suffix) {
+		return
+	}
+	switch suffix {
+	case "ogi":
+		if w.before(suffix, 1) != 'l' {
+			return
+		}
+	case "li":
+		if !contains("cdeghkmnrt", w.before(suffix, 1)) {
+			return
+		}
+	}
+	w.replace(suffix, englishStep2Suffixes[suffix])
+}
+
+var englishStep3Suffixes = map[string]string{
+	"tional": "tion", "ational": "ate", "alize": "al", "icate": "ic",
+	"iciti": "ic", "ical": "ic", "ful": "", "ness": "", "ative": "",
+}
+
+func englishStep3(w *word) {
+	suffix := longestOf(w, englishStep3Suffixes)
+	if suffix == "" || !w.inR1(suffix) {
+		return
+	}
+	if suffix == "ative" && !w.inR2(suffix) {
+		return
+	}
+	w.replace(suffix, englishStep3Suffixes[suffix])
+}
+
+func englishStep4(w *word) {
+	suffix := w.longest("al", "ance", "ence", "er", "ic", "able", "ible", "ant",
+		"ement", "ment", "ent", "ism", "ate", "iti", "ous", "ive", "ize", "ion")
+	if suffix == "" || !w.inR2(suffix) {
+		return
+	}
+	if suffix == "ion" && !contains("st", w.before(suffix, 1)) {
+		return
+	}
+	w.replace(suffix, "")
+}
+
+func englishStep5(w *word) {
+	switch {
+	case w.hasSuffix("e"):
+		if w.inR2("e") || w.inR1("e") && !englishShortSyllable(w.r, len(w.r)-2) {
+			w.replace("e", "")
+		}
+	case w.hasSuffix("l"):
+		if w.inR2("l") && w.before("l", 1) == 'l' {
+			w.replace("l", "")
+		}
+	}
+}
+
+// longestOf returns the longest suffix of the word among the keys of
+// suffixes, or "".
+func longestOf(w *word, suffixes map[string]string) string {
+	best := ""
+	s := w.String()
+	for suffix := range suffixes {
+		if len(suffix) > len(best) && strings.HasSuffix(s, suffix) {
+			best = suffix
+		}
+	}
+	return best
+}
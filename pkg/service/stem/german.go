@@ -0,0 +1,91 @@
+package stem
+
+import "strings"
+
+func germanVowel(r rune) bool {
+	return contains("aeiouyäöü", r)
+}
+
+// German stems a German word with the Snowball German algorithm.
+func German(s string) string {
+	w := &word{r: []rune(strings.Replace(s, "ß", "ss", -1))}
+	for i := 1; i < len(w.r)-1; i++ {
+		if (w.r[i] == 'u' || w.r[i] == 'y') && germanVowel(w.r[i-1]) && germanVowel(w.r[i+1]) {
+			w.r[i] -= 'a' - 'A'
+		}
+	}
+	w.setRegions(germanVowel)
+	// At least three letters must come before R1.
+	if w.r1 < 3 {
+		w.r1 = 3
+		if w.r1 > len(w.r) {
+			w.r1 = len(w.r)
+		}
+	}
+
+	germanStep1(w)
+	germanStep2(w)
+	germanStep3(w)
+
+	return strings.NewReplacer("U", "u", "Y", "y", "ä", "a", "ö", "o", "ü", "u").Replace(w.String())
+}
+
+func germanStep1(w *word) {
+	suffix := w.longest("em", "ern", "er", "e", "en", "es", "s")
+	if suffix == "" || !w.inR1(suffix) {
+		return
+	}
+	switch suffix {
+	case "s":
+		if !contains("bdfghklmnrt", w.before(suffix, 1)) {
+			return
+		}
+		w.replace(suffix, "")
+	case "e", "en", "es":
+		w.replace(suffix, "")
+		if w.hasSuffix("niss") {
+			w.replace("s", "")
+		}
+	default:
+		w.replace(suffix, "")
+	}
+}
+
+func germanStep2(w *word) {
+	suffix := w.longest("en", "er", "est", "st")
+	if suffix == "" || !w.inR1(suffix) {
+		return
+	}
+	if suffix == "st" && (!contains("bdfghklmnt", w.before(suffix, 1)) || w.start(suffix) < 4) {
+		return
+	}
+	w.replace(suffix, "")
+}
+
+func germanStep3(w *word) {
+	suffix := w.longest("end", "ung", "ig", "ik", "isch", "lich", "heit", "keit")
+	if suffix == "" || !w.inR2(suffix) {
+		return
+	}
+	switch suffix {
+	case "end", "ung":
+		w.replace(suffix, "")
+		if w.hasSuffix("ig") && w.inR2("ig") && w.before("ig", 1) != 'e' {
+			w.replace("ig", "")
+		}
+	case "ig", "ik", "isch":
+		if w.before(suffix, 1) != 'e' {
+			w.replace(suffix, "")
+		}
+	case "lich", "heit":
+		w.replace(suffix, "")
+		if p := w.longest("er", "en"); p != "" && w.inR1(p) {
+			w.replace(p, "")
+		}
+	case "keit":
+		w.replace(suffix, "")
+		if p := w.longest("lich", "ig"); p != "" && w.inR2(p) {
+			w.replace(p, "")
+		}
+	}
+}
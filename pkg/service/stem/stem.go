@@ -0,0 +1,123 @@
+// Package stem reduces words to their stems with the Snowball stemming
+// algorithms, so that inflected forms of a word such as "run", "runs" and
+// "running" are counted together.
+//
+// Stemmers expect lower case words without surrounding punctuation.
+package stem
+
+import "strings"
+
+// Version identifies the output of the stemmers. It must be increased
+// whenever a change makes any stemmer return a different stem for a word, so
+// that counts cached with the old stems are not used.
+const Version = 1
+
+// Stemmer reduces a lower case word to its stem.
+type Stemmer func(word string) string
+
+// stemmers are the stemmers by the ISO 639-1 code of their language.
+var stemmers = map[string]Stemmer{
+	"de": German,
+	"en": English,
+	"nl": Dutch,
+	"sv": Swedish,
+}
+
+// For returns the stemmer for a language.
+func For(lang string) (Stemmer, bool) {
+	s, ok := stemmers[lang]
+	return s, ok
+}
+
+// word is a word being stemmed with the regions that suffixes are removed
+// from.
+type word struct {
+	r []rune
+	// r1 and r2 are the starts of the regions R1 and R2. They are at least
+	// len(r) if a region is empty.
+	r1, r2 int
+}
+
+// regions finds the start of the region after the first non-vowel that
+// follows a vowel, searching from start.
+func regions(r []rune, start int, vowel func(rune) bool) int {
+	for i := start + 1; i < len(r); i++ {
+		if !vowel(r[i]) && vowel(r[i-1]) {
+			return i + 1
+		}
+	}
+	return len(r)
+}
+
+func (w *word) setRegions(vowel func(rune) bool) {
+	w.r1 = regions(w.r, 0, vowel)
+	w.r2 = regions(w.r, w.r1, vowel)
+}
+
+func (w *word) String() string {
+	return string(w.r)
+}
+
+func (w *word) hasSuffix(s string) bool {
+	return strings.HasSuffix(string(w.r), s)
+}
+
+// longest returns the longest of suffixes that the word ends with, or "".
+func (w *word) longest(suffixes ...string) string {
+	s := string(w.r)
+	best := ""
+	for _, suffix := range suffixes {
+		if len(suffix) > len(best) && strings.HasSuffix(s, suffix) {
+			best = suffix
+		}
+	}
+	return best
+}
+
+// longestIn returns the longest of suffixes that the word ends with and that
+// start at or after start, or "". This restricts the search to a region like
+// Snowball does, so a shorter suffix in the region is found even if a longer
+// one reaches outside of it.
+func (w *word) longestIn(start int, suffixes ...string) string {
+	best := ""
+	for _, suffix := range suffixes {
+		if len(suffix) > len(best) && w.hasSuffix(suffix) && w.start(suffix) >= start {
+			best = suffix
+		}
+	}
+	return best
+}
+
+// start returns the index of suffix within the word.
+func (w *word) start(suffix string) int {
+	return len(w.r) - len([]rune(suffix))
+}
+
+// inR1 and inR2 report if suffix is within R1 or R2.
+func (w *word) inR1(suffix string) bool { return w.start(suffix) >= w.r1 }
+func (w *word) inR2(suffix string) bool { return w.start(suffix) >= w.r2 }
+
+// replace replaces suffix, which the word must end with, by repl. The regions
+// keep their starts, so letters added after a shortened word are outside of
+// them.
+func (w *word) replace(suffix, repl string) {
+	w.r = append(w.r[:w.start(suffix)], []rune(repl)...)
+}
+
+// before returns the rune n runes before the start of suffix, or 0.
+func (w *word) before(suffix string, n int) rune {
+	i := w.start(suffix) - n
+	if i < 0 {
+		return 0
+	}
+	return w.r[i]
+}
+
+// precededBy reports if the part of the word before suffix ends with s.
+func (w *word) precededBy(suffix, s string) bool {
+	return strings.HasSuffix(string(w.r[:w.start(suffix)]), s)
+}
+
+func contains(set string, r rune) bool {
+	return r != 0 && strings.ContainsRune(set, r)
+}
@@ -0,0 +1,72 @@
+package stem_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rwool/saas-interview-challenge1/pkg/service/stem"
+)
+
+func testStems(t *testing.T, lang string, stems map[string]string) {
+	s, ok := stem.For(lang)
+	if !assert.True(t, ok, "There should be a stemmer for %s.", lang) {
+		return
+	}
+	for word, expected := range stems {
+		assert.Equal(t, expected, s(word), "%s: %s", lang, word)
+	}
+}
+
+func TestEnglish(t *testing.T) {
+	testStems(t, "en", map[string]string{
+		"run": "run", "runs": "run", "running": "run",
+		"caresses": "caress", "ponies": "poni", "ties": "tie", "cries": "cri",
+		"gas": "gas", "gaps": "gap", "kiwis": "kiwi",
+		"skies": "sky", "dying": "die", "news": "news", "proceed": "proceed",
+		"generously": "generous", "happiness": "happi", "hopeful": "hope",
+		"consign": "consign", "consigned": "consign", "consigning": "consign",
+		"consignment": "consign", "consistency": "consist", "consistently": "consist",
+		"consolation": "consol", "consolatory": "consolatori", "consoles": "consol",
+		"consolidated": "consolid", "consolingly": "consol", "consonant": "conson",
+		"conspicuously": "conspicu", "conspiracy": "conspiraci", "conspirators": "conspir",
+		"constable": "constabl", "constancy": "constanc", "constant": "constant",
+		"knightly": "knight", "knitting": "knit", "hoped": "hope", "dog's": "dog",
+		"by": "by", "say": "say", "cry": "cri", "yes": "yes",
+	})
+}
+
+func TestGerman(t *testing.T) {
+	testStems(t, "de", map[string]string{
+		"laufen": "lauf", "häuser": "haus", "haus": "haus", "straße": "strass",
+		"kinder": "kind", "freundlichkeit": "freundlich", "zeitung": "zeitung",
+	})
+}
+
+func TestDutch(t *testing.T) {
+	testStems(t, "nl", map[string]string{
+		"boeken": "boek", "maanden": "maand", "kinderen": "kinder", "lopen": "lop",
+	})
+}
+
+func TestSwedish(t *testing.T) {
+	testStems(t, "sv", map[string]string{
+		"klockorna": "klock", "hästarna": "häst", "bilar": "bil", "flickorna": "flick",
+		// Suffixes are only searched for within R1, so a shorter suffix is
+		// removed when a longer one starts before R1.
+		"ägarna": "ägarn", "anden": "and", "ägarens": "ägar", "friheten": "frihet",
+		"lyckligt": "lyck", "möjligheterna": "möj",
+		// From the Snowball vocabulary and output.
+		"jaktkarl": "jaktkarl", "jaktkarlar": "jaktkarl", "jaktkarlarne": "jaktkarl",
+		"jaktkarlarnes": "jaktkarlarn", "jaktkarlen": "jaktkarl", "jaktkarlens": "jaktkarl",
+		"jaktkarls": "jaktkarl", "jaktlopp": "jaktlopp", "klok": "klok", "kloka": "klok",
+		"klokare": "klok", "klokast": "klok", "klokaste": "klok", "klokhet": "klok",
+		"klokheten": "klok", "klokt": "klokt", "kärlekslöst": "kärlekslös",
+		"meningsfullt": "meningsfull",
+	})
+}
+
+func TestFor(t *testing.T) {
+	_, ok := stem.For("xx")
+	assert.False(t, ok, "Unknown languages should have no stemmer.")
+}
@@ -0,0 +1,45 @@
+package stem
+
+func swedishVowel(r rune) bool {
+	return contains("aeiouyäåö", r)
+}
+
+var swedishStep1Suffixes = []string{
+	"a", "arna", "erna", "heterna", "orna", "ad", "e", "ade", "ande", "arne",
+	"are", "aste", "en", "anden", "aren", "heten", "ern", "ar", "er", "heter",
+	"or", "as", "arnas", "ernas", "ornas", "es", "ades", "andes", "ens",
+	"arens", "hetens", "erns", "at", "andet", "het", "ast", "s",
+}
+
+// Swedish stems a Swedish word with the Snowball Swedish algorithm.
+func Swedish(s string) string {
+	w := &word{r: []rune(s)}
+	w.setRegions(swedishVowel)
+	// At least three letters must come before R1.
+	if w.r1 < 3 {
+		w.r1 = 3
+		if w.r1 > len(w.r) {
+			w.r1 = len(w.r)
+		}
+	}
+
+	// Every step searches for the longest suffix within R1.
+	if suffix := w.longestIn(w.r1, swedishStep1Suffixes...); suffix != "" {
+		if suffix != "s" || contains("bcdfghjklmnoprtvy", w.before(suffix, 1)) {
+			w.replace(suffix, "")
+		}
+	}
+	if suffix := w.longestIn(w.r1, "dd", "gd", "nn", "dt", "gt", "kt", "tt"); suffix != "" {
+		w.replace(suffix, suffix[:1])
+	}
+	switch suffix := w.longestIn(w.r1, "lig", "ig", "els", "löst", "fullt"); {
+	case suffix == "":
+	case suffix == "löst":
+		w.replace(suffix, "lös")
+	case suffix == "fullt":
+		w.replace(suffix, "full")
+	default:
+		w.replace(suffix, "")
+	}
+	return w.String()
+}
@@ -6,13 +6,21 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
 
+	"github.com/rwool/saas-interview-challenge1/pkg/service/blob"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/extract"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/keyspace"
 	"github.com/rwool/saas-interview-challenge1/pkg/service/keyvalue"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/language"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/search"
+	"github.com/rwool/saas-interview-challenge1/pkg/service/similarity"
 
 	"github.com/rwool/saas-interview-challenge1/pkg/service/queue"
 
@@ -28,23 +36,68 @@ type WorkerService interface {
 type DocumentID struct {
 	DocumentRequest
 	ID string
+	// Blob is the ID of the blob holding the document, if the document was
+	// streamed instead of being sent inline.
+	Blob string `json:",omitempty"`
+	// Shard is set if the document is one part of a larger document.
+	Shard *Shard `json:",omitempty"`
+	// Retention is how long the report is kept for. DefaultRetention is used
+	// if it is nil.
+	Retention *Retention `json:",omitempty"`
+	// Searchable is set if the document is kept in the document store, which
+	// removes it from the search index when it is deleted. Other documents
+	// are not indexed.
+	Searchable bool `json:",omitempty"`
+}
+
+// retention returns how long the report for the document is kept for.
+func (d DocumentID) retention() Retention {
+	if d.Retention != nil {
+		return *d.Retention
+	}
+	return DefaultRetention
 }
 
 // Frequency describes the frequency of a word.
 type Frequency struct {
 	Word      string
 	Frequency int
+	// Score is the weight of the word when words are ranked against a
+	// collection.
+	Score float64 `json:",omitempty"`
+	// Form is the most common form of a stemmed word in the document, when
+	// surface forms are requested.
+	Form string `json:",omitempty"`
 }
 
 type DocumentFrequencyReport struct {
 	DocumentFrequenciesResponse
+	// Retention is how long the cached report is kept for.
+	Retention *Retention `json:",omitempty"`
 }
 
+// DefaultMaxTokenSize is the longest word that can be scanned when no limit is
+// configured.
+const DefaultMaxTokenSize = 1024 * 1024
+
 type WorkerServiceConfig struct {
 	Queue   queue.Queue
 	KeyVal  keyvalue.KeyValue
 	Log     log.Logger
 	Channel string
+
+	// Blobs stores streamed documents.
+	Blobs *blob.Store
+	// MaxTokenSize is the longest word that can be scanned. Documents with
+	// longer words fail to parse. DefaultMaxTokenSize is used if it is 0.
+	MaxTokenSize int
+	// ShardSize is the size in bytes above which documents are split into
+	// shards that are counted by separate workers. Sharding is disabled if it
+	// is 0.
+	ShardSize int
+	// Index is the search index that analyzed documents are added to. Nothing
+	// is indexed if it is nil.
+	Index *search.Index
 }
 
 func NewWorkerService(conf WorkerServiceConfig) WorkerService {
@@ -52,19 +105,93 @@ func NewWorkerService(conf WorkerServiceConfig) WorkerService {
 }
 
 func newWorkerService(conf WorkerServiceConfig) *workerService {
+	maxTokenSize := conf.MaxTokenSize
+	if maxTokenSize <= 0 {
+		maxTokenSize = DefaultMaxTokenSize
+	}
 	return &workerService{
-		q:       conf.Queue,
-		kv:      conf.KeyVal,
-		log:     conf.Log,
-		channel: conf.Channel,
+		q:            conf.Queue,
+		kv:           conf.KeyVal,
+		blobs:        conf.Blobs,
+		log:          conf.Log,
+		channel:      conf.Channel,
+		maxTokenSize: maxTokenSize,
+		shardSize:    conf.ShardSize,
+		index:        conf.Index,
 	}
 }
 
 type workerService struct {
-	log     log.Logger
-	q       queue.Queue
-	kv      keyvalue.KeyValue
-	channel string
+	log          log.Logger
+	q            queue.Queue
+	kv           keyvalue.KeyValue
+	blobs        *blob.Store
+	channel      string
+	maxTokenSize int
+	shardSize    int
+	index        *search.Index
+}
+
+// openDocument returns a reader for the contents of a document and the size
+// of the document.
+func (w *workerService) openDocument(ctx context.Context, doc DocumentID) (io.Reader, int64, error) {
+	if doc.Blob == "" {
+		return strings.NewReader(doc.Document), int64(len(doc.Document)), nil
+	}
+	if w.blobs == nil {
+		return nil, 0, errors.New("streamed documents are not supported")
+	}
+	r, err := w.blobs.Open(ctx, doc.Blob)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "unable to open document %s", doc.ID)
+	}
+	return r, r.Manifest().Size, nil
+}
+
+// scanner returns a scanner for the words read from r.
+func (w *workerService) scanner(r io.Reader, analyzer language.Analyzer) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), w.maxTokenSize)
+	scanner.Split(analyzer.Split())
+	return scanner
+}
+
+// countWords counts the occurrences of each term read from r that the
+// analyzer does not drop as a stop word, and computes the MinHash signature of
+// the shingles of the document. If forms is not nil, the forms of each term are
+// counted in it.
+//
+// Only the current word is buffered, so documents of any size can be counted
+// in constant memory, apart from the counts themselves.
+func (w *workerService) countWords(r io.Reader, analyzer language.Analyzer, forms map[string]map[string]int) (map[string]int, similarity.MinHash, error) {
+	// Simple word scanner. Does not respect punctuation or capitalization
+	// differences.
+	words := make(map[string]int)
+	shingler := similarity.NewShingler(0)
+	scanner := w.scanner(r, analyzer)
+	for scanner.Scan() {
+		word := scanner.Text()
+		shingler.Add(word)
+		if analyzer.StopWord(word) {
+			continue
+		}
+		term, form := analyzer.Term(word)
+		if _, ok := words[term]; !ok {
+			words[term] = 1
+		} else {
+			words[term]++
+		}
+		if forms != nil {
+			if forms[term] == nil {
+				forms[term] = make(map[string]int)
+			}
+			forms[term][form]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.Wrap(err, "error while scanning document")
+	}
+	return words, shingler.Signature(), nil
 }
 
 func topN(m map[string]int, n int) []Frequency {
@@ -75,8 +202,12 @@ func topN(m map[string]int, n int) []Frequency {
 			Frequency: v,
 		})
 	}
+	// Break ties by word so that reports are deterministic.
 	sort.Slice(out, func(i, j int) bool {
-		return out[i].Frequency > out[j].Frequency
+		if out[i].Frequency != out[j].Frequency {
+			return out[i].Frequency > out[j].Frequency
+		}
+		return out[i].Word < out[j].Word
 	})
 
 	length := n
@@ -87,14 +218,150 @@ func topN(m map[string]int, n int) []Frequency {
 	return out[:length]
 }
 
+// detectLanguage returns the language of a document. The language of a shard is
+// the language of the whole document, and the language in the options is used
+// if it is set. Otherwise, it is detected from the start of text.
+func detectLanguage(doc DocumentID, text *bufio.Reader) language.Detection {
+	switch {
+	case doc.Shard != nil && doc.Shard.Language != nil:
+		return *doc.Shard.Language
+	case doc.Language != "":
+		return language.Detection{Language: doc.Language, Confidence: 1}
+	}
+	// Read errors are returned again when the document is counted.
+	sample, _ := text.Peek(language.SampleSize)
+	return language.Detect(string(sample))
+}
+
+// addForms sets the most common form of each word, if the forms of the words
+// were counted.
+func addForms(frequencies []Frequency, forms map[string]map[string]int) {
+	for i, f := range frequencies {
+		best, bestCount := "", 0
+		for form, count := range forms[f.Word] {
+			if count > bestCount || count == bestCount && form < best {
+				best, bestCount = form, count
+			}
+		}
+		frequencies[i].Form = best
+	}
+}
+
+// newReport creates the report for a document from its word counts.
+func newReport(id string, words map[string]int) DocumentFrequencyReport {
+	return DocumentFrequencyReport{
+		DocumentFrequenciesResponse: DocumentFrequenciesResponse{
+			DocumentID:  id,
+			Frequencies: topN(words, DefaultTopN),
+		},
+	}
+}
+
+// analysisExpiration is how long the counts and signatures of a document are
+// kept for comparing it with other documents, unless the report is retained
+// for longer.
+const analysisExpiration = 24 * time.Hour
+
+// termsKey returns the key that the counts of every word of a document are
+// stored under, given the key of its report.
+func termsKey(key string) string {
+	return keyspace.Terms(key)
+}
+
+// storeResult caches the report, the counts of every word and the signature
+// for a document under key, keeping them for the retention of the report.
+//
+// The counts and signature are stored first so that they are available once
+// the report is, and the key is tracked before anything is stored so that
+// deleting the document finds everything.
+func (w *workerService) storeResult(ctx context.Context, key string, dfr DocumentFrequencyReport, words map[string]int, minHash similarity.MinHash) error {
+	retention := DefaultRetention
+	if dfr.Retention != nil {
+		retention = *dfr.Retention
+	}
+	analysis := retention.atLeast(analysisExpiration)
+	if err := w.trackResult(ctx, key, analysis); err != nil {
+		return err
+	}
+	termsBytes, err := json.Marshal(words)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := w.kv.Store(ctx, termsKey(key), termsBytes, analysis); err != nil {
+		return errors.Wrapf(err, "unable to store word counts for document %s", dfr.DocumentID)
+	}
+	if err := w.storeSignature(ctx, key, signature{
+		MinHash: minHash,
+		SimHash: similarity.SimHash(words),
+	}, analysis); err != nil {
+		return errors.Wrapf(err, "unable to store signature for document %s", dfr.DocumentID)
+	}
+	dfrBytes, err := json.Marshal(dfr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = w.kv.Store(ctx, key, dfrBytes, retention.expiration())
+	return errors.Wrapf(err, "unable to store report for document %s", dfr.DocumentID)
+}
+
 // ParseDocument parses a document to find the frequencies of the different
 // words in the document.
 //
+// Documents larger than the configured shard size are split into shards that
+// are counted by other workers. In that case, the returned report only holds
+// the document ID and the complete report is stored once all of the shards
+// are done.
+//
+// The job of the document is moved to JobRunning first, so a document whose
+// job is already running on another worker is skipped, and to JobSucceeded or
+// JobFailed once its report is stored or it fails. Jobs of sharded documents
+// succeed once their shards are merged.
+//
 // The ID of the stored document and the top 10 frequencies are returned.
 func (w *workerService) ParseDocument(ctx context.Context, doc DocumentID) (DocumentFrequencyReport, error) {
+	if doc.ID == "" {
+		doc.ID = documentIDOf(doc.Document)
+	}
+	if doc.Shard != nil {
+		if doc.Shard.Run == "" {
+			err := errors.Errorf("shard %d of document %s has no run", doc.Shard.Index, doc.ID)
+			w.finishJob(ctx, doc.Shard.Key, err)
+			return DocumentFrequencyReport{}, err
+		}
+		dfr, _, err := w.parseDocument(ctx, doc)
+		if err != nil {
+			w.finishJob(ctx, doc.Shard.Key, errors.Wrapf(err, "shard %d", doc.Shard.Index))
+		}
+		return dfr, err
+	}
+
+	key := resultKey(doc.ID, doc.AnalysisOptions)
+	err := transitionJob(ctx, w.kv, key, JobRunning, nil)
+	if errors.Cause(err) == errJobTransition {
+		_ = w.log.Log("LEVEL", "DEBUG", "MESSAGE", fmt.Sprintf("Skipping document %s: %s", doc.ID, err))
+		return newReport(doc.ID, nil), nil
+	}
+	if err != nil {
+		return DocumentFrequencyReport{}, err
+	}
+	dfr, sharded, err := w.parseDocument(ctx, doc)
+	if !sharded {
+		w.finishJob(ctx, key, err)
+	}
+	return dfr, err
+}
+
+// parseDocument parses a document and stores its report, reporting whether
+// the document was split into shards instead.
+func (w *workerService) parseDocument(ctx context.Context, doc DocumentID) (DocumentFrequencyReport, bool, error) {
 	wait := time.NewTimer(time.Duration(doc.DurationSeconds) * time.Second)
 	defer wait.Stop() // Don't leak the timer.
+	waited := false
 	waitOrCancel := func() {
+		if waited {
+			return
+		}
+		waited = true
 		select {
 		case <-wait.C:
 			return
@@ -104,48 +371,63 @@ func (w *workerService) ParseDocument(ctx context.Context, doc DocumentID) (Docu
 	}
 	defer waitOrCancel()
 
-	// Simple word scanner. Does not respect punctuation or capitalization
-	// differences.
-	words := make(map[string]int)
-	reader := strings.NewReader(doc.Document)
-	scanner := bufio.NewScanner(reader)
-	scanner.Split(bufio.ScanWords)
-	for scanner.Scan() {
-		word := scanner.Text()
-		if _, ok := words[word]; !ok {
-			words[word] = 1
-		} else {
-			words[word]++
-		}
+	id := doc.ID
+
+	raw, size, err := w.openDocument(ctx, doc)
+	if err != nil {
+		return DocumentFrequencyReport{}, false, err
 	}
-	if err := scanner.Err(); err != nil {
-		return DocumentFrequencyReport{}, errors.Wrap(err, "error while scanning document")
+	reader, err := extract.Reader(raw, doc.ContentType, doc.extractOptions())
+	if err != nil {
+		return DocumentFrequencyReport{}, false, errors.Wrapf(err, "unable to read document %s", id)
 	}
+	defer reader.Close()
+	text := bufio.NewReaderSize(reader, language.SampleSize)
+	detection := detectLanguage(doc, text)
 
-	id := doc.ID
-	if id == "" {
-		id = createStringSHA256(doc.Document)
+	if doc.Shard == nil && w.shardSize > 0 && size > int64(w.shardSize) {
+		if err := w.splitAndEnqueue(ctx, doc, text, detection); err != nil {
+			return DocumentFrequencyReport{}, false, errors.Wrapf(err, "unable to shard document %s", id)
+		}
+		w.indexDocument(ctx, doc)
+		return newReport(id, nil), true, nil
 	}
 
-	dfr := DocumentFrequencyReport{
-		DocumentFrequenciesResponse: DocumentFrequenciesResponse{
-			DocumentID:  id,
-			Frequencies: topN(words, 10),
-		},
+	var forms map[string]map[string]int
+	if doc.SurfaceForms {
+		forms = make(map[string]map[string]int)
+	}
+	words, minHash, err := w.countWords(text, doc.analyzer(detection), forms)
+	if err != nil {
+		return DocumentFrequencyReport{}, false, err
+	}
+
+	if doc.Shard != nil {
+		if err := w.countShard(ctx, *doc.Shard, id, shardCounts{
+			Words:    words,
+			Forms:    forms,
+			MinHash:  minHash,
+			Language: detection,
+		}); err != nil {
+			return DocumentFrequencyReport{}, false, errors.Wrapf(err, "unable to count shard %d of document %s", doc.Shard.Index, id)
+		}
+		w.indexDocument(ctx, doc)
+		return newReport(id, words), false, nil
 	}
 
+	dfr := newReport(id, words)
+	addForms(dfr.Frequencies, forms)
+	dfr.Language = detection.Language
+	dfr.LanguageConfidence = detection.Confidence
+	retention := doc.retention()
+	dfr.Retention = &retention
+
 	// Pretend this work is more intensive than it actually is.
 	waitOrCancel()
 
-	dfrBytes, err := json.Marshal(dfr)
-	if err != nil {
-		return DocumentFrequencyReport{}, errors.WithStack(err)
-	}
-	if err := w.kv.Store(ctx, id, dfrBytes, 30*time.Second); err != nil {
-		// Suppress error because the normal way of handling errors is to log
-		// errors, but that is not working.
-		_ = w.log.Log("LEVEL", "ERROR", "MESSAGE", err.Error())
-		// Failure in a non-essential operation. Continue.
+	if err := w.storeResult(ctx, resultKey(id, doc.AnalysisOptions), dfr, words, minHash); err != nil {
+		return dfr, false, err
 	}
-	return dfr, nil
+	w.indexDocument(ctx, doc)
+	return dfr, false, nil
 }
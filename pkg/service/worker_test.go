@@ -2,6 +2,7 @@ package service_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -46,4 +47,153 @@ func TestWorker(t *testing.T) {
 	assert.Equal(t, 1, dfr.Frequencies[1].Frequency, "123 have one occurrence.")
 }
 
+func TestWorkerLongWord(t *testing.T) {
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   queuemock.New(),
+		KeyVal:  keyvaluemock.New(),
+		Log:     log.NewNopLogger(),
+		Channel: "worker_parse_document",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	long := strings.Repeat("a", 100*1024)
+	dfr, err := worker.ParseDocument(ctx, service.DocumentID{
+		DocumentRequest: service.DocumentRequest{
+			Document: long + " b " + long,
+		},
+		ID: "1",
+	})
+	require.NoError(t, err, "Words longer than 64KB should be scanned.")
+	require.Len(t, dfr.Frequencies, 2, "Should have two unique words.")
+	assert.Equal(t, long, dfr.Frequencies[0].Word, "Long word should be first word.")
+	assert.Equal(t, 2, dfr.Frequencies[0].Frequency, "Long word should have two occurrences.")
+}
+
+func TestWorkerContentType(t *testing.T) {
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   queuemock.New(),
+		KeyVal:  keyvaluemock.New(),
+		Log:     log.NewNopLogger(),
+		Channel: "worker_parse_document",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		doc  service.DocumentRequest
+	}{
+		{"Sniffed HTML", service.DocumentRequest{
+			Document: `<p>word <a href="https://example.com">word</a></p><script>code()</script>`,
+		}},
+		{"Declared JSON", service.DocumentRequest{
+			Document:        `{"word": "word", "other": ["word"], "n": 1}`,
+			AnalysisOptions: service.AnalysisOptions{ContentType: "application/json"},
+		}},
+		{"CSV Column", service.DocumentRequest{
+			Document: "a,b\nword,skipped\nword,skipped\n",
+			AnalysisOptions: service.AnalysisOptions{
+				ContentType: "text/csv",
+				CSVColumns:  []string{"a"},
+			},
+		}},
+	}
+	for _, test := range tests {
+		dfr, err := worker.ParseDocument(ctx, service.DocumentID{
+			DocumentRequest: test.doc,
+			ID:              "1",
+		})
+		require.NoError(t, err, "%s: Document parsing should succeed.", test.name)
+		assert.Equal(t, []service.Frequency{{Word: "word", Frequency: 2}}, dfr.Frequencies, test.name)
+	}
+}
+
+func TestWorkerLanguage(t *testing.T) {
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   queuemock.New(),
+		KeyVal:  keyvaluemock.New(),
+		Log:     log.NewNopLogger(),
+		Channel: "worker_parse_document",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	parse := func(doc service.DocumentRequest) service.DocumentFrequencyReport {
+		dfr, err := worker.ParseDocument(ctx, service.DocumentID{DocumentRequest: doc, ID: "1"})
+		require.NoError(t, err, "Document parsing should succeed.")
+		return dfr
+	}
+
+	dfr := parse(service.DocumentRequest{
+		Document: "The cat and the dog are in the garden, and the cat is asleep.",
+	})
+	assert.Equal(t, "en", dfr.Language, "English should be detected.")
+	assert.True(t, dfr.LanguageConfidence > 0, "Confidence should be reported.")
+	assert.Equal(t, service.Frequency{Word: "cat", Frequency: 2}, dfr.Frequencies[0], "Stop words should not be counted.")
+	for _, f := range dfr.Frequencies {
+		assert.NotContains(t, []string{"The", "the", "and", "are", "in", "is"}, f.Word)
+	}
+
+	dfr = parse(service.DocumentRequest{Document: "东京都和京都"})
+	assert.Equal(t, "zh", dfr.Language, "Chinese should be detected.")
+	assert.Equal(t, []service.Frequency{
+		{Word: "京都", Frequency: 2},
+		{Word: "东京", Frequency: 1},
+		{Word: "和京", Frequency: 1},
+		{Word: "都和", Frequency: 1},
+	}, dfr.Frequencies, "Chinese should be split into bigrams.")
+
+	dfr = parse(service.DocumentRequest{
+		Document:        "the the word",
+		AnalysisOptions: service.AnalysisOptions{Language: "de"},
+	})
+	assert.Equal(t, "de", dfr.Language, "The requested language should be used.")
+	assert.Equal(t, 1.0, dfr.LanguageConfidence)
+	assert.Equal(t, "the", dfr.Frequencies[0].Word, "Only German stop words should be dropped.")
+}
+
+func TestWorkerStemming(t *testing.T) {
+	worker := service.NewWorkerService(service.WorkerServiceConfig{
+		Queue:   queuemock.New(),
+		KeyVal:  keyvaluemock.New(),
+		Log:     log.NewNopLogger(),
+		Channel: "worker_parse_document",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	dfr, err := worker.ParseDocument(ctx, service.DocumentID{
+		DocumentRequest: service.DocumentRequest{
+			Document: "Running, running and runs: the dog runs because the dog likes running.",
+			AnalysisOptions: service.AnalysisOptions{
+				Stemmer:      service.StemmerAuto,
+				SurfaceForms: true,
+			},
+		},
+		ID: "1",
+	})
+	require.NoError(t, err, "Document parsing should succeed.")
+	assert.Equal(t, []service.Frequency{
+		{Word: "run", Frequency: 5, Form: "running"},
+		{Word: "dog", Frequency: 2, Form: "dog"},
+		{Word: "like", Frequency: 1, Form: "likes"},
+	}, dfr.Frequencies, "Words should be counted under their stems.")
+
+	dfr, err = worker.ParseDocument(ctx, service.DocumentID{
+		DocumentRequest: service.DocumentRequest{
+			Document:        "runs running",
+			AnalysisOptions: service.AnalysisOptions{Stemmer: "en"},
+		},
+		ID: "2",
+	})
+	require.NoError(t, err, "Document parsing should succeed.")
+	assert.Equal(t, []service.Frequency{{Word: "run", Frequency: 2}}, dfr.Frequencies,
+		"The requested stemmer should be used without reporting forms.")
+}
+
 // TODO: Add tests with more elements, parallel calls, etc.